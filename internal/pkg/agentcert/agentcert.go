@@ -0,0 +1,247 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package agentcert issues the client certificates of the agents and verifies the certificates the
+// agents present over mutual TLS.
+//
+// The certificates are signed by the certificate authority configured in
+// server.agent_certificates, and carry the ID of their agent as a URI SAN of the form
+// urn:elastic:fleet:agent:<id>. A certificate is not revoked on its own; the agent it identifies is,
+// through the active flag of its document.
+package agentcert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+const (
+	// uriPrefix is the prefix of the URI SAN carrying the agent ID.
+	uriPrefix = "urn:elastic:fleet:agent:"
+
+	// notBeforeSkew backdates the issued certificates to tolerate the clock skew of the agents.
+	notBeforeSkew = 5 * time.Minute
+)
+
+var (
+	ErrNoCertificate      = errors.New("no client certificate")
+	ErrInvalidCertificate = errors.New("invalid client certificate")
+	ErrInvalidCSR         = errors.New("invalid certificate signing request")
+)
+
+// CA is the certificate authority issuing the client certificates of the agents.
+type CA struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	pool     *x509.CertPool
+	validity time.Duration
+	required bool
+}
+
+// Load returns the CA of cfg, or nil when the agent certificates are disabled.
+func Load(cfg *config.AgentCertificates) (*CA, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	pair, err := tlscommon.LoadCertificate(&tlscommon.CertificateConfig{
+		Certificate: cfg.CA,
+		Key:         cfg.Key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the agent certificate authority: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the agent certificate authority: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the key of the agent certificate authority cannot sign")
+	}
+	return newCA(cert, key, cfg.Validity, cfg.Required)
+}
+
+func newCA(cert *x509.Certificate, key crypto.Signer, validity time.Duration, required bool) (*CA, error) {
+	if !cert.IsCA {
+		return nil, errors.New("the agent certificate authority is not a CA certificate")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &CA{
+		cert:     cert,
+		key:      key,
+		pool:     pool,
+		validity: validity,
+		required: required,
+	}, nil
+}
+
+// NewTestCA returns a self-signed CA issuing certificates valid for an hour, for the tests.
+func NewTestCA(required bool) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fleet-server test agent CA"},
+		NotBefore:             now.Add(-notBeforeSkew),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return newCA(cert, key, time.Hour, required)
+}
+
+// Required returns true when the agent requests without a valid certificate must be rejected.
+func (ca *CA) Required() bool {
+	return ca.required
+}
+
+// Certificate returns the certificate of the CA.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// ConfigureServer configures the TLS of the server to request the client certificates. The
+// certificates are verified by Verify, unless the server already verifies the client certificates,
+// in which case the CA is added to the trusted client CAs.
+func (ca *CA) ConfigureServer(cfg *tls.Config) {
+	if cfg.ClientCAs == nil {
+		cfg.ClientCAs = x509.NewCertPool()
+	}
+	cfg.ClientCAs.AddCert(ca.cert)
+	if cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequestClientCert
+	}
+}
+
+// Issue issues the client certificate of the agent. The certificate is issued for the public key of
+// the PEM certificate signing request when given, otherwise for a new key returned in PEM along the
+// PEM certificate.
+func (ca *CA) Issue(agentID string, csrPEM []byte) (certPEM, keyPEM []byte, err error) {
+	var pub crypto.PublicKey
+	if len(csrPEM) > 0 {
+		block, _ := pem.Decode(csrPEM)
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			return nil, nil, ErrInvalidCSR
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+		}
+		pub = csr.PublicKey
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub = key.Public()
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(ca.validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: agentID},
+		URIs:         []*url.URL{{Scheme: "urn", Opaque: strings.TrimPrefix(uriPrefix, "urn:") + agentID}},
+		NotBefore:    now.Add(-notBeforeSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// Verify verifies the client certificate of the connection against the CA, and returns the ID of
+// its agent.
+func (ca *CA) Verify(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", ErrNoCertificate
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         ca.pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	for _, uri := range leaf.URIs {
+		if id := strings.TrimPrefix(uri.String(), uriPrefix); id != uri.String() && id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no agent ID", ErrInvalidCertificate)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the ID of the agent authenticated by its certificate.
+func NewContext(ctx context.Context, agentID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, agentID)
+}
+
+// FromContext returns the ID of the agent authenticated by its certificate, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok
+}
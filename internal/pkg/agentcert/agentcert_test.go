@@ -0,0 +1,134 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package agentcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// connState returns the connection state of a client presenting the PEM certificate.
+func connState(t *testing.T, certPEM []byte) *tls.ConnectionState {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
+
+func TestIssue(t *testing.T) {
+	ca, err := NewTestCA(false)
+	require.NoError(t, err)
+
+	certPEM, keyPEM, err := ca.Issue("agent1", nil)
+	require.NoError(t, err)
+	_, err = tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err, "the key matches the certificate")
+
+	id, err := ca.Verify(connState(t, certPEM))
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", id)
+}
+
+func TestIssueCSR(t *testing.T) {
+	ca, err := NewTestCA(false)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "ignored"}}, key)
+	require.NoError(t, err)
+
+	certPEM, keyPEM, err := ca.Issue("agent1", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	require.NoError(t, err)
+	assert.Nil(t, keyPEM, "the key stays with the agent")
+
+	state := connState(t, certPEM)
+	assert.Equal(t, &key.PublicKey, state.PeerCertificates[0].PublicKey)
+	id, err := ca.Verify(state)
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", id)
+
+	_, _, err = ca.Issue("agent1", []byte("not a csr"))
+	assert.ErrorIs(t, err, ErrInvalidCSR)
+}
+
+func TestVerify(t *testing.T) {
+	ca, err := NewTestCA(false)
+	require.NoError(t, err)
+	other, err := NewTestCA(false)
+	require.NoError(t, err)
+
+	_, err = ca.Verify(nil)
+	assert.ErrorIs(t, err, ErrNoCertificate)
+	_, err = ca.Verify(&tls.ConnectionState{})
+	assert.ErrorIs(t, err, ErrNoCertificate)
+
+	certPEM, _, err := other.Issue("agent1", nil)
+	require.NoError(t, err)
+	_, err = ca.Verify(connState(t, certPEM))
+	assert.ErrorIs(t, err, ErrInvalidCertificate, "issued by another CA")
+
+	_, err = ca.Verify(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{ca.Certificate()}})
+	assert.ErrorIs(t, err, ErrInvalidCertificate, "the CA is not an agent")
+}
+
+func TestConfigureServer(t *testing.T) {
+	ca, err := NewTestCA(false)
+	require.NoError(t, err)
+
+	cfg := &tls.Config{} //nolint:gosec // test config
+	ca.ConfigureServer(cfg)
+	assert.Equal(t, tls.RequestClientCert, cfg.ClientAuth)
+	require.NotNil(t, cfg.ClientCAs)
+
+	cfg = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert} //nolint:gosec // test config
+	ca.ConfigureServer(cfg)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth, "the configured client auth is kept")
+}
+
+func TestLoad(t *testing.T) {
+	ca, err := Load(&config.AgentCertificates{})
+	require.NoError(t, err)
+	assert.Nil(t, ca, "disabled")
+
+	_, err = Load(&config.AgentCertificates{Enabled: true, CA: "missing.crt", Key: "missing.key"})
+	assert.Error(t, err)
+
+	test, err := NewTestCA(false)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(test.key)
+	require.NoError(t, err)
+	ca, err = Load(&config.AgentCertificates{
+		Enabled:  true,
+		CA:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: test.cert.Raw})),
+		Key:      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Validity: time.Hour,
+		Required: true,
+	})
+	require.NoError(t, err)
+	assert.True(t, ca.Required())
+
+	certPEM, _, err := ca.Issue("agent1", nil)
+	require.NoError(t, err)
+	_, err = test.Verify(connState(t, certPEM))
+	assert.NoError(t, err, "issued by the loaded CA")
+}
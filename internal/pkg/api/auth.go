@@ -6,18 +6,25 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
 	"go.elastic.co/apm"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -25,6 +32,14 @@ var (
 	ErrAgentCorrupted   = errors.New("agent record corrupted")
 	ErrAgentInactive    = errors.New("agent inactive")
 	ErrAgentIdentity    = errors.New("agent header contains wrong identifier")
+	ErrAgentCertificate = errors.New("agent certificate does not match the agent of the api key")
+)
+
+var (
+	// Concurrent authentications of an API key, and lookups of the agent of an API key ID, missing
+	// the cache are coalesced; e.g. when the agents reconnect after a restart.
+	authGroup  singleflight.Group
+	agentGroup singleflight.Group
 )
 
 // authAPIKey authenticates the provided API key, it checks that the key exists and is enabled.
@@ -42,6 +57,7 @@ func authAPIKey(r *http.Request, bulker bulk.Bulk, c cache.Cache) (*apikey.APIKe
 	}
 
 	if c.ValidAPIKey(*key) {
+		cntAPIKeyAuth.hit.Inc()
 		span.Context.SetLabel("api_key_cache_hit", true)
 		log.Debug().
 			Str("id", key.ID).
@@ -51,10 +67,11 @@ func authAPIKey(r *http.Request, bulker bulk.Bulk, c cache.Cache) (*apikey.APIKe
 			Msg("ApiKey authenticated")
 		return key, nil
 	} else {
+		cntAPIKeyAuth.miss.Inc()
 		span.Context.SetLabel("api_key_cache_hit", false)
 	}
 
-	info, err := bulker.APIKeyAuth(ctx, *key)
+	info, err := authenticate(ctx, bulker, *key)
 
 	if err != nil {
 		log.Info().
@@ -93,9 +110,24 @@ func authAPIKey(r *http.Request, bulker bulk.Bulk, c cache.Cache) (*apikey.APIKe
 
 // authAgent ensures that the requested API-Key is associated with the correct agent.
 // If all succeeds, it returns the agent associated with id.
+//
+// An agent authenticated by its client certificate, see withAgentCertificate, may omit its API key;
+// when it does not, the API key must be the one of the agent of the certificate.
 func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*model.Agent, error) {
+	// already authenticated by the limiter, see limitAuthenticator
+	if res, ok := r.Context().Value(authResultKey{}).(*authResult); ok && id != nil && *id == res.id {
+		return res.agent, res.err
+	}
+
 	start := time.Now()
 
+	certID, withCert := agentcert.FromContext(r.Context())
+	if withCert {
+		if _, ok := r.Header[apikey.AuthKey]; !ok {
+			return authAgentCertificate(r, certID, id, bulker)
+		}
+	}
+
 	// authenticate
 	key, err := authAPIKey(r, bulker, c)
 	if err != nil {
@@ -120,9 +152,15 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 			Msg("authApiKey slow")
 	}
 
-	agent, err := findAgentByAPIKeyID(r.Context(), bulker, key.ID)
+	agent, err := lookupAgent(r.Context(), bulker, c, key.ID)
 	if err != nil {
-		return nil, err
+		cached, ok := c.GetAgentByAPIKeyID(key.ID)
+		if !ok || !bulk.IsUnavailable(err) {
+			return nil, err
+		}
+		// Elasticsearch is unavailable; serve the agent as last seen with this key.
+		zlog.Debug().Err(err).Msg("elasticsearch unavailable, agent served from cache")
+		agent = &cached
 	}
 
 	if agent.Agent == nil {
@@ -171,5 +209,161 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 		return nil, ErrAgentInactive
 	}
 
+	if withCert && certID != agent.Id {
+		zlog.Warn().
+			Err(ErrAgentCertificate).
+			Str("agent.Id", agent.Id).
+			Str("certificate.agent.id", certID).
+			Msg("agent certificate mismatch against api key")
+		return nil, ErrAgentCertificate
+	}
+
 	return agent, nil
 }
+
+// authResultKey is the context key of the authentication of the agent of a request by the limiter.
+type authResultKey struct{}
+
+type authResult struct {
+	id    string
+	agent *model.Agent
+	err   error
+}
+
+// limitIdentifier returns the identifier of the agent limits of the routes of the agents. It only
+// identifies the agents of the API keys already authenticated in the cache, so that the requests
+// over the limits cost no request to elasticsearch; the other agents are identified once
+// authenticated by limitAuthenticator.
+func limitIdentifier(c cache.Cache) limit.Identifier {
+	return func(r *http.Request, _ httprouter.Params) (limit.Agent, bool) {
+		key, err := apikey.ExtractAPIKey(r)
+		if err != nil || !c.ValidAPIKey(*key) {
+			return limit.Agent{}, false
+		}
+		agent, ok := c.GetAgentByAPIKeyID(key.ID)
+		if !ok || agent.AccessAPIKeyID != key.ID {
+			return limit.Agent{}, false
+		}
+		return limit.Agent{ID: agent.Id, PolicyID: agent.PolicyID}, true
+	}
+}
+
+// limitAuthenticator returns the authenticator of the agent limits of the routes of the agents. It
+// authenticates the agent of the id parameter, see authAgent, and keeps the outcome in the context of
+// the request so that the handler does not authenticate the agent again.
+func limitAuthenticator(bulker bulk.Bulk, c cache.Cache) limit.Authenticator {
+	return func(r *http.Request, ps httprouter.Params) (*http.Request, limit.Agent, bool) {
+		id := ps.ByName("id")
+		agent, err := authAgent(r, &id, bulker, c)
+		r = r.WithContext(context.WithValue(r.Context(), authResultKey{}, &authResult{id: id, agent: agent, err: err}))
+		if err != nil {
+			return r, limit.Agent{}, false
+		}
+		return r, limit.Agent{ID: agent.Id, PolicyID: agent.PolicyID}, true
+	}
+}
+
+// authAgentCertificate returns the agent of certID, authenticated by its client certificate. The
+// agent is searched on each request rather than cached, so that deactivating the agent revokes its
+// certificate at once.
+func authAgentCertificate(r *http.Request, certID string, id *string, bulker bulk.Bulk) (*model.Agent, error) {
+	zlog := log.With().
+		Str(LogAgentID, certID).
+		Str(ECSHTTPRequestID, r.Header.Get(logger.HeaderRequestID)).
+		Logger()
+
+	// validate that the id in the header is equal to the agent id of the certificate
+	if id != nil && *id != certID {
+		zlog.Warn().
+			Err(ErrAgentIdentity).
+			Str("header.agent.id", *id).
+			Msg("agent certificate mismatch against http header")
+		return nil, ErrAgentIdentity
+	}
+
+	agent, err := findAgentByID(r.Context(), bulker, certID)
+	if err != nil {
+		return nil, err
+	}
+
+	if agent.Agent == nil {
+		zlog.Warn().
+			Err(ErrAgentCorrupted).
+			Msg("agent record does not contain required metadata section")
+		return nil, ErrAgentCorrupted
+	}
+
+	if !agent.Active {
+		zlog.Info().
+			Err(ErrAgentInactive).
+			Msg("agent record inactive, certificate revoked")
+		return nil, ErrAgentInactive
+	}
+
+	return agent, nil
+}
+
+func findAgentByID(ctx context.Context, bulker bulk.Bulk, id string) (*model.Agent, error) {
+	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByID, dl.FieldID, id)
+	if err != nil {
+		if errors.Is(err, dl.ErrNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, fmt.Errorf("findAgentByID: %w", err)
+	}
+	return &agent, nil
+}
+
+// authenticate authenticates the API key, coalesced with the concurrent authentications of the same
+// API key.
+func authenticate(ctx context.Context, bulker bulk.Bulk, key apikey.APIKey) (*apikey.SecurityInfo, error) {
+	leader := false
+	v, err, _ := authGroup.Do(key.Token(), func() (interface{}, error) {
+		leader = true
+		return bulker.APIKeyAuth(ctx, key)
+	})
+	if !leader {
+		cntAPIKeyAuth.coalesced.Inc()
+		// The request of the leader was cancelled, not this one
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			return bulker.APIKeyAuth(ctx, key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.(*apikey.SecurityInfo), nil
+}
+
+// lookupAgent returns the agent of the access API key ID, from the cache if it was cached for less
+// than the agent record TTL, otherwise searched and cached, coalesced with the concurrent lookups of
+// the same API key ID.
+func lookupAgent(ctx context.Context, bulker bulk.Bulk, c cache.Cache, id string) (*model.Agent, error) {
+	if agent, ok := c.GetFreshAgentByAPIKeyID(id); ok {
+		cntAgentLookup.hit.Inc()
+		return &agent, nil
+	}
+	cntAgentLookup.miss.Inc()
+
+	leader := false
+	v, err, _ := agentGroup.Do(id, func() (interface{}, error) {
+		leader = true
+		agent, err := findAgentByAPIKeyID(ctx, bulker, id)
+		if err != nil {
+			return nil, err
+		}
+		c.SetAgent(*agent)
+		return *agent, nil
+	})
+	if !leader {
+		cntAgentLookup.coalesced.Inc()
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			return findAgentByAPIKeyID(ctx, bulker, id)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	agent := v.(model.Agent)
+	return &agent, nil
+}
@@ -0,0 +1,310 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+// concurrently calls fn n times concurrently and waits for the calls to return.
+func concurrently(n int, fn func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAuthenticateCoalesced(t *testing.T) {
+	key := apikey.APIKey{ID: "coalesced", Key: "secret"}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, key).
+		After(100*time.Millisecond).
+		Return(&apikey.SecurityInfo{Enabled: true}, nil)
+
+	coalesced := cntAPIKeyAuth.coalesced.Get()
+	concurrently(10, func() {
+		info, err := authenticate(context.Background(), bulker, key)
+		assert.NoError(t, err)
+		assert.True(t, info.Enabled)
+	})
+
+	bulker.AssertNumberOfCalls(t, "APIKeyAuth", 1)
+	assert.Equal(t, coalesced+9, cntAPIKeyAuth.coalesced.Get())
+}
+
+func TestLookupAgent(t *testing.T) {
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000, AgentRecordTTL: time.Minute})
+	require.NoError(t, err)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		After(100*time.Millisecond).
+		Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+			ID:     "agent1",
+			Source: []byte(`{"active":true,"access_api_key_id":"access1","agent":{"id":"agent1"}}`),
+		}}}}, nil)
+
+	hit, miss, coalesced := cntAgentLookup.hit.Get(), cntAgentLookup.miss.Get(), cntAgentLookup.coalesced.Get()
+	concurrently(10, func() {
+		agent, err := lookupAgent(context.Background(), bulker, c, "access1")
+		assert.NoError(t, err)
+		assert.Equal(t, "agent1", agent.Id)
+	})
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+	assert.Equal(t, miss+10, cntAgentLookup.miss.Get())
+	assert.Equal(t, coalesced+9, cntAgentLookup.coalesced.Get())
+
+	// The agent is cached once the set is processed by ristretto
+	require.Eventually(t, func() bool {
+		_, ok := c.GetFreshAgentByAPIKeyID("access1")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	agent, err := lookupAgent(context.Background(), bulker, c, "access1")
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", agent.Id)
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+	assert.Equal(t, hit+1, cntAgentLookup.hit.Get())
+}
+
+func TestAuthAgentFromCache(t *testing.T) {
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	key := apikey.APIKey{ID: "access1", Key: "secret"}
+	c.SetAPIKey(key, true)
+	c.SetAgent(model.Agent{
+		ESDocument:     model.ESDocument{Id: "agent1"},
+		Active:         true,
+		AccessAPIKeyID: key.ID,
+		Agent:          &model.AgentMetadata{ID: "agent1"},
+	})
+	require.Eventually(t, func() bool {
+		_, ok := c.GetAgentByAPIKeyID(key.ID)
+		return ok && c.ValidAPIKey(key)
+	}, time.Second, 10*time.Millisecond)
+
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "ApiKey "+key.Token())
+		return r
+	}
+	id := "agent1"
+
+	t.Run("elasticsearch unavailable", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return((*es.ResultT)(nil), &es.ErrElastic{Status: http.StatusServiceUnavailable})
+		agent, err := authAgent(request(), &id, bulker, c)
+		require.NoError(t, err)
+		assert.Equal(t, "agent1", agent.Id)
+	})
+
+	t.Run("agent not found", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&es.ResultT{}, nil)
+		_, err := authAgent(request(), &id, bulker, c)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
+
+func TestLimitAuthenticator(t *testing.T) {
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	key := apikey.APIKey{ID: "access1", Key: "secret"}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, key).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+			ID:     "agent1",
+			Source: []byte(`{"active":true,"access_api_key_id":"access1","policy_id":"policy1","agent":{"id":"agent1"}}`),
+		}}}}, nil)
+	auth := limitAuthenticator(bulker, c)
+
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "ApiKey "+key.Token())
+		return r
+	}
+
+	r, agent, ok := auth(request(), httprouter.Params{{Key: "id", Value: "agent1"}})
+	require.True(t, ok)
+	assert.Equal(t, limit.Agent{ID: "agent1", PolicyID: "policy1"}, agent)
+
+	// the handler gets the agent authenticated by the limiter
+	id := "agent1"
+	authenticated, err := authAgent(r, &id, bulker, c)
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", authenticated.Id)
+	bulker.AssertNumberOfCalls(t, "APIKeyAuth", 1)
+
+	_, _, ok = auth(request(), httprouter.Params{{Key: "id", Value: "agent2"}})
+	assert.False(t, ok, "the agent of the URL must be the one of the api key")
+}
+
+func TestLimitRejectedWithoutElasticsearch(t *testing.T) {
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+			ID:     "agent1",
+			Source: []byte(`{"active":true,"access_api_key_id":"access1","agent":{"id":"agent1"}}`),
+		}}}}, nil)
+
+	cfg := &config.ServerLimits{CheckinLimit: config.Limit{Interval: time.Hour, Burst: 1}}
+	limiter := limit.NewHTTPWrapper("test", cfg, limit.WithAuthenticator(limitIdentifier(c), limitAuthenticator(bulker, c)))
+	h := limiter.WrapCheckin(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	}, &cntCheckin)
+
+	request := func(key apikey.APIKey) int {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "ApiKey "+key.Token())
+		w := httptest.NewRecorder()
+		h(w, r, httprouter.Params{{Key: "id", Value: "agent1"}})
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(apikey.APIKey{ID: "access1", Key: "secret"}))
+	assert.Equal(t, http.StatusTooManyRequests, request(apikey.APIKey{ID: "access2", Key: "secret"}))
+	bulker.AssertNumberOfCalls(t, "APIKeyAuth", 1)
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+}
+
+// certRequest returns a request presenting a client certificate issued by ca to the agent.
+func certRequest(t *testing.T, ca *agentcert.CA, agentID string) *http.Request {
+	t.Helper()
+	certPEM, _, err := ca.Issue(agentID, nil)
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return r
+}
+
+func TestAuthAgentCertificate(t *testing.T) {
+	ca, err := agentcert.NewTestCA(false)
+	require.NoError(t, err)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+
+	search := func(active bool) *ftesting.MockBulk {
+		source := `{"active":false,"agent":{"id":"agent1"}}`
+		if active {
+			source = `{"active":true,"agent":{"id":"agent1"}}`
+		}
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent1", Source: []byte(source)}}}}, nil)
+		return bulker
+	}
+
+	// authenticate runs authAgent behind withAgentCertificate, as the routes of the agents do.
+	authenticate := func(r *http.Request, id string, bulker *ftesting.MockBulk) (agent *model.Agent, err error) {
+		rt := &Router{ca: ca}
+		h := rt.withAgentCertificate(func(_ http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			agent, err = authAgent(r, &id, bulker, c)
+		}, &cntCheckin)
+		h(httptest.NewRecorder(), r, nil)
+		return agent, err
+	}
+
+	t.Run("certificate", func(t *testing.T) {
+		bulker := search(true)
+		agent, err := authenticate(certRequest(t, ca, "agent1"), "agent1", bulker)
+		require.NoError(t, err)
+		assert.Equal(t, "agent1", agent.Id)
+		bulker.AssertNotCalled(t, "APIKeyAuth", mock.Anything, mock.Anything)
+	})
+
+	t.Run("header mismatch", func(t *testing.T) {
+		_, err := authenticate(certRequest(t, ca, "agent1"), "agent2", search(true))
+		assert.ErrorIs(t, err, ErrAgentIdentity)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		_, err := authenticate(certRequest(t, ca, "agent1"), "agent1", search(false))
+		assert.ErrorIs(t, err, ErrAgentInactive)
+	})
+
+	t.Run("api key of another agent", func(t *testing.T) {
+		key := apikey.APIKey{ID: "access2", Key: "secret"}
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, key).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+				ID:     "agent2",
+				Source: []byte(`{"active":true,"access_api_key_id":"access2","agent":{"id":"agent2"}}`),
+			}}}}, nil)
+
+		r := certRequest(t, ca, "agent1")
+		r.Header.Set("Authorization", "ApiKey "+key.Token())
+		_, err := authenticate(r, "agent2", bulker)
+		assert.ErrorIs(t, err, ErrAgentCertificate)
+	})
+
+	t.Run("other CA", func(t *testing.T) {
+		other, err := agentcert.NewTestCA(false)
+		require.NoError(t, err)
+		_, err = authenticate(certRequest(t, other, "agent1"), "agent1", search(true))
+		assert.ErrorIs(t, err, apikey.ErrNoAuthHeader, "falls back to the api key")
+	})
+}
+
+func TestWithAgentCertificateRequired(t *testing.T) {
+	ca, err := agentcert.NewTestCA(true)
+	require.NoError(t, err)
+	rt := &Router{ca: ca}
+	called := false
+	h := rt.withAgentCertificate(func(_ http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		called = true
+		id, ok := agentcert.FromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "agent1", id)
+	}, &cntCheckin)
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/", nil), nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called)
+
+	w = httptest.NewRecorder()
+	h(w, certRequest(t, ca, "agent1"), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+}
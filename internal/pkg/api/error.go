@@ -11,6 +11,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 
@@ -118,6 +119,42 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			agentcert.ErrNoCertificate,
+			HTTPErrResp{
+				http.StatusUnauthorized,
+				"Unauthorized",
+				"client certificate required",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			agentcert.ErrInvalidCertificate,
+			HTTPErrResp{
+				http.StatusUnauthorized,
+				"Unauthorized",
+				"client certificate not valid",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrAgentCertificate,
+			HTTPErrResp{
+				http.StatusUnauthorized,
+				"Unauthorized",
+				"client certificate does not match the agent",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			agentcert.ErrInvalidCSR,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"InvalidCSR",
+				"certificate signing request is invalid",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			ErrUpdatingInactiveAgent,
 			HTTPErrResp{
@@ -215,7 +215,7 @@ func (ct *CheckinT) processRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	defer longPoll.Stop()
 
 	// Initial update on checkin, and any user fields that might have changed
-	err = ct.bc.CheckIn(agent.Id, req.Status, req.Message, rawMeta, rawComponents, seqno, ver)
+	err = ct.bc.CheckIn(agent.Id, req.Status, req.Message, rawMeta, rawComponents, seqno, ver, agent.CheckinStatus(req.Status))
 	if err != nil {
 		zlog.Error().Err(err).Str("agent_id", agent.Id).Msg("checkin failed")
 	}
@@ -248,6 +248,8 @@ func (ct *CheckinT) processRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 				break LOOP
 			case policy := <-sub.Output():
 				actionResp, err := processPolicy(ctx, zlog, ct.bulker, agent.Id, policy)
+				// The outputs of the agent record may have been updated
+				ct.cache.DeleteAgent(agent.AccessAPIKeyID)
 				if err != nil {
 					return errors.Wrap(err, "processPolicy")
 				}
@@ -257,7 +259,10 @@ func (ct *CheckinT) processRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 				zlog.Trace().Msg("fire long poll")
 				break LOOP
 			case <-tick.C:
-				err := ct.bc.CheckIn(agent.Id, req.Status, req.Message, nil, rawComponents, nil, ver)
+				// The status is recomputed from the latest agent record, its upgrade or its unenrollment
+				// may have started since the checkin.
+				agent = ct.refreshAgent(ctx, zlog, agent)
+				err := ct.bc.CheckIn(agent.Id, req.Status, req.Message, nil, rawComponents, nil, ver, agent.CheckinStatus(req.Status))
 				if err != nil {
 					zlog.Error().Err(err).Str("agent_id", agent.Id).Msg("checkin failed")
 				}
@@ -355,10 +360,15 @@ func (ct *CheckinT) resolveSeqNo(ctx context.Context, zlog zerolog.Logger, req C
 		var sn int64
 		sn, err = ct.tr.Resolve(ctx, ackToken)
 		if err != nil {
-			if errors.Is(err, dl.ErrNotFound) {
+			switch {
+			case errors.Is(err, dl.ErrNotFound):
 				zlog.Debug().Str("token", ackToken).Msg("revision token not found")
 				err = nil
-			} else {
+			case bulk.IsUnavailable(err):
+				// fallback on the agent record until elasticsearch is available
+				zlog.Debug().Err(err).Str("token", ackToken).Msg("elasticsearch unavailable, revision token not resolved")
+				return seqno, nil
+			default:
 				return seqno, errors.Wrap(err, "resolveSeqNo")
 			}
 		}
@@ -367,11 +377,30 @@ func (ct *CheckinT) resolveSeqNo(ctx context.Context, zlog zerolog.Logger, req C
 	return seqno, err
 }
 
+// refreshAgent returns the latest record of the agent, cached for up to the agent record TTL.
+// The agent is returned as is if its record cannot be read.
+func (ct *CheckinT) refreshAgent(ctx context.Context, zlog zerolog.Logger, agent *model.Agent) *model.Agent {
+	fresh, err := lookupAgent(ctx, ct.bulker, ct.cache, agent.AccessAPIKeyID)
+	if err != nil {
+		zlog.Debug().Err(err).Msg("agent record not refreshed, status computed from the one of the checkin")
+		return agent
+	}
+	if fresh.Id != agent.Id {
+		return agent
+	}
+	return fresh
+}
+
 func (ct *CheckinT) fetchAgentPendingActions(ctx context.Context, seqno sqn.SeqNo, agentID string) ([]model.Action, error) {
 
 	actions, err := dl.FindAgentActions(ctx, ct.bulker, seqno, ct.gcp.GetCheckpoint(), agentID)
 
 	if err != nil {
+		if bulk.IsUnavailable(err) {
+			// the pending actions are delivered on a later checkin once elasticsearch is available
+			log.Debug().Err(err).Str("agent_id", agentID).Msg("elasticsearch unavailable, pending actions not fetched")
+			return nil, nil
+		}
 		return nil, errors.Wrap(err, "fetchAgentPendingActions")
 	}
 
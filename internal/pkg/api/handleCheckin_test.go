@@ -8,11 +8,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
 	"testing"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertActions(t *testing.T) {
@@ -101,3 +110,36 @@ func TestFilterActions(t *testing.T) {
 		})
 	}
 }
+
+func TestRefreshAgent(t *testing.T) {
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	agent := &model.Agent{
+		ESDocument:     model.ESDocument{Id: "agent1"},
+		Active:         true,
+		AccessAPIKeyID: "access1",
+	}
+
+	t.Run("upgrade started during the long poll", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+				ID:     "agent1",
+				Source: []byte(`{"active":true,"access_api_key_id":"access1","upgrade_started_at":"2022-10-19T01:00:00Z"}`),
+			}}}}, nil).Once()
+		ct := &CheckinT{bulker: bulker, cache: c}
+
+		assert.Equal(t, model.AgentStatusOnline, agent.CheckinStatus("online"))
+		fresh := ct.refreshAgent(context.Background(), zerolog.Nop(), agent)
+		assert.Equal(t, model.AgentStatusUpdating, fresh.CheckinStatus("online"))
+	})
+	t.Run("record not read", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return((*es.ResultT)(nil), &es.ErrElastic{Status: http.StatusServiceUnavailable}).Once()
+		c.DeleteAgent("access1")
+		ct := &CheckinT{bulker: bulker, cache: c}
+
+		assert.Same(t, agent, ct.refreshAgent(context.Background(), zerolog.Nop(), agent))
+	})
+}
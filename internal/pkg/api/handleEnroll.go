@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
@@ -48,14 +49,17 @@ type EnrollerT struct {
 	cfg    *config.Server
 	bulker bulk.Bulk
 	cache  cache.Cache
+	ca     *agentcert.CA
 }
 
-func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache) (*EnrollerT, error) {
+// NewEnrollerT returns the enroller of the agents, issuing their client certificate with ca when not nil.
+func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache, ca *agentcert.CA) (*EnrollerT, error) {
 	return &EnrollerT{
 		verCon: verCon,
 		cfg:    cfg,
 		bulker: bulker,
 		cache:  c,
+		ca:     ca,
 	}, nil
 
 }
@@ -191,6 +195,16 @@ func (et *EnrollerT) _enroll(
 
 	agentID := u.String()
 
+	// Issue the client certificate first, a bad certificate signing request fails the enrollment
+	// before any document is created
+	var certPEM, keyPEM []byte
+	if et.ca != nil {
+		certPEM, keyPEM, err = et.ca.Issue(agentID, []byte(req.CSR))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Update the local metadata agent id
 	localMeta, err := updateLocalMetaAgentID(req.Meta.Local, agentID)
 	if err != nil {
@@ -222,6 +236,7 @@ func (et *EnrollerT) _enroll(
 		},
 		Tags: req.Meta.Tags,
 	}
+	agentData.Status = agentData.ComputeStatus(now)
 
 	err = createFleetAgent(ctx, et.bulker, agentID, agentData)
 	if err != nil {
@@ -247,6 +262,9 @@ func (et *EnrollerT) _enroll(
 			AccessAPIKey:   accessAPIKey.Token(),
 			Status:         "online",
 			Tags:           agentData.Tags,
+
+			ClientCertificate: string(certPEM),
+			ClientKey:         string(keyPEM),
 		},
 	}
 
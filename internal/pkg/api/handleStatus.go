@@ -35,10 +35,20 @@ type StatusT struct {
 	bulk   bulk.Bulk
 	cache  cache.Cache
 	authfn AuthFunc
+
+	caFingerprint string
 }
 
 type OptFunc func(*StatusT)
 
+// WithCATrustedFingerprint adds the fingerprint of the CA of the server certificate to the
+// authenticated status responses, for the agents to pin it.
+func WithCATrustedFingerprint(fingerprint string) OptFunc {
+	return func(st *StatusT) {
+		st.caFingerprint = fingerprint
+	}
+}
+
 func NewStatusT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache, opts ...OptFunc) *StatusT {
 	st := &StatusT{
 		cfg:   cfg,
@@ -80,6 +90,7 @@ func (st StatusT) handleStatus(_ *zerolog.Logger, r *http.Request, rt *Router) (
 			BuildHash: rt.bi.Commit,
 			BuildTime: rt.bi.BuildTime.Format(time.RFC3339),
 		}
+		resp.CATrustedFingerprint = st.caFingerprint
 	}
 
 	return resp, state
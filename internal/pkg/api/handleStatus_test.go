@@ -86,7 +86,7 @@ func TestHandleStatus(t *testing.T) {
 					state := client.UnitState(k)
 					r := Router{
 						ctx: ctx,
-						st:  NewStatusT(cfg, nil, c, withAuthFunc(tc.AuthFn)),
+						st:  NewStatusT(cfg, nil, c, withAuthFunc(tc.AuthFn), WithCATrustedFingerprint("abcd")),
 						sm:  &mockPolicyMonitor{state},
 						bi: fbuild.Info{
 							Version:   "8.1.0",
@@ -139,10 +139,16 @@ func TestHandleStatus(t *testing.T) {
 						if diff := cmp.Diff(r.bi.BuildTime.Format(time.RFC3339), res.Version.BuildTime); diff != "" {
 							t.Error(diff)
 						}
+						if diff := cmp.Diff("abcd", res.CATrustedFingerprint); diff != "" {
+							t.Error(diff)
+						}
 					} else {
 						if res.Version != nil {
 							t.Error("expected nil version information")
 						}
+						if res.CATrustedFingerprint != "" {
+							t.Error("expected no CA fingerprint")
+						}
 					}
 				})
 			}
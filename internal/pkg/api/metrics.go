@@ -33,6 +33,13 @@ var (
 	cntAcks      routeStats
 	cntStatus    routeStats
 	cntArtifacts artifactStats
+
+	cntAPIKeyAuth  lookupStats
+	cntAgentLookup lookupStats
+
+	cntTLSReload     *monitoring.Uint // reloads of the TLS certificate of the server
+	cntTLSReloadFail *monitoring.Uint
+	gaugeTLSNotAfter *monitoring.Int // expiry of the TLS certificate of the server, in unix seconds
 )
 
 func InitMetrics(ctx context.Context, cfg *config.Config, bi build.Info) (*api.Server, error) {
@@ -65,21 +72,27 @@ func InitMetrics(ctx context.Context, cfg *config.Config, bi build.Info) (*api.S
 }
 
 type routeStats struct {
-	active    *monitoring.Uint
-	total     *monitoring.Uint
-	rateLimit *monitoring.Uint
-	maxLimit  *monitoring.Uint
-	failure   *monitoring.Uint
-	drop      *monitoring.Uint
-	bodyIn    *monitoring.Uint
-	bodyOut   *monitoring.Uint
+	active         *monitoring.Uint
+	total          *monitoring.Uint
+	rateLimit      *monitoring.Uint
+	rateLimitAgent *monitoring.Uint
+	rateLimitIP    *monitoring.Uint
+	maxLimit       *monitoring.Uint
+	shed           *monitoring.Uint
+	failure        *monitoring.Uint
+	drop           *monitoring.Uint
+	bodyIn         *monitoring.Uint
+	bodyOut        *monitoring.Uint
 }
 
 func (rt *routeStats) Register(registry *monitoring.Registry) {
 	rt.active = monitoring.NewUint(registry, "active")
 	rt.total = monitoring.NewUint(registry, "total")
 	rt.rateLimit = monitoring.NewUint(registry, "limit_rate")
+	rt.rateLimitAgent = monitoring.NewUint(registry, "limit_rate_agent")
+	rt.rateLimitIP = monitoring.NewUint(registry, "limit_rate_ip")
 	rt.maxLimit = monitoring.NewUint(registry, "limit_max")
+	rt.shed = monitoring.NewUint(registry, "limit_shed")
 	rt.failure = monitoring.NewUint(registry, "fail")
 	rt.drop = monitoring.NewUint(registry, "drop")
 	rt.bodyIn = monitoring.NewUint(registry, "body_in")
@@ -103,15 +116,30 @@ func init() {
 	cntArtifacts.Register(routesRegistry.NewRegistry("artifacts"))
 	cntAcks.Register(routesRegistry.NewRegistry("acks"))
 	cntStatus.Register(routesRegistry.NewRegistry("status"))
+
+	authRegistry := registry.NewRegistry("auth")
+	cntAPIKeyAuth.Register(authRegistry.NewRegistry("api_key"))
+	cntAgentLookup.Register(authRegistry.NewRegistry("agent"))
+
+	tlsRegistry := registry.NewRegistry("tls")
+	cntTLSReload = monitoring.NewUint(tlsRegistry, "reload")
+	cntTLSReloadFail = monitoring.NewUint(tlsRegistry, "reload_fail")
+	gaugeTLSNotAfter = monitoring.NewInt(tlsRegistry, "not_after")
 }
 
 func (rt *routeStats) IncError(err error) {
 
 	switch {
+	case errors.Is(err, limit.ErrAgentRateLimit):
+		rt.rateLimitAgent.Inc()
+	case errors.Is(err, limit.ErrIPRateLimit):
+		rt.rateLimitIP.Inc()
 	case errors.Is(err, limit.ErrRateLimit):
 		rt.rateLimit.Inc()
 	case errors.Is(err, limit.ErrMaxLimit):
 		rt.maxLimit.Inc()
+	case errors.Is(err, limit.ErrShed):
+		rt.shed.Inc()
 	case errors.Is(err, context.Canceled):
 		rt.drop.Inc()
 	default:
@@ -125,6 +153,20 @@ func (rt *routeStats) IncStart() func() {
 	return rt.active.Dec
 }
 
+// lookupStats counts the lookups served from the cache, the lookups missing the cache and, among
+// those, the ones coalesced with a concurrent lookup.
+type lookupStats struct {
+	hit       *monitoring.Uint
+	miss      *monitoring.Uint
+	coalesced *monitoring.Uint
+}
+
+func (ls *lookupStats) Register(registry *monitoring.Registry) {
+	ls.hit = monitoring.NewUint(registry, "hit")
+	ls.miss = monitoring.NewUint(registry, "miss")
+	ls.coalesced = monitoring.NewUint(registry, "coalesced")
+}
+
 type artifactStats struct {
 	routeStats
 	notFound *monitoring.Uint
@@ -11,13 +11,14 @@ import (
 	"net"
 	"net/http"
 
-	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/proxy"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog/log"
 	"go.elastic.co/apm"
@@ -44,9 +45,13 @@ type Router struct {
 	sm     policy.SelfMonitor
 	tracer *apm.Tracer
 	bi     build.Info
+	ca     *agentcert.CA
+	limits []limit.Opt
 }
 
-func NewRouter(cfg *config.Server, bulker bulk.Bulk, ct *CheckinT, et *EnrollerT, at *ArtifactT, ack *AckT, st *StatusT, sm policy.SelfMonitor, tracer *apm.Tracer, bi build.Info) *Router {
+// NewRouter returns the router of the API, the limit options being applied to the limiter of each listener.
+// The agents may authenticate with the client certificates issued by ca when not nil.
+func NewRouter(cfg *config.Server, bulker bulk.Bulk, ct *CheckinT, et *EnrollerT, at *ArtifactT, ack *AckT, st *StatusT, sm policy.SelfMonitor, tracer *apm.Tracer, bi build.Info, ca *agentcert.CA, limits ...limit.Opt) *Router {
 	rt := &Router{
 		cfg:    cfg,
 		bulker: bulker,
@@ -58,6 +63,8 @@ func NewRouter(cfg *config.Server, bulker bulk.Bulk, ct *CheckinT, et *EnrollerT
 		st:     st,
 		tracer: tracer,
 		bi:     bi,
+		ca:     ca,
+		limits: limits,
 	}
 
 	return rt
@@ -66,7 +73,12 @@ func NewRouter(cfg *config.Server, bulker bulk.Bulk, ct *CheckinT, et *EnrollerT
 // Create a new httprouter, the passed addr is only added as a label in log messages
 func (rt *Router) newHTTPRouter(addr string) *httprouter.Router {
 	log.Info().Str("addr", addr).Interface("limits", rt.cfg.Limits).Msg("fleet-server creating new limiter")
-	limiter := limit.NewHTTPWrapper(addr, &rt.cfg.Limits)
+	limits := rt.limits
+	if rt.ct != nil {
+		// The agent limits apply to the authenticated agents
+		limits = append(limits[:len(limits):len(limits)], limit.WithAuthenticator(limitIdentifier(rt.ct.cache), limitAuthenticator(rt.bulker, rt.ct.cache)))
+	}
+	limiter := limit.NewHTTPWrapper(addr, &rt.cfg.Limits, limits...)
 
 	routes := []struct {
 		method  string
@@ -86,17 +98,17 @@ func (rt *Router) newHTTPRouter(addr string) *httprouter.Router {
 		{
 			http.MethodPost,
 			RouteCheckin,
-			limiter.WrapCheckin(rt.handleCheckin, &cntCheckin),
+			rt.withAgentCertificate(limiter.WrapCheckin(rt.handleCheckin, &cntCheckin), &cntCheckin),
 		},
 		{
 			http.MethodPost,
 			RouteAcks,
-			limiter.WrapAck(rt.handleAcks, &cntAcks),
+			rt.withAgentCertificate(limiter.WrapAck(rt.handleAcks, &cntAcks), &cntAcks),
 		},
 		{
 			http.MethodGet,
 			RouteArtifacts,
-			limiter.WrapArtifact(rt.handleArtifacts, &cntArtifacts),
+			limiter.WrapArtifact(rt.withAgentCertificate(rt.handleArtifacts, &cntArtifacts), &cntArtifacts),
 		},
 	}
 
@@ -125,6 +137,37 @@ func (rt *Router) newHTTPRouter(addr string) *httprouter.Router {
 	return router
 }
 
+// withAgentCertificate authenticates the agent of the client certificate of the request, if any,
+// see authAgent. A request without a valid certificate is rejected when the certificates are
+// required, otherwise it is authenticated by its API key alone.
+func (rt *Router) withAgentCertificate(h httprouter.Handle, cnt limit.StatIncer) httprouter.Handle {
+	if rt.ca == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		id, err := rt.ca.Verify(r.TLS)
+		switch {
+		case err == nil:
+			r = r.WithContext(agentcert.NewContext(r.Context(), id))
+		case rt.ca.Required():
+			cnt.IncError(err)
+			resp := NewHTTPErrResp(err)
+			log.WithLevel(resp.Level).
+				Err(err).
+				Str(ECSHTTPRequestID, r.Header.Get(logger.HeaderRequestID)).
+				Int(ECSHTTPResponseCode, resp.StatusCode).
+				Msg("agent certificate rejected")
+			if err := resp.Write(w); err != nil {
+				log.Error().Err(err).Msg("fail writing error response")
+			}
+			return
+		case !errors.Is(err, agentcert.ErrNoCertificate):
+			log.Debug().Err(err).Msg("agent certificate rejected, falling back to the api key")
+		}
+		h(w, r, ps)
+	}
+}
+
 // Run starts the api server on the listeners configured in the config.
 // Each listener has a unique limit.Limiter to allow for non-global rate limits.
 func (rt *Router) Run(ctx context.Context) error {
@@ -142,6 +185,21 @@ func (rt *Router) Run(ctx context.Context) error {
 	baseCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	trusted, err := proxy.NewTrusted(&rt.cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	// The TLS config is shared by the listeners, and reloaded with the files of its certificates.
+	var reloader *tlsReloader
+	if rt.cfg.TLS != nil && rt.cfg.TLS.IsEnabled() {
+		reloader, err = newTLSReloader(rt.cfg, rt.ca)
+		if err != nil {
+			return err
+		}
+		go reloader.Run(baseCtx) //nolint:errcheck // only returns the cancellation of the context
+	}
+
 	for _, addr := range listeners {
 		log.Info().
 			Str("bind", addr).
@@ -155,7 +213,7 @@ func (rt *Router) Run(ctx context.Context) error {
 			WriteTimeout:      wrto,
 			IdleTimeout:       idle,
 			ReadHeaderTimeout: rdhr,
-			Handler:           rt.newHTTPRouter(addr), // Note that we use a different router for each listener instead of wrapping with different middleware instances as it is cleaner to do
+			Handler:           proxy.Handler(trusted, rt.newHTTPRouter(addr)), // Note that we use a different router for each listener instead of wrapping with different middleware instances as it is cleaner to do
 			BaseContext:       bctx,
 			ConnState:         diagConn,
 			MaxHeaderBytes:    mhbz,
@@ -179,9 +237,7 @@ func (rt *Router) Run(ctx context.Context) error {
 			}
 		}()
 
-		var listenCfg net.ListenConfig
-
-		ln, err := listenCfg.Listen(ctx, "tcp", addr)
+		ln, err := listen(ctx, addr, rt.cfg)
 		if err != nil {
 			return err
 		}
@@ -194,28 +250,32 @@ func (rt *Router) Run(ctx context.Context) error {
 			}
 		}()
 
+		// The unix domain sockets are served over plain HTTP, their access being controlled by the
+		// permissions of their file.
+		_, unix := config.UnixSocketPath(addr)
+
+		// The PROXY protocol header precedes the TLS handshake. It is only read from the connections
+		// admitted by the Conn Limiter, the connections refused being logged with the address of the proxy.
+		if !unix && rt.cfg.TrustedProxies.ProxyProtocol {
+			ln = proxy.Listener(ln, trusted, rt.cfg.TrustedProxies.HeaderTimeout)
+		}
+
 		// Conn Limiter must be before the TLS handshake in the stack;
 		// The server should not eat the cost of the handshake if there
 		// is no capacity to service the connection.
 		// Also, it appears the HTTP2 implementation depends on the tls.Listener
 		// being at the top of the stack.
 		ln = wrapConnLimitter(ctx, ln, rt.cfg)
-
-		if rt.cfg.TLS != nil && rt.cfg.TLS.IsEnabled() {
-			commonTLSCfg, err := tlscommon.LoadTLSServerConfig(rt.cfg.TLS)
-			if err != nil {
-				return err
-			}
-			server.TLSConfig = commonTLSCfg.BuildServerConfig(rt.cfg.Host)
-
-			// Must enable http/2 in the configuration explicitly.
-			// (see https://golang.org/pkg/net/http/#Server.Serve)
-			server.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
-
+		if unix {
+			log.Info().Str("bind", addr).Msg("Exposed over a unix domain socket without TLS")
+		} else if reloader != nil {
+			server.TLSConfig = reloader.serverConfig()
 			ln = tls.NewListener(ln, server.TLSConfig)
-
 		} else {
 			log.Warn().Msg("Exposed over insecure HTTP; enablement of TLS is strongly recommended")
+			if rt.ca != nil {
+				log.Warn().Msg("Agent certificates enabled without TLS; the agents cannot present their certificate")
+			}
 		}
 
 		log.Debug().Msgf("Listening on %s", addr)
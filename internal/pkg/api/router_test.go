@@ -8,21 +8,31 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/apmtest"
 
 	fbuild "github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/proxy"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 )
 
@@ -45,10 +55,10 @@ func TestRun(t *testing.T) {
 	pm := policy.NewMonitor(bulker, pim, 5*time.Millisecond)
 	bc := checkin.NewBulk(nil)
 	ct := NewCheckinT(verCon, cfg, c, bc, pm, nil, nil, nil, nil)
-	et, err := NewEnrollerT(verCon, cfg, nil, c)
+	et, err := NewEnrollerT(verCon, cfg, nil, c, nil)
 	require.NoError(t, err)
 
-	router := NewRouter(cfg, bulker, ct, et, nil, nil, nil, nil, nil, fbuild.Info{})
+	router := NewRouter(cfg, bulker, ct, et, nil, nil, nil, nil, nil, fbuild.Info{}, nil)
 	errCh := make(chan error)
 
 	var wg sync.WaitGroup
@@ -71,3 +81,83 @@ func TestRun(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+// syncBuffer is a buffer safe for concurrent writes and reads.
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Lines() [][]byte {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n"))
+}
+
+// TestResolvedClientAddress checks that the requests of the clients of a trusted proxy are logged,
+// limited and traced with the address of their client.
+func TestResolvedClientAddress(t *testing.T) {
+	var logs syncBuffer
+	prev := log.Logger
+	log.Logger = zerolog.New(&logs).Level(zerolog.DebugLevel)
+	defer func() { log.Logger = prev }()
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Limits.StatusLimit.PerIP = config.KeyLimit{Interval: time.Hour, Burst: 1}
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	rt := &Router{
+		cfg:    cfg,
+		st:     NewStatusT(cfg, nil, c),
+		sm:     &mockPolicyMonitor{client.UnitStateHealthy},
+		tracer: tracer.Tracer,
+	}
+	trusted, err := proxy.NewTrusted(&config.TrustedProxies{CIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	h := proxy.Handler(trusted, rt.newHTTPRouter("test"))
+
+	request := func(clientIP string) int {
+		r := httptest.NewRequest(http.MethodGet, RouteStatus, nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set(proxy.HeaderForwardedFor, clientIP)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, request("203.0.113.1"))
+
+	t.Run("logs", func(t *testing.T) {
+		var logged []interface{}
+		for _, line := range logs.Lines() {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal(line, &entry))
+			if entry["message"] == "200 HTTP Request" {
+				logged = append(logged, entry[logger.ECSClientAddress])
+			}
+		}
+		assert.Equal(t, []interface{}{"203.0.113.1"}, logged)
+	})
+	t.Run("limits", func(t *testing.T) {
+		assert.Equal(t, http.StatusTooManyRequests, request("203.0.113.1"))
+		assert.Equal(t, http.StatusOK, request("203.0.113.2"), "the other clients of the proxy are not limited")
+	})
+	t.Run("audit", func(t *testing.T) {
+		tracer.Flush(nil)
+		transactions := tracer.Payloads().Transactions
+		require.NotEmpty(t, transactions)
+		require.NotNil(t, transactions[0].Context)
+		require.NotNil(t, transactions[0].Context.Request)
+		require.NotNil(t, transactions[0].Context.Request.Socket)
+		assert.Equal(t, "203.0.113.1", transactions[0].Context.Request.Socket.RemoteAddress)
+	})
+}
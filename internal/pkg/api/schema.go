@@ -50,6 +50,7 @@ type AgentAction struct {
 type EnrollRequest struct {
 	Type     string `json:"type"`
 	SharedID string `json:"shared_id"`
+	CSR      string `json:"csr,omitempty"` // PEM certificate signing request of the client certificate
 	Meta     struct {
 		User  json.RawMessage `json:"user_provided"`
 		Local json.RawMessage `json:"local"`
@@ -70,6 +71,11 @@ type EnrollResponseItem struct {
 	AccessAPIKey   string          `json:"access_api_key"`
 	Status         string          `json:"status"`
 	Tags           []string        `json:"tags"`
+
+	// The client certificate issued when the agent certificates are enabled, and its key unless the
+	// request had a certificate signing request.
+	ClientCertificate string `json:"client_certificate,omitempty"`
+	ClientKey         string `json:"client_key,omitempty"`
 }
 
 type EnrollResponse struct {
@@ -175,4 +181,7 @@ type StatusResponse struct {
 	Name    string                 `json:"name"`
 	Status  string                 `json:"status"`
 	Version *StatusResponseVersion `json:"version,omitempty"`
+
+	// CATrustedFingerprint is the fingerprint of the self-signed bootstrap CA of the server, if any.
+	CATrustedFingerprint string `json:"ca_trusted_fingerprint,omitempty"`
 }
@@ -6,9 +6,16 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	slog "log"
 	"net"
 	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
@@ -22,11 +29,14 @@ func diagConn(c net.Conn, s http.ConnState) {
 		return
 	}
 
-	log.Trace().
-		Str("local", c.LocalAddr().String()).
-		Str("remote", c.RemoteAddr().String()).
-		Str("state", s.String()).
-		Msg("connection state change")
+	// The remote address of a connection of a trusted proxy blocks on its PROXY protocol header, it is
+	// only read when traced
+	if e := log.Trace(); e.Enabled() {
+		e.Str("local", c.LocalAddr().String()).
+			Str("remote", c.RemoteAddr().String()).
+			Str("state", s.String()).
+			Msg("connection state change")
+	}
 
 	switch s {
 	case http.StateNew:
@@ -52,6 +62,171 @@ func wrapConnLimitter(_ context.Context, ln net.Listener, cfg *config.Server) ne
 	return ln
 }
 
+// listen listens on the bind address, a TCP address or a unix domain socket.
+//
+// The socket is bound in a private directory, where it gets its permissions and its ownership
+// before it is moved to its path, so that it is never reachable with broader permissions.
+func listen(ctx context.Context, addr string, cfg *config.Server) (net.Listener, error) {
+	var listenCfg net.ListenConfig
+
+	path, ok := config.UnixSocketPath(addr)
+	if !ok {
+		return listenCfg.Listen(ctx, "tcp", addr)
+	}
+
+	if err := removeStaleSocket(ctx, path); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".fleet-server")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the socket %s: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := listenCfg.Listen(ctx, "unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := ln.(*net.UnixListener) //nolint:errcheck // a unix listener
+	ul.SetUnlinkOnClose(false)
+	if err := chownSocket(tmp, &cfg.Socket); err != nil {
+		ul.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ul.Close()
+		return nil, fmt.Errorf("failed to move the socket to %s: %w", path, err)
+	}
+	return &unixListener{
+		UnixListener: ul,
+		addr:         &net.UnixAddr{Name: path, Net: "unix"},
+	}, nil
+}
+
+// removeStaleSocket removes the socket left at path by a run that did not exit cleanly, which would
+// fail the bind. The socket is only removed once the connections to it are refused, a socket in use
+// failing the bind, as any file at path that is not a socket.
+func removeStaleSocket(ctx context.Context, path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("failed to bind %s: file exists and is not a socket", path)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("failed to bind %s: socket in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("failed to check the socket %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the stale socket %s: %w", path, err)
+	}
+	return nil
+}
+
+// chownSocket sets the permissions and the ownership of the socket file.
+func chownSocket(path string, cfg *config.ServerSocket) error {
+	mode, err := cfg.FileMode()
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set the permissions of the socket %s: %w", path, err)
+	}
+	if cfg.User == "" && cfg.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if cfg.User != "" {
+		if uid, err = lookupID(cfg.User, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown socket user %q: %w", cfg.User, err)
+		}
+	}
+	if cfg.Group != "" {
+		if gid, err = lookupID(cfg.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown socket group %q: %w", cfg.Group, err)
+		}
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to set the ownership of the socket %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookupID returns the numeric id of a user or group given by name or id.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// unixListener is a listener of a unix domain socket, the remote address of its connections being
+// the path of the socket. The clients of a socket are usually unnamed, which would leave their
+// requests without a client address to log or to rate limit by.
+type unixListener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+}
+
+// Close closes the listener and removes its socket, bound at another path, see listen.
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if rerr := os.Remove(l.addr.Name); rerr != nil && !errors.Is(rerr, os.ErrNotExist) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	return &unixConn{UnixConn: conn, remote: l.addr}, nil
+}
+
+type unixConn struct {
+	*net.UnixConn
+	remote net.Addr
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
 type stubLogger struct {
 }
 
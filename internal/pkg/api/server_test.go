@@ -0,0 +1,97 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration && !windows
+// +build !integration,!windows
+
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	// The socket paths are limited to about a hundred bytes, shorter than some temporary directories
+	dir, err := os.MkdirTemp("", "fleet")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fleet.sock")
+
+	// A stale socket does not fail the bind
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Socket.Mode = "0600"
+	cfg.Socket.Group = strconv.Itoa(os.Getgid())
+	ln, err := listen(context.Background(), "unix://"+path, cfg)
+	require.NoError(t, err)
+	ln = wrapConnLimitter(context.Background(), ln, cfg)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr) //nolint:errcheck // test handler
+	})}
+	go server.Serve(ln) //nolint:errcheck // closed by the test
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://fleet-server/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, path, string(body), "the client address is the socket")
+}
+
+func TestListenUnixSocketInUse(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fleet")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fleet.sock")
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+
+	live, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	_, err = listen(context.Background(), "unix://"+path, cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "socket in use", "a socket in use is not removed")
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+	live.Close()
+
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	_, err = listen(context.Background(), "unix://"+path, cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a socket", "a file that is not a socket is not removed")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no bind directory is left behind")
+}
@@ -0,0 +1,162 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tlsReloader serves the TLS config of the server, reloaded when the files of its certificate, key
+// or certificate authorities change. The listeners are not restarted: the handshakes following a
+// reload use the new config, the established connections, e.g. the long polls, keep the old one.
+type tlsReloader struct {
+	cfg     *config.Server
+	ca      *agentcert.CA
+	current atomic.Value // *tls.Config
+	digest  [sha256.Size]byte
+}
+
+// newTLSReloader returns the reloader of the TLS config of cfg, the client certificates of the agents
+// being requested when ca is not nil.
+func newTLSReloader(cfg *config.Server, ca *agentcert.CA) (*tlsReloader, error) {
+	r := &tlsReloader{
+		cfg: cfg,
+		ca:  ca,
+	}
+	r.digest = r.files()
+	tlsCfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.swap(tlsCfg)
+	return r, nil
+}
+
+// serverConfig returns the TLS config of a listener, resolved to the current config on each
+// handshake.
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{ //nolint:gosec // the handshakes use the config returned by GetConfigForClient
+		// Must enable http/2 in the configuration explicitly.
+		// (see https://golang.org/pkg/net/http/#Server.Serve)
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// Run checks the files on each interval until ctx is cancelled, reloading the config when they change.
+func (r *tlsReloader) Run(ctx context.Context) error {
+	if r.cfg.TLSReload.Interval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(r.cfg.TLSReload.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			r.reload()
+		}
+	}
+}
+
+// reload reloads the config if the files changed since the last reload. The current config is kept
+// when the new one fails to load, e.g. while the certificate is written but not yet its key; it is
+// loaded again on the next change.
+func (r *tlsReloader) reload() {
+	digest := r.files()
+	if digest == r.digest {
+		return
+	}
+	r.digest = digest
+
+	tlsCfg, err := r.load()
+	if err != nil {
+		cntTLSReloadFail.Inc()
+		log.Error().Err(err).Msg("Fail to reload the TLS certificate, keeping the current one")
+		return
+	}
+	cntTLSReload.Inc()
+	r.swap(tlsCfg)
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	commonTLSCfg, err := tlscommon.LoadTLSServerConfig(r.cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := commonTLSCfg.BuildServerConfig(r.cfg.Host)
+	tlsCfg.NextProtos = []string{"h2", "http/1.1"}
+	if r.ca != nil {
+		r.ca.ConfigureServer(tlsCfg)
+	}
+	return tlsCfg, nil
+}
+
+// swap makes tlsCfg the current config and reports the expiry of its certificate.
+func (r *tlsReloader) swap(tlsCfg *tls.Config) {
+	r.current.Store(tlsCfg)
+	if len(tlsCfg.Certificates) == 0 || len(tlsCfg.Certificates[0].Certificate) == 0 {
+		return
+	}
+	leaf, err := x509.ParseCertificate(tlsCfg.Certificates[0].Certificate[0])
+	if err != nil {
+		log.Warn().Err(err).Msg("Fail to parse the TLS certificate")
+		return
+	}
+	gaugeTLSNotAfter.Set(leaf.NotAfter.Unix())
+	log.Info().
+		Str("subject", leaf.Subject.String()).
+		Time("not_after", leaf.NotAfter).
+		Msg("TLS certificate loaded")
+}
+
+// files returns the digest of the files of the certificate, key and certificate authorities. The
+// certificates given inline in the config never change, nor do they contribute to the digest.
+func (r *tlsReloader) files() [sha256.Size]byte {
+	tlsCfg := r.cfg.TLS
+	paths := append([]string{tlsCfg.Certificate.Certificate, tlsCfg.Certificate.Key}, tlsCfg.CAs...)
+
+	h := sha256.New()
+	for _, path := range paths {
+		if path == "" || tlscommon.IsPEMString(path) {
+			continue
+		}
+		io.WriteString(h, path) //nolint:errcheck // hashes do not fail
+		f, err := os.Open(path)
+		if err != nil {
+			// A missing file is part of the state, e.g. between the removal and the creation of a file
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Debug().Err(err).Str("path", path).Msg("Fail to read TLS file")
+			}
+			continue
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			log.Debug().Err(err).Str("path", path).Msg("Fail to read TLS file")
+		}
+	}
+
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
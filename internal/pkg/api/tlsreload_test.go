@@ -0,0 +1,95 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// writeCertificate writes a new certificate and its key to the files, and returns the certificate.
+func writeCertificate(t *testing.T, ca *agentcert.CA, certPath, keyPath string) []byte {
+	t.Helper()
+	certPEM, keyPEM, err := ca.Issue("fleet-server", nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return pair.Certificate[0]
+}
+
+// handshake returns the certificate served by the listener.
+func handshake(t *testing.T, ln net.Listener) []byte {
+	t.Helper()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake() //nolint:errcheck // checked by the client
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true}) //nolint:gosec // test certificate
+	require.NoError(t, err)
+	defer conn.Close()
+	peers := conn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, peers)
+	return peers[0].Raw
+}
+
+func TestTLSReload(t *testing.T) {
+	ca, err := agentcert.NewTestCA(false)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeCertificate(t, ca, certPath, keyPath)
+
+	cfg := &config.Server{
+		TLS: &tlscommon.ServerConfig{
+			Certificate: tlscommon.CertificateConfig{Certificate: certPath, Key: keyPath},
+		},
+		TLSReload: config.TLSReload{Interval: time.Second},
+	}
+	r, err := newTLSReloader(cfg, nil)
+	require.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.serverConfig())
+	require.NoError(t, err)
+	defer ln.Close()
+	assert.Equal(t, first, handshake(t, ln))
+
+	reloads, fails := cntTLSReload.Get(), cntTLSReloadFail.Get()
+	r.reload()
+	assert.Equal(t, reloads, cntTLSReload.Get(), "not reloaded while the files are unchanged")
+
+	second := writeCertificate(t, ca, certPath, keyPath)
+	r.reload()
+	assert.Equal(t, reloads+1, cntTLSReload.Get())
+	assert.Equal(t, second, handshake(t, ln), "served by the same listener")
+	leaf, err := x509.ParseCertificate(second)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.NotAfter.Unix(), gaugeTLSNotAfter.Get())
+
+	require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0600))
+	r.reload()
+	assert.Equal(t, fails+1, cntTLSReloadFail.Get())
+	assert.Equal(t, second, handshake(t, ln), "the current certificate is kept")
+}
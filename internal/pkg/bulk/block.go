@@ -14,12 +14,13 @@ type Buf = danger.Buf
 // However, the multiOp API's will allocate directly in large blocks.
 
 type bulkT struct {
-	action actionT    // requested actions
-	flags  flagsT     // execution flags
-	idx    int32      // idx of originating request, used in mulitOp
-	ch     chan respT // response channel, caller is waiting synchronously
-	buf    Buf        // json payload to be sent to elastic
-	next   *bulkT     // pointer to next bulkT, used for fast internal queueing
+	action  actionT    // requested actions
+	flags   flagsT     // execution flags
+	idx     int32      // idx of originating request, used in mulitOp
+	ch      chan respT // response channel, caller is waiting synchronously
+	buf     Buf        // json payload to be sent to elastic
+	next    *bulkT     // pointer to next bulkT, used for fast internal queueing
+	retries uint8      // number of times the action was retried
 }
 
 type flagsT int8
@@ -70,6 +71,7 @@ func (blk *bulkT) reset() {
 	blk.idx = 0
 	blk.buf.Reset()
 	blk.next = nil
+	blk.retries = 0
 }
 
 type respT struct {
@@ -0,0 +1,183 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// ErrCircuitOpen is returned without calling elasticsearch while it is considered unavailable.
+var ErrCircuitOpen = errors.New("elasticsearch unavailable: circuit breaker open")
+
+type breakerState int32
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// IsUnavailable returns true if the error means that elasticsearch could not be reached or could
+// not serve the request, as opposed to an error returned by elasticsearch for the request itself.
+func IsUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var esErr *es.ErrElastic
+	if errors.As(err, &esErr) {
+		switch esErr.Status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// transport errors
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// breakerT fails the requests fast while elasticsearch is unavailable.
+//
+// The breaker opens after threshold consecutive flushes failed because elasticsearch is unavailable.
+// Once the cooldown elapsed, it is half-open: a single request is let through as a probe, the others
+// keep failing fast. The breaker closes on the first successful flush, and opens again if the probe
+// fails. A probe that never completes is replaced after another cooldown.
+type breakerT struct {
+	threshold int
+	cooldown  time.Duration
+
+	mut      sync.Mutex
+	state    breakerState
+	failures int
+	since    time.Time // time of the last transition to open, or of the last probe when half-open
+	lastErr  error
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breakerT {
+	return &breakerT{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow returns ErrCircuitOpen if the request must fail fast. When half-open, the request allowed
+// is the probe.
+func (c *breakerT) allow() error {
+	if c.threshold <= 0 {
+		return nil
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	switch c.state {
+	case breakerClosed:
+		return nil
+	case breakerOpen:
+		if time.Since(c.since) < c.cooldown {
+			break
+		}
+		c.setState(breakerHalfOpen)
+		c.since = time.Now()
+		return nil
+	case breakerHalfOpen:
+		if time.Since(c.since) < c.cooldown {
+			break
+		}
+		// previous probe is lost, send another one
+		c.since = time.Now()
+		return nil
+	}
+
+	cntBreakerRejected.Inc()
+	return ErrCircuitOpen
+}
+
+// check returns ErrCircuitOpen while the breaker is not closed, without taking the probe.
+// Used by the requests that do not report their outcome to the breaker.
+func (c *breakerT) check() error {
+	if c.threshold <= 0 {
+		return nil
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.state == breakerClosed {
+		return nil
+	}
+	cntBreakerRejected.Inc()
+	return ErrCircuitOpen
+}
+
+// record updates the breaker with the outcome of a request to elasticsearch.
+func (c *breakerT) record(err error) {
+	if c.threshold <= 0 || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if !IsUnavailable(err) {
+		c.failures = 0
+		c.lastErr = nil
+		if c.state != breakerClosed {
+			log.Info().Str("mod", kModBulk).Msg("Elasticsearch available again, circuit breaker closed")
+			c.setState(breakerClosed)
+		}
+		return
+	}
+
+	c.failures++
+	c.lastErr = err
+	if c.state == breakerHalfOpen || (c.state == breakerClosed && c.failures >= c.threshold) {
+		log.Warn().
+			Err(err).
+			Str("mod", kModBulk).
+			Int("failures", c.failures).
+			Dur("cooldown", c.cooldown).
+			Msg("Elasticsearch unavailable, circuit breaker open")
+		c.setState(breakerOpen)
+		c.since = time.Now()
+		cntBreakerOpened.Inc()
+	}
+}
+
+// err returns the last error that opened the breaker, nil while it is closed.
+func (c *breakerT) err() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.state == breakerClosed {
+		return nil
+	}
+	return c.lastErr
+}
+
+// setState changes the state of the breaker.
+// WARNING: Expects mutex locked.
+func (c *breakerT) setState(s breakerState) {
+	c.state = s
+	gaugeBreakerState.Set(int64(s))
+}
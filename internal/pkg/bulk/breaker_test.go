@@ -0,0 +1,117 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{errConnRefused, true},
+		{ErrCircuitOpen, true},
+		{fmt.Errorf("search: %w", ErrCircuitOpen), true},
+		{&es.ErrElastic{Status: http.StatusServiceUnavailable}, true},
+		{&es.ErrElastic{Status: http.StatusBadRequest}, false},
+		{es.ErrElasticVersionConflict, false},
+		{es.ErrIndexNotFound, false},
+		{fmt.Errorf("search: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("agent not found"), false},
+		{fmt.Errorf("decode: %w", errors.New("invalid character")), false},
+	}
+	for _, test := range tests {
+		if got := IsUnavailable(test.err); got != test.expect {
+			t.Errorf("%v: expected %v, got %v", test.err, test.expect, got)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	c := newBreaker(2, 20*time.Millisecond)
+
+	c.record(errConnRefused)
+	if err := c.allow(); err != nil {
+		t.Fatalf("expected closed breaker after a single failure, got %v", err)
+	}
+	c.record(errConnRefused)
+	if err := c.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open breaker, got %v", err)
+	}
+	if err := c.err(); !errors.Is(err, errConnRefused) {
+		t.Fatalf("expected the error that opened the breaker, got %v", err)
+	}
+
+	// half-open, a single probe goes through
+	time.Sleep(30 * time.Millisecond)
+	if err := c.allow(); err != nil {
+		t.Fatalf("expected the probe to be allowed, got %v", err)
+	}
+	if err := c.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a single probe, got %v", err)
+	}
+
+	// failed probe opens the breaker again
+	c.record(errConnRefused)
+	if err := c.check(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open breaker after a failed probe, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := c.allow(); err != nil {
+		t.Fatalf("expected the probe to be allowed, got %v", err)
+	}
+	c.record(nil)
+	if err := c.allow(); err != nil {
+		t.Fatalf("expected closed breaker after a successful probe, got %v", err)
+	}
+	if err := c.err(); err != nil {
+		t.Fatalf("expected no error once closed, got %v", err)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	c := newBreaker(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		c.record(errConnRefused)
+	}
+	if err := c.allow(); err != nil {
+		t.Fatalf("expected disabled breaker, got %v", err)
+	}
+}
+
+func TestBreakerFailFast(t *testing.T) {
+	bulker := NewBulker(nil, nil, WithCircuitBreaker(1, time.Hour))
+	bulker.breaker.record(errConnRefused)
+
+	if err := bulker.Update(context.Background(), "testidx", "id", []byte(`{"doc":{}}`)); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected update to fail fast, got %v", err)
+	}
+	if _, err := bulker.MUpdate(context.Background(), []MultiOp{{ID: "id", Index: "testidx", Body: []byte(`{"doc":{}}`)}}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected multi update to fail fast, got %v", err)
+	}
+	if _, err := bulker.APIKeyAuth(context.Background(), APIKey{ID: "id", Key: "key"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected api key auth to fail fast, got %v", err)
+	}
+	if err := bulker.Unavailable(); !errors.Is(err, errConnRefused) {
+		t.Errorf("expected elasticsearch unavailable, got %v", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -370,3 +371,80 @@ func BenchmarkMockBulk(b *testing.B) {
 		b.Run(strconv.Itoa(n), bindFunc(n))
 	}
 }
+
+func TestWriteBulkMeta(t *testing.T) {
+	bulker := NewBulker(nil, nil)
+
+	tests := []struct {
+		name   string
+		opts   []Opt
+		expect string
+	}{
+		{
+			"plain",
+			nil,
+			`{"update":{"_id":"myid","_index":"testidx"}}` + "\n",
+		},
+		{
+			"retry on conflict",
+			[]Opt{WithRetryOnConflict(3)},
+			`{"update":{"_id":"myid","retry_on_conflict":3,"_index":"testidx"}}` + "\n",
+		},
+		{
+			"if seq_no",
+			[]Opt{WithIfSeqNo(42, 7)},
+			`{"update":{"_id":"myid","if_seq_no":42,"if_primary_term":7,"_index":"testidx"}}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt := bulker.parseOpts(test.opts...)
+
+			var buf Buf
+			if err := bulker.writeBulkMeta(&buf, ActionUpdate.String(), "testidx", "myid", &opt); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf.Bytes()) != test.expect {
+				t.Errorf("expected %q, got %q", test.expect, buf.Bytes())
+			}
+			if sz := bulker.calcBulkSz(ActionUpdate.String(), "testidx", "myid", &opt, nil); sz != buf.Len() {
+				t.Errorf("expected calculated size %d, got %d", buf.Len(), sz)
+			}
+		})
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	// create a bulker, but don't bother running it
+	bulker := NewBulker(nil, nil, WithBlockQueueSize(4), WithMaxPending(8))
+
+	if p := bulker.Backpressure(); p != 0 {
+		t.Errorf("expected no backpressure when idle, got %v", p)
+	}
+
+	bulker.ch[kQueueBulk] <- bulker.newBlk(ActionUpdate, optionsT{})
+	bulker.ch[kQueueBulk] <- bulker.newBlk(ActionUpdate, optionsT{})
+	if p := bulker.Backpressure(); p != 0.5 {
+		t.Errorf("expected backpressure of the block queue 0.5, got %v", p)
+	}
+
+	atomic.StoreInt64(&bulker.inflight, 6)
+	if p := bulker.Backpressure(); p != 0.75 {
+		t.Errorf("expected backpressure of the pending flushes 0.75, got %v", p)
+	}
+}
+
+func TestFlushStats(t *testing.T) {
+	// create a bulker, but don't bother running it
+	bulker := NewBulker(nil, nil)
+
+	bulker.recordFlush(100*time.Millisecond, nil)
+	bulker.recordFlush(300*time.Millisecond, errConnRefused)
+	bulker.recordFlush(time.Second, context.Canceled)
+
+	expected := FlushStats{Count: 2, Failed: 1, Latency: 400 * time.Millisecond}
+	if stats := bulker.FlushStats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
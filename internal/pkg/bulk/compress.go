@@ -0,0 +1,109 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Request compression algorithms
+const (
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+// compressorT compresses the request bodies sent to elasticsearch at or above a size threshold.
+// The writers are pooled, as allocating a flate writer is expensive.
+type compressorT struct {
+	algo      string
+	threshold int
+	pool      sync.Pool
+}
+
+// newCompressor returns the compressor of algo, nil when the requests are not compressed.
+// The algorithm and the level are validated with the configuration, see config.ServerBulk.
+func newCompressor(algo string, level, threshold int) (*compressorT, error) {
+	var newWriter func() (resetWriteCloser, error)
+	switch algo {
+	case CompressionGzip:
+		newWriter = func() (resetWriteCloser, error) { return gzip.NewWriterLevel(io.Discard, level) }
+	case CompressionDeflate:
+		// The deflate content encoding is the zlib format, not raw deflate, see RFC 9110.
+		newWriter = func() (resetWriteCloser, error) { return zlib.NewWriterLevel(io.Discard, level) }
+	default:
+		return nil, nil
+	}
+
+	w, err := newWriter()
+	if err != nil {
+		return nil, err
+	}
+	c := &compressorT{
+		algo:      algo,
+		threshold: threshold,
+	}
+	c.pool.New = func() interface{} {
+		// the level is valid, the first writer was allocated
+		w, _ := newWriter()
+		return w
+	}
+	c.pool.Put(w)
+	return c, nil
+}
+
+type resetWriteCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compress returns the body to send and the headers to add to the request.
+// The body is returned as is when compression is disabled, below the threshold, or fails.
+func (c *compressorT) compress(queue queueT, body []byte) (io.Reader, http.Header) {
+	m := &compressMetrics[queue.ty]
+	m.raw.Add(uint64(len(body)))
+
+	if c == nil || len(body) < c.threshold {
+		m.sent.Add(uint64(len(body)))
+		return bytes.NewReader(body), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(body) / 4)
+
+	w, _ := c.pool.Get().(resetWriteCloser)
+	defer c.pool.Put(w)
+	w.Reset(&buf)
+
+	_, err := w.Write(body)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("mod", kModBulk).Str("algo", c.algo).Msg("Fail to compress request, sending it uncompressed")
+		m.sent.Add(uint64(len(body)))
+		return bytes.NewReader(body), nil
+	}
+
+	m.compressed.Inc()
+	m.sent.Add(uint64(buf.Len()))
+
+	log.Trace().
+		Str("mod", kModBulk).
+		Str("queue", queue.Type()).
+		Str("algo", c.algo).
+		Int("srcSz", len(body)).
+		Int("dstSz", buf.Len()).
+		Msg("compressing request")
+
+	return &buf, http.Header{"Content-Encoding": []string{c.algo}}
+}
@@ -0,0 +1,119 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decompressTransport wraps a transport, decompressing the request bodies before forwarding
+// them, and records the content encoding of the requests.
+type decompressTransport struct {
+	next      *retryTransport
+	encodings []string
+}
+
+func (m *decompressTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.next.mut.Lock()
+	encoding := req.Header.Get("Content-Encoding")
+	m.encodings = append(m.encodings, encoding)
+	m.next.mut.Unlock()
+
+	var (
+		body io.Reader = req.Body
+		err  error
+	)
+	switch encoding {
+	case CompressionGzip:
+		if body, err = gzip.NewReader(req.Body); err != nil {
+			return nil, err
+		}
+	case CompressionDeflate:
+		if body, err = zlib.NewReader(req.Body); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	return m.next.Perform(req)
+}
+
+func TestRequestCompression(t *testing.T) {
+	body := []byte(`{"doc":{"padding":"` + strings.Repeat("x", 2048) + `"}}`)
+
+	tests := []struct {
+		name      string
+		algo      string
+		threshold int
+		expect    string
+	}{
+		{"none", CompressionNone, 0, ""},
+		{"gzip", CompressionGzip, 1024, CompressionGzip},
+		{"deflate", CompressionDeflate, 1024, CompressionDeflate},
+		{"below threshold", CompressionGzip, 1024 * 1024, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &decompressTransport{next: &retryTransport{status: func(string, int) int {
+				return http.StatusOK
+			}}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bulker := NewBulker(transport, nil, WithFlushInterval(time.Millisecond), WithRequestCompression(test.algo, flate.BestSpeed, test.threshold))
+			go func() { _ = bulker.Run(ctx) }()
+
+			raw := compressMetrics[kQueueBulk].raw.Get()
+			sent := compressMetrics[kQueueBulk].sent.Get()
+
+			if err := bulker.Update(ctx, "testidx", "agent1", body); err != nil {
+				t.Fatal(err)
+			}
+
+			transport.next.mut.Lock()
+			defer transport.next.mut.Unlock()
+			if len(transport.encodings) != 1 || transport.encodings[0] != test.expect {
+				t.Fatalf("expected a single request with encoding %q, got %q", test.expect, transport.encodings)
+			}
+
+			rawSz := compressMetrics[kQueueBulk].raw.Get() - raw
+			sentSz := compressMetrics[kQueueBulk].sent.Get() - sent
+			if rawSz < uint64(len(body)) {
+				t.Errorf("expected at least %d raw bytes, got %d", len(body), rawSz)
+			}
+			if test.expect == "" && sentSz != rawSz {
+				t.Errorf("expected %d bytes sent uncompressed, got %d", rawSz, sentSz)
+			}
+			if test.expect != "" && sentSz >= rawSz {
+				t.Errorf("expected less than %d bytes sent compressed, got %d", rawSz, sentSz)
+			}
+		})
+	}
+}
+
+func TestNewCompressor(t *testing.T) {
+	if c, err := newCompressor("", flate.BestSpeed, 0); c != nil || err != nil {
+		t.Errorf("expected compression disabled by default, got %v, %v", c, err)
+	}
+	if c, err := newCompressor(CompressionNone, flate.BestSpeed, 0); c != nil || err != nil {
+		t.Errorf("expected compression disabled, got %v, %v", c, err)
+	}
+	if _, err := newCompressor(CompressionGzip, 42, 0); err == nil {
+		t.Error("expected invalid level error")
+	}
+}
@@ -5,12 +5,15 @@
 package bulk
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
@@ -67,12 +70,18 @@ type Bulk interface {
 const kModBulk = "bulk"
 
 type Bulker struct {
+	inflight    int64 // flushes pending a response, accessed atomically
+	flushes     int64 // flushes completed, accessed atomically
+	failures    int64 // flushes failed, accessed atomically
+	latency     int64 // cumulated duration of the flushes in ns, accessed atomically
 	es          esapi.Transport
-	ch          chan *bulkT
+	ch          [kNumQueues]chan *bulkT // intake of the blocks, one per queue
 	opts        bulkOptT
 	blkPool     sync.Pool
 	apikeyLimit *semaphore.Weighted
 	tracer      *apm.Tracer
+	breaker     *breakerT
+	compressor  *compressorT
 }
 
 const (
@@ -83,6 +92,13 @@ const (
 	defaultBlockQueueSz      = 32 // Small capacity to allow multiOp to spin fast
 	defaultAPIKeyMaxParallel = 32
 	defaultApikeyMaxReqSize  = 100 * 1024 * 1024
+	defaultRetryMax          = 3
+	defaultRetryBackoffInit  = 100 * time.Millisecond
+	defaultRetryBackoffMax   = 5 * time.Second
+	defaultBreakerThreshold  = 5
+	defaultBreakerCooldown   = 10 * time.Second
+	defaultCompressionLevel  = flate.BestSpeed
+	defaultCompressionThresh = 1024
 )
 
 func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker {
@@ -93,14 +109,30 @@ func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker
 		return &bulkT{ch: make(chan respT, 1)}
 	}
 
-	return &Bulker{
+	compressor, err := newCompressor(bopts.compression, bopts.compressionLevel, bopts.compressionThresh)
+	if err != nil {
+		log.Warn().Err(err).Str("mod", kModBulk).Msg("Request compression disabled")
+	}
+
+	bulker := &Bulker{
 		opts:        bopts,
 		es:          es,
-		ch:          make(chan *bulkT, bopts.blockQueueSz),
 		blkPool:     sync.Pool{New: poolFunc},
 		apikeyLimit: semaphore.NewWeighted(int64(bopts.apikeyMaxParallel)),
 		tracer:      tracer,
+		breaker:     newBreaker(bopts.breakerThreshold, bopts.breakerCooldown),
+		compressor:  compressor,
+	}
+	for i := range bulker.ch {
+		bulker.ch[i] = make(chan *bulkT, bopts.blockQueueSz)
 	}
+	return bulker
+}
+
+// intake returns the channel the block is sent on to the Run loop. Each queue has its own, so
+// that a queue waiting for a concurrency slot holds back its own callers only.
+func (b *Bulker) intake(blk *bulkT) chan<- *bulkT {
+	return b.ch[blkToQueueType(blk)]
 }
 
 func (b *Bulker) Client() *elasticsearch.Client {
@@ -158,90 +190,155 @@ func (b *Bulker) Run(ctx context.Context) error {
 	timer := time.NewTimer(b.opts.flushInterval)
 	stopTimer(timer)
 	defer timer.Stop()
+	var timerOn bool
 
 	w := semaphore.NewWeighted(int64(b.opts.maxPending))
 
-	var queues [kNumQueues]queueT
+	var (
+		queues [kNumQueues]queueT
+		sems   [kNumQueues]*semaphore.Weighted
+		order  [kNumQueues]queueType
+	)
 
 	var i queueType
 	for ; i < kNumQueues; i++ {
 		queues[i].ty = i
+		sems[i] = semaphore.NewWeighted(int64(b.opts.queues[i].maxPending))
+		order[i] = i
 	}
 
-	var itemCnt int
-	var byteCnt int
+	// Flush the queues by priority
+	sort.SliceStable(order[:], func(i, j int) bool {
+		return b.opts.queues[order[i]].priority > b.opts.queues[order[j]].priority
+	})
 
-	doFlush := func() error {
+	// Signaled when a flush completes and releases its concurrency slot
+	released := make(chan struct{}, 1)
 
-		for i := range queues {
-			q := &queues[i]
-			if q.pending > 0 {
+	isFull := func(q *queueT) bool {
+		qopts := &b.opts.queues[q.ty]
+		return q.cnt >= qopts.flushThresholdCnt || q.pending >= qopts.flushThresholdSz
+	}
 
-				// Pass queue structure by value
-				if err := b.flushQueue(ctx, w, *q); err != nil {
-					return err
-				}
+	// Flush the ready queues that have a concurrency slot available, both for the queue and
+	// overall. The others stay ready until a flush completes.
+	doFlush := func() {
+		for _, ty := range order {
+			q := &queues[ty]
+			if !q.ready {
+				continue
+			}
 
-				// Reset local queue stored in array
-				q.cnt = 0
-				q.head = nil
-				q.pending = 0
+			sem := sems[ty]
+			if !sem.TryAcquire(1) {
+				continue
+			}
+			if !w.TryAcquire(1) {
+				sem.Release(1)
+				continue
 			}
-		}
 
-		// Reset threshold counters
-		itemCnt = 0
-		byteCnt = 0
+			release := func() {
+				w.Release(1)
+				sem.Release(1)
+				select {
+				case released <- struct{}{}:
+				default:
+				}
+			}
 
-		return nil
+			// Pass queue structure by value
+			b.flushQueue(ctx, *q, release)
+
+			// Reset local queue stored in array
+			queues[ty] = queueT{ty: ty}
+		}
 	}
 
-	for err == nil {
+	enqueue := func(blk *bulkT) {
+		queueIdx := blkToQueueType(blk)
+		q := &queues[queueIdx]
 
-		select {
+		// Prepend block to head of target queue
+		blk.next = q.head
+		q.head = blk
 
-		case blk := <-b.ch:
-
-			queueIdx := blkToQueueType(blk)
-			q := &queues[queueIdx]
+		// Update pending count on target queue
+		if q.cnt == 0 {
+			q.since = time.Now()
+		}
+		q.cnt += 1
+		q.pending += blk.buf.Len()
 
-			// Prepend block to head of target queue
-			blk.next = q.head
-			q.head = blk
+		// Threshold test, short circuit timer on pending count
+		if !q.ready && isFull(q) {
+			log.Trace().
+				Str("mod", kModBulk).
+				Str("queue", q.Type()).
+				Int("itemCnt", q.cnt).
+				Int("byteCnt", q.pending).
+				Msg("Flush on threshold")
 
-			// Update pending count on target queue
-			q.cnt += 1
-			q.pending += blk.buf.Len()
+			q.ready = true
+			doFlush()
+		}
+	}
 
-			// Update threshold counters
-			itemCnt += 1
-			byteCnt += blk.buf.Len()
+	for err == nil {
 
-			// Start timer on first queued item
-			if itemCnt == 1 {
-				timer.Reset(b.opts.flushInterval)
+		// Stop reading the new blocks of a full queue while it waits for a concurrency slot,
+		// the other queues keep being served.
+		var in [kNumQueues]chan *bulkT
+		pending := false
+		for i := range queues {
+			q := &queues[i]
+			if !q.ready || !isFull(q) {
+				in[i] = b.ch[i]
 			}
+			if q.cnt > 0 && !q.ready {
+				pending = true
+			}
+		}
 
-			// Threshold test, short circuit timer on pending count
-			if itemCnt >= b.opts.flushThresholdCnt || byteCnt >= b.opts.flushThresholdSz {
-				log.Trace().
-					Str("mod", kModBulk).
-					Int("itemCnt", itemCnt).
-					Int("byteCnt", byteCnt).
-					Msg("Flush on threshold")
+		// Start timer on first queued item
+		if pending && !timerOn {
+			timer.Reset(b.opts.flushInterval)
+			timerOn = true
+		}
 
-				err = doFlush()
+		select {
 
-				stopTimer(timer)
-			}
+		// One case per queue type, keep in sync with the queue types
+		case blk := <-in[kQueueBulk]:
+			enqueue(blk)
+		case blk := <-in[kQueueRead]:
+			enqueue(blk)
+		case blk := <-in[kQueueSearch]:
+			enqueue(blk)
+		case blk := <-in[kQueueFleetSearch]:
+			enqueue(blk)
+		case blk := <-in[kQueueRefreshBulk]:
+			enqueue(blk)
+		case blk := <-in[kQueueRefreshRead]:
+			enqueue(blk)
+		case blk := <-in[kQueueAPIKeyUpdate]:
+			enqueue(blk)
 
 		case <-timer.C:
+			timerOn = false
 			log.Trace().
 				Str("mod", kModBulk).
-				Int("itemCnt", itemCnt).
-				Int("byteCnt", byteCnt).
 				Msg("Flush on timer")
-			err = doFlush()
+
+			for i := range queues {
+				if queues[i].cnt > 0 {
+					queues[i].ready = true
+				}
+			}
+			doFlush()
+
+		case <-released:
+			doFlush()
 
 		case <-ctx.Done():
 			err = ctx.Err()
@@ -252,27 +349,20 @@ func (b *Bulker) Run(ctx context.Context) error {
 	return err
 }
 
-func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue queueT) error {
-	start := time.Now()
-	log.Trace().
-		Str("mod", kModBulk).
-		Int("cnt", queue.cnt).
-		Int("szPending", queue.pending).
-		Str("queue", queue.Type()).
-		Msg("flushQueue Wait")
-
-	if err := w.Acquire(ctx, 1); err != nil {
-		return err
-	}
+// flushQueue flushes the queue in the background, release is called once the flush completes.
+func (b *Bulker) flushQueue(ctx context.Context, queue queueT, release func()) {
+	wait := time.Since(queue.since)
+	waitMetrics[queue.ty].observe(wait)
 
 	log.Trace().
 		Str("mod", kModBulk).
 		Int("cnt", queue.cnt).
-		Dur("tdiff", time.Since(start)).
+		Dur("wait", wait).
 		Int("szPending", queue.pending).
 		Str("queue", queue.Type()).
-		Msg("flushQueue Acquired")
+		Msg("flushQueue")
 
+	atomic.AddInt64(&b.inflight, 1)
 	go func() {
 		start := time.Now()
 
@@ -284,7 +374,8 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 			defer trans.End()
 		}
 
-		defer w.Release(1)
+		defer release()
+		defer atomic.AddInt64(&b.inflight, -1)
 
 		var err error
 		switch queue.ty {
@@ -295,9 +386,14 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 		case kQueueAPIKeyUpdate:
 			err = b.flushUpdateAPIKey(ctx, queue)
 		default:
+			// The queue is LIFO; send the items in arrival order so that the
+			// operations of a caller are applied in the order they were issued.
+			queue.head = reverseQueue(queue.head)
 			err = b.flushBulk(ctx, queue)
 		}
 
+		b.breaker.record(err)
+		b.recordFlush(time.Since(start), err)
 		if err != nil {
 			failQueue(queue, err)
 		}
@@ -313,7 +409,70 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 
 	}()
 
-	return nil
+}
+
+// Unavailable returns the error that made elasticsearch unavailable while the circuit breaker
+// is open, nil otherwise.
+func (b *Bulker) Unavailable() error {
+	return b.breaker.err()
+}
+
+// FlushStats are the cumulated outcomes of the flushes of the bulk engine since it started.
+type FlushStats struct {
+	Count   uint64        // flushes completed
+	Failed  uint64        // flushes failed, the cancelled ones excepted
+	Latency time.Duration // cumulated duration of the flushes
+}
+
+// FlushStats returns the cumulated outcomes of the flushes, from which the latency and the error
+// rate of elasticsearch over a period can be computed.
+func (b *Bulker) FlushStats() FlushStats {
+	return FlushStats{
+		Count:   uint64(atomic.LoadInt64(&b.flushes)),
+		Failed:  uint64(atomic.LoadInt64(&b.failures)),
+		Latency: time.Duration(atomic.LoadInt64(&b.latency)),
+	}
+}
+
+// recordFlush records the outcome of a flush in the flush stats.
+func (b *Bulker) recordFlush(d time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&b.failures, 1)
+	}
+	atomic.AddInt64(&b.latency, int64(d))
+	atomic.AddInt64(&b.flushes, 1)
+}
+
+// Backpressure reports the load of the bulk engine, from 0 when idle to 1 when saturated.
+// It is the largest of the fill ratio of the block queues and of the flushes pending a response.
+func (b *Bulker) Backpressure() float64 {
+	var queued, inflight float64
+	for _, ch := range b.ch {
+		if c := cap(ch); c > 0 && float64(len(ch))/float64(c) > queued {
+			queued = float64(len(ch)) / float64(c)
+		}
+	}
+	if b.opts.maxPending > 0 {
+		inflight = float64(atomic.LoadInt64(&b.inflight)) / float64(b.opts.maxPending)
+	}
+	if queued > inflight {
+		return queued
+	}
+	return inflight
+}
+
+func reverseQueue(head *bulkT) *bulkT {
+	var prev *bulkT
+	for n := head; n != nil; {
+		next := n.next
+		n.next = prev
+		prev = n
+		n = next
+	}
+	return prev
 }
 
 func failQueue(queue queueT, err error) {
@@ -327,11 +486,7 @@ func failQueue(queue queueT, err error) {
 }
 
 func (b *Bulker) parseOpts(opts ...Opt) optionsT {
-	var opt optionsT
-	for _, o := range opts {
-		o(&opt)
-	}
-	return opt
+	return ParseOpts(opts...)
 }
 
 func (b *Bulker) newBlk(action actionT, opts optionsT) *bulkT {
@@ -383,9 +538,13 @@ func (b *Bulker) validateBody(body []byte) error {
 func (b *Bulker) dispatch(ctx context.Context, blk *bulkT) respT {
 	start := time.Now()
 
+	if err := b.breaker.allow(); err != nil {
+		return respT{err: err}
+	}
+
 	// Dispatch to bulk Run loop
 	select {
-	case b.ch <- blk:
+	case b.intake(blk) <- blk:
 	case <-ctx.Done():
 		log.Error().
 			Err(ctx.Err()).
@@ -0,0 +1,111 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+type retryMetricsT struct {
+	retried   *monitoring.Uint // items queued again after a transient error
+	exhausted *monitoring.Uint // items failed after using all their retries
+}
+
+type compressMetricsT struct {
+	raw        *monitoring.Uint // request body bytes before compression
+	sent       *monitoring.Uint // request body bytes sent, after compression
+	compressed *monitoring.Uint // requests sent compressed
+}
+
+// histogramT counts durations in cumulative buckets: each bucket counts the durations up to its bound.
+type histogramT struct {
+	bounds  []time.Duration
+	buckets []*monitoring.Uint
+	count   *monitoring.Uint
+	sumMs   *monitoring.Uint
+}
+
+// waitBounds are the bounds of the buckets of the queue wait histograms.
+var waitBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+func newHistogram(registry *monitoring.Registry, bounds []time.Duration) histogramT {
+	h := histogramT{
+		bounds:  bounds,
+		buckets: make([]*monitoring.Uint, len(bounds)),
+		count:   monitoring.NewUint(registry, "count"),
+		sumMs:   monitoring.NewUint(registry, "sum_ms"),
+	}
+	for i, bound := range bounds {
+		h.buckets[i] = monitoring.NewUint(registry, fmt.Sprintf("le_%dms", bound.Milliseconds()))
+	}
+	return h
+}
+
+func (h histogramT) observe(d time.Duration) {
+	h.count.Inc()
+	h.sumMs.Add(uint64(d.Milliseconds()))
+	for i, bound := range h.bounds {
+		if d <= bound {
+			h.buckets[i].Inc()
+		}
+	}
+}
+
+var (
+	registry        *monitoring.Registry
+	waitMetrics     [kNumQueues]histogramT // time from the arrival of the oldest item of a queue to its flush
+	retryMetrics    [kNumQueues]retryMetricsT
+	compressMetrics [kNumQueues]compressMetricsT
+
+	gaugeBreakerState  *monitoring.Int  // 0 closed, 1 open, 2 half-open
+	cntBreakerOpened   *monitoring.Uint // transitions to open
+	cntBreakerRejected *monitoring.Uint // requests failed fast
+)
+
+func init() {
+	registry = monitoring.Default.NewRegistry("bulk")
+
+	retryRegistry := registry.NewRegistry("retry")
+	for i := range retryMetrics {
+		queueRegistry := retryRegistry.NewRegistry(queueT{ty: queueType(i)}.Type())
+		retryMetrics[i] = retryMetricsT{
+			retried:   monitoring.NewUint(queueRegistry, "retried"),
+			exhausted: monitoring.NewUint(queueRegistry, "exhausted"),
+		}
+	}
+
+	waitRegistry := registry.NewRegistry("wait")
+	for i := range waitMetrics {
+		waitMetrics[i] = newHistogram(waitRegistry.NewRegistry(queueT{ty: queueType(i)}.Type()), waitBounds)
+	}
+
+	compressRegistry := registry.NewRegistry("compression")
+	for i := range compressMetrics {
+		queueRegistry := compressRegistry.NewRegistry(queueT{ty: queueType(i)}.Type())
+		compressMetrics[i] = compressMetricsT{
+			raw:        monitoring.NewUint(queueRegistry, "raw_bytes"),
+			sent:       monitoring.NewUint(queueRegistry, "compressed_bytes"),
+			compressed: monitoring.NewUint(queueRegistry, "requests"),
+		}
+	}
+
+	breakerRegistry := registry.NewRegistry("breaker")
+	gaugeBreakerState = monitoring.NewInt(breakerRegistry, "state")
+	cntBreakerOpened = monitoring.NewUint(breakerRegistry, "opened")
+	cntBreakerRejected = monitoring.NewUint(breakerRegistry, "rejected")
+}
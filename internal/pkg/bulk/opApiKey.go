@@ -36,6 +36,9 @@ type esAPIKeyBulkUpdateRequest struct {
 }
 
 func (b *Bulker) APIKeyAuth(ctx context.Context, key APIKey) (*SecurityInfo, error) {
+	if err := b.breaker.check(); err != nil {
+		return nil, err
+	}
 	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
 		return nil, err
 	}
@@ -45,6 +48,9 @@ func (b *Bulker) APIKeyAuth(ctx context.Context, key APIKey) (*SecurityInfo, err
 }
 
 func (b *Bulker) APIKeyCreate(ctx context.Context, name, ttl string, roles []byte, meta interface{}) (*APIKey, error) {
+	if err := b.breaker.check(); err != nil {
+		return nil, err
+	}
 	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
 		return nil, err
 	}
@@ -54,6 +60,9 @@ func (b *Bulker) APIKeyCreate(ctx context.Context, name, ttl string, roles []byt
 }
 
 func (b *Bulker) APIKeyRead(ctx context.Context, id string, withOwner bool) (*APIKeyMetadata, error) {
+	if err := b.breaker.check(); err != nil {
+		return nil, err
+	}
 	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
 		return nil, err
 	}
@@ -63,6 +72,9 @@ func (b *Bulker) APIKeyRead(ctx context.Context, id string, withOwner bool) (*AP
 }
 
 func (b *Bulker) APIKeyInvalidate(ctx context.Context, ids ...string) error {
+	if err := b.breaker.check(); err != nil {
+		return err
+	}
 	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
 		return err
 	}
@@ -218,8 +230,9 @@ func (b *Bulker) flushUpdateAPIKey(ctx context.Context, queue queueT) error {
 	// Do NOT return a non-nil value or failQueue
 	// up the stack will fail.
 
-	for n := queue.head; n != nil; n = n.next {
-		// 'n' is invalid immediately on channel send
+	n := queue.head
+	for n != nil {
+		next := n.next // 'n' is invalid immediately on channel send
 		responseIdx := IDToResponse[idxToID[n.idx]]
 		res := responses[responseIdx]
 		select {
@@ -234,6 +247,7 @@ func (b *Bulker) flushUpdateAPIKey(ctx context.Context, queue queueT) error {
 		default:
 			panic("Unexpected blocked response channel on flushRead")
 		}
+		n = next
 	}
 	return nil
 }
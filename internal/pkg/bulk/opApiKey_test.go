@@ -0,0 +1,81 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiKeyUpdateTransport answers the bulk API key updates.
+type apiKeyUpdateTransport struct{}
+
+func (m *apiKeyUpdateTransport) Perform(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`{"updated":[],"noops":[]}`)),
+	}, nil
+}
+
+// The callers free their block as soon as it is answered; all the blocks of the queue must be answered
+// nonetheless. Run with -race to catch the blocks read after they were answered.
+func TestFlushUpdateAPIKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bulker := NewBulker(&apiKeyUpdateTransport{}, nil)
+
+	const n = 64
+	queue := queueT{ty: kQueueAPIKeyUpdate}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		body, err := json.Marshal(&apiKeyUpdateRequest{ID: "key" + strconv.Itoa(i), Roles: []byte(`{}`), RolesHash: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk := bulker.newBlk(ActionUpdateAPIKey, optionsT{})
+		blk.idx = int32(i)
+		if err := bulker.writeBulkMeta(&blk.buf, ActionUpdateAPIKey.String(), "", "key"+strconv.Itoa(i), &optionsT{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := bulker.writeBulkBody(&blk.buf, ActionUpdateAPIKey, body); err != nil {
+			t.Fatal(err)
+		}
+		blk.next = queue.head
+		queue.head = blk
+		queue.cnt++
+
+		wg.Add(1)
+		go func(blk *bulkT) {
+			defer wg.Done()
+			select {
+			case resp := <-blk.ch:
+				if resp.err != nil {
+					t.Errorf("update %d: %v", resp.idx, resp.err)
+				}
+				bulker.freeBlk(blk)
+			case <-ctx.Done():
+				t.Errorf("update %d not answered", blk.idx)
+			}
+		}(blk)
+	}
+
+	if err := bulker.flushUpdateAPIKey(ctx, queue); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
@@ -57,7 +57,7 @@ func (b *Bulker) waitBulkAction(ctx context.Context, action actionT, index, id s
 	const kSlop = 64
 	blk.buf.Grow(len(body) + kSlop)
 
-	if err := b.writeBulkMeta(&blk.buf, action.String(), index, id, opt.RetryOnConflict); err != nil {
+	if err := b.writeBulkMeta(&blk.buf, action.String(), index, id, &opt); err != nil {
 		return nil, err
 	}
 
@@ -95,7 +95,7 @@ func (b *Bulker) writeMget(buf *Buf, index, id string) error {
 	return nil
 }
 
-func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id, retry string) error {
+func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id string, opt *optionsT) error {
 	if err := b.validateMeta(index, id); err != nil {
 		return err
 	}
@@ -108,9 +108,16 @@ func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id, retry string) error
 		_, _ = buf.WriteString(id)
 		_, _ = buf.WriteString(`",`)
 	}
-	if retry != "" {
+	if opt.RetryOnConflict != "" {
 		_, _ = buf.WriteString(`"retry_on_conflict":`)
-		_, _ = buf.WriteString(retry)
+		_, _ = buf.WriteString(opt.RetryOnConflict)
+		_, _ = buf.WriteString(`,`)
+	}
+	if opt.IfSeqNo != "" {
+		_, _ = buf.WriteString(`"if_seq_no":`)
+		_, _ = buf.WriteString(opt.IfSeqNo)
+		_, _ = buf.WriteString(`,"if_primary_term":`)
+		_, _ = buf.WriteString(opt.IfPrimaryTerm)
 		_, _ = buf.WriteString(`,`)
 	}
 
@@ -141,12 +148,16 @@ func (b *Bulker) writeBulkBody(buf *Buf, action actionT, body []byte) error {
 	return nil
 }
 
-func (b *Bulker) calcBulkSz(action, idx, id, retry string, body []byte) int {
+func (b *Bulker) calcBulkSz(action, idx, id string, opt *optionsT, body []byte) int {
 	const kFraming = 19
 	metaSz := kFraming + len(action) + len(idx)
 
-	if retry != "" {
-		metaSz += 21 + len(retry)
+	if opt.RetryOnConflict != "" {
+		metaSz += 21 + len(opt.RetryOnConflict)
+	}
+
+	if opt.IfSeqNo != "" {
+		metaSz += 32 + len(opt.IfSeqNo) + len(opt.IfPrimaryTerm)
 	}
 
 	var idSz int
@@ -184,8 +195,10 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 	}
 
 	// Do actual bulk request; defer to the client
+	body, header := b.compressor.compress(queue, buf.Bytes())
 	req := esapi.BulkRequest{
-		Body: bytes.NewReader(buf.Bytes()),
+		Body:   body,
+		Header: header,
 	}
 
 	if queue.ty == kQueueRefreshBulk {
@@ -257,11 +270,23 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 	// Do NOT return a non-nil value or failQueue
 	// up the stack will fail.
 
+	var retry []*bulkT
 	n := queue.head
 	for i := range blk.Items {
 		next := n.next // 'n' is invalid immediately on channel send
 
 		item := blk.Items[i].Choose()
+		if b.retryable(item) {
+			if int(n.retries) < b.opts.retryMax {
+				n.retries++
+				retry = append(retry, n)
+				retryMetrics[queue.ty].retried.Inc()
+				n = next
+				continue
+			}
+			retryMetrics[queue.ty].exhausted.Inc()
+		}
+
 		select {
 		case n.ch <- respT{
 			err:  item.deriveError(),
@@ -275,5 +300,9 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 		n = next
 	}
 
+	if len(retry) > 0 {
+		b.retryLater(ctx, queue, retry)
+	}
+
 	return nil
 }
@@ -20,10 +20,10 @@ func BenchmarkMultiUpdateMock(b *testing.B) {
 
 	// Allocate, but don't run.  Stub the client.
 	bulker := NewBulker(nil, nil)
-	defer close(bulker.ch)
+	defer close(bulker.ch[kQueueBulk])
 
 	go func() {
-		for v := range bulker.ch {
+		for v := range bulker.ch[kQueueBulk] {
 			v.ch <- respT{nil, v.idx, nil}
 		}
 	}()
@@ -76,8 +76,10 @@ func (b *Bulker) flushRead(ctx context.Context, queue queueT) error {
 	payload = append(payload[:len(payload)-1], []byte(rSuffix)...)
 
 	// Do actual bulk request; and send response on chan
+	body, header := b.compressor.compress(queue, payload)
 	req := esapi.MgetRequest{
-		Body: bytes.NewReader(payload),
+		Body:   body,
+		Header: header,
 	}
 
 	var refresh bool
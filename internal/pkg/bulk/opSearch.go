@@ -137,16 +137,19 @@ func (b *Bulker) flushSearch(ctx context.Context, queue queueT) error {
 		err error
 	)
 
+	body, header := b.compressor.compress(queue, buf.Bytes())
 	if queue.ty == kQueueFleetSearch {
 		// Using custom _fleet/_fleet_msearch, possibly temporary
 		// Replace with regular _msearch if _fleet/_fleet_msearch implementation merges with _msearch
 		req := es.FleetMsearchRequest{
-			Body: bytes.NewReader(buf.Bytes()),
+			Body:   body,
+			Header: header,
 		}
 		res, err = req.Do(ctx, b.es)
 	} else {
 		req := esapi.MsearchRequest{
-			Body: bytes.NewReader(buf.Bytes()),
+			Body:   body,
+			Header: header,
 		}
 		res, err = req.Do(ctx, b.es)
 	}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 )
@@ -19,12 +20,26 @@ import (
 type optionsT struct {
 	Refresh            bool
 	RetryOnConflict    string
+	IfSeqNo            string
+	IfPrimaryTerm      string
 	Indices            []string
 	WaitForCheckpoints []int64
 }
 
 type Opt func(*optionsT)
 
+// Options are the transaction options of an operation.
+type Options = optionsT
+
+// ParseOpts returns the transaction options set by opts, for the implementations of Bulk.
+func ParseOpts(opts ...Opt) Options {
+	var opt optionsT
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
 func WithRefresh() Opt {
 	return func(opt *optionsT) {
 		opt.Refresh = true
@@ -37,6 +52,15 @@ func WithRetryOnConflict(n int) Opt {
 	}
 }
 
+// WithIfSeqNo makes a write conditional on the document's current sequence number and primary term.
+// The write fails with es.ErrElasticVersionConflict if the document was changed in between.
+func WithIfSeqNo(seqNo, primaryTerm int64) Opt {
+	return func(opt *optionsT) {
+		opt.IfSeqNo = strconv.FormatInt(seqNo, 10)
+		opt.IfPrimaryTerm = strconv.FormatInt(primaryTerm, 10)
+	}
+}
+
 // WithIndex sets the index when searching
 func WithIndex(idx string) Opt {
 	return func(opt *optionsT) {
@@ -63,6 +87,31 @@ type bulkOptT struct {
 	blockQueueSz      int
 	apikeyMaxParallel int
 	apikeyMaxReqSize  int
+	retryMax          int
+	retryBackoffInit  time.Duration
+	retryBackoffMax   time.Duration
+	retryStatuses     []int
+	breakerThreshold  int
+	breakerCooldown   time.Duration
+	compression       string
+	compressionLevel  int
+	compressionThresh int
+	queues            [kNumQueues]queueOptT
+}
+
+// queueOptT holds the scheduling options of a queue.
+type queueOptT struct {
+	priority          int
+	maxPending        int
+	flushThresholdCnt int
+	flushThresholdSz  int
+}
+
+func (o queueOptT) MarshalZerologObject(e *zerolog.Event) {
+	e.Int("priority", o.priority)
+	e.Int("maxPending", o.maxPending)
+	e.Int("flushThresholdCnt", o.flushThresholdCnt)
+	e.Int("flushThresholdSz", o.flushThresholdSz)
 }
 
 type BulkOpt func(*bulkOptT)
@@ -95,7 +144,7 @@ func WithMaxPending(max int) BulkOpt {
 	}
 }
 
-// WithBlockQueueSize sets the size of the internal block queue (ie. channel)
+// WithBlockQueueSize sets the size of the internal block queues (ie. channels), one per queue
 func WithBlockQueueSize(sz int) BulkOpt {
 	return func(opt *bulkOptT) {
 		opt.blockQueueSz = sz
@@ -118,6 +167,88 @@ func WithAPIKeyMaxRequestSize(maxBytes int) BulkOpt {
 	}
 }
 
+// WithRetryMax sets the number of times an item rejected with a transient error is retried.
+// Zero disables the retries.
+func WithRetryMax(max int) BulkOpt {
+	return func(opt *bulkOptT) {
+		if max >= 0 {
+			opt.retryMax = max
+		}
+	}
+}
+
+// WithRetryBackoff sets the backoff between the retries of an item, doubling from init up to max.
+func WithRetryBackoff(init, max time.Duration) BulkOpt {
+	return func(opt *bulkOptT) {
+		if init > 0 {
+			opt.retryBackoffInit = init
+		}
+		if max > 0 {
+			opt.retryBackoffMax = max
+		}
+	}
+}
+
+// WithRetryStatuses sets the statuses of the items that are retried. Defaults to 429, 502, 503 and 504.
+func WithRetryStatuses(statuses ...int) BulkOpt {
+	return func(opt *bulkOptT) {
+		if len(statuses) > 0 {
+			opt.retryStatuses = statuses
+		}
+	}
+}
+
+// WithCircuitBreaker fails the requests fast after threshold consecutive flushes failed because
+// elasticsearch is unavailable, probing it again after cooldown. Zero threshold disables the breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) BulkOpt {
+	return func(opt *bulkOptT) {
+		if threshold >= 0 {
+			opt.breakerThreshold = threshold
+		}
+		if cooldown > 0 {
+			opt.breakerCooldown = cooldown
+		}
+	}
+}
+
+// WithRequestCompression compresses the bodies of the bulk, search and read requests of at least
+// threshold bytes with gzip or deflate at the given level. Compression is disabled by default.
+func WithRequestCompression(algo string, level, threshold int) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.compression = algo
+		opt.compressionLevel = level
+		if threshold >= 0 {
+			opt.compressionThresh = threshold
+		}
+	}
+}
+
+// WithQueue overrides the scheduling of the named queue: its priority, its number of flushes pending
+// a response, and its flush thresholds. Zero values keep the defaults.
+func WithQueue(name string, priority, maxPending, flushThresholdCnt, flushThresholdSz int) BulkOpt {
+	return func(opt *bulkOptT) {
+		ty, ok := queueTypeFromName(name)
+		if !ok {
+			log.Warn().Str("mod", kModBulk).Str("queue", name).Msg("Ignore options of unknown bulk queue")
+			return
+		}
+
+		q := &opt.queues[ty]
+		if priority > 0 {
+			q.priority = priority
+		}
+		if maxPending > 0 {
+			q.maxPending = maxPending
+		}
+		if flushThresholdCnt > 0 {
+			q.flushThresholdCnt = flushThresholdCnt
+		}
+		if flushThresholdSz > 0 {
+			q.flushThresholdSz = flushThresholdSz
+		}
+	}
+}
+
 func parseBulkOpts(opts ...BulkOpt) bulkOptT {
 	bopt := bulkOptT{
 		flushInterval:     defaultFlushInterval,
@@ -127,15 +258,51 @@ func parseBulkOpts(opts ...BulkOpt) bulkOptT {
 		apikeyMaxParallel: defaultAPIKeyMaxParallel,
 		blockQueueSz:      defaultBlockQueueSz,
 		apikeyMaxReqSize:  defaultApikeyMaxReqSize,
+		retryMax:          defaultRetryMax,
+		retryBackoffInit:  defaultRetryBackoffInit,
+		retryBackoffMax:   defaultRetryBackoffMax,
+		retryStatuses:     defaultRetryStatuses,
+		breakerThreshold:  defaultBreakerThreshold,
+		breakerCooldown:   defaultBreakerCooldown,
+		compressionLevel:  defaultCompressionLevel,
+		compressionThresh: defaultCompressionThresh,
 	}
 
 	for _, f := range opts {
 		f(&bopt)
 	}
 
+	for i := range bopt.queues {
+		bopt.setQueueDefaults(queueType(i))
+	}
+
 	return bopt
 }
 
+// setQueueDefaults fills the options of a queue that were not overridden. The writes may use
+// up to three quarters of the flushes pending a response, keeping the others for the reads.
+func (o *bulkOptT) setQueueDefaults(ty queueType) {
+	q := &o.queues[ty]
+	if q.priority == 0 {
+		q.priority = defaultQueuePriority(ty)
+	}
+	if q.maxPending == 0 {
+		q.maxPending = o.maxPending
+		if isWriteQueue(ty) && o.maxPending > 1 {
+			q.maxPending = o.maxPending - o.maxPending/4
+		}
+	}
+	if q.maxPending > o.maxPending {
+		q.maxPending = o.maxPending
+	}
+	if q.flushThresholdCnt == 0 {
+		q.flushThresholdCnt = o.flushThresholdCnt
+	}
+	if q.flushThresholdSz == 0 {
+		q.flushThresholdSz = o.flushThresholdSz
+	}
+}
+
 func (o *bulkOptT) MarshalZerologObject(e *zerolog.Event) {
 	e.Dur("flushInterval", o.flushInterval)
 	e.Int("flushThresholdCnt", o.flushThresholdCnt)
@@ -144,6 +311,20 @@ func (o *bulkOptT) MarshalZerologObject(e *zerolog.Event) {
 	e.Int("blockQueueSz", o.blockQueueSz)
 	e.Int("apikeyMaxParallel", o.apikeyMaxParallel)
 	e.Int("apikeyMaxReqSize", o.apikeyMaxReqSize)
+	e.Int("retryMax", o.retryMax)
+	e.Dur("retryBackoffInit", o.retryBackoffInit)
+	e.Dur("retryBackoffMax", o.retryBackoffMax)
+	e.Ints("retryStatuses", o.retryStatuses)
+	e.Int("breakerThreshold", o.breakerThreshold)
+	e.Dur("breakerCooldown", o.breakerCooldown)
+	e.Str("compression", o.compression)
+	e.Int("compressionLevel", o.compressionLevel)
+	e.Int("compressionThresh", o.compressionThresh)
+	queues := zerolog.Dict()
+	for i, q := range o.queues {
+		queues.Object(queueT{ty: queueType(i)}.Type(), q)
+	}
+	e.Dict("queues", queues)
 }
 
 // BulkOptsFromCfg transforms config to a slize of BulkOpt
@@ -158,12 +339,21 @@ func BulkOptsFromCfg(cfg *config.Config) []BulkOpt {
 		maxKeyParallel = cfg.Output.Elasticsearch.MaxConnPerHost - bulkCfg.FlushMaxPending
 	}
 
-	return []BulkOpt{
+	opts := []BulkOpt{
 		WithFlushInterval(bulkCfg.FlushInterval),
 		WithFlushThresholdCount(bulkCfg.FlushThresholdCount),
 		WithFlushThresholdSize(bulkCfg.FlushThresholdSize),
 		WithMaxPending(bulkCfg.FlushMaxPending),
+		WithRetryMax(bulkCfg.RetryMax),
+		WithRetryBackoff(bulkCfg.RetryBackoffInit, bulkCfg.RetryBackoffMax),
+		WithRetryStatuses(bulkCfg.RetryStatuses...),
+		WithCircuitBreaker(bulkCfg.CircuitBreakerThreshold, bulkCfg.CircuitBreakerCooldown),
+		WithRequestCompression(bulkCfg.Compression, bulkCfg.CompressionLevel, bulkCfg.CompressionThreshold),
 		WithAPIKeyMaxParallel(maxKeyParallel),
 		WithAPIKeyMaxRequestSize(cfg.Output.Elasticsearch.MaxContentLength),
 	}
+	for name, q := range bulkCfg.Queues {
+		opts = append(opts, WithQueue(name, q.Priority, q.MaxPending, q.FlushThresholdCount, q.FlushThresholdSize))
+	}
+	return opts
 }
@@ -4,11 +4,15 @@
 
 package bulk
 
+import "time"
+
 type queueT struct {
 	ty      queueType
 	cnt     int
 	head    *bulkT
 	pending int
+	since   time.Time // arrival of the oldest item
+	ready   bool      // flush requested, waiting for a concurrency slot
 }
 
 type queueType int
@@ -43,3 +47,34 @@ func (q queueT) Type() string {
 	}
 	panic("unknown")
 }
+
+func queueTypeFromName(name string) (queueType, bool) {
+	for i := queueType(0); i < kNumQueues; i++ {
+		if (queueT{ty: i}).Type() == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// defaultQueuePriority returns the priority of a queue, the queues with the highest priority are
+// flushed first. The reads are on the path of the agent requests, such as the agent lookups on
+// enrollment, and go before the searches, that go before the writes.
+func defaultQueuePriority(ty queueType) int {
+	switch ty {
+	case kQueueRead, kQueueRefreshRead:
+		return 3
+	case kQueueSearch, kQueueFleetSearch:
+		return 2
+	}
+	return 1
+}
+
+// isWriteQueue returns true for the queues that write to elasticsearch.
+func isWriteQueue(ty queueType) bool {
+	switch ty {
+	case kQueueBulk, kQueueRefreshBulk, kQueueAPIKeyUpdate:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingTransport answers _bulk and _mget requests of a single item, blocking the _bulk
+// requests while the gate is closed, and records the paths in the order the requests started.
+type blockingTransport struct {
+	gate chan struct{}
+
+	mut   sync.Mutex
+	paths []string
+}
+
+func (m *blockingTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.mut.Lock()
+	m.paths = append(m.paths, req.URL.Path)
+	m.mut.Unlock()
+
+	body := `{"docs":[{"found":true,"_source":{}}]}`
+	if strings.HasSuffix(req.URL.Path, "_bulk") {
+		<-m.gate
+		body = `{"took":1,"errors":false,"items":[{"update":{"_id":"id","status":200}}]}`
+	}
+
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}, nil
+}
+
+func (m *blockingTransport) started() []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return append([]string(nil), m.paths...)
+}
+
+func waitStarted(t *testing.T, transport *blockingTransport, cnt int) {
+	t.Helper()
+	for start := time.Now(); len(transport.started()) < cnt; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected %d requests, got %v", cnt, transport.started())
+		}
+	}
+}
+
+func runBlockingBulker(t *testing.T, transport *blockingTransport, opts ...BulkOpt) (*Bulker, *sync.WaitGroup) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts = append([]BulkOpt{WithFlushInterval(time.Millisecond)}, opts...)
+	bulker := NewBulker(transport, nil, opts...)
+	go func() { _ = bulker.Run(ctx) }()
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		close(transport.gate)
+		wg.Wait()
+		cancel()
+	})
+	return bulker, &wg
+}
+
+func asyncUpdate(t *testing.T, wg *sync.WaitGroup, bulker *Bulker, id string) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bulker.Update(context.Background(), "testidx", id, []byte(`{"doc":{}}`)); err != nil {
+			t.Error(err)
+		}
+	}()
+}
+
+func TestRunReadsFirst(t *testing.T) {
+	transport := &blockingTransport{gate: make(chan struct{})}
+	bulker, wg := runBlockingBulker(t, transport, WithMaxPending(1))
+
+	asyncUpdate(t, wg, bulker, "a")
+	waitStarted(t, transport, 1)
+
+	// Both wait for the single flush slot
+	asyncUpdate(t, wg, bulker, "b")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := bulker.Read(context.Background(), "testidx", "c"); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	transport.gate <- struct{}{}
+	waitStarted(t, transport, 3)
+
+	if paths := transport.started(); paths[1] != "/_mget" || paths[2] != "/_bulk" {
+		t.Errorf("expected the read to be flushed before the pending write, got %v", paths)
+	}
+}
+
+func TestRunWritesBudget(t *testing.T) {
+	transport := &blockingTransport{gate: make(chan struct{})}
+	bulker, wg := runBlockingBulker(t, transport, WithMaxPending(4))
+
+	// The writes use 3 of the 4 flush slots
+	for _, id := range []string{"a", "b", "c"} {
+		asyncUpdate(t, wg, bulker, id)
+		waitStarted(t, transport, len(transport.started())+1)
+	}
+	asyncUpdate(t, wg, bulker, "d")
+	time.Sleep(50 * time.Millisecond)
+	if paths := transport.started(); len(paths) != 3 {
+		t.Fatalf("expected the fourth write to wait, got %v", paths)
+	}
+
+	// The last slot is kept for the reads
+	if _, err := bulker.Read(context.Background(), "testidx", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if paths := transport.started(); len(paths) != 4 || paths[3] != "/_mget" {
+		t.Errorf("expected the read to be flushed, got %v", paths)
+	}
+}
+
+func TestQueueOpts(t *testing.T) {
+	opts := parseBulkOpts(
+		WithMaxPending(8),
+		WithFlushThresholdCount(100),
+		WithQueue("read", 0, 0, 10, 0),
+		WithQueue("bulk", 5, 16, 0, 0),
+		WithQueue("unknown", 1, 1, 1, 1),
+	)
+
+	read := opts.queues[kQueueRead]
+	if read.priority != 3 || read.maxPending != 8 || read.flushThresholdCnt != 10 || read.flushThresholdSz != defaultFlushThresholdSz {
+		t.Errorf("unexpected read queue options %+v", read)
+	}
+	bulk := opts.queues[kQueueBulk]
+	if bulk.priority != 5 || bulk.maxPending != 8 || bulk.flushThresholdCnt != 100 {
+		t.Errorf("unexpected bulk queue options %+v", bulk)
+	}
+	if refreshBulk := opts.queues[kQueueRefreshBulk]; refreshBulk.maxPending != 6 {
+		t.Errorf("expected writes to use 3/4 of the flush slots, got %d", refreshBulk.maxPending)
+	}
+}
+
+func TestRunSaturatedWritesDoNotDelayReads(t *testing.T) {
+	transport := &blockingTransport{gate: make(chan struct{})}
+	bulker, wg := runBlockingBulker(t, transport,
+		WithMaxPending(4),
+		WithBlockQueueSize(1),
+		WithQueue("bulk", 0, 0, 1, 0),
+	)
+
+	// The writes use their 3 flush slots, then fill their queue and its intake
+	for _, id := range []string{"a", "b", "c"} {
+		asyncUpdate(t, wg, bulker, id)
+		waitStarted(t, transport, len(transport.started())+1)
+	}
+	for _, id := range []string{"d", "e", "f", "g"} {
+		asyncUpdate(t, wg, bulker, id)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := bulker.Read(ctx, "testidx", "h"); err != nil {
+		t.Fatalf("expected the read to be served while the writes are saturated, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	mrand "math/rand"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultRetryStatuses are the statuses of the transient errors, such as
+// es_rejected_execution_exception when the write thread pool is full or
+// unavailable_shards_exception while a shard is relocating.
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryable returns true if the item was rejected with one of the retried statuses.
+func (b *Bulker) retryable(item *BulkIndexerResponseItem) bool {
+	if item == nil {
+		return false
+	}
+	for _, status := range b.opts.retryStatuses {
+		if item.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns the delay before the given retry attempt: exponential from the
+// initial backoff up to the max backoff, with a random jitter of up to half the delay.
+func (b *Bulker) retryBackoff(attempt uint8) time.Duration {
+	d := b.opts.retryBackoffInit
+	for i := uint8(1); i < attempt && d < b.opts.retryBackoffMax; i++ {
+		d *= 2
+	}
+	if d > b.opts.retryBackoffMax {
+		d = b.opts.retryBackoffMax
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + mrand.Int63n(half+1)) //nolint:gosec // jitter does not need a crypto secure source
+	}
+	return d
+}
+
+// retryLater queues the items again once the backoff has elapsed. The items are queued in order,
+// so the retried operations of a caller keep their relative order.
+// The items are failed if the bulk engine stops in the meantime.
+func (b *Bulker) retryLater(ctx context.Context, queue queueT, blks []*bulkT) {
+	var attempt uint8
+	for _, blk := range blks {
+		if blk.retries > attempt {
+			attempt = blk.retries
+		}
+	}
+	delay := b.retryBackoff(attempt)
+
+	log.Debug().
+		Str("mod", kModBulk).
+		Str("queue", queue.Type()).
+		Int("cnt", len(blks)).
+		Uint8("attempt", attempt).
+		Dur("delay", delay).
+		Msg("Retry bulk items rejected with a transient error")
+
+	go func() {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			failBlocks(blks, ctx.Err())
+			return
+		}
+
+		for i, blk := range blks {
+			select {
+			case b.intake(blk) <- blk:
+			case <-ctx.Done():
+				failBlocks(blks[i:], ctx.Err())
+				return
+			}
+		}
+	}()
+}
+
+func failBlocks(blks []*bulkT, err error) {
+	for _, blk := range blks {
+		blk.ch <- respT{
+			err: err,
+			idx: blk.idx,
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// retryTransport answers the update requests of a _bulk request with the status returned by
+// the status function for the document and its attempt, and records the order of the documents.
+type retryTransport struct {
+	status func(id string, attempt int) int
+
+	mut      sync.Mutex
+	attempts map[string]int
+	requests [][]string
+}
+
+func (m *retryTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.attempts == nil {
+		m.attempts = make(map[string]int)
+	}
+
+	var (
+		ids  []string
+		body bytes.Buffer
+	)
+	body.WriteString(`{"took":1,"errors":false,"items":[`)
+
+	scanner := bufio.NewScanner(req.Body)
+	for i := 0; scanner.Scan(); i++ {
+		if i%2 == 1 {
+			// update body
+			continue
+		}
+		var meta struct {
+			Update struct {
+				ID string `json:"_id"`
+			} `json:"update"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			return nil, err
+		}
+		id := meta.Update.ID
+		m.attempts[id]++
+		ids = append(ids, id)
+
+		if len(ids) > 1 {
+			body.WriteString(",")
+		}
+		status := m.status(id, m.attempts[id])
+		if status == http.StatusOK {
+			fmt.Fprintf(&body, `{"update":{"_id":%q,"status":200}}`, id)
+		} else {
+			fmt.Fprintf(&body, `{"update":{"_id":%q,"status":%d,"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}}`, id, status)
+		}
+	}
+	body.WriteString("]}")
+	m.requests = append(m.requests, ids)
+
+	return &http.Response{
+		Request:    req,
+		StatusCode: 200,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       ioutil.NopCloser(&body),
+	}, nil
+}
+
+func runRetryBulker(t *testing.T, transport *retryTransport, opts ...BulkOpt) *Bulker {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts = append([]BulkOpt{WithFlushInterval(time.Millisecond), WithRetryBackoff(time.Millisecond, 2*time.Millisecond)}, opts...)
+	bulker := NewBulker(transport, nil, opts...)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := bulker.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return bulker
+}
+
+func TestRetryTransientError(t *testing.T) {
+	transport := &retryTransport{status: func(id string, attempt int) int {
+		if id == "rejected" && attempt < 3 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	}}
+	bulker := runRetryBulker(t, transport)
+
+	retried := retryMetrics[kQueueBulk].retried.Get()
+
+	ops := []MultiOp{
+		{ID: "first", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+		{ID: "rejected", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+		{ID: "last", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+	}
+	items, err := bulker.MUpdate(context.Background(), ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range items {
+		if item.Status != http.StatusOK {
+			t.Errorf("expected item %d to succeed, got status %d", i, item.Status)
+		}
+	}
+
+	transport.mut.Lock()
+	defer transport.mut.Unlock()
+	if transport.attempts["rejected"] != 3 || transport.attempts["first"] != 1 {
+		t.Errorf("unexpected attempts: %v", transport.attempts)
+	}
+	if got := retryMetrics[kQueueBulk].retried.Get() - retried; got != 2 {
+		t.Errorf("expected 2 retries, got %d", got)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	transport := &retryTransport{status: func(string, int) int {
+		return http.StatusServiceUnavailable
+	}}
+	bulker := runRetryBulker(t, transport, WithRetryMax(1))
+
+	exhausted := retryMetrics[kQueueBulk].exhausted.Get()
+
+	err := bulker.Update(context.Background(), "testidx", "agent1", []byte(`{"doc":{}}`))
+	var esErr *es.ErrElastic
+	if !errors.As(err, &esErr) || esErr.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable error, got %v", err)
+	}
+
+	transport.mut.Lock()
+	defer transport.mut.Unlock()
+	if transport.attempts["agent1"] != 2 {
+		t.Errorf("expected 2 attempts, got %d", transport.attempts["agent1"])
+	}
+	if got := retryMetrics[kQueueBulk].exhausted.Get() - exhausted; got != 1 {
+		t.Errorf("expected 1 exhausted item, got %d", got)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	transport := &retryTransport{status: func(string, int) int {
+		return http.StatusConflict
+	}}
+	bulker := runRetryBulker(t, transport)
+
+	if err := bulker.Update(context.Background(), "testidx", "agent1", []byte(`{"doc":{}}`)); err == nil {
+		t.Fatal("expected conflict error")
+	}
+
+	transport.mut.Lock()
+	defer transport.mut.Unlock()
+	if transport.attempts["agent1"] != 1 {
+		t.Errorf("expected a single attempt, got %d", transport.attempts["agent1"])
+	}
+}
+
+func TestRetryStatuses(t *testing.T) {
+	transport := &retryTransport{status: func(_ string, attempt int) int {
+		if attempt == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	}}
+	bulker := runRetryBulker(t, transport, WithRetryStatuses(http.StatusInternalServerError))
+
+	if err := bulker.Update(context.Background(), "testidx", "agent1", []byte(`{"doc":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.mut.Lock()
+	defer transport.mut.Unlock()
+	if transport.attempts["agent1"] != 2 {
+		t.Errorf("expected 2 attempts, got %d", transport.attempts["agent1"])
+	}
+}
+
+func TestFlushBulkOrder(t *testing.T) {
+	transport := &retryTransport{status: func(string, int) int {
+		return http.StatusOK
+	}}
+	bulker := runRetryBulker(t, transport, WithFlushInterval(time.Hour), WithFlushThresholdCount(3))
+
+	ops := []MultiOp{
+		{ID: "a", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+		{ID: "b", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+		{ID: "c", Index: "testidx", Body: []byte(`{"doc":{}}`)},
+	}
+	if _, err := bulker.MUpdate(context.Background(), ops); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.mut.Lock()
+	defer transport.mut.Unlock()
+	if len(transport.requests) != 1 || fmt.Sprint(transport.requests[0]) != "[a b c]" {
+		t.Errorf("expected the items in arrival order, got %v", transport.requests)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	bulker := NewBulker(nil, nil, WithRetryBackoff(100*time.Millisecond, time.Second))
+
+	tests := []struct {
+		attempt uint8
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{200, time.Second},
+	}
+	for _, test := range tests {
+		d := bulker.retryBackoff(test.attempt)
+		if d < test.max/2 || d > test.max {
+			t.Errorf("attempt %d: expected backoff in [%v, %v], got %v", test.attempt, test.max/2, test.max, d)
+		}
+	}
+}
@@ -47,10 +47,10 @@ type BulkIndexerResponseItem struct {
 	//	Index      string `json:"_index"`
 	DocumentID string `json:"_id"`
 	//	Version    int64  `json:"_version"`
-	//	Result     string `json:"result"`
-	Status int `json:"status"`
-	//	SeqNo      int64  `json:"_seq_no"`
-	//	PrimTerm   int64  `json:"_primary_term"`
+	Result   string `json:"result"`
+	Status   int    `json:"status"`
+	SeqNo    int64  `json:"_seq_no"`
+	PrimTerm int64  `json:"_primary_term"`
 
 	//	Shards struct {
 	//		Total      int `json:"total"`
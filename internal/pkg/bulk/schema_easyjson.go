@@ -731,6 +731,8 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs3(in *jlexe
 			out.ID = string(in.String())
 		case "_seq_no":
 			out.SeqNo = int64(in.Int64())
+		case "_primary_term":
+			out.PrimaryTerm = int64(in.Int64())
 		case "version":
 			out.Version = int64(in.Int64())
 		case "_index":
@@ -773,6 +775,11 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs3(out *jwri
 		out.RawString(prefix)
 		out.Int64(int64(in.SeqNo))
 	}
+	{
+		const prefix string = ",\"_primary_term\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.PrimaryTerm))
+	}
 	{
 		const prefix string = ",\"version\":"
 		out.RawString(prefix)
@@ -1171,8 +1178,14 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jle
 		switch key {
 		case "_id":
 			out.DocumentID = string(in.String())
+		case "result":
+			out.Result = string(in.String())
 		case "status":
 			out.Status = int(in.Int())
+		case "_seq_no":
+			out.SeqNo = int64(in.Int64())
+		case "_primary_term":
+			out.PrimTerm = int64(in.Int64())
 		case "error":
 			if data := in.Raw(); in.Ok() {
 				in.AddError((out.Error).UnmarshalJSON(data))
@@ -1196,11 +1209,26 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out *jw
 		out.RawString(prefix[1:])
 		out.String(string(in.DocumentID))
 	}
+	{
+		const prefix string = ",\"result\":"
+		out.RawString(prefix)
+		out.String(string(in.Result))
+	}
 	{
 		const prefix string = ",\"status\":"
 		out.RawString(prefix)
 		out.Int(int(in.Status))
 	}
+	{
+		const prefix string = ",\"_seq_no\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.SeqNo))
+	}
+	{
+		const prefix string = ",\"_primary_term\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.PrimTerm))
+	}
 	if len(in.Error) != 0 {
 		const prefix string = ",\"error\":"
 		out.RawString(prefix)
@@ -26,12 +26,19 @@ type Cache interface {
 
 	SetAPIKey(key APIKey, enabled bool)
 	ValidAPIKey(key APIKey) bool
+	DeleteAPIKey(id string) bool
 
 	SetEnrollmentAPIKey(id string, key model.EnrollmentAPIKey, cost int64)
 	GetEnrollmentAPIKey(id string) (model.EnrollmentAPIKey, bool)
+	DeleteEnrollmentAPIKey(id string) bool
 
 	SetArtifact(artifact model.Artifact)
 	GetArtifact(ident, sha2 string) (model.Artifact, bool)
+
+	SetAgent(agent model.Agent)
+	GetAgentByAPIKeyID(id string) (model.Agent, bool)
+	GetFreshAgentByAPIKeyID(id string) (model.Agent, bool)
+	DeleteAgent(apiKeyID string) bool
 }
 
 type APIKey = apikey.APIKey
@@ -48,6 +55,11 @@ type actionCache struct {
 	actionType string
 }
 
+type agentCache struct {
+	agent   model.Agent
+	fetched time.Time
+}
+
 // New creates a new cache.
 func New(cfg config.Cache) (*CacheT, error) {
 	cache, err := newCache(cfg)
@@ -191,6 +203,95 @@ func (c *CacheT) ValidAPIKey(key APIKey) bool {
 	return ok
 }
 
+// DeleteAPIKey evicts the API key from the cache, so that it is authenticated again. It returns
+// true if the API key was cached.
+func (c *CacheT) DeleteAPIKey(id string) bool {
+	return c.del("api:"+id, "ApiKey", id)
+}
+
+// del evicts the scoped key, returning true if it was cached.
+func (c *CacheT) del(scopedKey, kind, id string) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	_, ok := c.cache.Get(scopedKey)
+	c.cache.Del(scopedKey)
+	log.Trace().Bool("cached", ok).Str("id", id).Msg(kind + " cache DEL")
+	return ok
+}
+
+// SetAgent caches the agent by the ID of its access API key.
+func (c *CacheT) SetAgent(agent model.Agent) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := "agent:" + agent.AccessAPIKeyID
+	// Rough estimate, the raw JSON fields dominate the size of the record.
+	const kFixedCost = 1024
+	cost := kFixedCost + len(agent.LocalMetadata) + len(agent.Components)
+	ttl := c.cfg.AgentTTL
+	v := agentCache{
+		agent:   agent,
+		fetched: time.Now(),
+	}
+	ok := c.cache.SetWithTTL(scopedKey, v, int64(cost), ttl)
+	log.Trace().
+		Bool("ok", ok).
+		Str("id", agent.Id).
+		Int("cost", cost).
+		Dur("ttl", ttl).
+		Msg("Agent cache SET")
+}
+
+// GetAgentByAPIKeyID returns the agent cached for the access API key ID.
+func (c *CacheT) GetAgentByAPIKeyID(id string) (model.Agent, bool) {
+	v, ok := c.getAgent(id)
+	return v.agent, ok
+}
+
+// GetFreshAgentByAPIKeyID returns the agent cached for the access API key ID if it was cached for
+// less than the agent record TTL.
+func (c *CacheT) GetFreshAgentByAPIKeyID(id string) (model.Agent, bool) {
+	v, ok := c.getAgent(id)
+	if !ok {
+		return model.Agent{}, false
+	}
+
+	c.mut.RLock()
+	ttl := c.cfg.AgentRecordTTL
+	c.mut.RUnlock()
+	if time.Since(v.fetched) >= ttl {
+		log.Trace().Str("id", id).Msg("Agent cache STALE")
+		return model.Agent{}, false
+	}
+	return v.agent, true
+}
+
+func (c *CacheT) getAgent(id string) (agentCache, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := "agent:" + id
+	if v, ok := c.cache.Get(scopedKey); ok {
+		log.Trace().Str("id", id).Msg("Agent cache HIT")
+		agent, ok := v.(agentCache)
+		if !ok {
+			log.Error().Str("id", id).Msg("Agent cache cast fail")
+			return agentCache{}, false
+		}
+		return agent, ok
+	}
+
+	log.Trace().Str("id", id).Msg("Agent cache MISS")
+	return agentCache{}, false
+}
+
+// DeleteAgent evicts the agent cached for the access API key ID. It returns true if the agent was
+// cached.
+func (c *CacheT) DeleteAgent(apiKeyID string) bool {
+	return c.del("agent:"+apiKeyID, "Agent", apiKeyID)
+}
+
 // GetEnrollmentAPIKey returns the enrollment API key by ID.
 func (c *CacheT) GetEnrollmentAPIKey(id string) (model.EnrollmentAPIKey, bool) {
 	c.mut.RLock()
@@ -228,6 +329,12 @@ func (c *CacheT) SetEnrollmentAPIKey(id string, key model.EnrollmentAPIKey, cost
 		Msg("EnrollmentApiKey cache SET")
 }
 
+// DeleteEnrollmentAPIKey evicts the enrollment API key from the cache. It returns true if the
+// enrollment API key was cached.
+func (c *CacheT) DeleteEnrollmentAPIKey(id string) bool {
+	return c.del("record:"+id, "EnrollmentApiKey", id)
+}
+
 func makeArtifactKey(ident, sha2 string) string {
 	return fmt.Sprintf("artifact:%s:%s", ident, sha2)
 }
@@ -0,0 +1,189 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
+)
+
+// AgentSource are the fields of the agents documents read by the Invalidator, to pass to the
+// monitor of the agents index.
+var AgentSource = []string{
+	dl.FieldActive,
+	dl.FieldAccessAPIKeyID,
+	"default_api_key_history",
+	"outputs.*." + dl.FieldPolicyOutputToRetireAPIKeyIDs,
+	dl.FieldUpdatedAt,
+}
+
+// EnrollmentAPIKeySource are the fields of the enrollment API keys documents read by the
+// Invalidator, to pass to the monitor of the enrollment API keys index.
+var EnrollmentAPIKeySource = []string{
+	dl.FieldActive,
+	dl.FieldAPIKeyID,
+	dl.FieldUpdatedAt,
+}
+
+// Invalidator evicts the cache entries revoked by any fleet-server or Kibana, as the agents and
+// enrollment API keys documents change, so that they are not used until their TTL expires:
+//
+//   - the access API key and the agent of the inactive agents
+//   - the retired output API keys of the agents
+//   - the enrollment API keys deactivated, and their API key
+//
+// Most changes of the agents are checkins, leaving their revoked keys as they were; the agents are
+// skipped until their revoked keys change.
+type Invalidator struct {
+	cache      Cache
+	agents     monitor.SimpleMonitor
+	enrollKeys monitor.SimpleMonitor
+	revoked    map[string]uint64 // fingerprint of the keys last evicted per agent ID
+	log        zerolog.Logger
+}
+
+// NewInvalidator creates an Invalidator of the cache reading the documents of the monitors of the
+// agents and of the enrollment API keys indices, run by the caller.
+func NewInvalidator(c Cache, agents, enrollKeys monitor.SimpleMonitor) *Invalidator {
+	return &Invalidator{
+		cache:      c,
+		agents:     agents,
+		enrollKeys: enrollKeys,
+		revoked:    make(map[string]uint64),
+		log:        log.With().Str("ctx", "cache invalidator").Logger(),
+	}
+}
+
+// Run evicts the cache entries until the context is cancelled.
+func (iv *Invalidator) Run(ctx context.Context) error {
+	iv.log.Info().Msg("cache invalidator started")
+	defer iv.log.Info().Msg("cache invalidator exited")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case hits := <-iv.agents.Output():
+			for i := range hits {
+				iv.invalidateAgent(&hits[i])
+			}
+		case hits := <-iv.enrollKeys.Output():
+			for i := range hits {
+				iv.invalidateEnrollmentAPIKey(&hits[i])
+			}
+		}
+	}
+}
+
+func (iv *Invalidator) invalidateAgent(hit *es.HitT) {
+	var agent model.Agent
+	if err := hit.Unmarshal(&agent); err != nil {
+		iv.log.Warn().Err(err).Str("id", hit.ID).Msg("failed to parse the agent")
+		return
+	}
+
+	retired := agent.DefaultAPIKeyHistory
+	for _, output := range agent.Outputs {
+		if output != nil {
+			retired = append(retired, output.ToRetireAPIKeyIds...)
+		}
+	}
+	inactive := !agent.Active && agent.AccessAPIKeyID != ""
+	if !inactive && len(retired) == 0 {
+		delete(iv.revoked, hit.ID)
+		return
+	}
+
+	// The revoked keys stay in the document once evicted, the agent is skipped until they change.
+	// The fingerprint does not depend on the order of the keys, the outputs being a map.
+	var fingerprint uint64
+	if inactive {
+		fingerprint = fingerprintKey("access:" + agent.AccessAPIKeyID)
+	}
+	for _, key := range retired {
+		fingerprint += fingerprintKey(key.ID)
+	}
+	if prev, ok := iv.revoked[hit.ID]; ok && prev == fingerprint {
+		return
+	}
+	iv.revoked[hit.ID] = fingerprint
+
+	// Only the cached entries are reported
+	var evicted bool
+	if inactive {
+		if iv.cache.DeleteAPIKey(agent.AccessAPIKeyID) {
+			cntEvictedAPIKeys.Inc()
+			evicted = true
+		}
+		if iv.cache.DeleteAgent(agent.AccessAPIKeyID) {
+			cntEvictedAgents.Inc()
+			evicted = true
+		}
+	}
+	for _, key := range retired {
+		if iv.cache.DeleteAPIKey(key.ID) {
+			cntEvictedAPIKeys.Inc()
+			evicted = true
+		}
+	}
+
+	if evicted {
+		iv.log.Debug().
+			Str("agent_id", hit.ID).
+			Bool(dl.FieldActive, agent.Active).
+			Int("retired", len(retired)).
+			Msg("evicted agent from the cache")
+		reportLag(agent.UpdatedAt)
+	}
+}
+
+func (iv *Invalidator) invalidateEnrollmentAPIKey(hit *es.HitT) {
+	var key model.EnrollmentAPIKey
+	if err := hit.Unmarshal(&key); err != nil {
+		iv.log.Warn().Err(err).Str("id", hit.ID).Msg("failed to parse the enrollment API key")
+		return
+	}
+	if key.Active || key.APIKeyID == "" {
+		return
+	}
+
+	var evicted bool
+	if iv.cache.DeleteEnrollmentAPIKey(key.APIKeyID) {
+		cntEvictedEnrollKeys.Inc()
+		evicted = true
+	}
+	if iv.cache.DeleteAPIKey(key.APIKeyID) {
+		cntEvictedAPIKeys.Inc()
+		evicted = true
+	}
+	if evicted {
+		iv.log.Debug().Str("id", key.APIKeyID).Msg("evicted enrollment API key from the cache")
+		reportLag(key.UpdatedAt)
+	}
+}
+
+func fingerprintKey(id string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return h.Sum64()
+}
+
+// reportLag reports the delay since the document was updated, if its update time is known.
+func reportLag(updatedAt string) {
+	t, err := time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil {
+		return
+	}
+	gaugeInvalidationLag.Set(time.Since(t).Milliseconds())
+}
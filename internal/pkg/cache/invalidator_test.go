@@ -0,0 +1,110 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
+)
+
+// deleteCache records the deleted entries, the entries of the cached set being reported as cached.
+type deleteCache struct {
+	Cache
+
+	mut     sync.Mutex
+	cached  map[string]bool
+	deleted []string
+}
+
+func (c *deleteCache) del(key string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.deleted = append(c.deleted, key)
+	ok := c.cached[key]
+	delete(c.cached, key)
+	return ok
+}
+
+func (c *deleteCache) DeleteAPIKey(id string) bool           { return c.del("api:" + id) }
+func (c *deleteCache) DeleteAgent(apiKeyID string) bool      { return c.del("agent:" + apiKeyID) }
+func (c *deleteCache) DeleteEnrollmentAPIKey(id string) bool { return c.del("record:" + id) }
+
+func (c *deleteCache) getDeleted() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return append([]string(nil), c.deleted...)
+}
+
+func TestInvalidator(t *testing.T) {
+	c := &deleteCache{cached: map[string]bool{"api:access1": true, "agent:access1": true, "record:enroll1": true}}
+	agentsCh := make(chan []es.HitT)
+	enrollKeysCh := make(chan []es.HitT)
+	agents := mock.NewMockMonitor()
+	agents.On("Output").Return((<-chan []es.HitT)(agentsCh))
+	enrollKeys := mock.NewMockMonitor()
+	enrollKeys.On("Output").Return((<-chan []es.HitT)(enrollKeysCh))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- NewInvalidator(c, agents, enrollKeys).Run(ctx) }()
+
+	apiKeys, agentsEvicted, enrollEvicted := cntEvictedAPIKeys.Get(), cntEvictedAgents.Get(), cntEvictedEnrollKeys.Get()
+	updatedAt := time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)
+	agentsCh <- []es.HitT{
+		{ID: "active", Source: []byte(`{"active":true,"access_api_key_id":"access0"}`)},
+		{ID: "inactive", Source: []byte(`{"active":false,"access_api_key_id":"access1","updated_at":"` + updatedAt + `"}`)},
+		{ID: "rotated", Source: []byte(`{"active":true,"access_api_key_id":"access2","default_api_key_history":[{"id":"old1"}],"outputs":{"default":{"to_retire_api_key_ids":[{"id":"old2"}]}}}`)},
+	}
+	enrollKeysCh <- []es.HitT{
+		{ID: "1", Source: []byte(`{"active":true,"api_key_id":"enroll0"}`)},
+		{ID: "2", Source: []byte(`{"active":false,"api_key_id":"enroll1"}`)},
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	assert.Equal(t, []string{
+		"api:access1", "agent:access1",
+		"api:old1", "api:old2",
+		"record:enroll1", "api:enroll1",
+	}, c.getDeleted())
+	assert.Equal(t, apiKeys+1, cntEvictedAPIKeys.Get(), "only the cached entries are counted")
+	assert.Equal(t, agentsEvicted+1, cntEvictedAgents.Get())
+	assert.Equal(t, enrollEvicted+1, cntEvictedEnrollKeys.Get())
+	assert.GreaterOrEqual(t, gaugeInvalidationLag.Get(), int64(1000))
+}
+
+func TestInvalidatorSkipsUnchangedAgents(t *testing.T) {
+	c := &deleteCache{cached: map[string]bool{}}
+	iv := NewInvalidator(c, nil, nil)
+
+	rotated := `{"active":true,"access_api_key_id":"access2","default_api_key_history":[{"id":"old1"}],` +
+		`"outputs":{"default":{"to_retire_api_key_ids":[{"id":"old2"}]},"remote":{"to_retire_api_key_ids":[{"id":"old3"}]}}}`
+	iv.invalidateAgent(&es.HitT{ID: "rotated", Source: []byte(rotated)})
+	assert.ElementsMatch(t, []string{"api:old1", "api:old2", "api:old3"}, c.getDeleted())
+
+	// checkins of the agent
+	for i := 0; i < 3; i++ {
+		iv.invalidateAgent(&es.HitT{ID: "rotated", Source: []byte(rotated)})
+	}
+	assert.Len(t, c.getDeleted(), 3, "the agent is skipped while its revoked keys are unchanged")
+
+	iv.invalidateAgent(&es.HitT{ID: "rotated", Source: []byte(`{"active":true,"access_api_key_id":"access2",` +
+		`"default_api_key_history":[{"id":"old1"},{"id":"old4"}]}`)})
+	assert.Equal(t, []string{"api:old1", "api:old4"}, c.getDeleted()[3:], "the agent is evicted again once its revoked keys changed")
+
+	iv.invalidateAgent(&es.HitT{ID: "rotated", Source: []byte(`{"active":false,"access_api_key_id":"access2",` +
+		`"default_api_key_history":[{"id":"old1"},{"id":"old4"}]}`)})
+	assert.Equal(t, []string{"api:access2", "agent:access2", "api:old1", "api:old4"}, c.getDeleted()[5:], "the agent is evicted once inactive")
+}
@@ -0,0 +1,28 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package cache
+
+import (
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var (
+	registry *monitoring.Registry
+
+	cntEvictedAPIKeys    *monitoring.Uint // API keys evicted on a change made by any fleet-server
+	cntEvictedAgents     *monitoring.Uint // agents evicted as they became inactive
+	cntEvictedEnrollKeys *monitoring.Uint // enrollment API keys evicted as they were deactivated
+	gaugeInvalidationLag *monitoring.Int  // delay between the last evicting change and the eviction in milliseconds
+)
+
+func init() {
+	registry = monitoring.Default.NewRegistry("cache")
+
+	invalidationRegistry := registry.NewRegistry("invalidation")
+	cntEvictedAPIKeys = monitoring.NewUint(invalidationRegistry, "api_keys")
+	cntEvictedAgents = monitoring.NewUint(invalidationRegistry, "agents")
+	cntEvictedEnrollKeys = monitoring.NewUint(invalidationRegistry, "enrollment_keys")
+	gaugeInvalidationLag = monitoring.NewInt(invalidationRegistry, "lag_ms")
+}
@@ -18,17 +18,54 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const defaultFlushInterval = 10 * time.Second
+const (
+	defaultFlushIntervalMin = 1 * time.Second
+	defaultFlushIntervalMax = 10 * time.Second
+	defaultFlushBatchMax    = 10000
+	defaultJournalMaxSize   = 256 * 1024 * 1024
+	finalFlushTimeout       = 10 * time.Second
+)
 
 type optionsT struct {
-	flushInterval time.Duration
+	flushIntervalMin time.Duration
+	flushIntervalMax time.Duration
+	flushBatchMax    int
+	journalDir       string
+	journalMaxSize   int64
 }
 
 type Opt func(*optionsT)
 
+// WithFlushInterval flushes the pending checkins at a fixed interval.
 func WithFlushInterval(d time.Duration) Opt {
+	return WithFlushBounds(d, d, 0)
+}
+
+// WithFlushBounds sets the bounds of the adaptive flush: the interval between two flushes
+// is kept between min and max, and each bulk request updates at most maxBatch agents.
+// Values that are not positive are ignored.
+func WithFlushBounds(min, max time.Duration, maxBatch int) Opt {
 	return func(opt *optionsT) {
-		opt.flushInterval = d
+		if min > 0 {
+			opt.flushIntervalMin = min
+		}
+		if max > 0 {
+			opt.flushIntervalMax = max
+		}
+		if maxBatch > 0 {
+			opt.flushBatchMax = maxBatch
+		}
+	}
+}
+
+// WithJournal enables the on-disk journal of the pending checkins in dir.
+// The segments of the journal are limited to maxSize bytes.
+func WithJournal(dir string, maxSize int64) Opt {
+	return func(opt *optionsT) {
+		opt.journalDir = dir
+		if maxSize > 0 {
+			opt.journalMaxSize = maxSize
+		}
 	}
 }
 
@@ -43,10 +80,11 @@ type extraT struct {
 // There will be 10's of thousands of items
 // in the map at any point.
 type pendingT struct {
-	ts      string
-	status  string
-	message string
-	extra   *extraT
+	ts          string
+	status      string
+	message     string
+	agentStatus string
+	extra       *extraT
 }
 
 // Bulk will batch pending checkins and update elasticsearch at a set interval.
@@ -55,6 +93,8 @@ type Bulk struct {
 	bulker  bulk.Bulk
 	mut     sync.Mutex
 	pending map[string]pendingT
+	journal *journal
+	full    chan struct{}
 
 	ts   string
 	unix int64
@@ -67,19 +107,27 @@ func NewBulk(bulker bulk.Bulk, opts ...Opt) *Bulk {
 		opts:    parsedOpts,
 		bulker:  bulker,
 		pending: make(map[string]pendingT),
+		full:    make(chan struct{}, 1),
 	}
 }
 
 func parseOpts(opts ...Opt) optionsT {
 
 	outOpts := optionsT{
-		flushInterval: defaultFlushInterval,
+		flushIntervalMin: defaultFlushIntervalMin,
+		flushIntervalMax: defaultFlushIntervalMax,
+		flushBatchMax:    defaultFlushBatchMax,
+		journalMaxSize:   defaultJournalMaxSize,
 	}
 
 	for _, f := range opts {
 		f(&outOpts)
 	}
 
+	if outOpts.flushIntervalMax < outOpts.flushIntervalMin {
+		outOpts.flushIntervalMax = outOpts.flushIntervalMin
+	}
+
 	return outOpts
 }
 
@@ -98,9 +146,11 @@ func (bc *Bulk) timestamp() string {
 }
 
 // CheckIn will add the agent (identified by id) to the pending set.
-// The pending agents are sent to elasticsearch as a bulk update at each flush interval.
+// The pending agents are sent to elasticsearch as a bulk update at each flush interval,
+// or earlier once a batch is full.
+// The agentStatus is the status of the agent computed for this checkin, see model.Agent.CheckinStatus.
 // WARNING: Bulk will take ownership of fields, so do not use after passing in.
-func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string) error {
+func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string, agentStatus string) error {
 	// Separate out the extra data to minimize
 	// the memory footprint of the 90% case of just
 	// updating the timestamp.
@@ -116,63 +166,273 @@ func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, c
 
 	bc.mut.Lock()
 
-	bc.pending[id] = pendingT{
-		ts:      bc.timestamp(),
-		status:  status,
-		message: message,
-		extra:   extra,
+	p := pendingT{
+		ts:          bc.timestamp(),
+		status:      status,
+		message:     message,
+		agentStatus: agentStatus,
+		extra:       extra,
 	}
+	bc.pending[id] = p
+	j := bc.journal
+	full := len(bc.pending) >= bc.opts.flushBatchMax
 
 	bc.mut.Unlock()
+
+	if j != nil {
+		appendJournal(j, id, p)
+	}
+
+	if full {
+		select {
+		case bc.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// batchFull returns true when there are enough pending checkins for a full batch.
+func (bc *Bulk) batchFull() bool {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return len(bc.pending) >= bc.opts.flushBatchMax
+}
+
+// backpressure returns the load of the bulk engine, 0 if it does not report it.
+func (bc *Bulk) backpressure() float64 {
+	if b, ok := bc.bulker.(backpressurer); ok {
+		return b.Backpressure()
+	}
+	return 0
+}
+
+// appendJournal writes the pending checkin to the journal, outside of the lock of the pending set.
+// A checkin taken by a flush before it is written lands in the next segment; it is only replayed,
+// at most one flush late, if the process crashes before the next flush.
+func appendJournal(j *journal, id string, p pendingT) {
+	line, err := json.Marshal(newJournalEntry(id, p))
+	if err == nil {
+		err = j.append(append(line, '\n'))
+	}
+	if err != nil {
+		// still flushed from memory, only lost on a crash
+		cntJournalSkipped.Inc()
+	}
+}
+
+// replayJournal opens the journal and adds the checkins left over by the previous run to the pending set.
+// Checkins received since the start take precedence over the replayed ones.
+func (bc *Bulk) replayJournal() error {
+	j, entries, skipped, err := openJournal(bc.opts.journalDir, bc.opts.journalMaxSize)
+	if err != nil {
+		return err
+	}
+	cntDropped.Add(uint64(skipped))
+
+	// later entries override the previous ones for the same agent
+	replayed := make(map[string]pendingT, len(entries))
+	for _, e := range entries {
+		replayed[e.ID] = e.pending()
+	}
+
+	bc.mut.Lock()
+	for id, p := range replayed {
+		if _, ok := bc.pending[id]; !ok {
+			bc.pending[id] = p
+		}
+	}
+	bc.journal = j
+	bc.mut.Unlock()
+
+	cntReplayed.Add(uint64(len(replayed)))
+	log.Info().
+		Str("path", bc.opts.journalDir).
+		Int("replayed", len(replayed)).
+		Int("dropped", skipped).
+		Msg("Replayed checkin journal")
 	return nil
 }
 
 // Run starts the flush timer and exit only when the context is cancelled.
+// The interval between two flushes adapts to the load, see scheduleT.
+// The pending checkins are flushed one last time before exiting.
 func (bc *Bulk) Run(ctx context.Context) error {
+	if bc.opts.journalDir != "" {
+		if err := bc.replayJournal(); err != nil {
+			log.Error().Err(err).Str("path", bc.opts.journalDir).Msg("Failed to open checkin journal; pending checkins are not journaled")
+		}
+	}
 
-	tick := time.NewTicker(bc.opts.flushInterval)
-	defer tick.Stop()
+	sched := newSchedule(bc.opts.flushIntervalMin, bc.opts.flushIntervalMax, bc.opts.flushBatchMax)
+	gaugeInterval.Set(sched.interval.Milliseconds())
 
-	var err error
+	last := time.Now()
+	deadline := last.Add(sched.interval)
+	timer := time.NewTimer(sched.interval)
+	defer timer.Stop()
+
+	var (
+		err         error
+		unavailable bool
+	)
 LOOP:
 	for {
 		select {
-		case <-tick.C:
-			if err = bc.flush(ctx); err != nil {
+		case <-timer.C:
+			start := time.Now()
+			var cnt int
+			if cnt, err = bc.flush(ctx); err != nil {
 				log.Error().Err(err).Msg("Eat bulk checkin error; Keep on truckin'")
 			}
 
+			// back off while elasticsearch is unavailable, the checkins are kept pending
+			pressure := bc.backpressure()
+			if unavailable = bulk.IsUnavailable(err); unavailable {
+				pressure = 1
+			}
+			interval := sched.next(cnt, time.Since(start), pressure)
+			gaugeInterval.Set(interval.Milliseconds())
+
+			last = time.Now()
+			deadline = last.Add(interval)
+			timer.Reset(interval)
+
+		case <-bc.full:
+			// Flush early once a batch is full, but not more often than the min interval.
+			// The signal may predate the last flush, check the pending checkins again.
+			early := last.Add(bc.opts.flushIntervalMin)
+			if !unavailable && early.Before(deadline) && bc.batchFull() {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				deadline = early
+				timer.Reset(time.Until(early))
+			}
+
 		case <-ctx.Done():
 			err = ctx.Err()
 			break LOOP
 		}
 	}
 
+	if ferr := bc.finalFlush(); ferr != nil {
+		log.Error().Err(ferr).Msg("Final bulk checkin flush failed")
+	}
+
 	return err
 }
 
-// flush sends the minium data needed to update records in elasticsearch.
-func (bc *Bulk) flush(ctx context.Context) error {
-	start := time.Now()
-
+// swap takes the pending set, and the journal segments holding it when the journal is enabled.
+func (bc *Bulk) swap() (map[string]pendingT, []string) {
 	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
 	pending := bc.pending
 	bc.pending = make(map[string]pendingT, len(pending))
+
+	var sealed []string
+	if bc.journal != nil {
+		var err error
+		if sealed, err = bc.journal.rotate(); err != nil {
+			log.Error().Err(err).Msg("Failed to rotate checkin journal")
+		}
+	}
+	return pending, sealed
+}
+
+// flush sends the pending checkins to elasticsearch, returns the number of checkins flushed.
+// Checkins of a failed flush are dropped, unless elasticsearch is unavailable: they are then
+// kept pending until it is available again.
+func (bc *Bulk) flush(ctx context.Context) (int, error) {
+	start := time.Now()
+	pending, sealed := bc.swap()
+
+	failed, err := bc.update(ctx, pending)
+	if bulk.IsUnavailable(err) {
+		bc.requeue(failed, sealed)
+		sealed = nil
+	} else {
+		cntDropped.Add(uint64(len(failed)))
+	}
+
+	if err := removeSegments(sealed); err != nil {
+		log.Error().Err(err).Msg("Failed to remove flushed checkin journal segments")
+	}
+
+	cntFlush.Inc()
+	gaugeFlushSize.Set(uint64(len(pending)))
+	gaugeFlushLatency.Set(time.Since(start).Milliseconds())
+	return len(pending), err
+}
+
+// requeue puts back the checkins of a flush that failed because elasticsearch is unavailable.
+// Checkins received in the meantime take precedence. The journal segments holding them are kept
+// until they are flushed.
+func (bc *Bulk) requeue(failed map[string]pendingT, sealed []string) {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
+	for id, p := range failed {
+		if _, ok := bc.pending[id]; !ok {
+			bc.pending[id] = p
+		}
+	}
+	if bc.journal != nil {
+		bc.journal.requeue(sealed)
+	}
+	cntRequeued.Add(uint64(len(failed)))
+}
+
+// finalFlush sends the pending checkins to elasticsearch on exit, with its own timeout as the
+// run context is cancelled. When it fails, the checkins remain in the journal if enabled, to be
+// replayed on the next start.
+func (bc *Bulk) finalFlush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+	defer cancel()
+
+	pending, sealed := bc.swap()
+	failed, err := bc.update(ctx, pending)
+
+	bc.mut.Lock()
+	j := bc.journal
+	bc.journal = nil
 	bc.mut.Unlock()
 
+	switch {
+	case err == nil:
+		if rerr := removeSegments(sealed); rerr != nil {
+			log.Error().Err(rerr).Msg("Failed to remove flushed checkin journal segments")
+		}
+	case j == nil:
+		cntDropped.Add(uint64(len(failed)))
+	}
+
+	if j != nil {
+		if cerr := j.close(); cerr != nil {
+			log.Error().Err(cerr).Msg("Failed to close checkin journal")
+		}
+	}
+	return err
+}
+
+// update sends the minium data needed to update records in elasticsearch, in batches of
+// at most the max batch size. Returns the checkins of the failed batches, and the first error.
+func (bc *Bulk) update(ctx context.Context, pending map[string]pendingT) (map[string]pendingT, error) {
 	if len(pending) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	updates := make([]bulk.MultiOp, 0, len(pending))
+	refresh := make([]bool, 0, len(pending))
 
 	simpleCache := make(map[pendingT][]byte)
 
-	nowTimestamp := start.UTC().Format(time.RFC3339)
+	nowTimestamp := time.Now().UTC().Format(time.RFC3339)
 
 	var err error
-	var needRefresh bool
 	for id, pendingData := range pending {
+		var needRefresh bool
 
 		// In the simple case, there are no fields and no seqNo.
 		// When that is true, we can reuse an already generated
@@ -188,19 +448,25 @@ func (bc *Bulk) flush(ctx context.Context) error {
 					dl.FieldUpdatedAt:          nowTimestamp,
 					dl.FieldLastCheckinStatus:  pendingData.status,
 					dl.FieldLastCheckinMessage: pendingData.message,
+					dl.FieldStatus:             pendingData.agentStatus,
+					dl.FieldOfflineAt:          nil,
+					dl.FieldInactiveAt:         nil,
 				}
 				if body, err = fields.Marshal(); err != nil {
-					return err
+					return pending, err
 				}
 				simpleCache[pendingData] = body
 			}
 		} else {
 
 			fields := bulk.UpdateFields{
-				dl.FieldLastCheckin:        pendingData.ts,      // Set the checkin timestamp
-				dl.FieldUpdatedAt:          nowTimestamp,        // Set "updated_at" to the current timestamp
-				dl.FieldLastCheckinStatus:  pendingData.status,  // Set the pending status
-				dl.FieldLastCheckinMessage: pendingData.message, // Set the status message
+				dl.FieldLastCheckin:        pendingData.ts,          // Set the checkin timestamp
+				dl.FieldUpdatedAt:          nowTimestamp,            // Set "updated_at" to the current timestamp
+				dl.FieldLastCheckinStatus:  pendingData.status,      // Set the pending status
+				dl.FieldLastCheckinMessage: pendingData.message,     // Set the status message
+				dl.FieldStatus:             pendingData.agentStatus, // Set the computed agent status
+				dl.FieldOfflineAt:          nil,                     // Clear the inactivity lifecycle marks
+				dl.FieldInactiveAt:         nil,
 			}
 
 			// If the agent version is not empty it needs to be updated
@@ -233,7 +499,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 			}
 
 			if body, err = fields.Marshal(); err != nil {
-				return err
+				return pending, err
 			}
 		}
 
@@ -242,6 +508,38 @@ func (bc *Bulk) flush(ctx context.Context) error {
 			Body:  body,
 			Index: dl.FleetAgents,
 		})
+		refresh = append(refresh, needRefresh)
+	}
+
+	var failed map[string]pendingT
+	var firstErr error
+	for i := 0; i < len(updates); i += bc.opts.flushBatchMax {
+		end := i + bc.opts.flushBatchMax
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if err := bc.updateBatch(ctx, updates[i:end], refresh[i:end]); err != nil {
+			if failed == nil {
+				failed = make(map[string]pendingT, end-i)
+			}
+			for _, u := range updates[i:end] {
+				failed[u.ID] = pending[u.ID]
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// updateBatch sends a batch of updates in a single bulk request.
+func (bc *Bulk) updateBatch(ctx context.Context, updates []bulk.MultiOp, refresh []bool) error {
+	start := time.Now()
+
+	var needRefresh bool
+	for _, r := range refresh {
+		needRefresh = needRefresh || r
 	}
 
 	var opts []bulk.Opt
@@ -249,7 +547,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 		opts = append(opts, bulk.WithRefresh())
 	}
 
-	_, err = bc.bulker.MUpdate(ctx, updates, opts...)
+	_, err := bc.bulker.MUpdate(ctx, updates, opts...)
 
 	log.Trace().
 		Err(err).
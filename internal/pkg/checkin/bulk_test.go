@@ -13,6 +13,7 @@ import (
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
@@ -48,6 +49,7 @@ func matchOp(tb testing.TB, c bulkcase, ts time.Time) func(ops []bulk.MultiOp) b
 			UpdatedAt   string          `json:"updated_at"`
 			Meta        json.RawMessage `json:"local_metadata"`
 			SeqNo       sqn.SeqNo       `json:"action_seq_no"`
+			AgentStatus string          `json:"status"`
 		}
 
 		m := make(map[string]updateT)
@@ -74,19 +76,24 @@ func matchOp(tb testing.TB, c bulkcase, ts time.Time) func(ops []bulk.MultiOp) b
 		if c.status != sub.Status {
 			tb.Error("status mismatch")
 		}
+
+		if c.agentStatus != sub.AgentStatus {
+			tb.Error("agent status mismatch")
+		}
 		return true
 	}
 }
 
 type bulkcase struct {
-	desc       string
-	id         string
-	status     string
-	message    string
-	meta       []byte
-	components []byte
-	seqno      sqn.SeqNo
-	ver        string
+	desc        string
+	id          string
+	status      string
+	message     string
+	meta        []byte
+	components  []byte
+	seqno       sqn.SeqNo
+	ver         string
+	agentStatus string
 }
 
 func TestBulkSimple(t *testing.T) {
@@ -103,6 +110,7 @@ func TestBulkSimple(t *testing.T) {
 			nil,
 			nil,
 			"",
+			model.AgentStatusOnline,
 		},
 		{
 			"Singled field case",
@@ -113,6 +121,7 @@ func TestBulkSimple(t *testing.T) {
 			[]byte(`[{"id":"winlog-default"}]`),
 			nil,
 			"",
+			model.AgentStatusOnline,
 		},
 		{
 			"Multi field case",
@@ -123,6 +132,7 @@ func TestBulkSimple(t *testing.T) {
 			[]byte(`[{"id":"winlog-default","type":"winlog"}]`),
 			nil,
 			ver,
+			model.AgentStatusOnline,
 		},
 		{
 			"Multi field nested case",
@@ -133,6 +143,7 @@ func TestBulkSimple(t *testing.T) {
 			[]byte(`[{"id":"winlog-default","type":"winlog"}]`),
 			nil,
 			"",
+			model.AgentStatusOnline,
 		},
 		{
 			"Simple case with seqNo",
@@ -143,6 +154,7 @@ func TestBulkSimple(t *testing.T) {
 			nil,
 			sqn.SeqNo{1, 2, 3, 4},
 			ver,
+			model.AgentStatusOnline,
 		},
 		{
 			"Field case with seqNo",
@@ -153,6 +165,7 @@ func TestBulkSimple(t *testing.T) {
 			[]byte(`[{"id":"log-default"}]`),
 			sqn.SeqNo{5, 6, 7, 8},
 			ver,
+			model.AgentStatusOnline,
 		},
 		{
 			"Unusual status",
@@ -163,6 +176,7 @@ func TestBulkSimple(t *testing.T) {
 			nil,
 			nil,
 			"",
+			model.AgentStatusOnline,
 		},
 		{
 			"Empty status",
@@ -173,6 +187,7 @@ func TestBulkSimple(t *testing.T) {
 			nil,
 			nil,
 			"",
+			model.AgentStatusOnline,
 		},
 	}
 
@@ -183,11 +198,11 @@ func TestBulkSimple(t *testing.T) {
 			mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchOp(t, c, start)), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
 			bc := NewBulk(mockBulk)
 
-			if err := bc.CheckIn(c.id, c.status, c.message, c.meta, c.components, c.seqno, c.ver); err != nil {
+			if err := bc.CheckIn(c.id, c.status, c.message, c.meta, c.components, c.seqno, c.ver, c.agentStatus); err != nil {
 				t.Fatal(err)
 			}
 
-			if err := bc.flush(context.Background()); err != nil {
+			if _, err := bc.flush(context.Background()); err != nil {
 				t.Fatal(err)
 			}
 
@@ -221,14 +236,14 @@ func benchmarkBulk(n int, flush bool, b *testing.B) {
 	for i := 0; i < b.N; i++ {
 
 		for _, id := range ids {
-			err := bc.CheckIn(id, "", "", nil, nil, nil, "")
+			err := bc.CheckIn(id, "", "", nil, nil, nil, "", "")
 			if err != nil {
 				b.Fatal(err)
 			}
 		}
 
 		if flush {
-			err := bc.flush(context.Background())
+			_, err := bc.flush(context.Background())
 			if err != nil {
 				b.Fatal(err)
 			}
@@ -0,0 +1,279 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package checkin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+const (
+	journalPrefix = "checkin-"
+	journalSuffix = ".journal"
+
+	maxJournalEntrySize = 16 * 1024 * 1024
+)
+
+var errJournalFull = errors.New("checkin journal segment full")
+
+// journalEntry is a pending checkin as written to the journal.
+type journalEntry struct {
+	ID          string          `json:"id"`
+	TS          string          `json:"ts"`
+	Status      string          `json:"status,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	AgentStatus string          `json:"agent_status,omitempty"`
+	Meta        json.RawMessage `json:"meta,omitempty"`
+	Components  json.RawMessage `json:"components,omitempty"`
+	SeqNo       sqn.SeqNo       `json:"seq_no,omitempty"`
+	Ver         string          `json:"ver,omitempty"`
+}
+
+func newJournalEntry(id string, p pendingT) journalEntry {
+	e := journalEntry{
+		ID:          id,
+		TS:          p.ts,
+		Status:      p.status,
+		Message:     p.message,
+		AgentStatus: p.agentStatus,
+	}
+	if p.extra != nil {
+		e.Meta = p.extra.meta
+		e.Components = p.extra.components
+		e.SeqNo = p.extra.seqNo
+		e.Ver = p.extra.ver
+	}
+	return e
+}
+
+func (e journalEntry) pending() pendingT {
+	p := pendingT{
+		ts:          e.TS,
+		status:      e.Status,
+		message:     e.Message,
+		agentStatus: e.AgentStatus,
+	}
+	if e.Meta != nil || e.SeqNo.IsSet() || e.Ver != "" || e.Components != nil {
+		p.extra = &extraT{
+			meta:       e.Meta,
+			seqNo:      e.SeqNo,
+			ver:        e.Ver,
+			components: e.Components,
+		}
+	}
+	return p
+}
+
+// journal is an append-only on-disk log of the pending checkins.
+//
+// The journal is split in segments: a new segment is started on every flush, the segments
+// sealed by a flush are removed once the flush completes. Segments left over by a crash
+// are replayed on startup.
+//
+// Entries are written without fsync, they survive a crash of the process but not of the host.
+// The journal is safe for concurrent use, the checkins being written outside of the lock of Bulk.
+type journal struct {
+	dir     string
+	maxSize int64
+
+	mut    sync.Mutex
+	seq    uint64
+	f      *os.File
+	size   int64
+	sealed []string
+}
+
+// openJournal opens the journal in dir, returning the entries of the segments left over by a
+// previous run and the number of entries that could not be read.
+func openJournal(dir string, maxSize int64) (*journal, []journalEntry, int, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to create checkin journal directory: %w", err)
+	}
+
+	segments, last, err := listSegments(dir)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var (
+		entries []journalEntry
+		skipped int
+	)
+	for _, name := range segments {
+		e, s, err := readSegment(name)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		entries = append(entries, e...)
+		skipped += s
+	}
+
+	j := &journal{
+		dir:     dir,
+		maxSize: maxSize,
+		seq:     last,
+		sealed:  segments,
+	}
+	if err := j.next(); err != nil {
+		return nil, nil, 0, err
+	}
+	return j, entries, skipped, nil
+}
+
+// listSegments returns the path of the segments in dir in order, and the sequence number of the last one.
+func listSegments(dir string) ([]string, uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list checkin journal: %w", err)
+	}
+
+	var (
+		segments []string
+		last     uint64
+	)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, journalPrefix) || !strings.HasSuffix(name, journalSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, journalPrefix), journalSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		if seq > last {
+			last = seq
+		}
+		segments = append(segments, filepath.Join(dir, name))
+	}
+
+	// zero padded names, lexical order is the sequence order
+	sort.Strings(segments)
+	return segments, last, nil
+}
+
+// readSegment reads the entries of a segment, skipping the ones that cannot be decoded,
+// such as an entry partially written by a crash.
+func readSegment(name string) ([]journalEntry, int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open checkin journal segment: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		entries []journalEntry
+		skipped int
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJournalEntrySize)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID == "" {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		if !errors.Is(err, bufio.ErrTooLong) {
+			return nil, 0, fmt.Errorf("failed to read checkin journal segment: %w", err)
+		}
+		// the remainder of the segment is unreadable
+		skipped++
+	}
+	return entries, skipped, nil
+}
+
+// next starts a new segment.
+func (j *journal) next() error {
+	j.seq++
+	name := filepath.Join(j.dir, fmt.Sprintf("%s%016x%s", journalPrefix, j.seq, journalSuffix))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create checkin journal segment: %w", err)
+	}
+	j.f = f
+	j.size = 0
+	return nil
+}
+
+// append writes the encoded entry to the current segment.
+func (j *journal) append(line []byte) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	if j.size+int64(len(line)) > j.maxSize {
+		return errJournalFull
+	}
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// rotate seals the current segment and starts a new one, returning all the sealed segments.
+// The current segment is kept when it is empty.
+func (j *journal) rotate() ([]string, error) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	if j.size > 0 {
+		if err := j.f.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close checkin journal segment: %w", err)
+		}
+		j.sealed = append(j.sealed, j.f.Name())
+		if err := j.next(); err != nil {
+			return nil, err
+		}
+	}
+	sealed := j.sealed
+	j.sealed = nil
+	return sealed, nil
+}
+
+// requeue puts back sealed segments whose entries were not flushed, to be sealed again by the next rotation.
+func (j *journal) requeue(sealed []string) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.sealed = append(sealed, j.sealed...)
+}
+
+// close closes the current segment, removing it when empty.
+func (j *journal) close() error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	name := j.f.Name()
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if j.size == 0 {
+		return os.Remove(name)
+	}
+	return nil
+}
+
+// removeSegments removes the segments once their entries have been flushed.
+func removeSegments(segments []string) error {
+	var errs []string
+	for _, name := range segments {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove checkin journal segments: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
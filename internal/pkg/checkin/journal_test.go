@@ -0,0 +1,182 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package checkin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// matchIDs matches the operations updating exactly the given agents.
+func matchIDs(ids ...string) func(ops []bulk.MultiOp) bool {
+	return func(ops []bulk.MultiOp) bool {
+		got := make([]string, 0, len(ops))
+		for _, op := range ops {
+			got = append(got, op.ID)
+		}
+		sort.Strings(got)
+		sort.Strings(ids)
+		return assert.ObjectsAreEqual(ids, got)
+	}
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	s, _, err := listSegments(dir)
+	require.NoError(t, err)
+	return s
+}
+
+func TestJournalReplay(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	dir := t.TempDir()
+
+	// first run crashes before flushing
+	bc := NewBulk(ftesting.NewMockBulk(), WithJournal(dir, 0))
+	require.NoError(t, bc.replayJournal())
+	require.NoError(t, bc.CheckIn("agent1", "online", "", []byte(`{"hey":"now"}`), nil, sqn.SeqNo{1}, "8.7.0", model.AgentStatusOnline))
+	require.NoError(t, bc.CheckIn("agent2", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.NoError(t, bc.CheckIn("agent2", "error", "", nil, nil, nil, "", model.AgentStatusError))
+
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchIDs("agent1", "agent2", "agent3")), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+	bc = NewBulk(mockBulk, WithJournal(dir, 0))
+
+	// checkins received before the replay take precedence
+	require.NoError(t, bc.CheckIn("agent3", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.NoError(t, bc.replayJournal())
+	assert.Len(t, bc.pending, 3)
+	assert.Equal(t, model.AgentStatusError, bc.pending["agent2"].agentStatus)
+	require.NotNil(t, bc.pending["agent1"].extra)
+	assert.Equal(t, sqn.SeqNo{1}, bc.pending["agent1"].extra.seqNo)
+	assert.Equal(t, "8.7.0", bc.pending["agent1"].extra.ver)
+
+	_, err := bc.flush(context.Background())
+	require.NoError(t, err)
+	mockBulk.AssertExpectations(t)
+
+	// only the empty current segment is left
+	s := segments(t, dir)
+	require.Len(t, s, 1)
+	fi, err := os.Stat(s[0])
+	require.NoError(t, err)
+	assert.Zero(t, fi.Size())
+}
+
+func TestJournalCorruptEntries(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	dir := t.TempDir()
+
+	content := `{"id":"agent1","ts":"2022-10-01T00:00:00Z","agent_status":"online"}
+not json
+{"id":"agent2","ts":"2022-10-01T00:00:00Z","agent_st`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, journalPrefix+"0000000000000001"+journalSuffix), []byte(content), 0600))
+
+	j, entries, skipped, err := openJournal(dir, defaultJournalMaxSize)
+	require.NoError(t, err)
+	defer j.close()
+
+	require.Len(t, entries, 1)
+	assert.Equal(t, "agent1", entries[0].ID)
+	assert.Equal(t, 2, skipped)
+
+	// new segments are started after the left over ones
+	assert.Equal(t, uint64(2), j.seq)
+}
+
+func TestJournalFull(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	dir := t.TempDir()
+
+	bc := NewBulk(ftesting.NewMockBulk(), WithJournal(dir, 100))
+	require.NoError(t, bc.replayJournal())
+	require.NoError(t, bc.CheckIn("agent1", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.NoError(t, bc.CheckIn("agent2", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+
+	// the second checkin is not journaled but is still pending
+	assert.Len(t, bc.pending, 2)
+	j, entries, _, err := openJournal(dir, 100)
+	require.NoError(t, err)
+	defer j.close()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "agent1", entries[0].ID)
+}
+
+func TestRunFinalFlush(t *testing.T) {
+	_ = testlog.SetLogger(t)
+
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchIDs("agent1")), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+	bc := NewBulk(mockBulk)
+	require.NoError(t, bc.CheckIn("agent1", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := bc.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	mockBulk.AssertExpectations(t)
+}
+
+func TestRunFinalFlushFailureKeepsJournal(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	dir := t.TempDir()
+
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, errors.New("unavailable")).Once()
+	bc := NewBulk(mockBulk, WithJournal(dir, 0))
+	require.NoError(t, bc.replayJournal())
+	require.NoError(t, bc.CheckIn("agent1", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.Error(t, bc.finalFlush())
+	mockBulk.AssertExpectations(t)
+
+	// replayed on the next start
+	j, entries, _, err := openJournal(dir, defaultJournalMaxSize)
+	require.NoError(t, err)
+	defer j.close()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "agent1", entries[0].ID)
+}
+
+func TestFlushUnavailableRequeues(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	dir := t.TempDir()
+
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, bulk.ErrCircuitOpen).Once()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchIDs("agent1", "agent2")), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+	bc := NewBulk(mockBulk, WithJournal(dir, 0))
+	require.NoError(t, bc.replayJournal())
+	require.NoError(t, bc.CheckIn("agent1", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.NoError(t, bc.CheckIn("agent2", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+
+	_, err := bc.flush(context.Background())
+	require.ErrorIs(t, err, bulk.ErrCircuitOpen)
+
+	// kept pending with their journal segment until elasticsearch is available
+	assert.Len(t, bc.pending, 2)
+	require.NoError(t, bc.CheckIn("agent2", "error", "", nil, nil, nil, "", model.AgentStatusError))
+	assert.Equal(t, model.AgentStatusError, bc.pending["agent2"].agentStatus)
+	assert.Len(t, segments(t, dir), 2)
+
+	_, err = bc.flush(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, bc.pending)
+	assert.Len(t, segments(t, dir), 1)
+	mockBulk.AssertExpectations(t)
+}
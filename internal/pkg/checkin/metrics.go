@@ -0,0 +1,39 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package checkin
+
+import (
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var (
+	registry *monitoring.Registry
+
+	cntReplayed       *monitoring.Uint // checkins replayed from the journal
+	cntDropped        *monitoring.Uint // checkins lost, either by a failed flush or unreadable in the journal
+	cntRequeued       *monitoring.Uint // checkins kept pending after a flush failed as elasticsearch is unavailable
+	cntJournalSkipped *monitoring.Uint // checkins that could not be written to the journal
+
+	cntFlush          *monitoring.Uint // flushes
+	gaugeFlushSize    *monitoring.Uint // checkins sent by the last flush
+	gaugeFlushLatency *monitoring.Int  // duration of the last flush in milliseconds
+	gaugeInterval     *monitoring.Int  // current interval between two flushes in milliseconds
+)
+
+func init() {
+	registry = monitoring.Default.NewRegistry("checkin")
+	cntDropped = monitoring.NewUint(registry, "dropped")
+	cntRequeued = monitoring.NewUint(registry, "requeued")
+
+	journalRegistry := registry.NewRegistry("journal")
+	cntReplayed = monitoring.NewUint(journalRegistry, "replayed")
+	cntJournalSkipped = monitoring.NewUint(journalRegistry, "skipped")
+
+	flushRegistry := registry.NewRegistry("flush")
+	cntFlush = monitoring.NewUint(flushRegistry, "total")
+	gaugeFlushSize = monitoring.NewUint(flushRegistry, "size")
+	gaugeFlushLatency = monitoring.NewInt(flushRegistry, "latency_ms")
+	gaugeInterval = monitoring.NewInt(flushRegistry, "interval_ms")
+}
@@ -0,0 +1,61 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package checkin
+
+import (
+	"time"
+)
+
+// highBackpressure is the load of the bulk engine above which the flushes are spaced out.
+const highBackpressure = 0.75
+
+// backpressurer is implemented by the bulk engines reporting their load, see bulk.Bulker.Backpressure.
+type backpressurer interface {
+	Backpressure() float64
+}
+
+// scheduleT adapts the interval between two flushes to the load, within the configured bounds.
+//
+// The interval shrinks while the flushes reach the max batch size, so that large fleets are flushed
+// in bounded batches, and grows back when they are small to avoid tiny bulk requests. It grows as well
+// when a flush takes more than half of the interval or when the bulk engine is saturated, to give
+// elasticsearch room to recover.
+type scheduleT struct {
+	min      time.Duration
+	max      time.Duration
+	maxBatch int
+
+	interval time.Duration
+}
+
+func newSchedule(min, max time.Duration, maxBatch int) scheduleT {
+	return scheduleT{
+		min:      min,
+		max:      max,
+		maxBatch: maxBatch,
+		interval: max,
+	}
+}
+
+// next returns the interval until the next flush, given the size and latency of the last flush
+// and the load of the bulk engine.
+func (s *scheduleT) next(cnt int, latency time.Duration, pressure float64) time.Duration {
+	switch {
+	case pressure >= highBackpressure || latency > s.interval/2:
+		s.interval *= 2
+	case cnt >= s.maxBatch:
+		s.interval /= 2
+	case cnt < s.maxBatch/4:
+		s.interval += s.interval / 4
+	}
+
+	if s.interval < s.min {
+		s.interval = s.min
+	}
+	if s.interval > s.max {
+		s.interval = s.max
+	}
+	return s.interval
+}
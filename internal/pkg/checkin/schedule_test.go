@@ -0,0 +1,116 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package checkin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		cnt      int
+		latency  time.Duration
+		pressure float64
+		expect   time.Duration
+	}{
+		{"full batch", 8 * time.Second, 1000, time.Millisecond, 0, 4 * time.Second},
+		{"full batch min", 1 * time.Second, 1000, time.Millisecond, 0, 1 * time.Second},
+		{"steady", 4 * time.Second, 500, time.Millisecond, 0, 4 * time.Second},
+		{"small batch", 4 * time.Second, 10, time.Millisecond, 0, 5 * time.Second},
+		{"small batch max", 10 * time.Second, 10, time.Millisecond, 0, 10 * time.Second},
+		{"slow flush", 2 * time.Second, 1000, 1500 * time.Millisecond, 0, 4 * time.Second},
+		{"backpressure", 2 * time.Second, 1000, time.Millisecond, 0.9, 4 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newSchedule(time.Second, 10*time.Second, 1000)
+			s.interval = test.interval
+			assert.Equal(t, test.expect, s.next(test.cnt, test.latency, test.pressure))
+		})
+	}
+}
+
+type backpressureBulk struct {
+	*ftesting.MockBulk
+	pressure float64
+}
+
+func (b *backpressureBulk) Backpressure() float64 {
+	return b.pressure
+}
+
+func TestBulkBackpressure(t *testing.T) {
+	bc := NewBulk(ftesting.NewMockBulk())
+	assert.Zero(t, bc.backpressure())
+
+	bc = NewBulk(&backpressureBulk{MockBulk: ftesting.NewMockBulk(), pressure: 0.5})
+	assert.Equal(t, 0.5, bc.backpressure())
+}
+
+func TestFlushBatches(t *testing.T) {
+	_ = testlog.SetLogger(t)
+
+	batchLen := func(n int) func(ops []bulk.MultiOp) bool {
+		return func(ops []bulk.MultiOp) bool { return len(ops) == n }
+	}
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(batchLen(2)), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Twice()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(batchLen(1)), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+
+	bc := NewBulk(mockBulk, WithFlushBounds(0, 0, 2))
+	for _, id := range []string{"agent1", "agent2", "agent3", "agent4", "agent5"} {
+		require.NoError(t, bc.CheckIn(id, "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	}
+
+	cnt, err := bc.flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 5, cnt)
+	mockBulk.AssertExpectations(t)
+}
+
+func TestRunFlushOnFullBatch(t *testing.T) {
+	_ = testlog.SetLogger(t)
+
+	flushed := make(chan struct{})
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchIDs("agent1", "agent2")), mock.Anything).
+		Run(func(mock.Arguments) { close(flushed) }).
+		Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+
+	// the max interval is never reached, the full batch triggers the flush
+	bc := NewBulk(mockBulk, WithFlushBounds(10*time.Millisecond, time.Hour, 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- bc.Run(ctx) }()
+
+	require.NoError(t, bc.CheckIn("agent1", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+	require.NoError(t, bc.CheckIn("agent2", "online", "", nil, nil, nil, "", model.AgentStatusOnline))
+
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("full batch was not flushed")
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+	mockBulk.AssertExpectations(t)
+}
@@ -0,0 +1,44 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// AgentCertificates is the configuration of the client certificates issued to the agents.
+//
+// When enabled, each agent is issued a client certificate at enrollment, signed by the configured
+// certificate authority, and authenticates with it over mutual TLS instead of, or in addition to,
+// its API key. The TLS of the server must be enabled for the agents to present their certificate.
+type AgentCertificates struct {
+	Enabled  bool          `config:"enabled"`
+	CA       string        `config:"certificate_authority"` // PEM certificate of the CA, or path to it
+	Key      string        `config:"key"`                   // PEM key of the CA, or path to it
+	Validity time.Duration `config:"validity"`              // validity of the issued certificates
+	Required bool          `config:"required"`              // reject the agent requests without a valid certificate
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *AgentCertificates) InitDefaults() {
+	c.Enabled = false
+	c.Validity = 365 * 24 * time.Hour
+	c.Required = false
+}
+
+// Validate ensures that the configuration is valid.
+func (c *AgentCertificates) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CA == "" || c.Key == "" {
+		return errors.New("agent_certificates requires certificate_authority and key when enabled")
+	}
+	if c.Validity <= 0 {
+		return errors.New("agent_certificates validity must be positive")
+	}
+	return nil
+}
@@ -16,6 +16,9 @@ const (
 	defaultArtifactTTL  = time.Hour * 24
 	defaultAPIKeyTTL    = time.Minute * 15 // APIKey validation is a bottleneck.
 	defaultAPIKeyJitter = time.Minute * 5  // Jitter allows some randomness on APIKeyTTL, zero to disable
+	defaultAgentTTL     = time.Minute * 30 // Agents are served from the cache only while Elasticsearch is unavailable
+
+	defaultAgentRecordTTL = time.Second * 10 // Agents are served from the cache without searching Elasticsearch
 )
 
 type Cache struct {
@@ -26,6 +29,9 @@ type Cache struct {
 	ArtifactTTL  time.Duration `config:"ttl_artifact"`
 	APIKeyTTL    time.Duration `config:"ttl_api_key"`
 	APIKeyJitter time.Duration `config:"jitter_api_key"`
+	AgentTTL     time.Duration `config:"ttl_agent"`
+
+	AgentRecordTTL time.Duration `config:"ttl_agent_record"`
 }
 
 func (c *Cache) InitDefaults() {
@@ -57,6 +63,12 @@ func (c *Cache) LoadLimits(limits *envLimits) {
 	if c.APIKeyJitter == 0 {
 		c.APIKeyJitter = defaultAPIKeyJitter
 	}
+	if c.AgentTTL == 0 {
+		c.AgentTTL = defaultAgentTTL
+	}
+	if c.AgentRecordTTL == 0 {
+		c.AgentRecordTTL = defaultAgentRecordTTL
+	}
 }
 
 // CopyCache returns a copy of the config's Cache settings
@@ -70,6 +82,9 @@ func CopyCache(cfg *Config) Cache {
 		ArtifactTTL:  ccfg.ArtifactTTL,
 		APIKeyTTL:    ccfg.APIKeyTTL,
 		APIKeyJitter: ccfg.APIKeyJitter,
+		AgentTTL:     ccfg.AgentTTL,
+
+		AgentRecordTTL: ccfg.AgentRecordTTL,
 	}
 }
 
@@ -82,4 +97,6 @@ func (c *Cache) MarshalZerologObject(e *zerolog.Event) {
 	e.Dur("artifactTTL", c.ArtifactTTL)
 	e.Dur("apiKeyTTL", c.APIKeyTTL)
 	e.Dur("apiKeyJitter", c.APIKeyJitter)
+	e.Dur("agentTTL", c.AgentTTL)
+	e.Dur("agentRecordTTL", c.AgentRecordTTL)
 }
@@ -0,0 +1,22 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+// CheckinJournal is the configuration of the on-disk journal of the pending checkins.
+//
+// When enabled, the checkins waiting to be flushed to Elasticsearch are appended to the
+// journal and replayed on startup, so they survive a crash of the Fleet Server.
+type CheckinJournal struct {
+	Enabled bool   `config:"enabled"`
+	Path    string `config:"path"`     // directory of the journal segments
+	MaxSize int64  `config:"max_size"` // maximum size of a segment, checkins are not journaled past it
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *CheckinJournal) InitDefaults() {
+	c.Enabled = false
+	c.Path = "checkin-journal"
+	c.MaxSize = 256 * 1024 * 1024
+}
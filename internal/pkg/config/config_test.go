@@ -38,8 +38,11 @@ func TestConfig(t *testing.T) {
 						Server: defaultServer(),
 						Cache:  defaultCache(),
 						Monitor: Monitor{
-							FetchSize:   defaultFetchSize,
-							PollTimeout: defaultPollTimeout,
+							FetchSize:                defaultFetchSize,
+							PollTimeout:              defaultPollTimeout,
+							SubscriptionPolicy:       defaultSubscriptionPolicy,
+							SubscriptionQueueSize:    defaultSubscriptionQueueSize,
+							SubscriptionLagThreshold: defaultSubscriptionLagThreshold,
 						},
 					},
 				},
@@ -66,8 +69,11 @@ func TestConfig(t *testing.T) {
 						Server: defaultServer(),
 						Cache:  defaultCache(),
 						Monitor: Monitor{
-							FetchSize:   defaultFetchSize,
-							PollTimeout: defaultPollTimeout,
+							FetchSize:                defaultFetchSize,
+							PollTimeout:              defaultPollTimeout,
+							SubscriptionPolicy:       defaultSubscriptionPolicy,
+							SubscriptionQueueSize:    defaultSubscriptionQueueSize,
+							SubscriptionLagThreshold: defaultSubscriptionLagThreshold,
 						},
 					},
 				},
@@ -87,8 +93,11 @@ func TestConfig(t *testing.T) {
 						Server: defaultServer(),
 						Cache:  defaultCache(),
 						Monitor: Monitor{
-							FetchSize:   defaultFetchSize,
-							PollTimeout: defaultPollTimeout,
+							FetchSize:                defaultFetchSize,
+							PollTimeout:              defaultPollTimeout,
+							SubscriptionPolicy:       defaultSubscriptionPolicy,
+							SubscriptionQueueSize:    defaultSubscriptionQueueSize,
+							SubscriptionLagThreshold: defaultSubscriptionLagThreshold,
 						},
 					},
 				},
@@ -109,6 +118,10 @@ func TestConfig(t *testing.T) {
 							Host:         "localhost",
 							Port:         8888,
 							InternalPort: 8221,
+							Socket:       ServerSocket{Mode: "0660"},
+							TrustedProxies: TrustedProxies{
+								HeaderTimeout: 5 * time.Second,
+							},
 							Timeouts: ServerTimeouts{
 								Read:             20 * time.Second,
 								ReadHeader:       5 * time.Second,
@@ -118,6 +131,11 @@ func TestConfig(t *testing.T) {
 								CheckinLongPoll:  5 * time.Minute,
 								CheckinJitter:    30 * time.Second,
 							},
+							TLSReload: TLSReload{Interval: 30 * time.Second},
+							TLSSelfSigned: SelfSigned{
+								Path:     "bootstrap-certs",
+								Validity: 365 * 24 * time.Hour,
+							},
 							Profiler: ServerProfiler{
 								Enabled: false,
 								Bind:    "localhost:6060",
@@ -127,11 +145,16 @@ func TestConfig(t *testing.T) {
 							Limits:            generateServerLimits(12500),
 							Bulk:              defaultServerBulk(),
 							GC:                defaultServerGC(),
+							CheckinJournal:    defaultCheckinJournal(),
+							AgentCertificates: defaultAgentCertificates(),
 						},
 						Cache: generateCache(12500),
 						Monitor: Monitor{
-							FetchSize:   defaultFetchSize,
-							PollTimeout: defaultPollTimeout,
+							FetchSize:                defaultFetchSize,
+							PollTimeout:              defaultPollTimeout,
+							SubscriptionPolicy:       defaultSubscriptionPolicy,
+							SubscriptionQueueSize:    defaultSubscriptionQueueSize,
+							SubscriptionLagThreshold: defaultSubscriptionLagThreshold,
 						},
 					},
 				},
@@ -240,6 +263,18 @@ func defaultServerBulk() ServerBulk {
 	return d
 }
 
+func defaultCheckinJournal() CheckinJournal {
+	var d CheckinJournal
+	d.InitDefaults()
+	return d
+}
+
+func defaultAgentCertificates() AgentCertificates {
+	var d AgentCertificates
+	d.InitDefaults()
+	return d
+}
+
 func defaultServerGC() GC {
 	var d GC
 	d.InitDefaults()
@@ -47,6 +47,29 @@ type ServerBulk struct {
 	FlushThresholdCount int           `config:"flush_threshold_cnt"`
 	FlushThresholdSize  int           `config:"flush_threshold_size"`
 	FlushMaxPending     int           `config:"flush_max_pending"`
+
+	// Retries of the items rejected with a transient error
+	RetryMax         int           `config:"retry_max"`
+	RetryBackoffInit time.Duration `config:"retry_backoff_init"`
+	RetryBackoffMax  time.Duration `config:"retry_backoff_max"`
+	RetryStatuses    []int         `config:"retry_statuses"` // 429, 502, 503 and 504 when empty
+
+	// Fail fast once elasticsearch is unavailable
+	CircuitBreakerThreshold int           `config:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `config:"circuit_breaker_cooldown"`
+
+	// Compression of the request bodies sent to elasticsearch: none, gzip or deflate
+	Compression          string `config:"compression"`
+	CompressionLevel     int    `config:"compression_level"`
+	CompressionThreshold int    `config:"compression_threshold"`
+
+	// Scheduling overrides by queue name: bulk, read, search, fleetSearch, refreshBulk, refreshRead, apiKeyUpdate
+	Queues map[string]ServerBulkQueue `config:"queues"`
+
+	// Bounds of the adaptive flush of the agent checkins
+	CheckinFlushIntervalMin time.Duration `config:"checkin_flush_interval_min"`
+	CheckinFlushIntervalMax time.Duration `config:"checkin_flush_interval_max"`
+	CheckinFlushBatchMax    int           `config:"checkin_flush_batch_max"`
 }
 
 func (c *ServerBulk) InitDefaults() {
@@ -54,6 +77,38 @@ func (c *ServerBulk) InitDefaults() {
 	c.FlushThresholdCount = 2048
 	c.FlushThresholdSize = 1024 * 1024
 	c.FlushMaxPending = 8
+	c.RetryMax = 3
+	c.RetryBackoffInit = 100 * time.Millisecond
+	c.RetryBackoffMax = 5 * time.Second
+	c.CircuitBreakerThreshold = 5
+	c.CircuitBreakerCooldown = 10 * time.Second
+	c.Compression = "none"
+	c.CompressionLevel = flate.BestSpeed
+	c.CompressionThreshold = 1024
+	c.CheckinFlushIntervalMin = 1 * time.Second
+	c.CheckinFlushIntervalMax = 10 * time.Second
+	c.CheckinFlushBatchMax = 10000
+}
+
+// ServerBulkQueue is the scheduling of a bulk engine queue, zero values keep the defaults.
+type ServerBulkQueue struct {
+	Priority            int `config:"priority"` // queues with a higher priority are flushed first
+	MaxPending          int `config:"max_pending"`
+	FlushThresholdCount int `config:"flush_threshold_cnt"`
+	FlushThresholdSize  int `config:"flush_threshold_size"`
+}
+
+// Validate ensures that the configuration is valid.
+func (c *ServerBulk) Validate() error {
+	switch c.Compression {
+	case "", "none", "gzip", "deflate":
+	default:
+		return fmt.Errorf("bulk compression must be one of none, gzip or deflate, got %q", c.Compression)
+	}
+	if c.CompressionLevel < flate.HuffmanOnly || c.CompressionLevel > flate.BestCompression {
+		return fmt.Errorf("bulk compression_level must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	return nil
 }
 
 // Server is the configuration for the server
@@ -61,7 +116,12 @@ type Server struct {
 	Host              string                  `config:"host"`
 	Port              uint16                  `config:"port"`
 	InternalPort      uint16                  `config:"internal_port"`
+	InternalHost      string                  `config:"internal_host"` // localhost when empty
+	Socket            ServerSocket            `config:"socket"`
+	TrustedProxies    TrustedProxies          `config:"trusted_proxies"`
 	TLS               *tlscommon.ServerConfig `config:"ssl"`
+	TLSReload         TLSReload               `config:"ssl_reload"`
+	TLSSelfSigned     SelfSigned              `config:"ssl_self_signed"`
 	Timeouts          ServerTimeouts          `config:"timeouts"`
 	Profiler          ServerProfiler          `config:"profiler"`
 	CompressionLevel  int                     `config:"compression_level"`
@@ -71,6 +131,9 @@ type Server struct {
 	Bulk              ServerBulk              `config:"bulk"`
 	GC                GC                      `config:"gc"`
 	Instrumentation   Instrumentation         `config:"instrumentation"`
+	AgentLifecycle    AgentLifecycle          `config:"agent_lifecycle"`
+	CheckinJournal    CheckinJournal          `config:"checkin_journal"`
+	AgentCertificates AgentCertificates       `config:"agent_certificates"`
 }
 
 // InitDefaults initializes the defaults for the configuration.
@@ -78,7 +141,11 @@ func (c *Server) InitDefaults() {
 	c.Host = kDefaultHost
 	c.Port = kDefaultPort
 	c.InternalPort = kDefaultInternalPort
+	c.Socket.InitDefaults()
+	c.TrustedProxies.InitDefaults()
 	c.Timeouts.InitDefaults()
+	c.TLSReload.InitDefaults()
+	c.TLSSelfSigned.InitDefaults()
 	c.CompressionLevel = flate.BestSpeed
 	c.CompressionThresh = 1024
 	c.Profiler.InitDefaults()
@@ -86,6 +153,8 @@ func (c *Server) InitDefaults() {
 	c.Runtime.InitDefaults()
 	c.Bulk.InitDefaults()
 	c.GC.InitDefaults()
+	c.CheckinJournal.InitDefaults()
+	c.AgentCertificates.InitDefaults()
 }
 
 // BindEndpoints returns the binding address for the all HTTP server listeners.
@@ -108,14 +177,22 @@ func (c *Server) BindAddress() string {
 
 // BindInternalAddress returns the binding address for the internal HTTP server.
 func (c *Server) BindInternalAddress() string {
+	host := c.InternalHost
+	if host == "" {
+		host = kDefaultInternalHost
+	}
 	if c.InternalPort <= 0 {
-		return bindAddress(kDefaultInternalHost, kDefaultInternalPort)
+		return bindAddress(host, kDefaultInternalPort)
 	}
 
-	return bindAddress(kDefaultInternalHost, c.InternalPort)
+	return bindAddress(host, c.InternalPort)
 }
 
+// bindAddress returns the address of host and port, or host alone for a unix domain socket.
 func bindAddress(host string, port uint16) string {
+	if _, ok := UnixSocketPath(host); ok {
+		return host
+	}
 	if strings.Count(host, ":") > 1 && strings.Count(host, "]") == 0 {
 		host = "[" + host + "]"
 	}
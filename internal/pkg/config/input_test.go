@@ -8,12 +8,15 @@
 package config
 
 import (
+	"os"
 	"testing"
 
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
+	"github.com/elastic/go-ucfg/yaml"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBindAddress(t *testing.T) {
@@ -35,6 +38,13 @@ func TestBindAddress(t *testing.T) {
 			},
 			result: "[::1]:6565",
 		},
+		"unix socket": {
+			cfg: Server{
+				Host: "unix:///run/fleet-server.sock",
+				Port: 8220,
+			},
+			result: "unix:///run/fleet-server.sock",
+		},
 	}
 
 	for name, test := range testcases {
@@ -50,3 +60,66 @@ func TestBindAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestBindEndpoints(t *testing.T) {
+	cfg := Server{Host: "0.0.0.0", Port: 8220}
+	assert.Equal(t, []string{"0.0.0.0:8220", "localhost:8221"}, cfg.BindEndpoints())
+
+	cfg.InternalHost = "unix:///run/fleet-server-internal.sock"
+	assert.Equal(t, []string{"0.0.0.0:8220", "unix:///run/fleet-server-internal.sock"}, cfg.BindEndpoints())
+
+	path, ok := UnixSocketPath(cfg.BindInternalAddress())
+	assert.True(t, ok)
+	assert.Equal(t, "/run/fleet-server-internal.sock", path)
+	_, ok = UnixSocketPath(cfg.BindAddress())
+	assert.False(t, ok)
+}
+
+func TestServerSocketFileMode(t *testing.T) {
+	socket := ServerSocket{}
+	socket.InitDefaults()
+	mode, err := socket.FileMode()
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), mode)
+
+	for _, invalid := range []string{"", "rw", "0999", "01777"} {
+		socket.Mode = invalid
+		assert.Error(t, socket.Validate(), invalid)
+	}
+}
+
+func TestTrustedProxiesNetworks(t *testing.T) {
+	proxies := TrustedProxies{CIDRs: []string{"10.0.0.0/8", "192.168.1.10", "fd00::1"}}
+	nets, err := proxies.Networks()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.10/32", "fd00::1/128"}, []string{nets[0].String(), nets[1].String(), nets[2].String()})
+
+	proxies.CIDRs = []string{"10.0.0.0/33"}
+	assert.Error(t, proxies.Validate())
+	proxies.CIDRs = []string{"proxy.example.com"}
+	assert.Error(t, proxies.Validate())
+
+	proxies = TrustedProxies{ProxyProtocol: true}
+	assert.Error(t, proxies.Validate(), "the PROXY protocol is not accepted from any peer")
+}
+
+func TestMemoryLimitsValidate(t *testing.T) {
+	assert.NoError(t, (&MemoryLimits{}).Validate())
+	assert.NoError(t, (&MemoryLimits{SoftLimit: 1000}).Validate(), "a single limit is valid")
+	assert.NoError(t, (&MemoryLimits{SoftLimit: 1000, HardLimit: 1000}).Validate())
+	assert.Error(t, (&MemoryLimits{SoftLimit: 2000, HardLimit: 1000}).Validate())
+}
+
+func TestServerBulkRetryStatuses(t *testing.T) {
+	var defaults ServerBulk
+	defaults.InitDefaults()
+	assert.Empty(t, defaults.RetryStatuses, "the bulk engine retries its default statuses")
+
+	c, err := yaml.NewConfig([]byte("retry_statuses: [429]"), DefaultOptions...)
+	require.NoError(t, err)
+
+	var bulk ServerBulk
+	require.NoError(t, c.Unpack(&bulk, DefaultOptions...))
+	assert.Equal(t, []int{429}, bulk.RetryStatuses)
+	assert.Equal(t, 3, bulk.RetryMax)
+}
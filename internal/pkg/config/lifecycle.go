@@ -0,0 +1,12 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+// AgentLifecycle is the configuration for the inactivity lifecycle of the Elastic Agents.
+// The lifecycle timeouts are set per policy, it is enforced by the leader of the policy.
+type AgentLifecycle struct {
+	// DryRun reports the lifecycle transitions that would be made without writing them.
+	DryRun bool `config:"dry_run"`
+}
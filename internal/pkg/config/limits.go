@@ -5,6 +5,7 @@
 package config
 
 import (
+	"errors"
 	"time"
 )
 
@@ -13,6 +14,22 @@ type Limit struct {
 	Burst    int           `config:"burst"`
 	Max      int64         `config:"max"`
 	MaxBody  int64         `config:"max_body_byte_size"`
+
+	// PerAgent and PerIP limit the rate of the requests of a single agent and of a single source IP
+	// address, on top of the rate of all requests. They are disabled when their interval is 0.
+	PerAgent KeyLimit `config:"per_agent"`
+	PerIP    KeyLimit `config:"per_ip"`
+
+	// FleetServerShare is the share of the rate and of the max reserved to the agents supervising a
+	// fleet-server, on the checkin and ack routes. It is disabled when 0.
+	FleetServerShare float64 `config:"fleet_server_share"`
+}
+
+// KeyLimit is a token bucket per key, the buckets of the MaxKeys most recently seen keys being kept.
+type KeyLimit struct {
+	Interval time.Duration `config:"interval"`
+	Burst    int           `config:"burst"`
+	MaxKeys  int           `config:"max_keys"`
 }
 
 type ServerLimits struct {
@@ -26,8 +43,46 @@ type ServerLimits struct {
 	EnrollLimit   Limit `config:"enroll_limit"`
 	AckLimit      Limit `config:"ack_limit"`
 	StatusLimit   Limit `config:"status_limit"`
+
+	Adaptive AdaptiveLimits `config:"adaptive"`
+	Memory   MemoryLimits   `config:"memory"`
+}
+
+// AdaptiveLimits adjusts the max of the checkin, enroll and ack limits to the latency and the error
+// rate of elasticsearch, between MinRatio times the configured max and the configured max.
+type AdaptiveLimits struct {
+	Enabled       bool          `config:"enabled"`
+	Interval      time.Duration `config:"interval"`
+	TargetLatency time.Duration `config:"target_latency"`
+	MaxErrorRate  float64       `config:"max_error_rate"`
+	MinRatio      float64       `config:"min_ratio"`
+}
+
+// MemoryLimits sheds the new enrollments once the heap reaches SoftLimit bytes, and the new checkins
+// as well once it reaches HardLimit bytes. A zero limit is disabled.
+type MemoryLimits struct {
+	SoftLimit uint64        `config:"soft_limit"`
+	HardLimit uint64        `config:"hard_limit"`
+	Interval  time.Duration `config:"interval"`
+}
+
+// Validate ensures that the configuration is valid.
+func (c *MemoryLimits) Validate() error {
+	if c.SoftLimit != 0 && c.HardLimit != 0 && c.HardLimit < c.SoftLimit {
+		return errors.New("memory hard_limit must be greater than or equal to soft_limit")
+	}
+	return nil
 }
 
+const (
+	defaultMemoryInterval = time.Second
+
+	defaultAdaptiveInterval      = 5 * time.Second
+	defaultAdaptiveTargetLatency = time.Second
+	defaultAdaptiveMaxErrorRate  = 0.05
+	defaultAdaptiveMinRatio      = 0.1
+)
+
 // InitDefaults initializes the defaults for the configuration.
 func (c *ServerLimits) InitDefaults() {
 	c.LoadLimits(loadLimits(0))
@@ -51,6 +106,22 @@ func (c *ServerLimits) LoadLimits(limits *envLimits) {
 	c.EnrollLimit = mergeEnvLimit(c.EnrollLimit, l.EnrollLimit)
 	c.AckLimit = mergeEnvLimit(c.AckLimit, l.AckLimit)
 	c.StatusLimit = mergeEnvLimit(c.StatusLimit, l.StatusLimit)
+
+	if c.Adaptive.Interval == 0 {
+		c.Adaptive.Interval = defaultAdaptiveInterval
+	}
+	if c.Adaptive.TargetLatency == 0 {
+		c.Adaptive.TargetLatency = defaultAdaptiveTargetLatency
+	}
+	if c.Adaptive.MaxErrorRate == 0 {
+		c.Adaptive.MaxErrorRate = defaultAdaptiveMaxErrorRate
+	}
+	if c.Adaptive.MinRatio == 0 {
+		c.Adaptive.MinRatio = defaultAdaptiveMinRatio
+	}
+	if c.Memory.Interval == 0 {
+		c.Memory.Interval = defaultMemoryInterval
+	}
 }
 
 func mergeEnvLimit(L Limit, l limit) Limit {
@@ -59,6 +130,10 @@ func mergeEnvLimit(L Limit, l limit) Limit {
 		Burst:    L.Burst,
 		Max:      L.Max,
 		MaxBody:  L.MaxBody,
+		PerAgent: L.PerAgent,
+		PerIP:    L.PerIP,
+
+		FleetServerShare: L.FleetServerShare,
 	}
 	if result.Interval == 0 {
 		result.Interval = l.Interval
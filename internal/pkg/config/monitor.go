@@ -4,19 +4,48 @@
 
 package config
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 const (
-	defaultFetchSize   = 1000
-	defaultPollTimeout = 4 * time.Minute
+	defaultFetchSize                = 1000
+	defaultPollTimeout              = 4 * time.Minute
+	defaultSubscriptionPolicy       = "timeout"
+	defaultSubscriptionQueueSize    = 1
+	defaultSubscriptionLagThreshold = 30 * time.Second
 )
 
 type Monitor struct {
 	FetchSize   int           `config:"fetch_size"`
 	PollTimeout time.Duration `config:"poll_timeout"`
+
+	// SubscriptionPolicy is applied when the queue of a subscription is full: timeout waits for
+	// the subscription timeout then drops the new documents, block waits for the subscriber,
+	// drop_oldest drops the oldest queued documents and coalesce merges them with the new ones.
+	SubscriptionPolicy       string        `config:"subscription_policy"`
+	SubscriptionQueueSize    int           `config:"subscription_queue_size"`
+	SubscriptionLagThreshold time.Duration `config:"subscription_lag_threshold"` // a warning is logged once a subscription is stalled for longer
 }
 
 func (m *Monitor) InitDefaults() {
 	m.FetchSize = defaultFetchSize
 	m.PollTimeout = defaultPollTimeout
+	m.SubscriptionPolicy = defaultSubscriptionPolicy
+	m.SubscriptionQueueSize = defaultSubscriptionQueueSize
+	m.SubscriptionLagThreshold = defaultSubscriptionLagThreshold
+}
+
+// Validate ensures that the configuration is valid.
+func (m *Monitor) Validate() error {
+	switch m.SubscriptionPolicy {
+	case "timeout", "block", "drop_oldest", "coalesce":
+	default:
+		return fmt.Errorf("monitor subscription_policy must be one of timeout, block, drop_oldest or coalesce, got %q", m.SubscriptionPolicy)
+	}
+	if m.SubscriptionQueueSize < 1 {
+		return fmt.Errorf("monitor subscription_queue_size must be at least 1")
+	}
+	return nil
 }
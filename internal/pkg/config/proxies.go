@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// TrustedProxies is the configuration of the load balancers and proxies in front of the server.
+//
+// The client address of the connections and requests from the trusted proxies is resolved from
+// their PROXY protocol header when enabled, and from their X-Forwarded-For header. The resolved
+// address is the one logged and rate limited by.
+type TrustedProxies struct {
+	CIDRs         []string      `config:"cidrs"`          // networks, or addresses, of the trusted proxies
+	ProxyProtocol bool          `config:"proxy_protocol"` // accept the PROXY protocol v1 and v2 headers from the trusted proxies
+	HeaderTimeout time.Duration `config:"header_timeout"` // timeout to read a PROXY protocol header
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *TrustedProxies) InitDefaults() {
+	c.ProxyProtocol = false
+	c.HeaderTimeout = 5 * time.Second
+}
+
+// Validate ensures that the configuration is valid.
+func (c *TrustedProxies) Validate() error {
+	if _, err := c.Networks(); err != nil {
+		return err
+	}
+	if c.ProxyProtocol && len(c.CIDRs) == 0 {
+		return errors.New("trusted_proxies proxy_protocol requires the cidrs of the trusted proxies")
+	}
+	return nil
+}
+
+// Networks returns the networks of the trusted proxies, an address being a network of its own.
+func (c *TrustedProxies) Networks() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(c.CIDRs))
+	for _, cidr := range c.CIDRs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted_proxies cidrs must be networks or addresses, got %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies cidrs must be networks or addresses, got %q", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
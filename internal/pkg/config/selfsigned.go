@@ -0,0 +1,28 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+// SelfSigned is the configuration of the self-signed bootstrap certificate of the server.
+//
+// When enabled and the TLS of the server is not configured, a local CA and a server certificate
+// signed by it are generated on the first start and persisted to path, then served on the next
+// starts. The server certificate is issued for localhost, the hostname, the host of the server and
+// the extra hosts, and issued again when it does not cover them or is about to expire. The agents
+// pin the CA with the fingerprint of the authenticated status response.
+type SelfSigned struct {
+	Enabled  bool          `config:"enabled"`
+	Path     string        `config:"path"`     // directory of the CA and the server certificate
+	Hosts    []string      `config:"hosts"`    // extra host names and IPs of the server certificate
+	Validity time.Duration `config:"validity"` // validity of the server certificate
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *SelfSigned) InitDefaults() {
+	c.Enabled = false
+	c.Path = "bootstrap-certs"
+	c.Validity = 365 * 24 * time.Hour
+}
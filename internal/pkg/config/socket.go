@@ -0,0 +1,55 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix is the prefix of the bind addresses of the unix domain sockets, e.g. unix:///run/fleet-server.sock.
+const unixPrefix = "unix://"
+
+// ServerSocket is the configuration of the files of the unix domain sockets the server binds to,
+// when its host or internal host is a unix:// address. The sockets are served over plain HTTP, the
+// access to them being controlled by their file permissions.
+type ServerSocket struct {
+	Mode  string `config:"mode"`  // octal permissions of the socket files
+	User  string `config:"user"`  // owner of the socket files, name or uid, unchanged when empty
+	Group string `config:"group"` // group of the socket files, name or gid, unchanged when empty
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ServerSocket) InitDefaults() {
+	c.Mode = "0660"
+}
+
+// Validate ensures that the configuration is valid.
+func (c *ServerSocket) Validate() error {
+	if _, err := c.FileMode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FileMode returns the permissions of the socket files.
+func (c *ServerSocket) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("socket mode must be octal permissions, got %q", c.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// UnixSocketPath returns the path of the unix domain socket of a bind address, and true if the
+// address is the one of a unix domain socket.
+func UnixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, unixPrefix), true
+}
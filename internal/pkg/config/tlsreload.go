@@ -0,0 +1,18 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+// TLSReload is the configuration of the reload of the TLS certificate, key and certificate
+// authorities of the server when their files change, without restarting the listeners.
+type TLSReload struct {
+	Interval time.Duration `config:"interval"` // interval between the checks of the files, 0 to disable
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *TLSReload) InitDefaults() {
+	c.Interval = 30 * time.Second
+}
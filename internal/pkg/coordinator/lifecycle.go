@@ -0,0 +1,318 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const unenrollActionDelete = "delete" // policy unenroll_action deleting the agent instead of unenrolling it
+
+// stageT is a stage of the inactivity lifecycle of an agent.
+type stageT int
+
+const (
+	stageOffline stageT = iota
+	stageInactive
+	stageUnenroll
+)
+
+func (s stageT) String() string {
+	switch s {
+	case stageOffline:
+		return "offline"
+	case stageInactive:
+		return "inactive"
+	case stageUnenroll:
+		return "unenroll"
+	}
+	panic("unknown")
+}
+
+// lifecycleT is the inactivity lifecycle of the agents of a policy.
+//
+// Agents that stopped checking in are marked offline after offlineTimeout, marked inactive
+// after inactivityTimeout and finally unenrolled (or deleted) after unenrollTimeout.
+// A zero timeout disables the stage.
+type lifecycleT struct {
+	offlineTimeout    time.Duration
+	inactivityTimeout time.Duration
+	unenrollTimeout   time.Duration
+	deleteOnUnenroll  bool
+}
+
+func newLifecycle(p *model.Policy) lifecycleT {
+	return lifecycleT{
+		offlineTimeout:    time.Duration(p.OfflineTimeout) * time.Second,
+		inactivityTimeout: time.Duration(p.InactivityTimeout) * time.Second,
+		unenrollTimeout:   time.Duration(p.UnenrollTimeout) * time.Second,
+		deleteOnUnenroll:  p.UnenrollAction == unenrollActionDelete,
+	}
+}
+
+func (lc lifecycleT) timeout(s stageT) time.Duration {
+	switch s {
+	case stageOffline:
+		return lc.offlineTimeout
+	case stageInactive:
+		return lc.inactivityTimeout
+	default:
+		return lc.unenrollTimeout
+	}
+}
+
+// enabled returns true when at least one stage of the lifecycle is enabled.
+func (lc lifecycleT) enabled() bool {
+	return lc.offlineTimeout > 0 || lc.inactivityTimeout > 0 || lc.unenrollTimeout > 0
+}
+
+// gracePeriod returns the smallest enabled timeout.
+func (lc lifecycleT) gracePeriod() time.Duration {
+	var d time.Duration
+	for _, s := range []stageT{stageOffline, stageInactive, stageUnenroll} {
+		if t := lc.timeout(s); t > 0 && (d == 0 || t < d) {
+			d = t
+		}
+	}
+	return d
+}
+
+func (lc lifecycleT) MarshalZerologObject(e *zerolog.Event) {
+	e.Dur("offline_timeout", lc.offlineTimeout)
+	e.Dur("inactivity_timeout", lc.inactivityTimeout)
+	e.Dur("unenroll_timeout", lc.unenrollTimeout)
+	e.Bool("delete_on_unenroll", lc.deleteOnUnenroll)
+}
+
+func runLifecycle(ctx context.Context, bulker bulk.Bulk, policyID string, lc lifecycleT, l zerolog.Logger, checkInterval time.Duration, agentsIndex string, dryRun bool) {
+	// When fleet-server is offline for a long period and finally recovers, it means that the connected
+	// agent will be offline for a long period of time since their last checkin and fleet server will
+	// start unenrolling every Elastic Agent from the system. Instead on boot the Elastic Agent
+	// should wait at least the timeout of a stage before actively enforcing it on agents in the system.
+	// This gives a grace period to the Elastic Agent to connect back to the system.
+	started := time.Now()
+	l = l.With().Object("lifecycle", lc).Bool("dry_run", dryRun).Logger()
+	l.Info().
+		Dur("checkInterval", checkInterval).
+		Msg("giving a grace period to Elastic Agent before enforcing the inactivity lifecycle monitor")
+
+	if err := waitWithContext(ctx, lc.gracePeriod()); err != nil {
+		l.Err(err).Msg("failed to delay the inactivity lifecycle monitor startup")
+	}
+
+	l.Info().
+		Dur("checkInterval", checkInterval).
+		Msg("inactivity lifecycle monitor start")
+	defer l.Info().Msg("inactivity lifecycle monitor exit")
+
+	t := time.NewTimer(checkInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			l.Debug().Msg("running inactivity lifecycle")
+			if err := runLifecycleWork(ctx, bulker, policyID, lc, time.Since(started), l, agentsIndex, dryRun); err != nil {
+				l.Err(err).Msg("failed to enforce inactivity lifecycle on offline agents")
+			}
+			t.Reset(checkInterval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runLifecycleWork moves the offline agents of the policy to the next stage of the lifecycle.
+// A stage is only enforced once the monitor has been running for at least the stage timeout.
+func runLifecycleWork(ctx context.Context, bulker bulk.Bulk, policyID string, lc lifecycleT, running time.Duration, zlog zerolog.Logger, agentsIndex string, dryRun bool) error {
+	for _, s := range []stageT{stageOffline, stageInactive, stageUnenroll} {
+		timeout := lc.timeout(s)
+		if timeout == 0 || running < timeout {
+			continue
+		}
+		if err := runStageWork(ctx, bulker, policyID, s, lc, zlog, agentsIndex, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runStageWork(ctx context.Context, bulker bulk.Bulk, policyID string, s stageT, lc lifecycleT, zlog zerolog.Logger, agentsIndex string, dryRun bool) error {
+	var tmpl *dsl.Tmpl
+	switch s {
+	case stageOffline:
+		tmpl = dl.QueryAgentsToMarkOffline
+	case stageInactive:
+		tmpl = dl.QueryAgentsToMarkInactive
+	default:
+		tmpl = dl.QueryOfflineAgentsByPolicyID
+	}
+
+	timeout := lc.timeout(s)
+	zlog = zlog.With().Str("stage", s.String()).Dur("timeout", timeout).Logger()
+
+	agents, err := dl.FindOfflineAgentsWithQuery(ctx, bulker, tmpl, policyID, timeout, dl.WithIndexName(agentsIndex))
+	if err != nil {
+		if errors.Is(err, dl.ErrNotFound) {
+			zlog.Debug().Msg("no agents to transition")
+			return nil
+		}
+		return err
+	}
+
+	agentIds := make([]string, len(agents))
+	for i := range agents {
+		agentIds[i] = agents[i].Id
+	}
+
+	if dryRun {
+		zlog.Info().
+			Strs(logger.AgentID, agentIds).
+			Msg("dry run: agents would be transitioned due to inactivity")
+		return nil
+	}
+
+	switch {
+	case s == stageUnenroll && lc.deleteOnUnenroll:
+		err = deleteAgents(ctx, zlog, bulker, agents, agentsIndex)
+	case s == stageUnenroll:
+		err = unenrollAgents(ctx, zlog, bulker, agents, agentsIndex)
+	default:
+		err = markAgents(ctx, bulker, s, agents, agentsIndex)
+	}
+	if err != nil {
+		return err
+	}
+
+	zlog.Info().
+		Strs(logger.AgentID, agentIds).
+		Msg("transitioned agents due to inactivity")
+
+	return nil
+}
+
+func markAgents(ctx context.Context, bulker bulk.Bulk, s stageT, agents []model.Agent, agentsIndex string) error {
+	now := time.Now().UTC()
+	ts := now.Format(time.RFC3339)
+	field := dl.FieldOfflineAt
+	if s == stageInactive {
+		field = dl.FieldInactiveAt
+	}
+
+	// bodies only differ by the computed status
+	bodies := make(map[string][]byte)
+	ops := make([]bulk.MultiOp, len(agents))
+	for i := range agents {
+		agent := agents[i]
+		if s == stageInactive {
+			agent.InactiveAt = ts
+		} else {
+			agent.OfflineAt = ts
+		}
+		status := agent.ComputeStatus(now)
+
+		body, ok := bodies[status]
+		if !ok {
+			fields := bulk.UpdateFields{
+				field:             ts,
+				dl.FieldStatus:    status,
+				dl.FieldUpdatedAt: ts,
+			}
+			var err error
+			if body, err = fields.Marshal(); err != nil {
+				return err
+			}
+			bodies[status] = body
+		}
+
+		ops[i] = bulk.MultiOp{
+			ID:    agents[i].Id,
+			Index: agentsIndex,
+			Body:  body,
+		}
+	}
+
+	_, err := bulker.MUpdate(ctx, ops, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+	return err
+}
+
+func unenrollAgents(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agents []model.Agent, agentsIndex string) error {
+	if err := invalidateAgentsAPIKeys(ctx, zlog, bulker, agents); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	fields := bulk.UpdateFields{
+		dl.FieldActive:           false,
+		dl.FieldUnenrolledAt:     now,
+		dl.FieldUnenrolledReason: unenrolledReasonTimeout,
+		dl.FieldStatus:           model.AgentStatusUnenrolled,
+		dl.FieldUpdatedAt:        now,
+	}
+	body, err := fields.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ops := make([]bulk.MultiOp, len(agents))
+	for i := range agents {
+		ops[i] = bulk.MultiOp{
+			ID:    agents[i].Id,
+			Index: agentsIndex,
+			Body:  body,
+		}
+	}
+
+	if _, err = bulker.MUpdate(ctx, ops, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
+		zlog.Error().Err(err).Msg("Fail unenrollAgents record update")
+	}
+	return err
+}
+
+func deleteAgents(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agents []model.Agent, agentsIndex string) error {
+	if err := invalidateAgentsAPIKeys(ctx, zlog, bulker, agents); err != nil {
+		return err
+	}
+
+	ops := make([]bulk.MultiOp, len(agents))
+	for i := range agents {
+		ops[i] = bulk.MultiOp{
+			ID:    agents[i].Id,
+			Index: agentsIndex,
+		}
+	}
+
+	_, err := bulker.MDelete(ctx, ops, bulk.WithRefresh())
+	if err != nil {
+		zlog.Error().Err(err).Msg("Fail deleteAgents record delete")
+	}
+	return err
+}
+
+func invalidateAgentsAPIKeys(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agents []model.Agent) error {
+	var apiKeys []string
+	for i := range agents {
+		apiKeys = append(apiKeys, agents[i].APIKeyIDs()...)
+	}
+	if len(apiKeys) == 0 {
+		return nil
+	}
+
+	if err := bulker.APIKeyInvalidate(ctx, apiKeys...); err != nil {
+		zlog.Error().Err(err).Strs(logger.APIKeyID, apiKeys).Msg("Fail apiKey invalidate")
+		return err
+	}
+	return nil
+}
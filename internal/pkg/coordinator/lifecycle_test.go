@@ -0,0 +1,130 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+func TestNewLifecycle(t *testing.T) {
+	lc := newLifecycle(&model.Policy{
+		OfflineTimeout:    300,
+		InactivityTimeout: 3600,
+		UnenrollTimeout:   86400,
+		UnenrollAction:    unenrollActionDelete,
+	})
+	assert.Equal(t, 5*time.Minute, lc.timeout(stageOffline))
+	assert.Equal(t, time.Hour, lc.timeout(stageInactive))
+	assert.Equal(t, 24*time.Hour, lc.timeout(stageUnenroll))
+	assert.True(t, lc.deleteOnUnenroll)
+	assert.True(t, lc.enabled())
+	assert.Equal(t, 5*time.Minute, lc.gracePeriod())
+
+	lc = newLifecycle(&model.Policy{InactivityTimeout: 3600})
+	assert.True(t, lc.enabled())
+	assert.Equal(t, time.Hour, lc.gracePeriod())
+
+	lc = newLifecycle(&model.Policy{})
+	assert.False(t, lc.enabled())
+}
+
+func offlineAgentResult(t *testing.T, id string) *es.ResultT {
+	t.Helper()
+	body, err := json.Marshal(model.Agent{
+		Active:         true,
+		AccessAPIKeyID: id + "-key",
+		LastCheckin:    time.Now().UTC().Add(-48 * time.Hour).Format(time.RFC3339),
+	})
+	require.NoError(t, err)
+	return &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: id, Source: body}}}}
+}
+
+func TestRunLifecycleWork(t *testing.T) {
+	lc := lifecycleT{
+		offlineTimeout:    time.Minute,
+		inactivityTimeout: time.Hour,
+		unenrollTimeout:   24 * time.Hour,
+	}
+
+	tests := []struct {
+		name    string
+		lc      lifecycleT
+		running time.Duration
+		dryRun  bool
+		setup   func(m *ftesting.MockBulk)
+	}{{
+		name:    "grace period",
+		lc:      lc,
+		running: 30 * time.Second,
+		setup:   func(m *ftesting.MockBulk) {},
+	}, {
+		name:    "offline and inactive stages",
+		lc:      lc,
+		running: 2 * time.Hour,
+		setup: func(m *ftesting.MockBulk) {
+			m.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+				return len(ops) == 1 && ops[0].ID == "agent1" && ops[0].Index == dl.FleetAgents
+			}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Twice()
+		},
+	}, {
+		name:    "all stages",
+		lc:      lc,
+		running: 48 * time.Hour,
+		setup: func(m *ftesting.MockBulk) {
+			m.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Times(3)
+			m.On("APIKeyInvalidate", mock.Anything, []string{"agent1-key"}).Return(nil).Once()
+		},
+	}, {
+		name: "delete on unenroll",
+		lc: lifecycleT{
+			unenrollTimeout:  24 * time.Hour,
+			deleteOnUnenroll: true,
+		},
+		running: 48 * time.Hour,
+		setup: func(m *ftesting.MockBulk) {
+			m.On("APIKeyInvalidate", mock.Anything, []string{"agent1-key"}).Return(nil).Once()
+			m.On("MDelete", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		},
+	}, {
+		name:    "dry run",
+		lc:      lc,
+		running: 48 * time.Hour,
+		dryRun:  true,
+		setup:   func(m *ftesting.MockBulk) {},
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockBulk := ftesting.NewMockBulk()
+			mockBulk.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(offlineAgentResult(t, "agent1"), nil).Maybe()
+			tc.setup(mockBulk)
+
+			err := runLifecycleWork(context.Background(), mockBulk, "policy1", tc.lc, tc.running, zerolog.Nop(), dl.FleetAgents, tc.dryRun)
+			require.NoError(t, err)
+			mockBulk.AssertExpectations(t)
+			if tc.dryRun {
+				mockBulk.AssertNotCalled(t, "MUpdate", mock.Anything, mock.Anything, mock.Anything)
+				mockBulk.AssertNotCalled(t, "APIKeyInvalidate", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
@@ -22,7 +22,6 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
-	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
@@ -34,6 +33,7 @@ const (
 	defaultMetadataInterval        = 5 * time.Minute  // update metadata every 5 minutes
 	defaultCoordinatorRestartDelay = 5 * time.Second  // delay in restarting coordinator on failure
 	defaultUnenrollCheckInterval   = 1 * time.Minute  // perform unenroll timeout interval check
+	defaultStatusCheckInterval     = 1 * time.Minute  // update the status of agents that stopped checking in
 
 	unenrolledReasonTimeout = "timeout" // reason agent was unenrolled
 )
@@ -45,10 +45,66 @@ type Monitor interface {
 }
 
 type policyT struct {
-	id              string
-	cord            Coordinator
-	cordCanceller   context.CancelFunc
-	unenrollTimeout time.Duration
+	id            string
+	cord          Coordinator
+	cordCanceller context.CancelFunc
+	lease         *leaseT
+	lifecycle     lifecycleT
+}
+
+// leaseT holds the fencing lease of a policy led by this Fleet Server.
+//
+// Renewals and fenced writes are serialized, so a renewal cannot invalidate
+// the lease that a policy write is being checked against.
+type leaseT struct {
+	mu       sync.Mutex
+	policyID string
+	serverID string
+	version  string
+	index    string
+	lease    dl.PolicyLease
+}
+
+// renew renews the leadership held with the lease.
+func (l *leaseT) renew(ctx context.Context, bulker bulk.Bulk) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease, err := dl.RenewPolicyLeadership(ctx, bulker, l.policyID, l.serverID, l.version, l.lease, dl.WithIndexName(l.index))
+	if err != nil {
+		return err
+	}
+	l.lease = lease
+	return nil
+}
+
+// fenced runs fn with the fencing token of the lease only if the lease is still held, returns
+// dl.ErrPolicyLeaseLost otherwise.
+//
+// The lease can be lost between the check and the write of fn, so fn must write create-only under an ID
+// the leaders share: a stale leader then either writes the revision before the new leader, which finds it
+// written and keeps it as is, or fails to write it. When fn finds the revision written, the lease is
+// checked again to tell the stale leader, which gets dl.ErrPolicyLeaseLost, from the new one, which gets
+// dl.ErrPolicyRevisionExists.
+func (l *leaseT) fenced(ctx context.Context, bulker bulk.Bulk, fn func(token int64) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := dl.CheckPolicyLeadership(ctx, bulker, l.policyID, l.serverID, l.lease, dl.WithIndexName(l.index))
+	if err != nil {
+		return err
+	}
+	err = fn(l.lease.Token)
+	if errors.Is(err, dl.ErrPolicyRevisionExists) {
+		if cerr := dl.CheckPolicyLeadership(ctx, bulker, l.policyID, l.serverID, l.lease, dl.WithIndexName(l.index)); cerr != nil {
+			return cerr
+		}
+	}
+	return err
+}
+
+func (l *leaseT) token() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lease.Token
 }
 
 type monitorT struct {
@@ -59,6 +115,7 @@ type monitorT struct {
 	factory Factory
 
 	fleet         config.Fleet
+	lifecycle     config.AgentLifecycle
 	version       string
 	agentMetadata model.AgentMetadata
 	hostMetadata  model.HostMetadata
@@ -68,6 +125,7 @@ type monitorT struct {
 	metadataInterval      time.Duration
 	coordRestartDelay     time.Duration
 	unenrollCheckInterval time.Duration
+	statusCheckInterval   time.Duration
 
 	serversIndex  string
 	policiesIndex string
@@ -81,11 +139,12 @@ type monitorT struct {
 }
 
 // NewMonitor creates a new coordinator policy monitor.
-func NewMonitor(fleet config.Fleet, version string, bulker bulk.Bulk, monitor monitor.Monitor, factory Factory) Monitor {
+func NewMonitor(fleet config.Fleet, lifecycle config.AgentLifecycle, version string, bulker bulk.Bulk, monitor monitor.Monitor, factory Factory) Monitor {
 	return &monitorT{
 		log:                   log.With().Str("ctx", "policy leader manager").Logger(),
 		version:               version,
 		fleet:                 fleet,
+		lifecycle:             lifecycle,
 		bulker:                bulker,
 		monitor:               monitor,
 		factory:               factory,
@@ -94,6 +153,7 @@ func NewMonitor(fleet config.Fleet, version string, bulker bulk.Bulk, monitor mo
 		metadataInterval:      defaultMetadataInterval,
 		coordRestartDelay:     defaultCoordinatorRestartDelay,
 		unenrollCheckInterval: defaultUnenrollCheckInterval,
+		statusCheckInterval:   defaultStatusCheckInterval,
 		serversIndex:          dl.FleetServers,
 		policiesIndex:         dl.FleetPolicies,
 		leadersIndex:          dl.FleetPoliciesLeader,
@@ -260,6 +320,26 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 		}
 	}
 
+	// stop coordinators of policies that another server took the leadership of
+	for id, pt := range m.policies {
+		leader, ok := leaders[id]
+		if !ok || leader.Server == nil || leader.Server.ID == m.agentMetadata.ID || containsPolicy(lead, id) {
+			continue
+		}
+		m.log.Warn().Str(dl.FieldPolicyID, id).Str("leader", leader.Server.ID).Msg("policy leadership taken over by another server")
+		if pt.cordCanceller != nil {
+			pt.cordCanceller()
+		}
+		delete(m.policies, id)
+
+		m.muPoliciesCanceller.Lock()
+		if c, ok := m.policiesCanceller[id]; ok {
+			c()
+			delete(m.policiesCanceller, id)
+		}
+		m.muPoliciesCanceller.Unlock()
+	}
+
 	// take/keep leadership and start new coordinators
 	res := make(chan policyT)
 	for _, p := range lead {
@@ -271,9 +351,14 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 			}()
 
 			l := m.log.With().Str(dl.FieldPolicyID, pt.id).Logger()
-			err := dl.TakePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.version, dl.WithIndexName(m.leadersIndex))
+			err := m.holdLeadership(ctx, &pt)
 			if err != nil {
-				l.Err(err).Msg("failed to take ownership")
+				if errors.Is(err, dl.ErrPolicyLeaseLost) {
+					l.Warn().Err(err).Msg("lost policy leadership")
+				} else {
+					l.Err(err).Msg("failed to take ownership")
+				}
+				pt.lease = nil
 				if pt.cord != nil {
 					pt.cord = nil
 				}
@@ -291,12 +376,14 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 					if err != nil {
 						l.Err(err).Msg("failed to release policy leadership")
 					}
+					pt.lease = nil
 					return
 				}
 
 				cordCtx, canceller := context.WithCancel(ctx)
 				go runCoordinator(cordCtx, cord, l, m.coordRestartDelay)
-				go runCoordinatorOutput(cordCtx, cord, m.bulker, l, m.policiesIndex)
+				go runCoordinatorOutput(cordCtx, cord, m.bulker, l, m.policiesIndex, pt.lease)
+				go runStatusSweep(cordCtx, m.bulker, pt.id, l, m.statusCheckInterval, m.agentsIndex)
 				pt.cord = cord
 				pt.cordCanceller = canceller
 			} else {
@@ -324,6 +411,35 @@ func (m *monitorT) ensureLeadership(ctx context.Context) error {
 	return nil
 }
 
+// holdLeadership renews the leadership of the policy when a lease is held, otherwise takes it over.
+func (m *monitorT) holdLeadership(ctx context.Context, pt *policyT) error {
+	if pt.lease != nil {
+		return pt.lease.renew(ctx, m.bulker)
+	}
+	lease, err := dl.TakePolicyLeadership(ctx, m.bulker, pt.id, m.agentMetadata.ID, m.version, dl.WithIndexName(m.leadersIndex))
+	if err != nil {
+		return err
+	}
+	m.log.Info().Str(dl.FieldPolicyID, pt.id).Int64("fencing_token", lease.Token).Msg("took policy leadership")
+	pt.lease = &leaseT{
+		policyID: pt.id,
+		serverID: m.agentMetadata.ID,
+		version:  m.version,
+		index:    m.leadersIndex,
+		lease:    lease,
+	}
+	return nil
+}
+
+func containsPolicy(policies []model.Policy, id string) bool {
+	for _, p := range policies {
+		if p.PolicyID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // releaseLeadership releases current leadership
 func (m *monitorT) releaseLeadership() {
 	var wg sync.WaitGroup
@@ -406,23 +522,23 @@ func (m *monitorT) rescheduleUnenroller(ctx context.Context, pt *policyT, p *mod
 
 	u := uuid.Must(uuid.NewV4())
 	l := m.log.With().Str(dl.FieldPolicyID, pt.id).Str("unenroller_uuid", u.String()).Logger()
-	unenrollTimeout := time.Duration(p.UnenrollTimeout) * time.Second
-	if unenrollTimeout != pt.unenrollTimeout {
-		// unenroll timeout changed
+	lifecycle := newLifecycle(p)
+	if lifecycle != pt.lifecycle {
+		// inactivity lifecycle changed
 		if c, ok := m.policiesCanceller[pt.id]; ok {
-			l.Debug().Dur("unenroll_timeout", unenrollTimeout).Msg("cancelling unenrollment monitor")
+			l.Debug().Object("lifecycle", lifecycle).Msg("cancelling inactivity lifecycle monitor")
 			c()
 			delete(m.policiesCanceller, pt.id)
 		}
 
-		if unenrollTimeout > 0 {
-			// start worker for unenrolling agents based timeout
+		if lifecycle.enabled() {
+			// start worker for moving offline agents through the lifecycle stages
 			unenrollCtx, canceller := context.WithCancel(ctx)
 			m.policiesCanceller[pt.id] = canceller
-			go runUnenroller(unenrollCtx, m.bulker, pt.id, unenrollTimeout, l, m.unenrollCheckInterval, m.agentsIndex)
+			go runLifecycle(unenrollCtx, m.bulker, pt.id, lifecycle, l, m.unenrollCheckInterval, m.agentsIndex, m.lifecycle.DryRun)
 		}
-		l.Debug().Dur("unenroll_timeout", unenrollTimeout).Msg("setting unenrollment timeout")
-		pt.unenrollTimeout = unenrollTimeout
+		l.Debug().Object("lifecycle", lifecycle).Msg("setting inactivity lifecycle")
+		pt.lifecycle = lifecycle
 	}
 }
 
@@ -450,13 +566,26 @@ func runCoordinator(ctx context.Context, cord Coordinator, l zerolog.Logger, d t
 	}
 }
 
-func runCoordinatorOutput(ctx context.Context, cord Coordinator, bulker bulk.Bulk, l zerolog.Logger, policiesIndex string) {
+// runCoordinatorOutput writes the policies produced by the coordinator.
+//
+// Every write is fenced by the leadership lease, so a coordinator that lost the
+// leadership (paused or partitioned server) cannot write policy revisions anymore:
+// the revision is created once under an ID shared by the leaders, so a stale leader
+// never rewrites a revision written by the new leader.
+func runCoordinatorOutput(ctx context.Context, cord Coordinator, bulker bulk.Bulk, l zerolog.Logger, policiesIndex string, lease *leaseT) {
 	for {
 		select {
 		case p := <-cord.Output():
 			s := l.With().Int64(dl.FieldRevisionIdx, p.RevisionIdx).Int64(dl.FieldCoordinatorIdx, p.CoordinatorIdx).Logger()
-			_, err := dl.CreatePolicy(ctx, bulker, p, dl.WithIndexName(policiesIndex))
-			if err != nil {
+			err := lease.fenced(ctx, bulker, func(token int64) error {
+				_, err := dl.CreateFencedPolicy(ctx, bulker, p, token, dl.WithIndexName(policiesIndex))
+				return err
+			})
+			if errors.Is(err, dl.ErrPolicyLeaseLost) {
+				s.Warn().Int64("fencing_token", lease.token()).Msg("Policy coordinator is no longer the policy leader; rejected new policy revision")
+			} else if errors.Is(err, dl.ErrPolicyRevisionExists) {
+				s.Info().Msg("Policy revision already written by a previous policy leader")
+			} else if err != nil {
 				s.Err(err).Msg("Policy coordinator failed to add a new policy revision")
 			} else {
 				s.Info().Int64("revision_id", p.RevisionIdx).Msg("Policy coordinator added a new policy revision")
@@ -467,112 +596,6 @@ func runCoordinatorOutput(ctx context.Context, cord Coordinator, bulker bulk.Bul
 	}
 }
 
-func runUnenroller(ctx context.Context, bulker bulk.Bulk, policyID string, unenrollTimeout time.Duration, l zerolog.Logger, checkInterval time.Duration, agentsIndex string) {
-	// When fleet-server is offline for a long period and finally recovers, it means that the connected
-	// agent will be offline for a long period of time since their last checkin and fleet server will
-	// start unenrolling every Elastic Agent from the system. Instead on boot the Elastic Agent
-	// should wait at least the unenrollTimeout period before actively unenrolling agents in the system.
-	// This gives a grace period to the Elastic Agent to connect back to the system.
-	l.Info().
-		Dur("checkInterval", checkInterval).
-		Dur("unenrollTimeout", unenrollTimeout).
-		Msg("giving a grace period to Elastic Agent before enforcing unenrollTimeout monitor")
-
-	if err := waitWithContext(ctx, unenrollTimeout); err != nil {
-		l.Err(err).Dur("unenroll_timeout", unenrollTimeout).
-			Msg("failed to delay the unenroller monitor startup")
-	}
-
-	l.Info().
-		Dur("checkInterval", checkInterval).
-		Dur("unenrollTimeout", unenrollTimeout).
-		Msg("unenroll monitor start")
-	defer l.Info().Msg("Unenroll monitor exit")
-
-	t := time.NewTimer(checkInterval)
-	defer t.Stop()
-
-	for {
-		select {
-		case <-t.C:
-			l.Debug().Msg("running unenroller")
-			if err := runUnenrollerWork(ctx, bulker, policyID, unenrollTimeout, l, agentsIndex); err != nil {
-				l.Err(err).Dur("unenroll_timeout", unenrollTimeout).Msg("failed to unenroll offline agents")
-			}
-			t.Reset(checkInterval)
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func runUnenrollerWork(ctx context.Context, bulker bulk.Bulk, policyID string, unenrollTimeout time.Duration, zlog zerolog.Logger, agentsIndex string) error {
-	agents, err := dl.FindOfflineAgents(ctx, bulker, policyID, unenrollTimeout, dl.WithIndexName(agentsIndex))
-	if err != nil {
-		if errors.Is(err, dl.ErrNotFound) {
-			zlog.Info().Msg("no agents to unenroll")
-			return nil
-		}
-		return err
-	}
-
-	zlog = zlog.With().Dur("timeout", unenrollTimeout).Logger()
-
-	agentIds := make([]string, len(agents))
-
-	for i := range agents {
-		agent := agents[i]
-		err = unenrollAgent(ctx, zlog, bulker, &agent, agentsIndex)
-		if err != nil {
-			return err
-		}
-		agentIds[i] = agent.Id
-	}
-
-	zlog.Info().
-		Strs(logger.APIKeyID, agentIds).
-		Msg("marked agents unenrolled due to unenroll timeout")
-
-	return nil
-}
-
-func unenrollAgent(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agent *model.Agent, agentsIndex string) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	fields := bulk.UpdateFields{
-		dl.FieldActive:           false,
-		dl.FieldUnenrolledAt:     now,
-		dl.FieldUnenrolledReason: unenrolledReasonTimeout,
-		dl.FieldUpdatedAt:        now,
-	}
-
-	body, err := fields.Marshal()
-	if err != nil {
-		return err
-	}
-
-	apiKeys := agent.APIKeyIDs()
-
-	zlog = zlog.With().
-		Str(logger.AgentID, agent.Id).
-		Strs(logger.APIKeyID, apiKeys).
-		Logger()
-
-	zlog.Info().Msg("unenrollAgent due to unenroll timeout")
-
-	if len(apiKeys) > 0 {
-		err = bulker.APIKeyInvalidate(ctx, apiKeys...)
-		if err != nil {
-			zlog.Error().Err(err).Msg("Fail apiKey invalidate")
-			return err
-		}
-	}
-	if err = bulker.Update(ctx, agentsIndex, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
-		zlog.Error().Err(err).Msg("Fail unenrollAgent record update")
-	}
-
-	return err
-}
-
 func waitWithContext(ctx context.Context, to time.Duration) error {
 	t := time.NewTimer(to)
 	defer t.Stop()
@@ -48,7 +48,7 @@ func TestMonitorLeadership(t *testing.T) {
 		t.Fatal(err)
 	}
 	cfg := makeFleetConfig()
-	pm := NewMonitor(cfg, "1.0.0", bulker, pim, NewCoordinatorZero)
+	pm := NewMonitor(cfg, config.AgentLifecycle{}, "1.0.0", bulker, pim, NewCoordinatorZero)
 	pm.(*monitorT).serversIndex = serversIndex
 	pm.(*monitorT).leadersIndex = leadersIndex
 	pm.(*monitorT).policiesIndex = policiesIndex
@@ -133,7 +133,7 @@ func TestMonitorUnenroller(t *testing.T) {
 	pim, err := monitor.New(policiesIndex, bulker.Client(), bulker.Client())
 	require.NoError(t, err)
 	cfg := makeFleetConfig()
-	pm := NewMonitor(cfg, "1.0.0", bulker, pim, NewCoordinatorZero)
+	pm := NewMonitor(cfg, config.AgentLifecycle{}, "1.0.0", bulker, pim, NewCoordinatorZero)
 	pm.(*monitorT).serversIndex = serversIndex
 	pm.(*monitorT).leadersIndex = leadersIndex
 	pm.(*monitorT).policiesIndex = policiesIndex
@@ -254,7 +254,7 @@ func TestMonitorUnenrollerSetAndClear(t *testing.T) {
 	pim, err := monitor.New(policiesIndex, bulker.Client(), bulker.Client())
 	require.NoError(t, err)
 	cfg := makeFleetConfig()
-	pm := NewMonitor(cfg, "1.0.0", bulker, pim, NewCoordinatorZero)
+	pm := NewMonitor(cfg, config.AgentLifecycle{}, "1.0.0", bulker, pim, NewCoordinatorZero)
 	pm.(*monitorT).serversIndex = serversIndex
 	pm.(*monitorT).leadersIndex = leadersIndex
 	pm.(*monitorT).policiesIndex = policiesIndex
@@ -0,0 +1,71 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/membulk"
+)
+
+func TestFencedStaleLeader(t *testing.T) {
+	ctx := context.Background()
+	bulker := membulk.New()
+	bulker.CreateIndex(dl.FleetPoliciesLeader, dl.FleetPolicies)
+
+	takeLease := func(serverID string) *leaseT {
+		lease, err := dl.TakePolicyLeadership(ctx, bulker, "policy1", serverID, "1.0.0")
+		require.NoError(t, err)
+		return &leaseT{policyID: "policy1", serverID: serverID, version: "1.0.0", index: dl.FleetPoliciesLeader, lease: lease}
+	}
+	write := func(l *leaseT, p model.Policy) error {
+		return l.fenced(ctx, bulker, func(token int64) error {
+			_, err := dl.CreateFencedPolicy(ctx, bulker, p, token)
+			return err
+		})
+	}
+	stored := func(id string) model.Policy {
+		data, err := bulker.Read(ctx, dl.FleetPolicies, id)
+		require.NoError(t, err)
+		var stored model.Policy
+		require.NoError(t, json.Unmarshal(data, &stored))
+		return stored
+	}
+
+	p := model.Policy{PolicyID: "policy1", RevisionIdx: 1, CoordinatorIdx: 1}
+	stale := takeLease("server1")
+	staleToken := stale.token()
+	leader := takeLease("server2")
+
+	// the stale leader checked its lease before the take over and writes after it
+	id, err := dl.CreateFencedPolicy(ctx, bulker, p, staleToken)
+	require.NoError(t, err)
+	checkpoint := bulker.GlobalCheckpoint(dl.FleetPolicies)
+
+	// the new leader finds the revision written and does not write it again
+	assert.ErrorIs(t, write(leader, p), dl.ErrPolicyRevisionExists)
+	assert.Equal(t, checkpoint, bulker.GlobalCheckpoint(dl.FleetPolicies), "the revision is not dispatched again")
+	assert.Equal(t, staleToken, stored(id).FencingToken)
+
+	// the stale leader is fenced out of the revisions it did not write
+	p.RevisionIdx = 2
+	assert.ErrorIs(t, write(stale, p), dl.ErrPolicyLeaseLost)
+	require.NoError(t, write(leader, p))
+
+	// and cannot rewrite the revisions of the new leader
+	assert.ErrorIs(t, write(stale, p), dl.ErrPolicyLeaseLost)
+	_, err = dl.CreateFencedPolicy(ctx, bulker, p, staleToken)
+	assert.ErrorIs(t, err, dl.ErrPolicyRevisionExists)
+}
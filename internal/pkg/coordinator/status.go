@@ -0,0 +1,62 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package coordinator
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+)
+
+// runStatusSweep periodically updates the status of the agents of the policy that stopped checking in.
+//
+// The status of agents checking in is computed when their checkins are flushed; agents that stopped
+// checking in have no checkin to recompute it, so the leader of the policy does it on their behalf.
+func runStatusSweep(ctx context.Context, bulker bulk.Bulk, policyID string, l zerolog.Logger, checkInterval time.Duration, agentsIndex string) {
+	l.Info().
+		Dur("checkInterval", checkInterval).
+		Msg("agent status sweep start")
+	defer l.Info().Msg("agent status sweep exit")
+
+	t := time.NewTimer(checkInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			updated, err := runStatusSweepWork(ctx, bulker, policyID, time.Now(), agentsIndex)
+			if err != nil {
+				l.Err(err).Msg("failed to update the status of agents that stopped checking in")
+			} else if updated > 0 {
+				l.Info().Int("count", updated).Msg("updated the status of agents that stopped checking in")
+			}
+			t.Reset(checkInterval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runStatusSweepWork updates the status of the agents of the policy that stopped checking in, returns the
+// number of agents updated.
+func runStatusSweepWork(ctx context.Context, bulker bulk.Bulk, policyID string, now time.Time, agentsIndex string) (int, error) {
+	var total int
+	for {
+		hits, err := dl.FindAgentsWithStaleStatus(ctx, bulker, policyID, now, dl.WithIndexName(agentsIndex))
+		if err != nil || len(hits) == 0 {
+			return total, err
+		}
+		updated, err := dl.UpdateAgentsStatus(ctx, bulker, hits, now, dl.WithIndexName(agentsIndex))
+		total += updated
+		if err != nil || updated == 0 {
+			// no progress; the remaining agents are updated concurrently or on the next sweep
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+func TestRunStatusSweepWork(t *testing.T) {
+	now := time.Now().UTC()
+	stale := now.Add(-time.Hour).Format(time.RFC3339)
+
+	hit := func(id string, agent model.Agent) es.HitT {
+		body, err := json.Marshal(agent)
+		require.NoError(t, err)
+		return es.HitT{ID: id, SeqNo: 3, PrimaryTerm: 1, Source: body}
+	}
+	res := &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit("offline", model.Agent{Active: true, LastCheckin: stale, Status: model.AgentStatusOnline}),
+		hit("inactive", model.Agent{Active: true, LastCheckin: stale, InactiveAt: stale, Status: model.AgentStatusError}),
+	}}}
+
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(res, nil).Once()
+	mockBulk.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+	for id, status := range map[string]string{"offline": model.AgentStatusOffline, "inactive": model.AgentStatusInactive} {
+		status := status
+		mockBulk.On("Update", mock.Anything, dl.FleetAgents, id, mock.MatchedBy(func(body []byte) bool {
+			var doc struct {
+				Doc struct {
+					Status string `json:"status"`
+				} `json:"doc"`
+			}
+			return json.Unmarshal(body, &doc) == nil && doc.Doc.Status == status
+		}), mock.Anything).Return(nil).Once()
+	}
+
+	updated, err := runStatusSweepWork(context.Background(), mockBulk, "policy1", now, dl.FleetAgents)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated)
+	mockBulk.AssertExpectations(t)
+}
+
+func TestRunStatusSweepWorkConflict(t *testing.T) {
+	now := time.Now().UTC()
+	body, err := json.Marshal(model.Agent{Active: true, LastCheckin: now.Add(-time.Hour).Format(time.RFC3339)})
+	require.NoError(t, err)
+	res := &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent1", Source: body}}}}
+
+	// the agent checked in since it was read, the sweep must not loop on it
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(res, nil).Once()
+	mockBulk.On("Update", mock.Anything, dl.FleetAgents, "agent1", mock.Anything, mock.Anything).Return(es.ErrElasticVersionConflict).Once()
+
+	updated, err := runStatusSweepWork(context.Background(), mockBulk, "policy1", now, dl.FleetAgents)
+	require.NoError(t, err)
+	assert.Equal(t, 0, updated)
+	mockBulk.AssertExpectations(t)
+}
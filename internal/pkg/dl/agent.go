@@ -21,7 +21,9 @@ const (
 var (
 	QueryAgentByAssessAPIKeyID   = prepareAgentFindByAccessAPIKeyID()
 	QueryAgentByID               = prepareAgentFindByID()
-	QueryOfflineAgentsByPolicyID = prepareOfflineAgentsByPolicyID()
+	QueryOfflineAgentsByPolicyID = prepareOfflineAgentsByPolicyID("")
+	QueryAgentsToMarkOffline     = prepareOfflineAgentsByPolicyID(FieldOfflineAt)
+	QueryAgentsToMarkInactive    = prepareOfflineAgentsByPolicyID(FieldInactiveAt)
 )
 
 func prepareAgentFindByID() *dsl.Tmpl {
@@ -36,7 +38,9 @@ func prepareAgentFindByField(field string) *dsl.Tmpl {
 	return prepareFindByField(field, map[string]interface{}{"version": true})
 }
 
-func prepareOfflineAgentsByPolicyID() *dsl.Tmpl {
+// prepareOfflineAgentsByPolicyID prepares the query for active agents of a policy that did not check in since a
+// given time. When markedField is set, agents that already have that field set are excluded.
+func prepareOfflineAgentsByPolicyID(markedField string) *dsl.Tmpl {
 	tmpl := dsl.NewTmpl()
 
 	root := dsl.NewRoot()
@@ -44,6 +48,9 @@ func prepareOfflineAgentsByPolicyID() *dsl.Tmpl {
 	filter.Term(FieldActive, true, nil)
 	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
 	filter.Range(FieldLastCheckin, dsl.WithRangeLTE(tmpl.Bind(FieldLastCheckin)))
+	if markedField != "" {
+		root.Query().Bool().MustNot().Exists(markedField)
+	}
 
 	tmpl.MustResolve(root)
 	return tmpl
@@ -68,10 +75,17 @@ func FindAgent(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, name strin
 	return agent, nil
 }
 
+// FindOfflineAgents returns the active agents of the policy that did not check in for the unenroll timeout.
 func FindOfflineAgents(ctx context.Context, bulker bulk.Bulk, policyID string, unenrollTimeout time.Duration, opt ...Option) ([]model.Agent, error) {
+	return FindOfflineAgentsWithQuery(ctx, bulker, QueryOfflineAgentsByPolicyID, policyID, unenrollTimeout, opt...)
+}
+
+// FindOfflineAgentsWithQuery returns the active agents of the policy that did not check in for the timeout,
+// using one of the offline agents queries.
+func FindOfflineAgentsWithQuery(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, policyID string, timeout time.Duration, opt ...Option) ([]model.Agent, error) {
 	o := newOption(FleetAgents, opt...)
-	past := time.Now().UTC().Add(-unenrollTimeout).Format(time.RFC3339)
-	res, err := Search(ctx, bulker, QueryOfflineAgentsByPolicyID, o.indexName, map[string]interface{}{
+	past := time.Now().UTC().Add(-timeout).Format(time.RFC3339)
+	res, err := Search(ctx, bulker, tmpl, o.indexName, map[string]interface{}{
 		FieldPolicyID:    policyID,
 		FieldLastCheckin: past,
 	})
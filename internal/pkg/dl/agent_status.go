@@ -0,0 +1,142 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package dl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const agentStatusBatchSize = 1000
+
+var (
+	QueryAgentsWithStaleStatus = prepareAgentsWithStaleStatusByPolicyID()
+	QueryAgentsWithoutStatus   = prepareAgentsWithoutStatus()
+)
+
+// prepareAgentsWithStaleStatusByPolicyID prepares the query for active agents of a policy that did not
+// check in since a given time and whose status does not reflect it yet.
+func prepareAgentsWithStaleStatusByPolicyID() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	root.Size(agentStatusBatchSize)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActive, true, nil)
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	filter.Range(FieldLastCheckin, dsl.WithRangeLTE(tmpl.Bind(FieldLastCheckin)))
+	root.Query().Bool().MustNot().Terms(FieldStatus, []string{
+		model.AgentStatusOffline,
+		model.AgentStatusInactive,
+		model.AgentStatusUnenrolling,
+	}, nil)
+
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareAgentsWithoutStatus prepares the query for agents that were never assigned a status.
+func prepareAgentsWithoutStatus() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	root.Size(agentStatusBatchSize)
+	root.Query().Bool().MustNot().Exists(FieldStatus)
+
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// FindAgentsWithStaleStatus returns the hits of the active agents of the policy that did not check in since
+// the offline threshold and are not yet reported offline.
+func FindAgentsWithStaleStatus(ctx context.Context, bulker bulk.Bulk, policyID string, now time.Time, opt ...Option) ([]es.HitT, error) {
+	o := newOption(FleetAgents, opt...)
+	past := now.UTC().Add(-model.AgentOfflineThreshold).Format(time.RFC3339)
+	res, err := Search(ctx, bulker, QueryAgentsWithStaleStatus, o.indexName, map[string]interface{}{
+		FieldPolicyID:    policyID,
+		FieldLastCheckin: past,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed searching for agents with stale status: %w", err)
+	}
+	return res.Hits, nil
+}
+
+// UpdateAgentsStatus computes the status of the agents at the given time and updates the ones whose
+// status changed.
+//
+// Each update is conditional on the agent document not having changed since it was read, so the status
+// written by a concurrent checkin is never overwritten. Returns the number of agents updated.
+func UpdateAgentsStatus(ctx context.Context, bulker bulk.Bulk, hits []es.HitT, now time.Time, opt ...Option) (int, error) {
+	o := newOption(FleetAgents, opt...)
+
+	type updateT struct {
+		hit  *es.HitT
+		body []byte
+	}
+	var updates []updateT
+	for i := range hits {
+		var agent model.Agent
+		if err := hits[i].Unmarshal(&agent); err != nil {
+			return 0, fmt.Errorf("could not unmarshal ES document into model.Agent: %w", err)
+		}
+		status := agent.ComputeStatus(now)
+		if status == agent.Status {
+			continue
+		}
+		body, err := bulk.UpdateFields{
+			FieldStatus: status,
+		}.Marshal()
+		if err != nil {
+			return 0, err
+		}
+		updates = append(updates, updateT{hit: &hits[i], body: body})
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		updated int
+		errs    []error
+	)
+	// concurrent updates are batched together by the bulker
+	wg.Add(len(updates))
+	for _, u := range updates {
+		go func(u updateT) {
+			defer wg.Done()
+			err := bulker.Update(ctx, o.indexName, u.hit.ID, u.body, bulk.WithRefresh(), bulk.WithIfSeqNo(u.hit.SeqNo, u.hit.PrimaryTerm))
+			mu.Lock()
+			defer mu.Unlock()
+			var esErr *es.ErrElastic
+			switch {
+			case err == nil:
+				updated++
+			case errors.Is(err, es.ErrElasticVersionConflict):
+				// agent changed since it was read; left to the writer, or to the next search
+			case errors.As(err, &esErr) && esErr.Status == http.StatusNotFound:
+				// agent deleted since it was read
+			default:
+				errs = append(errs, err)
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return updated, fmt.Errorf("failed to update the status of %d agents: %w", len(errs), errs[0])
+	}
+	return updated, nil
+}
@@ -44,12 +44,15 @@ const (
 	FieldPolicyOutputToRetireAPIKeyIDs = "to_retire_api_key_ids" //nolint:gosec // false positive
 	FieldPolicyRevisionIdx             = "policy_revision_idx"
 	FieldRevisionIdx                   = "revision_idx"
+	FieldStatus                        = "status"
 	FieldUnenrolledReason              = "unenrolled_reason"
 	FiledType                          = "type"
 
 	FieldActive           = "active"
 	FieldUpdatedAt        = "updated_at"
 	FieldUnenrolledAt     = "unenrolled_at"
+	FieldOfflineAt        = "offline_at"
+	FieldInactiveAt       = "inactive_at"
 	FieldUpgradedAt       = "upgraded_at"
 	FieldUpgradeStartedAt = "upgrade_started_at"
 	FieldUpgradeStatus    = "upgrade_status"
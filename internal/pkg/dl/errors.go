@@ -6,4 +6,8 @@ package dl
 
 import "errors"
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrPolicyLeaseLost      = errors.New("policy leadership lease lost")
+	ErrPolicyRevisionExists = errors.New("policy revision already written")
+)
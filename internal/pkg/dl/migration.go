@@ -18,6 +18,7 @@ import (
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 )
 
 type (
@@ -47,7 +48,7 @@ var timeNow = time.Now
 // function is responsible to ensure it only applies the migration if needed,
 // being a no-op otherwise.
 func Migrate(ctx context.Context, bulker bulk.Bulk) error {
-	for _, fn := range []migrationFn{migrateTov7_15, migrateToV8_5} {
+	for _, fn := range []migrationFn{migrateTov7_15, migrateToV8_5, migrateToV8_7} {
 		if err := fn(ctx, bulker); err != nil {
 			return err
 		}
@@ -293,3 +294,62 @@ func migratePolicyCoordinatorIdx() (string, string, []byte, error) {
 
 	return migrationName, FleetPolicies, body, nil
 }
+
+// ============================== V8.7.0 migration =============================
+
+func migrateToV8_7(ctx context.Context, bulker bulk.Bulk) error {
+	log.Debug().Msg("applying migration to v8.7.0")
+	_, err := migrateAgentStatus(ctx, bulker, FleetAgents)
+	if err != nil {
+		return fmt.Errorf("v8.7.0 data migration failed: %w", err)
+	}
+
+	return nil
+}
+
+// migrateAgentStatus backfills the status of the agents.
+//
+// FleetServer 8.7.0 computes the status of the agents when flushing their
+// checkins. The agents that did not check in since, such as the offline ones,
+// are assigned their status by this migration. The status is computed with
+// model.Agent.ComputeStatus instead of a painless script so the migration can
+// never disagree with the checkins. The updates are conditional, a concurrent
+// checkin always wins; the agents written concurrently are searched again.
+func migrateAgentStatus(ctx context.Context, bulker bulk.Bulk, index string) (int, error) {
+	const migrationName = "AgentStatus"
+	start := time.Now()
+
+	var updatedDocs int
+	for {
+		res, err := Search(ctx, bulker, QueryAgentsWithoutStatus, index, nil)
+		if err != nil {
+			if errors.Is(err, es.ErrIndexNotFound) {
+				// Ignore index not created yet; nothing to upgrade
+				return updatedDocs, nil
+			}
+			return updatedDocs, fmt.Errorf("failed to search agents for migration %s: %w", migrationName, err)
+		}
+		if len(res.Hits) == 0 {
+			break
+		}
+
+		updated, err := UpdateAgentsStatus(ctx, bulker, res.Hits, timeNow(), WithIndexName(index))
+		updatedDocs += updated
+		if err != nil {
+			return updatedDocs, fmt.Errorf("failed to apply migration %q: %w", migrationName, err)
+		}
+		// The agents written concurrently, for a checkin or not, are not updated; the migration is
+		// done only once the search finds no agent without status.
+		if err := ctx.Err(); err != nil {
+			return updatedDocs, fmt.Errorf("migration %s interrupted: %w", migrationName, err)
+		}
+	}
+
+	log.Info().
+		Str("fleet.migration.name", migrationName).
+		Int("fleet.migration.updated", updatedDocs).
+		Dur("fleet.migration.total.duration", time.Since(start)).
+		Msgf("migration %s done", migrationName)
+
+	return updatedDocs, nil
+}
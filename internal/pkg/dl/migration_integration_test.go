@@ -356,3 +356,33 @@ func TestMigrateOutputs_no_agent_document(t *testing.T) {
 
 	assert.Equal(t, 0, migratedAgents)
 }
+
+func TestMigrateAgentStatus(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, nowStr)
+	require.NoError(t, err, "could not parse time "+nowStr)
+	timeNow = func() time.Time {
+		return now.Add(time.Hour)
+	}
+
+	index, bulker := ftesting.SetupCleanIndex(context.Background(), t, FleetAgents)
+	apiKey := bulk.APIKey{
+		ID:  "testAgent_",
+		Key: "testAgent_key_",
+	}
+
+	agentIDs := createSomeAgents(t, 25, apiKey, index, bulker)
+
+	migratedAgents, err := migrateAgentStatus(context.Background(), bulker, index)
+	require.NoError(t, err)
+	assert.Equal(t, len(agentIDs), migratedAgents)
+
+	for _, id := range agentIDs {
+		agent, err := FindAgent(context.Background(), bulker, QueryAgentByID, FieldID, id, WithIndexName(index))
+		require.NoError(t, err)
+		assert.Equal(t, model.AgentStatusOffline, agent.Status)
+	}
+
+	migratedAgents2, err := migrateAgentStatus(context.Background(), bulker, index)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migratedAgents2)
+}
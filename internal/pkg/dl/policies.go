@@ -8,8 +8,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
@@ -72,3 +74,28 @@ func CreatePolicy(ctx context.Context, bulker bulk.Bulk, policy model.Policy, op
 	}
 	return bulker.Create(ctx, o.indexName, "", data, bulk.WithRefresh())
 }
+
+// CreateFencedPolicy writes a coordinated policy revision as the policy leader holding the fencing token.
+//
+// The revision is stored under an ID derived from the policy ID and its revision and coordinator indexes,
+// so the Fleet Servers coordinating the same revision write the same document. The write is create-only:
+// a revision is written once, by the first leader to write it, and never rewritten, so it is dispatched
+// once. ErrPolicyRevisionExists is returned when the revision was already written.
+func CreateFencedPolicy(ctx context.Context, bulker bulk.Bulk, policy model.Policy, token int64, opt ...Option) (string, error) {
+	o := newOption(FleetPolicies, opt...)
+	policy.FencingToken = token
+	data, err := json.Marshal(&policy)
+	if err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("%s:%d:%d", policy.PolicyID, policy.RevisionIdx, policy.CoordinatorIdx)
+	_, err = bulker.MCreate(ctx, []bulk.MultiOp{{ID: id, Index: o.indexName, Body: data}}, bulk.WithRefresh())
+	if errors.Is(err, es.ErrElasticVersionConflict) {
+		return "", ErrPolicyRevisionExists
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
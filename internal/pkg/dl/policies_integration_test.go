@@ -9,6 +9,8 @@ package dl
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -93,3 +95,39 @@ func TestCreatePolicy(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCreateFencedPolicy(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPolicies)
+
+	policyID := uuid.Must(uuid.NewV4()).String()
+	p := createRandomPolicy(policyID, 1)
+	p.CoordinatorIdx = 1
+	id, err := CreateFencedPolicy(ctx, bulker, p, 2, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the revision is never rewritten, by a stale leader or by a newer one
+	for _, token := range []int64{1, 3} {
+		_, err = CreateFencedPolicy(ctx, bulker, p, token, WithIndexName(index))
+		if !errors.Is(err, ErrPolicyRevisionExists) {
+			t.Fatalf("expected ErrPolicyRevisionExists, got %v", err)
+		}
+	}
+
+	data, err := bulker.Read(ctx, index, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored model.Policy
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.FencingToken != 2 || stored.PolicyID != policyID {
+		t.Fatalf("unexpected stored policy %+v", stored)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ var (
 func prepareSearchPolicyLeaders() (*dsl.Tmpl, error) {
 	tmpl := dsl.NewTmpl()
 	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
 	root.Query().Terms(FieldID, tmpl.Bind(FieldID), nil)
 
 	err := tmpl.Resolve(root)
@@ -35,8 +37,7 @@ func prepareSearchPolicyLeaders() (*dsl.Tmpl, error) {
 	return tmpl, nil
 }
 
-// SearchPolicyLeaders returns all the leaders for the provided policies
-func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, opt ...Option) (leaders map[string]model.PolicyLeader, err error) {
+func searchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, index string, ids []string) (res *es.ResultT, err error) {
 	initSearchPolicyLeadersOnce.Do(func() {
 		tmplSearchPolicyLeaders, err = prepareSearchPolicyLeaders()
 		if err != nil {
@@ -44,12 +45,17 @@ func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, op
 		}
 	})
 
-	o := newOption(FleetPoliciesLeader, opt...)
 	data, err := tmplSearchPolicyLeaders.RenderOne(FieldID, ids)
 	if err != nil {
-		return
+		return nil, err
 	}
-	res, err := bulker.Search(ctx, o.indexName, data)
+	return bulker.Search(ctx, index, data)
+}
+
+// SearchPolicyLeaders returns all the leaders for the provided policies
+func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, opt ...Option) (leaders map[string]model.PolicyLeader, err error) {
+	o := newOption(FleetPoliciesLeader, opt...)
+	res, err := searchPolicyLeaders(ctx, bulker, o.indexName, ids)
 	if err != nil {
 		if errors.Is(err, es.ErrIndexNotFound) {
 			log.Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
@@ -70,20 +76,38 @@ func SearchPolicyLeaders(ctx context.Context, bulker bulk.Bulk, ids []string, op
 	return leaders, nil
 }
 
+// PolicyLease is the fencing state held by the current leader of a policy.
+//
+// Token is the monotonically increasing fencing token stored in the leader document.
+// SeqNo and PrimaryTerm identify the last write made by the leader; any write made
+// by another Fleet Server in between invalidates the lease.
+type PolicyLease struct {
+	Token       int64
+	SeqNo       int64
+	PrimaryTerm int64
+}
+
 // TakePolicyLeadership tries to take leadership of a policy
-func TakePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID, version string, opt ...Option) error {
+//
+// Taking leadership increments the fencing token of the policy. The returned lease
+// must be used to renew the leadership and to fence writes made as the leader.
+// The takeover is conditional on the leader document read, so when several Fleet Servers
+// take over the same policy only one succeeds; the others get ErrPolicyLeaseLost.
+func TakePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID, version string, opt ...Option) (PolicyLease, error) {
 	o := newOption(FleetPoliciesLeader, opt...)
-	data, err := bulker.Read(ctx, o.indexName, policyID, bulk.WithRefresh())
-	if err != nil && !errors.Is(err, es.ErrElasticNotFound) {
-		return err
+	res, err := searchPolicyLeaders(ctx, bulker, o.indexName, []string{policyID})
+	if err != nil && !errors.Is(err, es.ErrIndexNotFound) {
+		return PolicyLease{}, err
 	}
-	var l model.PolicyLeader
-	found := false
-	if !errors.Is(err, es.ErrElasticNotFound) {
-		found = true
-		err = json.Unmarshal(data, &l)
+	var (
+		l     model.PolicyLeader
+		found *es.HitT
+	)
+	if err == nil && len(res.Hits) > 0 {
+		found = &res.Hits[0]
+		err = found.Unmarshal(&l)
 		if err != nil {
-			return err
+			return PolicyLease{}, err
 		}
 	}
 	if l.Server == nil {
@@ -91,28 +115,109 @@ func TakePolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serve
 	}
 	l.Server.ID = serverID
 	l.Server.Version = version
+	l.FencingToken++
 	l.SetTime(time.Now().UTC())
-	if found {
+
+	var (
+		data  []byte
+		items []bulk.BulkIndexerResponseItem
+	)
+	if found != nil {
 		data, err = json.Marshal(&struct {
 			Doc model.PolicyLeader `json:"doc"`
 		}{
 			Doc: l,
 		})
 		if err != nil {
-			return err
+			return PolicyLease{}, err
 		}
-		err = bulker.Update(ctx, o.indexName, policyID, data, bulk.WithRefresh())
+		items, err = bulker.MUpdate(ctx, []bulk.MultiOp{{ID: policyID, Index: o.indexName, Body: data}},
+			bulk.WithRefresh(), bulk.WithIfSeqNo(found.SeqNo, found.PrimaryTerm))
 	} else {
 		data, err = json.Marshal(&l)
 		if err != nil {
-			return err
+			return PolicyLease{}, err
 		}
-		_, err = bulker.Create(ctx, o.indexName, policyID, data, bulk.WithRefresh())
+		items, err = bulker.MCreate(ctx, []bulk.MultiOp{{ID: policyID, Index: o.indexName, Body: data}}, bulk.WithRefresh())
 	}
+	if err != nil {
+		if errors.Is(err, es.ErrElasticVersionConflict) {
+			// another Fleet Server took over in between
+			return PolicyLease{}, ErrPolicyLeaseLost
+		}
+		return PolicyLease{}, err
+	}
+	return leaseFromItems(l.FencingToken, items)
+}
+
+// RenewPolicyLeadership renews the leadership of a policy held with the provided lease.
+//
+// Returns ErrPolicyLeaseLost if another Fleet Server wrote the leader document since the lease was obtained.
+func RenewPolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID, version string, lease PolicyLease, opt ...Option) (PolicyLease, error) {
+	o := newOption(FleetPoliciesLeader, opt...)
+	l := model.PolicyLeader{
+		FencingToken: lease.Token,
+		Server: &model.ServerMetadata{
+			ID:      serverID,
+			Version: version,
+		},
+	}
+	l.SetTime(time.Now().UTC())
+	data, err := json.Marshal(&struct {
+		Doc model.PolicyLeader `json:"doc"`
+	}{
+		Doc: l,
+	})
+	if err != nil {
+		return PolicyLease{}, err
+	}
+	items, err := bulker.MUpdate(ctx, []bulk.MultiOp{{ID: policyID, Index: o.indexName, Body: data}},
+		bulk.WithRefresh(), bulk.WithIfSeqNo(lease.SeqNo, lease.PrimaryTerm))
+	if err != nil {
+		if errors.Is(err, es.ErrElasticVersionConflict) {
+			return PolicyLease{}, ErrPolicyLeaseLost
+		}
+		return PolicyLease{}, err
+	}
+	return leaseFromItems(lease.Token, items)
+}
+
+// CheckPolicyLeadership verifies that the provided lease is still current.
+//
+// The check is a conditional no-op update of the leader document, so it is resolved by
+// Elasticsearch against the latest version of the document and does not change its sequence number.
+// Returns ErrPolicyLeaseLost if another Fleet Server wrote the leader document since the lease was obtained.
+func CheckPolicyLeadership(ctx context.Context, bulker bulk.Bulk, policyID, serverID string, lease PolicyLease, opt ...Option) error {
+	o := newOption(FleetPoliciesLeader, opt...)
+	// Only the fields of the lease are sent, so that the update of a current lease is a no-op.
+	data, err := json.Marshal(map[string]interface{}{
+		"doc": map[string]interface{}{
+			"fencing_token": lease.Token,
+			"server": map[string]interface{}{
+				"id": serverID,
+			},
+		},
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = bulker.MUpdate(ctx, []bulk.MultiOp{{ID: policyID, Index: o.indexName, Body: data}},
+		bulk.WithIfSeqNo(lease.SeqNo, lease.PrimaryTerm))
+	if errors.Is(err, es.ErrElasticVersionConflict) {
+		return ErrPolicyLeaseLost
+	}
+	return err
+}
+
+func leaseFromItems(token int64, items []bulk.BulkIndexerResponseItem) (PolicyLease, error) {
+	if len(items) != 1 {
+		return PolicyLease{}, fmt.Errorf("unexpected number of policy leader write results: %d", len(items))
+	}
+	return PolicyLease{
+		Token:       token,
+		SeqNo:       items[0].SeqNo,
+		PrimaryTerm: items[0].PrimTerm,
+	}, nil
 }
 
 // ReleasePolicyLeadership releases leadership of a policy
@@ -10,7 +10,9 @@ package dl
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -33,7 +35,7 @@ func TestSearchPolicyLeaders(t *testing.T) {
 	for i := 0; i < 3; i++ {
 		policyID := uuid.Must(uuid.NewV4()).String()
 		version := testVer
-		err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
+		_, err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -63,7 +65,7 @@ func TestTakePolicyLeadership(t *testing.T) {
 	serverID := uuid.Must(uuid.NewV4()).String()
 	policyID := uuid.Must(uuid.NewV4()).String()
 	version := testVer
-	err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
+	_, err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -80,6 +82,9 @@ func TestTakePolicyLeadership(t *testing.T) {
 	if leader.Server.ID != serverID || leader.Server.Version != version {
 		t.Fatal("server.id and server.version should match")
 	}
+	if leader.FencingToken != 1 {
+		t.Fatalf("fencing_token should be 1; instead its %d", leader.FencingToken)
+	}
 	lt, err := leader.Time()
 	if err != nil {
 		t.Fatal(err)
@@ -89,6 +94,100 @@ func TestTakePolicyLeadership(t *testing.T) {
 	}
 }
 
+func TestPolicyLeadershipFencing(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPoliciesLeader)
+
+	serverID := uuid.Must(uuid.NewV4()).String()
+	policyID := uuid.Must(uuid.NewV4()).String()
+	lease, err := TakePolicyLeadership(ctx, bulker, policyID, serverID, testVer, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err = RenewPolicyLeadership(ctx, bulker, policyID, serverID, testVer, lease, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckPolicyLeadership(ctx, bulker, policyID, serverID, lease, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another server takes over the leadership
+	otherServerID := uuid.Must(uuid.NewV4()).String()
+	otherLease, err := TakePolicyLeadership(ctx, bulker, policyID, otherServerID, testVer, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherLease.Token <= lease.Token {
+		t.Fatalf("fencing token should increase on take over: %d <= %d", otherLease.Token, lease.Token)
+	}
+
+	// the stale leader is fenced out
+	err = CheckPolicyLeadership(ctx, bulker, policyID, serverID, lease, WithIndexName(index))
+	if !errors.Is(err, ErrPolicyLeaseLost) {
+		t.Fatalf("expected ErrPolicyLeaseLost, got %v", err)
+	}
+	_, err = RenewPolicyLeadership(ctx, bulker, policyID, serverID, testVer, lease, WithIndexName(index))
+	if !errors.Is(err, ErrPolicyLeaseLost) {
+		t.Fatalf("expected ErrPolicyLeaseLost, got %v", err)
+	}
+	err = CheckPolicyLeadership(ctx, bulker, policyID, otherServerID, otherLease, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTakePolicyLeadershipConcurrent(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPoliciesLeader)
+
+	policyID := uuid.Must(uuid.NewV4()).String()
+	_, err := TakePolicyLeadership(ctx, bulker, policyID, uuid.Must(uuid.NewV4()).String(), testVer, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// servers taking over at the same time never share a fencing token
+	const n = 5
+	var (
+		mut    sync.Mutex
+		wg     sync.WaitGroup
+		tokens = map[int64]int{}
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := TakePolicyLeadership(ctx, bulker, policyID, uuid.Must(uuid.NewV4()).String(), testVer, WithIndexName(index))
+			if errors.Is(err, ErrPolicyLeaseLost) {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mut.Lock()
+			tokens[lease.Token]++
+			mut.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(tokens) == 0 {
+		t.Fatal("expected a server to take over the leadership")
+	}
+	for token, cnt := range tokens {
+		if cnt != 1 {
+			t.Errorf("fencing token %d taken by %d servers", token, cnt)
+		}
+	}
+}
+
 func TestReleasePolicyLeadership(t *testing.T) {
 	ctx, cn := context.WithCancel(context.Background())
 	defer cn()
@@ -98,7 +197,7 @@ func TestReleasePolicyLeadership(t *testing.T) {
 	serverID := uuid.Must(uuid.NewV4()).String()
 	policyID := uuid.Must(uuid.NewV4()).String()
 	version := testVer
-	err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
+	_, err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,7 +237,7 @@ func TestReleasePolicyLeadership_NothingIfNotLeader(t *testing.T) {
 	serverID := uuid.Must(uuid.NewV4()).String()
 	policyID := uuid.Must(uuid.NewV4()).String()
 	version := testVer
-	err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
+	_, err := TakePolicyLeadership(ctx, bulker, policyID, serverID, version, WithIndexName(index))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -10,6 +10,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -22,6 +23,9 @@ import (
 
 var ErrGlobalCheckpoint = errors.New("global checkpoint error")
 
+// ErrUnsupported is returned when the cluster has no fleet global checkpoints API.
+var ErrUnsupported = errors.New("fleet global checkpoints API not supported")
+
 // Global checkpoint response
 // {"global_checkpoints":[-1]}
 
@@ -77,6 +81,17 @@ func processGlobalCheckpointResponse(res *esapi.Response) (seqno sqn.SeqNo, err
 	// Parse payload
 	var sres globalCheckpointsResponse
 	err = json.NewDecoder(res.Body).Decode(&sres)
+
+	// Without the fleet plugin the endpoint is not found, while a missing index is reported as such
+	if res.StatusCode == http.StatusNotFound {
+		if err == nil {
+			err = esh.TranslateError(res.StatusCode, sres.Error)
+			if errors.Is(err, esh.ErrIndexNotFound) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
 	if err != nil {
 		return
 	}
@@ -0,0 +1,72 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gcheckpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	esh "github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+// Shard level index stats response, reduced to the sequence numbers
+// {"indices":{"index":{"shards":{"0":[{"routing":{"primary":true},"seq_no":{"global_checkpoint":5}}]}}}}
+
+type shardStatsT struct {
+	Routing struct {
+		Primary bool `json:"primary"`
+	} `json:"routing"`
+	SeqNo struct {
+		GlobalCheckpoint int64 `json:"global_checkpoint"`
+	} `json:"seq_no"`
+}
+
+type indexStatsResponse struct {
+	Indices map[string]struct {
+		Shards map[string][]shardStatsT `json:"shards"`
+	} `json:"indices"`
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// QueryStats returns the global checkpoint of the index from the shard level index stats, for the
+// clusters without the fleet global checkpoints API. As the fleet indices, the index has a single
+// primary shard; an alias resolves to a single concrete index.
+func QueryStats(ctx context.Context, es *elasticsearch.Client, index string) (sqn.SeqNo, error) {
+	res, err := es.Indices.Stats(
+		es.Indices.Stats.WithContext(ctx),
+		es.Indices.Stats.WithIndex(index),
+		es.Indices.Stats.WithMetric("docs"),
+		es.Indices.Stats.WithLevel("shards"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var sres indexStatsResponse
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return nil, err
+	}
+
+	if err := esh.TranslateError(res.StatusCode, sres.Error); err != nil {
+		if errors.Is(err, esh.ErrIndexNotFound) {
+			return sqn.DefaultSeqNo, nil
+		}
+		return nil, err
+	}
+
+	for _, stats := range sres.Indices {
+		for _, shard := range stats.Shards["0"] {
+			if shard.Routing.Primary {
+				return sqn.SeqNo{shard.SeqNo.GlobalCheckpoint}, nil
+			}
+		}
+	}
+	return nil, esh.ErrNotFound
+}
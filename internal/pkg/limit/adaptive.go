@@ -0,0 +1,169 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// adaptiveDecrease is the factor applied to the effective max while elasticsearch is overloaded.
+	adaptiveDecrease = 0.75
+	// adaptiveIncreaseSteps is the number of intervals to grow from 0 back to the configured max.
+	adaptiveIncreaseSteps = 20
+)
+
+// flushStatser is implemented by the bulk engines reporting the outcomes of their flushes, see
+// bulk.Bulker.FlushStats.
+type flushStatser interface {
+	FlushStats() bulk.FlushStats
+}
+
+// adaptiveMax is the effective max of a route, shared by the limiters of the route of all listeners.
+type adaptiveMax struct {
+	effective int64 // accessed atomically
+	min       int64
+	max       int64
+	step      int64
+	gauge     *monitoring.Int
+}
+
+func newAdaptiveMax(max int64, minRatio float64, gauge *monitoring.Int) *adaptiveMax {
+	gauge.Set(max)
+	if max == 0 {
+		// The route is unlimited, there is nothing to adjust
+		return nil
+	}
+	a := &adaptiveMax{
+		effective: max,
+		min:       int64(math.Ceil(float64(max) * minRatio)),
+		max:       max,
+		step:      max / adaptiveIncreaseSteps,
+		gauge:     gauge,
+	}
+	if a.min < 1 {
+		a.min = 1
+	}
+	if a.step < 1 {
+		a.step = 1
+	}
+	return a
+}
+
+func (a *adaptiveMax) load() int64 {
+	return atomic.LoadInt64(&a.effective)
+}
+
+// adjust decreases the effective max multiplicatively when overloaded, otherwise increases it
+// additively, within the bounds.
+func (a *adaptiveMax) adjust(overloaded bool) {
+	effective := a.load()
+	if overloaded {
+		effective = int64(float64(effective) * adaptiveDecrease)
+		if effective < a.min {
+			effective = a.min
+		}
+	} else {
+		effective += a.step
+		if effective > a.max {
+			effective = a.max
+		}
+	}
+	atomic.StoreInt64(&a.effective, effective)
+	a.gauge.Set(effective)
+}
+
+// Controller adjusts the max of the checkin, enroll and ack limits to the load of elasticsearch,
+// observed through the latency and the error rate of the flushes of the bulk engine (AIMD).
+//
+// On each interval, the effective max of the routes decreases by a quarter if the mean latency of
+// the flushes exceeds the target or too many of them failed, otherwise it increases by a twentieth
+// of the configured max. It stays between the configured min ratio of the configured max and the
+// configured max. The routes without a configured max are not limited.
+type Controller struct {
+	cfg     config.AdaptiveLimits
+	bulker  bulk.Bulk
+	checkin *adaptiveMax
+	enroll  *adaptiveMax
+	ack     *adaptiveMax
+	last    bulk.FlushStats
+}
+
+// NewController returns the controller of the adaptive limits of cfg, driven by the flushes of bulker.
+func NewController(cfg *config.ServerLimits, bulker bulk.Bulk) *Controller {
+	c := &Controller{
+		cfg:    cfg.Adaptive,
+		bulker: bulker,
+	}
+	if cfg.Adaptive.Enabled {
+		c.checkin = newAdaptiveMax(cfg.CheckinLimit.Max, cfg.Adaptive.MinRatio, gaugeCheckinMax)
+		c.enroll = newAdaptiveMax(cfg.EnrollLimit.Max, cfg.Adaptive.MinRatio, gaugeEnrollMax)
+		c.ack = newAdaptiveMax(cfg.AckLimit.Max, cfg.Adaptive.MinRatio, gaugeAckMax)
+	} else {
+		gaugeCheckinMax.Set(cfg.CheckinLimit.Max)
+		gaugeEnrollMax.Set(cfg.EnrollLimit.Max)
+		gaugeAckMax.Set(cfg.AckLimit.Max)
+	}
+	return c
+}
+
+// Run adjusts the limits on each interval until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	if !c.cfg.Enabled {
+		return nil
+	}
+	src, ok := c.bulker.(flushStatser)
+	if !ok {
+		log.Warn().Msg("Adaptive limits disabled, the bulk engine does not report its flushes")
+		return nil
+	}
+	c.last = src.FlushStats()
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			c.adjust(src.FlushStats())
+		}
+	}
+}
+
+// adjust adjusts the limits to the flushes completed since the last adjustment. The limits are left
+// unchanged when there was no flush.
+func (c *Controller) adjust(stats bulk.FlushStats) {
+	count := stats.Count - c.last.Count
+	if count == 0 {
+		return
+	}
+	latency := (stats.Latency - c.last.Latency) / time.Duration(count)
+	errorRate := float64(stats.Failed-c.last.Failed) / float64(count)
+	c.last = stats
+
+	overloaded := latency > c.cfg.TargetLatency || errorRate > c.cfg.MaxErrorRate
+	for _, a := range []*adaptiveMax{c.checkin, c.enroll, c.ack} {
+		if a != nil {
+			a.adjust(overloaded)
+		}
+	}
+
+	if overloaded {
+		log.Debug().
+			Dur("latency", latency).
+			Float64("errorRate", errorRate).
+			Msg("Elasticsearch overloaded, decreasing the adaptive limits")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func testAdaptiveLimits() *config.ServerLimits {
+	return &config.ServerLimits{
+		CheckinLimit: config.Limit{Max: 100},
+		EnrollLimit:  config.Limit{Max: 10},
+		Adaptive: config.AdaptiveLimits{
+			Enabled:       true,
+			Interval:      time.Second,
+			TargetLatency: 100 * time.Millisecond,
+			MaxErrorRate:  0.1,
+			MinRatio:      0.2,
+		},
+	}
+}
+
+func TestControllerAdjust(t *testing.T) {
+	c := NewController(testAdaptiveLimits(), nil)
+	require.Nil(t, c.ack, "the routes without max are not limited")
+
+	stats := bulk.FlushStats{}
+	flush := func(count, failed uint64, latency time.Duration) {
+		stats.Count += count
+		stats.Failed += failed
+		stats.Latency += time.Duration(count) * latency
+		c.adjust(stats)
+	}
+
+	flush(10, 0, 500*time.Millisecond)
+	assert.Equal(t, int64(75), c.checkin.load(), "decreased on high latency")
+	assert.Equal(t, int64(7), c.enroll.load())
+	assert.Equal(t, int64(75), gaugeCheckinMax.Get())
+
+	flush(10, 2, 10*time.Millisecond)
+	assert.Equal(t, int64(56), c.checkin.load(), "decreased on high error rate")
+
+	for i := 0; i < 10; i++ {
+		flush(10, 0, 500*time.Millisecond)
+	}
+	assert.Equal(t, int64(20), c.checkin.load(), "bounded by the min ratio")
+	assert.Equal(t, int64(2), c.enroll.load())
+
+	c.adjust(stats)
+	assert.Equal(t, int64(20), c.checkin.load(), "unchanged without flushes")
+
+	flush(10, 0, 10*time.Millisecond)
+	assert.Equal(t, int64(25), c.checkin.load(), "increased when healthy")
+	assert.Equal(t, int64(3), c.enroll.load())
+
+	for i := 0; i < 20; i++ {
+		flush(10, 0, 10*time.Millisecond)
+	}
+	assert.Equal(t, int64(100), c.checkin.load(), "bounded by the configured max")
+	assert.Equal(t, int64(10), c.enroll.load())
+}
+
+func TestControllerDisabled(t *testing.T) {
+	cfg := testAdaptiveLimits()
+	cfg.Adaptive.Enabled = false
+	c := NewController(cfg, nil)
+	assert.Nil(t, c.checkin)
+	assert.Equal(t, int64(100), gaugeCheckinMax.Get(), "the static max is reported")
+	assert.NoError(t, c.Run(context.Background()))
+}
+
+func TestWrapAdaptive(t *testing.T) {
+	cfg := testAdaptiveLimits()
+	cfg.CheckinLimit.Max = 1
+	c := NewController(cfg, nil)
+	l := NewHTTPWrapper("test", cfg, WithController(c))
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	inc := &mockIncer{}
+	inc.On("IncStart").Return(func() {})
+	h := l.WrapCheckin(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		close(started)
+		<-release
+	}, inc)
+	i := &mockIncer{}
+	i.On("IncStart").Return(func() {})
+	i.On("IncError", ErrMaxLimit).Once()
+	h2 := l.WrapCheckin(stubHandle(), i)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(httptest.NewRecorder(), &http.Request{}, nil)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h2(w, &http.Request{}, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the effective max is reached")
+	close(release)
+	<-done
+
+	w = httptest.NewRecorder()
+	h2(w, &http.Request{}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	i.AssertExpectations(t)
+}
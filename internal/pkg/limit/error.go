@@ -7,6 +7,7 @@ package limit
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
@@ -15,6 +16,14 @@ import (
 var (
 	ErrRateLimit = errors.New("rate limit")
 	ErrMaxLimit  = errors.New("max limit")
+
+	// ErrAgentRateLimit and ErrIPRateLimit are the rate limit of a single agent and of a single
+	// source IP address.
+	ErrAgentRateLimit = fmt.Errorf("agent %w", ErrRateLimit)
+	ErrIPRateLimit    = fmt.Errorf("source IP %w", ErrRateLimit)
+
+	// ErrShed is returned for the requests shed because of the memory usage.
+	ErrShed = errors.New("load shed")
 )
 
 // writeError recreates the behaviour of api/error.go.
@@ -30,12 +39,22 @@ func writeError(w http.ResponseWriter, err error) error {
 		Message: "unknown limiter error encountered",
 	}
 	switch {
+	case errors.Is(err, ErrAgentRateLimit):
+		resp.Error = "RateLimit"
+		resp.Message = "exceeded the agent rate limit"
+	case errors.Is(err, ErrIPRateLimit):
+		resp.Error = "RateLimit"
+		resp.Message = "exceeded the source IP rate limit"
 	case errors.Is(err, ErrRateLimit):
 		resp.Error = "RateLimit"
 		resp.Message = "exceeded the rate limit"
 	case errors.Is(err, ErrMaxLimit):
 		resp.Error = "MaxLimit"
 		resp.Message = "exceeded the max limit"
+	case errors.Is(err, ErrShed):
+		resp.Status = http.StatusServiceUnavailable
+		resp.Error = "LoadShed"
+		resp.Message = "server overloaded, retry later"
 	default:
 		log.Error().Err(err).Msg("Encountered unknown limiter error")
 	}
@@ -45,7 +64,7 @@ func writeError(w http.ResponseWriter, err error) error {
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusTooManyRequests)
+	w.WriteHeader(resp.Status)
 	_, wErr = w.Write(p)
 	return wErr
 }
@@ -16,21 +16,35 @@ import (
 
 func TestWriteError(t *testing.T) {
 	tests := []struct {
-		name string
-		err  error
-		want string
+		name   string
+		err    error
+		want   string
+		status int
 	}{{
-		name: "unknown",
-		err:  errors.New("unknown"),
-		want: "UnknownLimiterError",
+		name:   "unknown",
+		err:    errors.New("unknown"),
+		want:   "UnknownLimiterError",
+		status: http.StatusTooManyRequests,
 	}, {
-		name: "rate limit",
-		err:  ErrRateLimit,
-		want: "RateLimit",
+		name:   "rate limit",
+		err:    ErrRateLimit,
+		want:   "RateLimit",
+		status: http.StatusTooManyRequests,
 	}, {
-		name: "max limit",
-		err:  ErrMaxLimit,
-		want: "MaxLimit",
+		name:   "agent rate limit",
+		err:    ErrAgentRateLimit,
+		want:   "RateLimit",
+		status: http.StatusTooManyRequests,
+	}, {
+		name:   "max limit",
+		err:    ErrMaxLimit,
+		want:   "MaxLimit",
+		status: http.StatusTooManyRequests,
+	}, {
+		name:   "shed",
+		err:    ErrShed,
+		want:   "LoadShed",
+		status: http.StatusServiceUnavailable,
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -40,7 +54,7 @@ func TestWriteError(t *testing.T) {
 			require.NoError(t, err)
 			resp := w.Result()
 			defer resp.Body.Close()
-			require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			require.Equal(t, tt.status, resp.StatusCode)
 
 			var body struct {
 				Status int    `json:"statusCode"`
@@ -49,7 +63,7 @@ func TestWriteError(t *testing.T) {
 			dec := json.NewDecoder(resp.Body)
 			err = dec.Decode(&body)
 			require.NoError(t, err)
-			require.Equal(t, http.StatusTooManyRequests, body.Status)
+			require.Equal(t, tt.status, body.Status)
 			require.Equal(t, tt.want, body.Error)
 		})
 	}
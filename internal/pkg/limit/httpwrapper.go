@@ -21,16 +21,59 @@ type HTTPWrapper struct {
 	log      zerolog.Logger
 }
 
+// Opt is an option of the HTTPWrapper.
+type Opt func(*HTTPWrapper)
+
+// WithController applies the adaptive limits of c in place of the static max of the checkin, enroll
+// and ack limits.
+func WithController(c *Controller) Opt {
+	return func(l *HTTPWrapper) {
+		l.checkin.adaptive = c.checkin
+		l.enroll.adaptive = c.enroll
+		l.ack.adaptive = c.ack
+	}
+}
+
+// WithShedder sheds the new enrollments, then the new checkins, past the memory limits of s.
+func WithShedder(s *Shedder) Opt {
+	return func(l *HTTPWrapper) {
+		l.enroll.shedder, l.enroll.shedLevel = s, shedEnroll
+		l.checkin.shedder, l.checkin.shedLevel = s, shedCheckin
+	}
+}
+
+// WithAuthenticator identifies the agent of the checkin and ack requests from the cache with i, for
+// their per agent limits and their priority, and authenticates it with a once the request is admitted.
+func WithAuthenticator(i Identifier, a Authenticator) Opt {
+	return func(l *HTTPWrapper) {
+		l.checkin.identify, l.checkin.authAgent = i, a
+		l.ack.identify, l.ack.authAgent = i, a
+	}
+}
+
+// WithPrioritizer gives the agents supervising a fleet-server, as recognized by p, the capacity of
+// the checkin and ack limits reserved by their fleet-server share.
+func WithPrioritizer(p Prioritizer) Opt {
+	return func(l *HTTPWrapper) {
+		l.checkin.prioritizer = p
+		l.ack.prioritizer = p
+	}
+}
+
 // Create a new HTTPWrapper using the specified limits.
-func NewHTTPWrapper(addr string, cfg *config.ServerLimits) *HTTPWrapper {
-	return &HTTPWrapper{
-		checkin:  newLimiter(&cfg.CheckinLimit),
+func NewHTTPWrapper(addr string, cfg *config.ServerLimits, opts ...Opt) *HTTPWrapper {
+	l := &HTTPWrapper{
+		checkin:  newAgentLimiter(&cfg.CheckinLimit),
 		artifact: newLimiter(&cfg.ArtifactLimit),
 		enroll:   newLimiter(&cfg.EnrollLimit),
-		ack:      newLimiter(&cfg.AckLimit),
+		ack:      newAgentLimiter(&cfg.AckLimit),
 		status:   newLimiter(&cfg.StatusLimit),
 		log:      log.With().Str("addr", addr).Logger(),
 	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
 }
 
 // WhapCheckin wraps the checkin handler with the rate limiter and tracks statistics for the endpoint.
@@ -0,0 +1,83 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+)
+
+const defaultMaxKeys = 10000
+
+// keyLimiter is a token bucket per key, e.g. per agent ID, the buckets of the least recently seen
+// keys being evicted past maxKeys. An evicted key starts over with a full bucket.
+type keyLimiter struct {
+	limit   rate.Limit
+	burst   int
+	mut     sync.Mutex
+	buckets *lru.Cache
+}
+
+// newKeyLimiter returns the key limiter of cfg, or nil when the limit is disabled.
+func newKeyLimiter(cfg *config.KeyLimit) *keyLimiter {
+	if cfg.Interval == time.Duration(0) {
+		return nil
+	}
+	maxKeys := cfg.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	buckets, _ := lru.New(maxKeys) // only fails for a non-positive size
+	return &keyLimiter{
+		limit:   rate.Every(cfg.Interval),
+		burst:   cfg.Burst,
+		buckets: buckets,
+	}
+}
+
+// allow takes a token of the bucket of key, or returns the delay until a token is available.
+func (k *keyLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	k.mut.Lock()
+	v, ok := k.buckets.Get(key)
+	if !ok {
+		v = rate.NewLimiter(k.limit, k.burst)
+		k.buckets.Add(key, v)
+	}
+	k.mut.Unlock()
+	return allow(v.(*rate.Limiter), now)
+}
+
+// allow takes a token of lim, or returns the delay until a token is available.
+func allow(lim *rate.Limiter, now time.Time) (time.Duration, bool) {
+	r := lim.ReserveN(now, 1)
+	if !r.OK() {
+		// The burst is 0, there is never a token available
+		return time.Duration(math.MaxInt64), false
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d, false
+	}
+	return 0, true
+}
+
+// retryAfter returns the Retry-After header value of a delay, in seconds, rounded up to at least 1s.
+func retryAfter(d time.Duration) int64 {
+	const maxRetryAfter = int64(time.Hour / time.Second)
+	secs := int64(math.Ceil(d.Seconds()))
+	switch {
+	case secs < 1:
+		return 1
+	case secs > maxRetryAfter:
+		return maxRetryAfter
+	}
+	return secs
+}
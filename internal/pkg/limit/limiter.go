@@ -6,7 +6,10 @@
 package limit
 
 import (
+	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
@@ -19,9 +22,36 @@ import (
 
 type releaseFunc func()
 
+// Agent is the agent a request is authenticated for.
+type Agent struct {
+	ID       string
+	PolicyID string
+}
+
+// Identifier identifies the agent of a request from what is already cached, without a request to
+// elasticsearch, so that the agent limits and the priority of a request are known before it is
+// admitted. It returns false when the agent is not known yet.
+type Identifier func(r *http.Request, p httprouter.Params) (Agent, bool)
+
+// Authenticator authenticates the agent of a request once it is admitted. It returns the request to
+// pass on to the handler, and false when the agent is not authenticated, in which case the request is
+// passed on without the limits for the handler to report the failure.
+type Authenticator func(r *http.Request, p httprouter.Params) (*http.Request, Agent, bool)
+
 type limiter struct {
-	rateLimit *rate.Limiter
-	maxLimit  *semaphore.Weighted
+	inflight   int64 // requests holding the adaptive max, accessed atomically
+	adaptive   *adaptiveMax
+	rateLimit  *rate.Limiter
+	maxLimit   *semaphore.Weighted
+	agentLimit *keyLimiter
+	ipLimit    *keyLimiter
+	identify   Identifier    // identifies the agent of the request before it is admitted
+	authAgent  Authenticator // authenticates the agent of the request once admitted
+	shedder    *Shedder
+	shedLevel  shedLevel // the level from which the route is shed
+
+	prioritizer Prioritizer
+	reserved    *reservationT
 }
 
 func newLimiter(cfg *config.Limit) *limiter {
@@ -29,7 +59,9 @@ func newLimiter(cfg *config.Limit) *limiter {
 		return &limiter{}
 	}
 
-	l := &limiter{}
+	l := &limiter{
+		ipLimit: newKeyLimiter(&cfg.PerIP),
+	}
 
 	if cfg.Interval != time.Duration(0) {
 		l.rateLimit = rate.NewLimiter(rate.Every(cfg.Interval), cfg.Burst)
@@ -42,21 +74,110 @@ func newLimiter(cfg *config.Limit) *limiter {
 	return l
 }
 
-func (l *limiter) acquire() (releaseFunc, error) {
+// newAgentLimiter returns the limiter of a route of the agents, which also limits the rate of the
+// requests per agent once authenticated, see WithAuthenticator.
+func newAgentLimiter(cfg *config.Limit) *limiter {
+	l := newLimiter(cfg)
+	if cfg != nil {
+		l.agentLimit = newKeyLimiter(&cfg.PerAgent)
+		if cfg.FleetServerShare > 0 && cfg.FleetServerShare < 1 {
+			l.reserve(cfg)
+		}
+	}
+	return l
+}
+
+// acquire acquires the limits of the request unless it is shed, the per source IP and per agent
+// ones first so that a client over its own limit does not take from the shared ones. The agent
+// limits apply to the agent identified from the cache by identify, never to the agent ID of the URL,
+// so that a client cannot spend the limits of another agent. The agents of a policy supervising a
+// fleet-server take from the reserved capacity first. The request is authenticated by authAgent only
+// once admitted, so that the requests over the limits cost no request to elasticsearch; an agent
+// not known before is held to its agent limit once authenticated. It returns the request to pass on
+// to the handler and, when a limit is reached, the delay after which the request may be retried.
+func (l *limiter) acquire(r *http.Request, p httprouter.Params) (*http.Request, releaseFunc, time.Duration, error) {
 	releaseFunc := noop
+	now := time.Now()
 
-	if l.rateLimit != nil && !l.rateLimit.Allow() {
-		return nil, ErrRateLimit
+	if l.shedder != nil {
+		if err := l.shedder.shed(l.shedLevel); err != nil {
+			return r, nil, shedRetryAfter, err
+		}
 	}
 
-	if l.maxLimit != nil {
+	if l.ipLimit != nil {
+		if d, ok := l.ipLimit.allow(remoteIP(r), now); !ok {
+			return r, nil, d, ErrIPRateLimit
+		}
+	}
+
+	var (
+		agent Agent
+		known bool
+	)
+	if l.identify != nil {
+		agent, known = l.identify(r, p)
+	}
+
+	if l.agentLimit != nil && known {
+		if d, ok := l.agentLimit.allow(agent.ID, now); !ok {
+			return r, nil, d, ErrAgentRateLimit
+		}
+	}
+
+	priority := l.prioritizer != nil && known && l.prioritizer.IsFleetServerPolicy(agent.PolicyID)
+
+	if l.rateLimit != nil && !(priority && l.reserved.allowRate(now)) {
+		if d, ok := allow(l.rateLimit, now); !ok {
+			return r, nil, d, ErrRateLimit
+		}
+	}
+
+	switch {
+	case l.adaptive != nil:
+		max := l.adaptive.load()
+		if !priority && l.reserved != nil {
+			max -= reservedMax(max, l.reserved.share)
+		}
+		if atomic.AddInt64(&l.inflight, 1) > max {
+			atomic.AddInt64(&l.inflight, -1)
+			return r, nil, time.Second, ErrMaxLimit
+		}
+		releaseFunc = l.releaseAdaptive
+	case priority && l.reserved.acquireMax():
+		releaseFunc = l.releaseReserved
+	case l.maxLimit != nil:
 		if !l.maxLimit.TryAcquire(1) {
-			return nil, ErrMaxLimit
+			return r, nil, time.Second, ErrMaxLimit
 		}
 		releaseFunc = l.release
 	}
 
-	return releaseFunc, nil
+	if l.authAgent == nil {
+		return r, releaseFunc, 0, nil
+	}
+
+	r, authed, authn := l.authAgent(r, p)
+	if !authn {
+		releaseFunc()
+		return r, noop, 0, nil
+	}
+	if l.agentLimit != nil && (!known || authed.ID != agent.ID) {
+		if d, ok := l.agentLimit.allow(authed.ID, now); !ok {
+			releaseFunc()
+			return r, nil, d, ErrAgentRateLimit
+		}
+	}
+
+	return r, releaseFunc, 0, nil
+}
+
+func (l *limiter) releaseAdaptive() {
+	atomic.AddInt64(&l.inflight, -1)
+}
+
+func (l *limiter) releaseReserved() {
+	l.reserved.maxLimit.Release(1)
 }
 
 func (l *limiter) release() {
@@ -70,9 +191,10 @@ func (l *limiter) wrap(logger zerolog.Logger, level zerolog.Level, h httprouter.
 		dfunc := i.IncStart()
 		defer dfunc()
 
-		lf, err := l.acquire()
+		r, lf, delay, err := l.acquire(r, p)
 		if err != nil {
 			logger.WithLevel(level).Err(err).Msg("limit reached")
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter(delay), 10))
 			if wErr := writeError(w, err); wErr != nil {
 				logger.Error().Err(wErr).Msg("fail writing error response")
 			}
@@ -86,3 +208,12 @@ func (l *limiter) wrap(logger zerolog.Logger, level zerolog.Level, h httprouter.
 
 func noop() {
 }
+
+// remoteIP returns the IP address of the client of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
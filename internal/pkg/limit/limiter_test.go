@@ -7,12 +7,16 @@ package limit
 import (
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
 )
@@ -36,6 +40,19 @@ func stubHandle() httprouter.Handle {
 	}
 }
 
+// authID authenticates the requests as the agent of the id parameter, of the policy of the policy
+// parameter.
+func authID(r *http.Request, p httprouter.Params) (*http.Request, Agent, bool) {
+	agent, ok := identifyID(r, p)
+	return r, agent, ok
+}
+
+// identifyID identifies the requests as the agent of the id parameter, as authID authenticates them.
+func identifyID(_ *http.Request, p httprouter.Params) (Agent, bool) {
+	id := p.ByName("id")
+	return Agent{ID: id, PolicyID: p.ByName("policy")}, id != ""
+}
+
 func TestWrap(t *testing.T) {
 	t.Run("no limits reached", func(t *testing.T) {
 		var b bool
@@ -93,5 +110,138 @@ func TestWrap(t *testing.T) {
 		i.AssertExpectations(t)
 		assert.True(t, b, "expected dec func to have been called")
 		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "3600", resp.Header.Get("Retry-After"))
+	})
+}
+
+func TestWrapPerKey(t *testing.T) {
+	request := func(h httprouter.Handle, agentID, remoteAddr string) *http.Response {
+		w := httptest.NewRecorder()
+		h(w, &http.Request{RemoteAddr: remoteAddr}, httprouter.Params{{Key: "id", Value: agentID}})
+		resp := w.Result()
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("per agent", func(t *testing.T) {
+		i := &mockIncer{}
+		i.On("IncStart").Return(func() {})
+		i.On("IncError", ErrAgentRateLimit).Once()
+		l := newAgentLimiter(&config.Limit{PerAgent: config.KeyLimit{Interval: 10 * time.Second, Burst: 1}})
+		l.authAgent = authID
+		h := l.wrap(zerolog.Nop(), zerolog.DebugLevel, stubHandle(), i)
+
+		assert.Equal(t, http.StatusOK, request(h, "agent1", "10.0.0.1:1234").StatusCode)
+		resp := request(h, "agent1", "10.0.0.2:1234")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		retry, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		assert.NoError(t, err)
+		assert.InDelta(t, 10, retry, 1)
+		assert.Equal(t, http.StatusOK, request(h, "agent2", "10.0.0.1:1234").StatusCode, "the other agents are not limited")
+		i.AssertExpectations(t)
+	})
+	t.Run("per IP", func(t *testing.T) {
+		i := &mockIncer{}
+		i.On("IncStart").Return(func() {})
+		i.On("IncError", ErrIPRateLimit).Once()
+		l := newLimiter(&config.Limit{PerIP: config.KeyLimit{Interval: 10 * time.Second, Burst: 1}})
+		h := l.wrap(zerolog.Nop(), zerolog.DebugLevel, stubHandle(), i)
+
+		assert.Equal(t, http.StatusOK, request(h, "agent1", "10.0.0.1:1234").StatusCode)
+		assert.Equal(t, http.StatusTooManyRequests, request(h, "agent2", "10.0.0.1:5678").StatusCode)
+		assert.Equal(t, http.StatusOK, request(h, "agent1", "10.0.0.2:1234").StatusCode, "the other addresses are not limited")
+		i.AssertExpectations(t)
+	})
+	t.Run("limited keys do not take from the route limit", func(t *testing.T) {
+		i := &mockIncer{}
+		i.On("IncStart").Return(func() {})
+		i.On("IncError", ErrAgentRateLimit).Times(3)
+		l := newAgentLimiter(&config.Limit{
+			Interval: 10 * time.Second,
+			Burst:    2,
+			PerAgent: config.KeyLimit{Interval: 10 * time.Second, Burst: 1},
+		})
+		l.identify, l.authAgent = identifyID, authID
+		h := l.wrap(zerolog.Nop(), zerolog.DebugLevel, stubHandle(), i)
+
+		for n := 0; n < 4; n++ {
+			request(h, "agent1", "10.0.0.1:1234")
+		}
+		assert.Equal(t, http.StatusOK, request(h, "agent2", "10.0.0.1:1234").StatusCode)
+		i.AssertExpectations(t)
+	})
+	t.Run("per authenticated agent", func(t *testing.T) {
+		i := &mockIncer{}
+		i.On("IncStart").Return(func() {})
+		i.On("IncError", ErrAgentRateLimit).Once()
+		l := newAgentLimiter(&config.Limit{PerAgent: config.KeyLimit{Interval: 10 * time.Second, Burst: 1}})
+		l.authAgent = func(r *http.Request, p httprouter.Params) (*http.Request, Agent, bool) {
+			if p.ByName("id") == "unauthenticated" {
+				return r, Agent{}, false
+			}
+			return r, Agent{ID: "agent1"}, true
+		}
+		h := l.wrap(zerolog.Nop(), zerolog.DebugLevel, stubHandle(), i)
+
+		assert.Equal(t, http.StatusOK, request(h, "agent2", "10.0.0.1:1234").StatusCode)
+		assert.Equal(t, http.StatusTooManyRequests, request(h, "agent3", "10.0.0.1:1234").StatusCode,
+			"the limit is the one of the authenticated agent, not of the agent of the URL")
+		assert.Equal(t, http.StatusOK, request(h, "unauthenticated", "10.0.0.1:1234").StatusCode)
+		assert.Equal(t, http.StatusOK, request(h, "unauthenticated", "10.0.0.1:1234").StatusCode,
+			"the requests failing authentication take from no agent limit")
+		i.AssertExpectations(t)
+	})
+}
+
+func TestAuthenticateAdmitted(t *testing.T) {
+	t.Run("rejected requests are not authenticated", func(t *testing.T) {
+		var authenticated int
+		l := newAgentLimiter(&config.Limit{Interval: time.Hour, Burst: 1})
+		l.identify = identifyID
+		l.authAgent = func(r *http.Request, p httprouter.Params) (*http.Request, Agent, bool) {
+			authenticated++
+			return authID(r, p)
+		}
+
+		_, _, _, err := l.acquire(&http.Request{}, httprouter.Params{{Key: "id", Value: "agent1"}})
+		require.NoError(t, err)
+		_, _, _, err = l.acquire(&http.Request{}, httprouter.Params{{Key: "id", Value: "agent2"}})
+		assert.ErrorIs(t, err, ErrRateLimit)
+		assert.Equal(t, 1, authenticated)
+	})
+	t.Run("failed authentication releases the max", func(t *testing.T) {
+		l := newAgentLimiter(&config.Limit{Max: 1})
+		l.authAgent = func(r *http.Request, p httprouter.Params) (*http.Request, Agent, bool) {
+			return r, Agent{}, false
+		}
+
+		_, release, _, err := l.acquire(&http.Request{}, nil)
+		require.NoError(t, err)
+		defer release()
+		_, release, _, err = l.acquire(&http.Request{}, nil)
+		require.NoError(t, err, "the request failing authentication holds no slot")
+		defer release()
 	})
 }
+
+func TestKeyLimiterEviction(t *testing.T) {
+	k := newKeyLimiter(&config.KeyLimit{Interval: time.Hour, Burst: 1, MaxKeys: 2})
+	now := time.Now()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, ok := k.allow(key, now)
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 2, k.buckets.Len())
+	_, ok := k.allow("a", now)
+	assert.True(t, ok, "the bucket of the least recently seen key was evicted")
+	_, ok = k.allow("c", now)
+	assert.False(t, ok)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, int64(1), retryAfter(0))
+	assert.Equal(t, int64(1), retryAfter(10*time.Millisecond))
+	assert.Equal(t, int64(2), retryAfter(1500*time.Millisecond))
+	assert.Equal(t, int64(3600), retryAfter(time.Duration(1<<62)))
+}
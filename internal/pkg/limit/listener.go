@@ -21,6 +21,12 @@ import (
 // to prevent DDOS attack that eats all the server's CPU.
 // The downside to this is that it will Close() valid connections
 // indiscriminately.
+//
+// The connections refused are closed at once, before any byte is read from
+// them, so the PROXY protocol header of the connections of a trusted proxy is
+// never read: they are logged with the address of their peer, i.e. the proxy,
+// as source.address. The client address resolved from the header is the one
+// of the requests of the connections served, in their logs, limits and traces.
 
 func Listener(l net.Listener, n int) net.Listener {
 	return &limitListener{
@@ -50,30 +56,35 @@ func (l *limitListener) acquire() bool {
 func (l *limitListener) release() { <-l.sem }
 
 func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		// Accept the connection irregardless
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
 
-	// Accept the connection irregardless
-	c, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
-	}
-
-	// If we cannot acquire the semaphore, close the connection
-	if acquired := l.acquire(); !acquired {
-		zlog := log.Warn()
-
-		var err error
-		if c != nil {
-			err = c.Close()
-			zlog.Str(logger.ECSServerAddress, c.LocalAddr().String())
-			zlog.Str(logger.ECSClientAddress, c.RemoteAddr().String())
-			zlog.Err(err)
+		if acquired := l.acquire(); acquired {
+			return &limitListenerConn{Conn: c, release: l.release}, nil
 		}
-		zlog.Int("max", cap(l.sem)).Msg("Connection closed due to max limit")
 
-		return c, nil
+		// If we cannot acquire the semaphore, close the connection
+		err = c.Close()
+		log.Warn().
+			Str(logger.ECSServerAddress, c.LocalAddr().String()).
+			Str(logger.ECSSourceAddress, peerAddr(c).String()).
+			Err(err).
+			Int("max", cap(l.sem)).
+			Msg("Connection closed due to max limit")
 	}
+}
 
-	return &limitListenerConn{Conn: c, release: l.release}, nil
+// peerAddr returns the address of the peer of c, the proxy for the connections of a trusted proxy,
+// without waiting for their PROXY protocol header.
+func peerAddr(c net.Conn) net.Addr {
+	if p, ok := c.(interface{ PeerAddr() net.Addr }); ok {
+		return p.PeerAddr()
+	}
+	return c.RemoteAddr()
 }
 
 func (l *limitListener) Close() error {
@@ -0,0 +1,126 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/proxy"
+)
+
+func TestListenerRefusesOverLimit(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := Listener(tcp, 1)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", tcp.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	dial()
+	first := <-accepted
+
+	// The connection over the limit is closed without being returned
+	refused := dial()
+	require.NoError(t, refused.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = refused.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Accepting again once the first connection is closed
+	require.NoError(t, first.Close())
+	last := dial()
+	select {
+	case c := <-accepted:
+		assert.Equal(t, last.LocalAddr().String(), c.RemoteAddr().String())
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted")
+	}
+}
+
+// logLines is a writer of the log lines to a channel.
+type logLines chan []byte
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestListenerRefusesSilentProxy(t *testing.T) {
+	logs := make(logLines, 1)
+	prev := log.Logger
+	log.Logger = zerolog.New(logs)
+	defer func() { log.Logger = prev }()
+
+	trusted, err := proxy.NewTrusted(&config.TrustedProxies{CIDRs: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := Listener(proxy.Listener(tcp, trusted, time.Hour), 1)
+	defer ln.Close()
+
+	refused := make(chan struct{})
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		<-refused
+	}()
+
+	first, err := net.Dial("tcp", tcp.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	// The proxy sends no header, refusing its connection must not wait for it
+	silent, err := net.Dial("tcp", tcp.Addr().String())
+	require.NoError(t, err)
+	defer silent.Close()
+
+	go func() {
+		_, _ = ln.Accept()
+	}()
+	require.NoError(t, silent.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = silent.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	close(refused)
+
+	var entry map[string]interface{}
+	select {
+	case line := <-logs:
+		require.NoError(t, json.Unmarshal(line, &entry))
+	case <-time.After(5 * time.Second):
+		t.Fatal("refused connection not logged")
+	}
+	assert.Equal(t, silent.LocalAddr().String(), entry[logger.ECSSourceAddress], "logged with the address of the proxy")
+	assert.NotContains(t, entry, logger.ECSClientAddress)
+}
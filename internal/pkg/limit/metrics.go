@@ -0,0 +1,32 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var (
+	registry *monitoring.Registry
+
+	// The effective max of the routes of adaptive limits, 0 when unlimited
+	gaugeCheckinMax *monitoring.Int
+	gaugeEnrollMax  *monitoring.Int
+	gaugeAckMax     *monitoring.Int
+
+	gaugeHeapBytes *monitoring.Int // the heap sampled by the shedder
+	gaugeShedLevel *monitoring.Int // 0 none, 1 enrollments shed, 2 enrollments and checkins shed
+)
+
+func init() {
+	registry = monitoring.Default.NewRegistry("limits")
+	gaugeCheckinMax = monitoring.NewInt(registry.NewRegistry("checkin"), "max")
+	gaugeEnrollMax = monitoring.NewInt(registry.NewRegistry("enroll"), "max")
+	gaugeAckMax = monitoring.NewInt(registry.NewRegistry("ack"), "max")
+
+	memoryRegistry := registry.NewRegistry("memory")
+	gaugeHeapBytes = monitoring.NewInt(memoryRegistry, "heap_bytes")
+	gaugeShedLevel = monitoring.NewInt(memoryRegistry, "level")
+}
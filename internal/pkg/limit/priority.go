@@ -0,0 +1,84 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"math"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// Prioritizer recognizes the policies of the agents supervising a fleet-server, see policy.Monitor.
+type Prioritizer interface {
+	IsFleetServerPolicy(policyID string) bool
+}
+
+// reservationT is the share of the rate and of the max of a route reserved to the agents supervising
+// a fleet-server. Those agents use their reservation first, then the capacity shared with the others.
+type reservationT struct {
+	share     float64
+	rateLimit *rate.Limiter
+	maxLimit  *semaphore.Weighted
+}
+
+// reserve splits the rate and the max of the limiter between the agents supervising a fleet-server
+// and the others, as configured by the fleet-server share of cfg.
+func (l *limiter) reserve(cfg *config.Limit) {
+	share := cfg.FleetServerShare
+	res := &reservationT{share: share}
+
+	if l.rateLimit != nil {
+		limit := rate.Every(cfg.Interval)
+		l.rateLimit = rate.NewLimiter(limit*rate.Limit(1-share), splitBurst(cfg.Burst, 1-share))
+		res.rateLimit = rate.NewLimiter(limit*rate.Limit(share), splitBurst(cfg.Burst, share))
+	}
+
+	if l.maxLimit != nil {
+		reserved := reservedMax(cfg.Max, share)
+		l.maxLimit = semaphore.NewWeighted(cfg.Max - reserved)
+		res.maxLimit = semaphore.NewWeighted(reserved)
+	}
+
+	l.reserved = res
+}
+
+// allowRate takes a token of the reserved rate, if any.
+func (res *reservationT) allowRate(now time.Time) bool {
+	if res == nil || res.rateLimit == nil {
+		return false
+	}
+	_, ok := allow(res.rateLimit, now)
+	return ok
+}
+
+// acquireMax acquires the reserved max, if any.
+func (res *reservationT) acquireMax() bool {
+	return res != nil && res.maxLimit != nil && res.maxLimit.TryAcquire(1)
+}
+
+// reservedMax returns the share of max reserved, leaving at least one for the other agents.
+func reservedMax(max int64, share float64) int64 {
+	reserved := int64(math.Ceil(float64(max) * share))
+	if reserved > max-1 {
+		reserved = max - 1
+	}
+	if reserved < 0 {
+		return 0
+	}
+	return reserved
+}
+
+// splitBurst returns the share of burst, at least 1 unless burst is 0.
+func splitBurst(burst int, share float64) int {
+	b := int(math.Ceil(float64(burst) * share))
+	if b < 1 && burst > 0 {
+		return 1
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+type fleetServerPolicies map[string]bool
+
+func (p fleetServerPolicies) IsFleetServerPolicy(policyID string) bool {
+	return p[policyID]
+}
+
+// acquireAs acquires the limits of l for an agent of the policy.
+func acquireAs(l *limiter, policyID string) (releaseFunc, error) {
+	_, release, _, err := l.acquire(&http.Request{}, httprouter.Params{{Key: "id", Value: "agent1"}, {Key: "policy", Value: policyID}})
+	return release, err
+}
+
+func TestReservedMax(t *testing.T) {
+	l := newAgentLimiter(&config.Limit{Max: 10, FleetServerShare: 0.2})
+	l.identify, l.authAgent = identifyID, authID
+	l.prioritizer = fleetServerPolicies{"fleet-server": true}
+
+	for i := 0; i < 8; i++ {
+		_, err := acquireAs(l, "agent")
+		require.NoError(t, err)
+	}
+	_, err := acquireAs(l, "agent")
+	assert.ErrorIs(t, err, ErrMaxLimit, "the reserved share is not available to the other agents")
+
+	for i := 0; i < 2; i++ {
+		_, err := acquireAs(l, "fleet-server")
+		require.NoError(t, err)
+	}
+	_, err = acquireAs(l, "fleet-server")
+	assert.ErrorIs(t, err, ErrMaxLimit)
+}
+
+func TestReservedMaxShared(t *testing.T) {
+	l := newAgentLimiter(&config.Limit{Max: 10, FleetServerShare: 0.2})
+	l.identify, l.authAgent = identifyID, authID
+	l.prioritizer = fleetServerPolicies{"fleet-server": true}
+
+	for i := 0; i < 10; i++ {
+		_, err := acquireAs(l, "fleet-server")
+		require.NoError(t, err, "the fleet-server agents use the shared capacity past their reservation")
+	}
+	_, err := acquireAs(l, "agent")
+	assert.ErrorIs(t, err, ErrMaxLimit)
+}
+
+func TestReservedRate(t *testing.T) {
+	l := newAgentLimiter(&config.Limit{Interval: time.Hour, Burst: 10, FleetServerShare: 0.2})
+	l.identify, l.authAgent = identifyID, authID
+	l.prioritizer = fleetServerPolicies{"fleet-server": true}
+
+	for i := 0; i < 8; i++ {
+		_, err := acquireAs(l, "agent")
+		require.NoError(t, err)
+	}
+	_, err := acquireAs(l, "agent")
+	assert.ErrorIs(t, err, ErrRateLimit)
+
+	for i := 0; i < 2; i++ {
+		_, err := acquireAs(l, "fleet-server")
+		require.NoError(t, err)
+	}
+	_, err = acquireAs(l, "fleet-server")
+	assert.ErrorIs(t, err, ErrRateLimit)
+}
+
+func TestReservedAdaptive(t *testing.T) {
+	cfg := testAdaptiveLimits()
+	cfg.CheckinLimit.FleetServerShare = 0.2
+	l := NewHTTPWrapper("test", cfg, WithController(NewController(cfg, nil)), WithAuthenticator(identifyID, authID), WithPrioritizer(fleetServerPolicies{"fleet-server": true}))
+
+	for i := 0; i < 80; i++ {
+		_, err := acquireAs(l.checkin, "agent")
+		require.NoError(t, err)
+	}
+	_, err := acquireAs(l.checkin, "agent")
+	assert.ErrorIs(t, err, ErrMaxLimit)
+	release, err := acquireAs(l.checkin, "fleet-server")
+	require.NoError(t, err)
+	release()
+}
+
+func TestReservedMaxBounds(t *testing.T) {
+	assert.Equal(t, int64(0), reservedMax(1, 0.5), "at least one is left to the other agents")
+	assert.Equal(t, int64(1), reservedMax(10, 0.01))
+	assert.Equal(t, int64(9), reservedMax(10, 0.99))
+	assert.Equal(t, 1, splitBurst(10, 0.01))
+	assert.Equal(t, 0, splitBurst(0, 0.5))
+}
@@ -0,0 +1,150 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"context"
+	"fmt"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shedLevel is a level of load shedding, each level shedding the routes of the lower levels too.
+type shedLevel int32
+
+const (
+	shedNone    shedLevel = iota
+	shedEnroll            // the heap reached the soft limit
+	shedCheckin           // the heap reached the hard limit
+)
+
+const (
+	// shedHysteresis is the ratio of a limit the heap must fall below to leave its level.
+	shedHysteresis = 0.9
+	// shedRetryAfter is the delay after which a shed request may be retried.
+	shedRetryAfter = 30 * time.Second
+
+	heapMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// Shedder rejects the new requests of the routes past the memory limits, before the route limiters:
+// the new enrollments once the heap reaches the soft limit, and the new checkins as well once it
+// reaches the hard limit. The requests in progress, e.g. the long polls, are not affected.
+//
+// The shedding is reported by the policy self monitor, see Shedding.
+type Shedder struct {
+	level int32 // the shedLevel, accessed atomically
+	cfg   config.MemoryLimits
+	heap  func() uint64
+}
+
+// NewShedder returns the shedder of cfg.
+func NewShedder(cfg *config.MemoryLimits) *Shedder {
+	return &Shedder{
+		cfg:  *cfg,
+		heap: heapBytes,
+	}
+}
+
+// Run samples the heap on each interval until ctx is cancelled.
+func (s *Shedder) Run(ctx context.Context) error {
+	if s.cfg.SoftLimit == 0 && s.cfg.HardLimit == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// sample updates the shedding level to the heap.
+func (s *Shedder) sample() {
+	heap := s.heap()
+	gaugeHeapBytes.Set(int64(heap))
+
+	prev := s.load()
+	level := s.levelOf(heap, prev)
+	if level == prev {
+		return
+	}
+	atomic.StoreInt32(&s.level, int32(level))
+	gaugeShedLevel.Set(int64(level))
+
+	msg := s.Shedding()
+	if level == shedNone {
+		msg = "Memory usage back to normal, accepting all requests"
+	}
+	log.Warn().Uint64("heap", heap).Int32("level", int32(level)).Msg(msg)
+}
+
+// Shedding returns the requests currently shed and why, empty when none is.
+func (s *Shedder) Shedding() string {
+	switch s.load() {
+	case shedEnroll:
+		return fmt.Sprintf("Memory usage high, rejecting new enrollments: heap past the soft limit of %d bytes", s.cfg.SoftLimit)
+	case shedCheckin:
+		return fmt.Sprintf("Memory usage critical, rejecting new enrollments and checkins: heap past the hard limit of %d bytes", s.cfg.HardLimit)
+	}
+	return ""
+}
+
+// levelOf returns the shedding level of heap, the limits of the levels up to prev being lowered by
+// the hysteresis so that the level does not flap around a limit.
+func (s *Shedder) levelOf(heap uint64, prev shedLevel) shedLevel {
+	levels := []struct {
+		level shedLevel
+		limit uint64
+	}{
+		{shedCheckin, s.cfg.HardLimit},
+		{shedEnroll, s.cfg.SoftLimit},
+	}
+	for _, l := range levels {
+		if l.limit == 0 {
+			continue
+		}
+		threshold := l.limit
+		if prev >= l.level {
+			threshold = uint64(float64(l.limit) * shedHysteresis)
+		}
+		if heap >= threshold {
+			return l.level
+		}
+	}
+	return shedNone
+}
+
+func (s *Shedder) load() shedLevel {
+	return shedLevel(atomic.LoadInt32(&s.level))
+}
+
+// shed returns ErrShed when the routes of level are shed.
+func (s *Shedder) shed(level shedLevel) error {
+	if s.load() >= level {
+		return ErrShed
+	}
+	return nil
+}
+
+// heapBytes returns the bytes of the heap objects, reachable or not yet swept.
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
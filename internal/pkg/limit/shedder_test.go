@@ -0,0 +1,95 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestShedderLevels(t *testing.T) {
+	s := NewShedder(&config.MemoryLimits{SoftLimit: 1000, HardLimit: 2000})
+	var heap uint64
+	s.heap = func() uint64 { return heap }
+
+	for _, tc := range []struct {
+		heap     uint64
+		level    shedLevel
+		shedding string
+	}{
+		{heap: 500, level: shedNone},
+		{heap: 1000, level: shedEnroll, shedding: "rejecting new enrollments"},
+		{heap: 950, level: shedEnroll, shedding: "rejecting new enrollments"},
+		{heap: 2100, level: shedCheckin, shedding: "rejecting new enrollments and checkins"},
+		{heap: 1850, level: shedCheckin, shedding: "rejecting new enrollments and checkins"},
+		{heap: 1700, level: shedEnroll, shedding: "rejecting new enrollments"},
+		{heap: 899, level: shedNone},
+	} {
+		heap = tc.heap
+		s.sample()
+		assert.Equal(t, tc.level, s.load(), "heap %d", tc.heap)
+		assert.Equal(t, int64(tc.heap), gaugeHeapBytes.Get())
+		if tc.shedding == "" {
+			assert.Empty(t, s.Shedding(), "heap %d", tc.heap)
+		} else {
+			assert.Contains(t, s.Shedding(), tc.shedding, "heap %d", tc.heap)
+		}
+	}
+}
+
+func TestWrapShed(t *testing.T) {
+	s := NewShedder(&config.MemoryLimits{SoftLimit: 1000, HardLimit: 2000})
+	var heap uint64
+	s.heap = func() uint64 { return heap }
+	l := NewHTTPWrapper("test", &config.ServerLimits{}, WithShedder(s))
+
+	i := &mockIncer{}
+	i.On("IncStart").Return(func() {})
+	i.On("IncError", ErrShed)
+	enroll := l.WrapEnroll(stubHandle(), i)
+	checkin := l.WrapCheckin(stubHandle(), i)
+	ack := l.WrapAck(stubHandle(), i)
+	request := func(h httprouter.Handle) *http.Response {
+		w := httptest.NewRecorder()
+		h(w, &http.Request{}, nil)
+		return w.Result()
+	}
+
+	for _, tc := range []struct {
+		heap    uint64
+		enroll  int
+		checkin int
+	}{
+		{heap: 500, enroll: http.StatusOK, checkin: http.StatusOK},
+		{heap: 1500, enroll: http.StatusServiceUnavailable, checkin: http.StatusOK},
+		{heap: 2500, enroll: http.StatusServiceUnavailable, checkin: http.StatusServiceUnavailable},
+	} {
+		heap = tc.heap
+		s.sample()
+
+		resp := request(enroll)
+		resp.Body.Close()
+		assert.Equal(t, tc.enroll, resp.StatusCode, "enroll at heap %d", tc.heap)
+		resp = request(checkin)
+		resp.Body.Close()
+		assert.Equal(t, tc.checkin, resp.StatusCode, "checkin at heap %d", tc.heap)
+		if tc.checkin == http.StatusServiceUnavailable {
+			assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+		}
+		resp = request(ack)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "ack is never shed")
+	}
+}
+
+func TestHeapBytes(t *testing.T) {
+	assert.NotZero(t, heapBytes())
+}
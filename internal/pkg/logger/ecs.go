@@ -36,6 +36,9 @@ const (
 	// Server
 	ECSServerAddress = "server.address"
 
+	// Source, the peer of a connection, e.g. the proxy of a client
+	ECSSourceAddress = "source.address"
+
 	// TLS
 	ECSTLSEstablished        = "tls.established"
 	ECSTLSsResumed           = "tls.resumed"
@@ -83,12 +83,16 @@ func (rc *ResponseCounter) Count() uint64 {
 	return atomic.LoadUint64(&rc.count)
 }
 
+// splitAddr returns the host and port of addr, the port being 0 when addr is an IP without a port,
+// e.g. the client IP resolved from the X-Forwarded-For header of a proxy.
 func splitAddr(addr string) (host string, port int) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err == nil {
 		if v, err := strconv.Atoi(portS); err == nil {
 			port = v
 		}
+	} else if net.ParseIP(addr) != nil {
+		host = addr
 	}
 
 	return //nolint:nakedret // short function
@@ -153,10 +157,12 @@ func httpMeta(r *http.Request, e *zerolog.Event) {
 
 func httpDebug(r *http.Request, e *zerolog.Event) {
 	// Client info
-	if r.RemoteAddr != "" {
-		remoteIP, remotePort := splitAddr(r.RemoteAddr)
+	// The address of a unix domain socket client is a path, without IP nor port
+	if remoteIP, remotePort := splitAddr(r.RemoteAddr); remoteIP != "" {
 		e.Str(ECSClientIP, remoteIP)
-		e.Int(ECSClientPort, remotePort)
+		if remotePort != 0 {
+			e.Int(ECSClientPort, remotePort)
+		}
 	}
 
 	if r.TLS != nil {
@@ -64,3 +64,67 @@ func (a *Agent) APIKeyIDs() []string {
 	return keys
 
 }
+
+// Status of an Elastic Agent computed by Fleet Server.
+const (
+	AgentStatusOnline      = "online"
+	AgentStatusOffline     = "offline"
+	AgentStatusError       = "error"
+	AgentStatusDegraded    = "degraded"
+	AgentStatusUpdating    = "updating"
+	AgentStatusUnenrolling = "unenrolling"
+	AgentStatusUnenrolled  = "unenrolled"
+	AgentStatusInactive    = "inactive"
+)
+
+// AgentOfflineThreshold is the duration without checkin after which an Elastic Agent is offline.
+//
+// The threshold is not configurable so that all the Fleet Servers compute the same status.
+const AgentOfflineThreshold = 5 * time.Minute
+
+// ComputeStatus returns the status of the Elastic Agent at the given time.
+func (a *Agent) ComputeStatus(now time.Time) string {
+	online := a.OfflineAt == "" && a.InactiveAt == "" && a.checkedInSince(now.Add(-AgentOfflineThreshold))
+	return a.status(a.LastCheckinStatus, online)
+}
+
+// CheckinStatus returns the status of the Elastic Agent once it checked in reporting checkinStatus.
+func (a *Agent) CheckinStatus(checkinStatus string) string {
+	return a.status(checkinStatus, true)
+}
+
+func (a *Agent) status(checkinStatus string, online bool) string {
+	switch {
+	case !a.Active:
+		return AgentStatusUnenrolled
+	case a.UnenrollmentStartedAt != "":
+		return AgentStatusUnenrolling
+	case !online && a.InactiveAt != "":
+		return AgentStatusInactive
+	case !online:
+		return AgentStatusOffline
+	case a.UpgradeStartedAt != "":
+		return AgentStatusUpdating
+	}
+
+	switch checkinStatus {
+	case "error", "failed":
+		return AgentStatusError
+	case "degraded":
+		return AgentStatusDegraded
+	}
+	return AgentStatusOnline
+}
+
+// checkedInSince returns true if the agent checked in, or enrolled when it never checked in, after t.
+func (a *Agent) checkedInSince(t time.Time) bool {
+	ts := a.LastCheckin
+	if ts == "" {
+		ts = a.EnrolledAt
+	}
+	last, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return false
+	}
+	return last.After(t)
+}
@@ -6,6 +6,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
@@ -159,3 +160,93 @@ func TestAgentAPIKeyIDs(t *testing.T) {
 		})
 	}
 }
+
+func TestAgentComputeStatus(t *testing.T) {
+	now := time.Now().UTC()
+	recent := now.Add(-time.Minute).Format(time.RFC3339)
+	stale := now.Add(-time.Hour).Format(time.RFC3339)
+
+	tcs := []struct {
+		name  string
+		agent Agent
+		want  string
+	}{
+		{
+			name:  "online",
+			agent: Agent{Active: true, LastCheckin: recent, LastCheckinStatus: "online"},
+			want:  AgentStatusOnline,
+		},
+		{
+			name:  "error",
+			agent: Agent{Active: true, LastCheckin: recent, LastCheckinStatus: "error"},
+			want:  AgentStatusError,
+		},
+		{
+			name:  "failed",
+			agent: Agent{Active: true, LastCheckin: recent, LastCheckinStatus: "failed"},
+			want:  AgentStatusError,
+		},
+		{
+			name:  "degraded",
+			agent: Agent{Active: true, LastCheckin: recent, LastCheckinStatus: "degraded"},
+			want:  AgentStatusDegraded,
+		},
+		{
+			name:  "updating",
+			agent: Agent{Active: true, LastCheckin: recent, LastCheckinStatus: "degraded", UpgradeStartedAt: recent},
+			want:  AgentStatusUpdating,
+		},
+		{
+			name:  "offline",
+			agent: Agent{Active: true, LastCheckin: stale, LastCheckinStatus: "online", UpgradeStartedAt: recent},
+			want:  AgentStatusOffline,
+		},
+		{
+			name:  "marked offline",
+			agent: Agent{Active: true, LastCheckin: recent, OfflineAt: recent},
+			want:  AgentStatusOffline,
+		},
+		{
+			name:  "never checked in",
+			agent: Agent{Active: true, EnrolledAt: recent},
+			want:  AgentStatusOnline,
+		},
+		{
+			name:  "never checked in and stale",
+			agent: Agent{Active: true, EnrolledAt: stale},
+			want:  AgentStatusOffline,
+		},
+		{
+			name:  "inactive",
+			agent: Agent{Active: true, LastCheckin: stale, InactiveAt: recent},
+			want:  AgentStatusInactive,
+		},
+		{
+			name:  "unenrolling",
+			agent: Agent{Active: true, LastCheckin: stale, UnenrollmentStartedAt: recent},
+			want:  AgentStatusUnenrolling,
+		},
+		{
+			name:  "unenrolled",
+			agent: Agent{Active: false, LastCheckin: recent, UnenrollmentStartedAt: recent},
+			want:  AgentStatusUnenrolled,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.agent.ComputeStatus(now))
+		})
+	}
+}
+
+func TestAgentCheckinStatus(t *testing.T) {
+	stale := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+
+	agent := Agent{Active: true, LastCheckin: stale, InactiveAt: stale, LastCheckinStatus: "error"}
+	assert.Equal(t, AgentStatusOnline, agent.CheckinStatus("online"))
+	assert.Equal(t, AgentStatusDegraded, agent.CheckinStatus("degraded"))
+
+	agent.UnenrollmentStartedAt = stale
+	assert.Equal(t, AgentStatusUnenrolling, agent.CheckinStatus("online"))
+}
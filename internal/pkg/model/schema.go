@@ -139,6 +139,9 @@ type Agent struct {
 	// Date/time the Elastic Agent enrolled
 	EnrolledAt string `json:"enrolled_at"`
 
+	// Date/time the Elastic Agent was marked inactive because it stopped checking in
+	InactiveAt string `json:"inactive_at,omitempty"`
+
 	// Date/time the Elastic Agent checked in last time
 	LastCheckin string `json:"last_checkin,omitempty"`
 
@@ -154,6 +157,9 @@ type Agent struct {
 	// Local metadata information for the Elastic Agent
 	LocalMetadata json.RawMessage `json:"local_metadata,omitempty"`
 
+	// Date/time the Elastic Agent was marked offline because it stopped checking in
+	OfflineAt string `json:"offline_at,omitempty"`
+
 	// Outputs is the policy output data, mapping the output name to its data
 	Outputs map[string]*PolicyOutput `json:"outputs,omitempty"`
 
@@ -175,6 +181,9 @@ type Agent struct {
 	// Shared ID
 	SharedID string `json:"shared_id,omitempty"`
 
+	// Status of the Elastic Agent computed by Fleet Server
+	Status string `json:"status,omitempty"`
+
 	// User provided tags for the Elastic Agent
 	Tags []string `json:"tags,omitempty"`
 
@@ -317,6 +326,15 @@ type Policy struct {
 	// True when this policy is the default policy to start Fleet Server
 	DefaultFleetServer bool `json:"default_fleet_server"`
 
+	// Fencing token of the policy leader that wrote the coordinated revision
+	FencingToken int64 `json:"fencing_token,omitempty"`
+
+	// Timeout (seconds) after which an Elastic Agent that stopped checking in is marked inactive and hidden.
+	InactivityTimeout int64 `json:"inactivity_timeout,omitempty"`
+
+	// Timeout (seconds) after which an Elastic Agent that stopped checking in is marked offline.
+	OfflineTimeout int64 `json:"offline_timeout,omitempty"`
+
 	// The ID of the policy
 	PolicyID string `json:"policy_id"`
 
@@ -326,6 +344,9 @@ type Policy struct {
 	// Date/time the policy revision was created
 	Timestamp string `json:"@timestamp,omitempty"`
 
+	// Action taken on an Elastic Agent when the unenroll timeout is reached; defaults to unenroll.
+	UnenrollAction string `json:"unenroll_action,omitempty"`
+
 	// Timeout (seconds) that an Elastic Agent should be un-enrolled.
 	UnenrollTimeout int64 `json:"unenroll_timeout,omitempty"`
 }
@@ -333,7 +354,10 @@ type Policy struct {
 // PolicyLeader The current leader Fleet Server for a policy
 type PolicyLeader struct {
 	ESDocument
-	Server *ServerMetadata `json:"server"`
+
+	// Monotonically increasing token, incremented every time a Fleet Server takes over the leadership
+	FencingToken int64           `json:"fencing_token,omitempty"`
+	Server       *ServerMetadata `json:"server"`
 
 	// Date/time the leader was taken or held
 	Timestamp string `json:"@timestamp,omitempty"`
@@ -0,0 +1,102 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package monitor
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var (
+	registry *monitoring.Registry
+
+	metricsMx      sync.Mutex
+	metricsByIndex = make(map[string]*metricsT)
+)
+
+type metricsT struct {
+	lag     *monitoring.Int  // global checkpoint minus the seq_no of the last document sent to the output
+	fetched *monitoring.Uint // documents fetched
+	pages   *monitoring.Uint // search requests fetching documents
+	pits    *monitoring.Uint // points in time opened to page through a backlog
+
+	dropped   *monitoring.Uint // documents dropped by the subscriptions
+	coalesced *monitoring.Uint // subscription notifications merged with the next one
+
+	subsMx sync.Mutex
+	subs   map[*subT]struct{} // subscriptions reported under subscriptions.<id>
+}
+
+func init() {
+	registry = monitoring.Default.NewRegistry("monitor")
+}
+
+// indexMetrics returns the metrics of the monitors of the index, registered under monitor.<index>
+// without the leading dot of the fleet system indices.
+func indexMetrics(index string) *metricsT {
+	metricsMx.Lock()
+	defer metricsMx.Unlock()
+
+	if m, ok := metricsByIndex[index]; ok {
+		return m
+	}
+
+	name := strings.ReplaceAll(strings.TrimPrefix(index, "."), ".", "_")
+	indexRegistry := registry.NewRegistry(name)
+	m := &metricsT{
+		lag:     monitoring.NewInt(indexRegistry, "lag"),
+		fetched: monitoring.NewUint(indexRegistry, "fetched"),
+		pages:   monitoring.NewUint(indexRegistry, "pages"),
+		pits:    monitoring.NewUint(indexRegistry, "pits"),
+
+		dropped:   monitoring.NewUint(indexRegistry, "dropped"),
+		coalesced: monitoring.NewUint(indexRegistry, "coalesced"),
+		subs:      make(map[*subT]struct{}),
+	}
+	monitoring.NewFunc(indexRegistry, "subscriptions", m.reportSubscriptions)
+	metricsByIndex[index] = m
+	return m
+}
+
+func (m *metricsT) addSubscription(s *subT) {
+	m.subsMx.Lock()
+	defer m.subsMx.Unlock()
+	m.subs[s] = struct{}{}
+}
+
+func (m *metricsT) removeSubscription(s *subT) {
+	m.subsMx.Lock()
+	defer m.subsMx.Unlock()
+	delete(m.subs, s)
+}
+
+// reportSubscriptions reports the queue depth, last delivery, dropped documents and coalesced
+// notifications of each subscription.
+func (m *metricsT) reportSubscriptions(_ monitoring.Mode, v monitoring.Visitor) {
+	m.subsMx.Lock()
+	subs := make([]*subT, 0, len(m.subs))
+	for s := range m.subs {
+		subs = append(subs, s)
+	}
+	m.subsMx.Unlock()
+
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+	for _, s := range subs {
+		stats := s.stats()
+		monitoring.ReportNamespace(v, strconv.FormatUint(s.idx, 10), func() {
+			monitoring.ReportInt(v, "queue", int64(stats.queue))
+			if !stats.lastDelivery.IsZero() {
+				monitoring.ReportString(v, "last_delivery", stats.lastDelivery.UTC().Format(time.RFC3339Nano))
+			}
+			monitoring.ReportInt(v, "dropped", int64(stats.dropped))
+			monitoring.ReportInt(v, "coalesced", int64(stats.coalesced))
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -44,6 +45,16 @@ const (
 	// 2. Any other error waiting on global checkpoint, except timeouts.
 	// For the long poll timeout, start a new request as soon as possible.
 	retryDelay = 3 * time.Second
+
+	// Keep alive of the point in time used to page through a backlog, between two pages.
+	pitKeepAlive = "1m"
+
+	// Timeout closing a point in time, once the monitor context may be cancelled.
+	pitCloseTimeout = 10 * time.Second
+
+	// Bounds of the adaptive interval between two polls, without the fleet plugin.
+	defaultPollIntervalMin = 500 * time.Millisecond
+	defaultPollIntervalMax = 10 * time.Second
 )
 
 const (
@@ -85,11 +96,21 @@ type simpleMonitorT struct {
 	pollTimeout    time.Duration
 	withExpiration bool
 	fetchSize      int
+	source         []string // fields of the fetched documents, all when empty
+
+	// Without the fleet plugin, the index is polled at an interval between the bounds
+	polling         bool
+	pollIntervalMin time.Duration
+	pollIntervalMax time.Duration
+
+	// Options of the subscriptions, when wrapped by the subscription monitor
+	subOpts subscriptionOptsT
 
 	checkpoint sqn.SeqNo    // index global checkpoint
 	mx         sync.RWMutex // checkpoint mutex
 
-	log zerolog.Logger
+	log     zerolog.Logger
+	metrics *metricsT
 
 	outCh chan []es.HitT
 
@@ -103,14 +124,21 @@ type Option func(SimpleMonitor)
 func NewSimple(index string, esCli, monCli *elasticsearch.Client, opts ...Option) (SimpleMonitor, error) {
 
 	m := &simpleMonitorT{
-		index:          index,
-		esCli:          esCli,
-		monCli:         monCli,
-		pollTimeout:    defaultPollTimeout,
-		withExpiration: defaultWithExpiration,
-		fetchSize:      defaultFetchSize,
-		checkpoint:     sqn.DefaultSeqNo,
-		outCh:          make(chan []es.HitT, 1),
+		index:           index,
+		esCli:           esCli,
+		monCli:          monCli,
+		pollTimeout:     defaultPollTimeout,
+		withExpiration:  defaultWithExpiration,
+		fetchSize:       defaultFetchSize,
+		pollIntervalMin: defaultPollIntervalMin,
+		pollIntervalMax: defaultPollIntervalMax,
+		checkpoint:      sqn.DefaultSeqNo,
+		outCh:           make(chan []es.HitT, 1),
+		subOpts: subscriptionOptsT{
+			policy:       SubscriptionTimeout,
+			queueSize:    defaultSubscriptionQueueSize,
+			lagThreshold: defaultSubscriptionLagThreshold,
+		},
 	}
 
 	for _, opt := range opts {
@@ -118,6 +146,7 @@ func NewSimple(index string, esCli, monCli *elasticsearch.Client, opts ...Option
 	}
 
 	m.log = log.With().Str("index", m.index).Str("ctx", "index monitor").Logger()
+	m.metrics = indexMetrics(m.index)
 
 	tmplCheck, err := m.prepareCheckQuery()
 	if err != nil {
@@ -150,6 +179,53 @@ func WithPollTimeout(to time.Duration) Option {
 	}
 }
 
+// WithPollInterval sets the bounds of the adaptive interval between two polls of the index, used when
+// the cluster has no fleet plugin.
+func WithPollInterval(min, max time.Duration) Option {
+	return func(m SimpleMonitor) {
+		if min > 0 && max >= min {
+			m.(*simpleMonitorT).pollIntervalMin = min
+			m.(*simpleMonitorT).pollIntervalMax = max
+		}
+	}
+}
+
+// WithSubscriptionPolicy sets the policy applied when the queue of a subscription is full, for the
+// subscription monitor.
+func WithSubscriptionPolicy(policy SubscriptionPolicy) Option {
+	return func(m SimpleMonitor) {
+		if policy != "" {
+			m.(*simpleMonitorT).subOpts.policy = policy
+		}
+	}
+}
+
+// WithSubscriptionQueueSize sets the number of notifications queued per subscription, for the
+// subscription monitor.
+func WithSubscriptionQueueSize(size int) Option {
+	return func(m SimpleMonitor) {
+		if size > 0 {
+			m.(*simpleMonitorT).subOpts.queueSize = size
+		}
+	}
+}
+
+// WithSubscriptionLagThreshold sets the stall duration after which a warning is logged for a
+// subscription, zero disabling the warning, for the subscription monitor.
+func WithSubscriptionLagThreshold(threshold time.Duration) Option {
+	return func(m SimpleMonitor) {
+		m.(*simpleMonitorT).subOpts.lagThreshold = threshold
+	}
+}
+
+// WithSource limits the fields of the fetched documents to the source fields, which may contain
+// wildcards.
+func WithSource(fields ...string) Option {
+	return func(m SimpleMonitor) {
+		m.(*simpleMonitorT).source = fields
+	}
+}
+
 // WithExpiration adds the expiration field to the monitor query.
 func WithExpiration(withExpiration bool) Option {
 	return func(m SimpleMonitor) {
@@ -206,6 +282,11 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 	// Initialize global checkpoint from the index stats
 	var checkpoint sqn.SeqNo
 	checkpoint, err = gcheckpt.Query(ctx, m.monCli, m.index)
+	if errors.Is(err, gcheckpt.ErrUnsupported) {
+		m.log.Warn().Err(err).Msg("fleet plugin not found, polling the index")
+		m.polling = true
+		checkpoint, err = gcheckpt.QueryStats(ctx, m.monCli, m.index)
+	}
 	if err != nil {
 		m.log.Error().Err(err).Msg("failed to initialize the global checkpoints")
 		return err
@@ -218,6 +299,10 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 		m.readyCh = nil
 	}
 
+	if m.polling {
+		return m.poll(ctx, checkpoint)
+	}
+
 	for {
 		checkpoint := m.loadCheckpoint()
 
@@ -254,23 +339,100 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 		// 5. Return to step 1
 
 		// Fetch up to known checkpoint
-		count := m.fetchSize
-		for count == m.fetchSize {
-			hits, err := m.fetch(ctx, checkpoint, newCheckpoint)
-			if err != nil {
-				m.log.Error().Err(err).Msg("failed checking new documents")
-				break
-			}
+		m.metrics.lag.Set(newCheckpoint.Value() - checkpoint.Value())
+		m.deliver(ctx, checkpoint, newCheckpoint)
+	}
+}
+
+type pageT struct {
+	hits []es.HitT
+	err  error
+}
+
+// deliver pages through the documents up to maxCheckpoint, sending each page to the output while the
+// next one is fetched. At most one page waits for delivery, keeping the memory bounded on a backlog.
+func (m *simpleMonitorT) deliver(ctx context.Context, checkpoint, maxCheckpoint sqn.SeqNo) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			// Notify call updates checkpoint
-			count = m.notify(ctx, hits)
+	pages := make(chan pageT)
+	go m.fetchPages(ctx, checkpoint, maxCheckpoint, pages)
 
-			// Get the latest checkpoint for the next fetch iteration
-			if count == m.fetchSize {
-				checkpoint = m.loadCheckpoint()
+	for page := range pages {
+		if page.err != nil {
+			m.log.Error().Err(page.err).Msg("failed checking new documents")
+			return
+		}
+		if m.notify(ctx, page.hits) == 0 {
+			break
+		}
+		m.metrics.lag.Set(maxCheckpoint.Value() - page.hits[len(page.hits)-1].SeqNo)
+	}
+	m.metrics.lag.Set(0)
+}
+
+// fetchPages sends the pages of documents after checkpoint up to maxCheckpoint, then closes pages.
+//
+// The first page waits for maxCheckpoint to be searchable. If it is full, the backlog is paged with
+// search_after in a point in time, or from the seq_no of the last document if the point in time
+// cannot be opened.
+func (m *simpleMonitorT) fetchPages(ctx context.Context, checkpoint, maxCheckpoint sqn.SeqNo, pages chan<- pageT) {
+	defer close(pages)
+
+	// send returns true if there may be more documents to fetch
+	send := func(hits []es.HitT, err error) bool {
+		select {
+		case pages <- pageT{hits: hits, err: err}:
+		case <-ctx.Done():
+			return false
+		}
+		m.metrics.fetched.Add(uint64(len(hits)))
+		return err == nil && len(hits) == m.fetchSize
+	}
+
+	m.metrics.pages.Inc()
+	hits, err := m.fetch(ctx, checkpoint, maxCheckpoint)
+	if !send(hits, err) {
+		return
+	}
+	last := sqn.SeqNo{hits[len(hits)-1].SeqNo}
+
+	pitID, err := m.openPIT(ctx)
+	if err != nil {
+		m.log.Warn().Err(err).Msg("failed to open a point in time, paging without")
+		for {
+			m.metrics.pages.Inc()
+			hits, err = m.fetch(ctx, last, maxCheckpoint)
+			if !send(hits, err) {
+				return
 			}
+			last = sqn.SeqNo{hits[len(hits)-1].SeqNo}
 		}
 	}
+	m.metrics.pits.Inc()
+	defer func() {
+		m.closePIT(pitID)
+	}()
+
+	// The first page in the point in time starts after the last document, the next ones after the
+	// sort values of their last hit, including the point in time tiebreaker.
+	var after []interface{}
+	for {
+		m.metrics.pages.Inc()
+		var res *pitResultT
+		res, err = m.searchPIT(ctx, pitID, last, maxCheckpoint, after)
+		if err != nil {
+			send(nil, err)
+			return
+		}
+		if res.pitID != "" {
+			pitID = res.pitID
+		}
+		if !send(res.hits, nil) {
+			return
+		}
+		after = res.lastSort
+	}
 }
 
 func (m *simpleMonitorT) notify(ctx context.Context, hits []es.HitT) int {
@@ -307,18 +469,148 @@ func (m *simpleMonitorT) fetch(ctx context.Context, checkpoint, maxCheckpoint sq
 	return hits, nil
 }
 
+func (m *simpleMonitorT) openPIT(ctx context.Context) (string, error) {
+	res, err := m.esCli.OpenPointInTime([]string{m.index}, pitKeepAlive,
+		m.esCli.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var pres struct {
+		ID    string          `json:"id"`
+		Error json.RawMessage `json:"error,omitempty"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pres); err != nil {
+		return "", err
+	}
+	if res.IsError() {
+		return "", es.TranslateError(res.StatusCode, pres.Error)
+	}
+	return pres.ID, nil
+}
+
+// closePIT closes the point in time, logging the errors as it expires anyway.
+func (m *simpleMonitorT) closePIT(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), pitCloseTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return
+	}
+	res, err := m.esCli.ClosePointInTime(
+		m.esCli.ClosePointInTime.WithContext(ctx),
+		m.esCli.ClosePointInTime.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		m.log.Debug().Err(err).Msg("failed to close the point in time")
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		m.log.Debug().Str("status", res.Status()).Msg("failed to close the point in time")
+	}
+}
+
+type pitResultT struct {
+	pitID    string
+	hits     []es.HitT
+	lastSort []interface{}
+}
+
+// searchPIT fetches the documents after checkpoint up to maxCheckpoint in the point in time,
+// after the sort values if any.
+func (m *simpleMonitorT) searchPIT(ctx context.Context, pitID string, checkpoint, maxCheckpoint sqn.SeqNo, after []interface{}) (*pitResultT, error) {
+	params := map[string]interface{}{
+		dl.FieldSeqNo:    checkpoint.Value(),
+		dl.FieldMaxSeqNo: maxCheckpoint.Value(),
+	}
+	if m.withExpiration {
+		params[dl.FieldExpiration] = time.Now().UTC().Format(time.RFC3339)
+	}
+	query, err := m.tmplQuery.Render(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// The searches in a point in time do not name the index
+	var body map[string]interface{}
+	if err := json.Unmarshal(query, &body); err != nil {
+		return nil, err
+	}
+	body["pit"] = map[string]string{"id": pitID, "keep_alive": pitKeepAlive}
+	if after != nil {
+		body["search_after"] = after
+	}
+	if query, err = json.Marshal(body); err != nil {
+		return nil, err
+	}
+
+	res, err := m.esCli.Search(
+		m.esCli.Search.WithContext(ctx),
+		m.esCli.Search.WithBody(bytes.NewReader(query)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var sres struct {
+		PitID string `json:"pit_id"`
+		Hits  struct {
+			Hits []struct {
+				es.HitT
+				Sort []interface{} `json:"sort"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Error json.RawMessage `json:"error,omitempty"`
+	}
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber() // keep the tiebreaker exact
+	if err := dec.Decode(&sres); err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, es.TranslateError(res.StatusCode, sres.Error)
+	}
+
+	r := &pitResultT{
+		pitID: sres.PitID,
+		hits:  make([]es.HitT, 0, len(sres.Hits.Hits)),
+	}
+	for _, hit := range sres.Hits.Hits {
+		r.hits = append(r.hits, hit.HitT)
+	}
+	if n := len(sres.Hits.Hits); n > 0 {
+		r.lastSort = sres.Hits.Hits[n-1].Sort
+	}
+	return r, nil
+}
+
 func (m *simpleMonitorT) search(ctx context.Context, tmpl *dsl.Tmpl, params map[string]interface{}, seqNos []int64) ([]es.HitT, error) {
 	query, err := tmpl.Render(params)
 	if err != nil {
 		return nil, err
 	}
 
-	req := es.FleetSearchRequest{
-		Index:              []string{m.index},
-		Body:               bytes.NewBuffer(query),
-		WaitForCheckpoints: seqNos,
+	var res *esapi.Response
+	if m.polling {
+		// The index is refreshed up to the checkpoints before searching
+		res, err = m.esCli.Search(
+			m.esCli.Search.WithContext(ctx),
+			m.esCli.Search.WithIndex(m.index),
+			m.esCli.Search.WithBody(bytes.NewReader(query)),
+		)
+	} else {
+		req := es.FleetSearchRequest{
+			Index:              []string{m.index},
+			Body:               bytes.NewBuffer(query),
+			WaitForCheckpoints: seqNos,
+		}
+		res, err = req.Do(ctx, m.esCli)
 	}
-	res, err := req.Do(ctx, m.esCli)
 
 	if err != nil {
 		return nil, err
@@ -363,6 +655,9 @@ func (m *simpleMonitorT) prepareQuery() (*dsl.Tmpl, error) {
 	tmpl, root := m.prepareCommon(true)
 	root.Size(uint64(m.fetchSize))
 	root.Sort().SortOrder(fieldSeqNo, dsl.SortAscend)
+	if len(m.source) > 0 {
+		root.Source().Includes(m.source...)
+	}
 
 	if err := tmpl.Resolve(root); err != nil {
 		return nil, err
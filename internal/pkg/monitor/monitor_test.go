@@ -0,0 +1,183 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/membulk"
+)
+
+// gatedHandler holds the global checkpoints long polls until the gate is closed, so that the
+// documents written meanwhile are a backlog, and records the requests.
+type gatedHandler struct {
+	next  http.Handler
+	gate  chan struct{}
+	noPIT bool
+
+	mut      sync.Mutex
+	requests []string
+}
+
+func (h *gatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mut.Lock()
+	h.requests = append(h.requests, r.Method+" "+r.URL.Path)
+	h.mut.Unlock()
+
+	if r.URL.Query().Get("wait_for_advance") == "true" {
+		<-h.gate
+	}
+	if h.noPIT && strings.HasSuffix(r.URL.Path, "_pit") {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"no pit"},"status":400}`))
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func (h *gatedHandler) has(request string) bool {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for _, r := range h.requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func runBacklogTest(t *testing.T, index string, noPIT bool) *gatedHandler {
+	mem := membulk.New()
+	handler := &gatedHandler{next: membulk.NewHandler(mem), gate: make(chan struct{}), noPIT: noPIT}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// Cancelled before closing the server, ending the long polls
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+
+	readyCh := make(chan error)
+	mon, err := NewSimple(index, client, client, WithFetchSize(2), WithReadyChan(readyCh))
+	require.NoError(t, err)
+	go func() { _ = mon.Run(ctx) }()
+	require.NoError(t, <-readyCh)
+
+	ids := []string{"a", "b", "c", "d", "e"}
+	for _, id := range ids {
+		_, err = mem.Create(ctx, index, id, []byte(`{}`))
+		require.NoError(t, err)
+	}
+	close(handler.gate)
+
+	var got []string
+	pages := 0
+	for len(got) < len(ids) {
+		select {
+		case hits := <-mon.Output():
+			pages++
+			for _, hit := range hits {
+				got = append(got, hit.ID)
+			}
+		case <-ctx.Done():
+			t.Fatalf("expected %v, got %v", ids, got)
+		}
+	}
+	assert.Equal(t, ids, got)
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, int64(4), mon.GetCheckpoint().Value())
+	assert.Eventually(t, func() bool { return indexMetrics(index).lag.Get() == 0 }, time.Second, time.Millisecond)
+	if !noPIT {
+		assert.Eventually(t, func() bool { return handler.has("DELETE /_pit") }, time.Second, time.Millisecond, "the point in time is closed")
+	}
+	return handler
+}
+
+func TestSimpleMonitorBacklog(t *testing.T) {
+	metrics := indexMetrics("backlog")
+	pits, fetched := metrics.pits.Get(), metrics.fetched.Get()
+	handler := runBacklogTest(t, "backlog", false)
+
+	assert.True(t, handler.has("POST /backlog/_pit"))
+	assert.True(t, handler.has("POST /_search"))
+	assert.Equal(t, pits+1, metrics.pits.Get())
+	assert.Equal(t, fetched+5, metrics.fetched.Get())
+}
+
+func TestSimpleMonitorBacklogWithoutPIT(t *testing.T) {
+	metrics := indexMetrics("backlog-nopit")
+	pits := metrics.pits.Get()
+	handler := runBacklogTest(t, "backlog-nopit", true)
+
+	assert.False(t, handler.has("POST /_search"))
+	assert.Equal(t, pits, metrics.pits.Get())
+}
+
+func TestSimpleMonitorWithoutFleetPlugin(t *testing.T) {
+	mem := membulk.New()
+	gate := make(chan struct{})
+	close(gate)
+	handler := &gatedHandler{next: membulk.NewHandler(mem, membulk.WithoutFleetPlugin()), gate: gate}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// Cancelled before closing the server
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+
+	readyCh := make(chan error)
+	mon, err := NewSimple("polled", client, client, WithFetchSize(2), WithPollInterval(time.Millisecond, 10*time.Millisecond), WithReadyChan(readyCh))
+	require.NoError(t, err)
+	go func() { _ = mon.Run(ctx) }()
+	require.NoError(t, <-readyCh)
+
+	ids := []string{"a", "b", "c"}
+	var got []string
+	for _, id := range ids {
+		_, err = mem.Create(ctx, "polled", id, []byte(`{}`))
+		require.NoError(t, err)
+
+		select {
+		case hits := <-mon.Output():
+			for _, hit := range hits {
+				got = append(got, hit.ID)
+			}
+		case <-ctx.Done():
+			t.Fatalf("expected %v, got %v", ids, got)
+		}
+	}
+	assert.Equal(t, ids, got)
+	assert.Equal(t, int64(2), mon.GetCheckpoint().Value())
+	assert.True(t, handler.has("GET /polled/_stats/docs"))
+	assert.True(t, handler.has("POST /polled/_refresh"))
+	assert.True(t, handler.has("POST /polled/_search"))
+	assert.False(t, handler.has("POST /polled/_fleet/_fleet_search"))
+}
+
+func TestPollBackoff(t *testing.T) {
+	m := &simpleMonitorT{pollIntervalMin: time.Second, pollIntervalMax: 3 * time.Second}
+
+	assert.Equal(t, 2*time.Second, m.backoff(m.pollIntervalMin))
+	assert.Equal(t, 3*time.Second, m.backoff(2*time.Second))
+	assert.Equal(t, 3*time.Second, m.backoff(3*time.Second))
+}
@@ -0,0 +1,91 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/gcheckpt"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+// poll monitors the index of a cluster without the fleet plugin, starting after checkpoint.
+//
+// The global checkpoint is read from the index stats. Once it advanced, the index is refreshed so
+// that the documents up to the global checkpoint are searchable, then they are searched with the
+// standard search API. The interval between two polls doubles while the global checkpoint does not
+// advance, up to pollIntervalMax, and is reset to pollIntervalMin once it does.
+func (m *simpleMonitorT) poll(ctx context.Context, checkpoint sqn.SeqNo) error {
+	// The global checkpoint of the last poll, which may be past the monitor checkpoint when the
+	// last documents were deleted or expired
+	polled := checkpoint
+	interval := m.pollIntervalMin
+
+	for {
+		if err := sleep.WithContext(ctx, interval); err != nil {
+			return err
+		}
+
+		newCheckpoint, err := gcheckpt.QueryStats(ctx, m.monCli, m.index)
+		if err == nil && newCheckpoint.Value() > polled.Value() {
+			err = m.refresh(ctx)
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			m.log.Info().Err(err).Msg("failed polling the index")
+			interval = m.backoff(interval)
+			continue
+		}
+		if newCheckpoint.Value() <= polled.Value() {
+			interval = m.backoff(interval)
+			continue
+		}
+
+		checkpoint = m.loadCheckpoint()
+		m.metrics.lag.Set(newCheckpoint.Value() - checkpoint.Value())
+		m.deliver(ctx, checkpoint, newCheckpoint)
+		polled = newCheckpoint
+		interval = m.pollIntervalMin
+	}
+}
+
+// backoff returns the interval following interval while the index is idle.
+func (m *simpleMonitorT) backoff(interval time.Duration) time.Duration {
+	interval *= 2
+	if interval > m.pollIntervalMax {
+		return m.pollIntervalMax
+	}
+	return interval
+}
+
+// refresh refreshes the index, a missing index being left to the search.
+func (m *simpleMonitorT) refresh(ctx context.Context) error {
+	res, err := m.esCli.Indices.Refresh(
+		m.esCli.Indices.Refresh.WithContext(ctx),
+		m.esCli.Indices.Refresh.WithIndex(m.index),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		var eres es.Response
+		if err := json.NewDecoder(res.Body).Decode(&eres); err != nil {
+			return err
+		}
+		if err := es.TranslateError(res.StatusCode, eres.Error); !errors.Is(err, es.ErrIndexNotFound) {
+			return err
+		}
+	}
+	return nil
+}
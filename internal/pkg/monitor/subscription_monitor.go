@@ -6,6 +6,7 @@ package monitor
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,14 +15,38 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	defaultSubscriptionTimeout = 5 * time.Second // max amount of time subscription has to read from channel
+	defaultSubscriptionTimeout      = 5 * time.Second  // max amount of time subscription has to read from channel
+	defaultSubscriptionQueueSize    = 1                // notifications queued per subscription
+	defaultSubscriptionLagThreshold = 30 * time.Second // stall duration after which a warning is logged
 )
 
+// SubscriptionPolicy is the policy applied when the queue of a subscription is full.
+type SubscriptionPolicy string
+
+const (
+	// SubscriptionTimeout waits for the subscription timeout, then drops the new documents.
+	SubscriptionTimeout SubscriptionPolicy = "timeout"
+	// SubscriptionBlock waits for the subscriber, holding the other subscriptions and the index monitor.
+	SubscriptionBlock SubscriptionPolicy = "block"
+	// SubscriptionDropOldest drops the oldest queued documents.
+	SubscriptionDropOldest SubscriptionPolicy = "drop_oldest"
+	// SubscriptionCoalesce merges the oldest queued documents with the new ones.
+	SubscriptionCoalesce SubscriptionPolicy = "coalesce"
+)
+
+// subscriptionOptsT are the options of the subscriptions, set on the simple monitor options.
+type subscriptionOptsT struct {
+	policy       SubscriptionPolicy
+	queueSize    int
+	lagThreshold time.Duration
+}
+
 var gCounter uint64
 
 // Subscription is a subscription to get notified for new documents.
@@ -46,6 +71,13 @@ type Monitor interface {
 type subT struct {
 	idx uint64
 	c   chan []es.HitT
+
+	mut          sync.Mutex
+	lastDelivery time.Time // last time documents were queued
+	stalledSince time.Time // first time the queue was found full since the last delivery
+	warned       bool      // the lag warning was logged since stalledSince
+	dropped      uint64    // documents dropped
+	coalesced    uint64    // notifications merged with the next one
 }
 
 // Output returns the subscription channel.
@@ -59,6 +91,9 @@ type monitorT struct {
 	mut        sync.RWMutex
 	subs       map[uint64]*subT
 	subTimeout time.Duration
+	subOpts    subscriptionOptsT
+	log        zerolog.Logger
+	metrics    *metricsT
 }
 
 // New creates new subscription monitor.
@@ -68,10 +103,20 @@ func New(index string, esCli, monCli *elasticsearch.Client, opts ...Option) (Mon
 		return nil, err
 	}
 
+	simple := sm.(*simpleMonitorT)
+	switch simple.subOpts.policy {
+	case SubscriptionTimeout, SubscriptionBlock, SubscriptionDropOldest, SubscriptionCoalesce:
+	default:
+		return nil, fmt.Errorf("unknown subscription policy %q", simple.subOpts.policy)
+	}
+
 	m := &monitorT{
 		sm:         sm,
 		subTimeout: defaultSubscriptionTimeout,
+		subOpts:    simple.subOpts,
 		subs:       make(map[uint64]*subT),
+		log:        log.With().Str("index", index).Str("ctx", "subscription monitor").Logger(),
+		metrics:    simple.metrics,
 	}
 
 	return m, nil
@@ -88,12 +133,13 @@ func (m *monitorT) Subscribe() Subscription {
 
 	s := &subT{
 		idx: idx,
-		c:   make(chan []es.HitT, 1),
+		c:   make(chan []es.HitT, m.subOpts.queueSize),
 	}
 
 	m.mut.Lock()
 	m.subs[idx] = s
 	m.mut.Unlock()
+	m.metrics.addSubscription(s)
 	return s
 }
 
@@ -111,6 +157,7 @@ func (m *monitorT) Unsubscribe(sub Subscription) {
 		delete(m.subs, s.idx)
 	}
 	m.mut.Unlock()
+	m.metrics.removeSubscription(s)
 }
 
 // Run starts the Monitor.
@@ -143,20 +190,177 @@ func (m *monitorT) notify(ctx context.Context, hits []es.HitT) {
 		for _, s := range m.subs {
 			go func(s *subT) {
 				defer wg.Done()
-				lc, cn := context.WithTimeout(ctx, m.subTimeout)
-				defer cn()
-				select {
-				case s.c <- hits:
-				case <-lc.Done():
-					log.Error().
-						Err(lc.Err()).
-						Str("ctx", "subscription monitor").
-						Dur("timeout", m.subTimeout).
-						Msg("dropped notification")
-				}
+				m.deliver(ctx, s, hits)
 			}(s)
 		}
 		m.mut.RUnlock()
 		wg.Wait()
 	}
 }
+
+// deliver queues the hits to the subscription, applying the subscription policy when its queue is full.
+// It is not called concurrently for a subscription, so the queue has room once a notification was
+// removed from it.
+func (m *monitorT) deliver(ctx context.Context, s *subT, hits []es.HitT) {
+	select {
+	case s.c <- hits:
+		s.queued(true)
+		return
+	default:
+	}
+	s.stalled()
+	if d, ok := s.untilLagWarning(m.subOpts.lagThreshold); ok && d == 0 {
+		s.warn(m.log, m.subOpts.policy)
+	}
+
+	switch m.subOpts.policy {
+	case SubscriptionDropOldest:
+		select {
+		case old := <-s.c:
+			s.drop(len(old))
+			m.metrics.dropped.Add(uint64(len(old)))
+		default:
+		}
+	case SubscriptionCoalesce:
+		select {
+		case old := <-s.c:
+			// The hits are shared with the other subscriptions
+			merged := make([]es.HitT, 0, len(old)+len(hits))
+			merged = append(merged, old...)
+			hits = append(merged, hits...)
+			s.coalesce()
+			m.metrics.coalesced.Inc()
+		default:
+		}
+	case SubscriptionBlock:
+		if m.send(ctx, s, hits) {
+			s.queued(true)
+		}
+		return
+	default:
+		lc, cn := context.WithTimeout(ctx, m.subTimeout)
+		defer cn()
+		if !m.send(lc, s, hits) {
+			s.drop(len(hits))
+			m.metrics.dropped.Add(uint64(len(hits)))
+			m.log.Error().
+				Err(lc.Err()).
+				Uint64("subscription", s.idx).
+				Dur("timeout", m.subTimeout).
+				Msg("dropped notification")
+			return
+		}
+		s.queued(true)
+		return
+	}
+
+	// The subscription is still stalled, its oldest documents having been dropped or coalesced
+	if m.send(ctx, s, hits) {
+		s.queued(false)
+	}
+}
+
+// send waits until the hits are queued to the subscription or the context is done, logging a warning
+// once the subscription is stalled for longer than the lag threshold.
+func (m *monitorT) send(ctx context.Context, s *subT, hits []es.HitT) bool {
+	var warnC <-chan time.Time
+	if d, ok := s.untilLagWarning(m.subOpts.lagThreshold); ok {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		warnC = t.C
+	}
+
+	for {
+		select {
+		case s.c <- hits:
+			return true
+		case <-warnC:
+			warnC = nil
+			s.warn(m.log, m.subOpts.policy)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// queued records that documents were queued, the subscriber having caught up if its queue had room.
+func (s *subT) queued(caughtUp bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.lastDelivery = time.Now()
+	if caughtUp {
+		s.stalledSince = time.Time{}
+		s.warned = false
+	}
+}
+
+func (s *subT) stalled() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.stalledSince.IsZero() {
+		s.stalledSince = time.Now()
+	}
+}
+
+func (s *subT) drop(n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.dropped += uint64(n)
+}
+
+func (s *subT) coalesce() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.coalesced++
+}
+
+// untilLagWarning returns the time left before the stalled subscription lags past the threshold, or
+// false if the warning is disabled or was already logged.
+func (s *subT) untilLagWarning(threshold time.Duration) (time.Duration, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if threshold <= 0 || s.warned {
+		return 0, false
+	}
+	d := threshold - time.Since(s.stalledSince)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+func (s *subT) warn(log zerolog.Logger, policy SubscriptionPolicy) {
+	stats := s.stats()
+
+	s.mut.Lock()
+	s.warned = true
+	stalledSince := s.stalledSince
+	s.mut.Unlock()
+
+	log.Warn().
+		Uint64("subscription", s.idx).
+		Str("policy", string(policy)).
+		Dur("stalled", time.Since(stalledSince)).
+		Int("queue", stats.queue).
+		Time("last_delivery", stats.lastDelivery).
+		Uint64("dropped", stats.dropped).
+		Msg("subscription is lagging")
+}
+
+type subStatsT struct {
+	queue        int
+	lastDelivery time.Time
+	dropped      uint64
+	coalesced    uint64
+}
+
+func (s *subT) stats() subStatsT {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return subStatsT{
+		queue:        len(s.c),
+		lastDelivery: s.lastDelivery,
+		dropped:      s.dropped,
+		coalesced:    s.coalesced,
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+func newTestMonitor(index string, policy SubscriptionPolicy, lagThreshold time.Duration) (*monitorT, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return &monitorT{
+		subs:       make(map[uint64]*subT),
+		subTimeout: 10 * time.Millisecond,
+		subOpts: subscriptionOptsT{
+			policy:       policy,
+			queueSize:    1,
+			lagThreshold: lagThreshold,
+		},
+		log:     zerolog.New(&buf),
+		metrics: indexMetrics(index),
+	}, &buf
+}
+
+func testHits(ids ...string) []es.HitT {
+	hits := make([]es.HitT, 0, len(ids))
+	for _, id := range ids {
+		hits = append(hits, es.HitT{ID: id})
+	}
+	return hits
+}
+
+func hitIDs(hits []es.HitT) []string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestSubscriptionPolicies(t *testing.T) {
+	tests := []struct {
+		policy    SubscriptionPolicy
+		queued    []string
+		dropped   uint64
+		coalesced uint64
+	}{
+		{policy: SubscriptionTimeout, queued: []string{"a", "b"}, dropped: 1},
+		{policy: SubscriptionDropOldest, queued: []string{"c"}, dropped: 2},
+		{policy: SubscriptionCoalesce, queued: []string{"a", "b", "c"}, coalesced: 1},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			m, _ := newTestMonitor("subs-"+string(tc.policy), tc.policy, 0)
+			dropped, coalesced := m.metrics.dropped.Get(), m.metrics.coalesced.Get()
+			s := m.Subscribe().(*subT)
+			defer m.Unsubscribe(s)
+
+			first := testHits("a", "b")
+			m.notify(context.Background(), first)
+			m.notify(context.Background(), testHits("c"))
+
+			assert.Equal(t, tc.queued, hitIDs(<-s.Output()))
+			assert.Equal(t, []string{"a", "b"}, hitIDs(first), "the notified hits are not modified")
+			stats := s.stats()
+			assert.Equal(t, tc.dropped, stats.dropped)
+			assert.Equal(t, tc.coalesced, stats.coalesced)
+			assert.Equal(t, dropped+tc.dropped, m.metrics.dropped.Get())
+			assert.Equal(t, coalesced+tc.coalesced, m.metrics.coalesced.Get())
+		})
+	}
+}
+
+func TestSubscriptionBlock(t *testing.T) {
+	m, _ := newTestMonitor("subs-block", SubscriptionBlock, 0)
+	s := m.Subscribe()
+	defer m.Unsubscribe(s)
+
+	m.notify(context.Background(), testHits("a"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.notify(context.Background(), testHits("b"))
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("notify returned while the subscription queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, []string{"a"}, hitIDs(<-s.Output()))
+	<-done
+	assert.Equal(t, []string{"b"}, hitIDs(<-s.Output()))
+}
+
+func TestSubscriptionLagWarning(t *testing.T) {
+	for _, policy := range []SubscriptionPolicy{SubscriptionBlock, SubscriptionDropOldest} {
+		t.Run(string(policy), func(t *testing.T) {
+			m, buf := newTestMonitor("subs-lag-"+string(policy), policy, 20*time.Millisecond)
+			s := m.Subscribe()
+			defer m.Unsubscribe(s)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			m.notify(ctx, testHits("a"))
+			m.notify(ctx, testHits("b"))
+			time.Sleep(30 * time.Millisecond)
+			m.notify(ctx, testHits("c"))
+			m.notify(ctx, testHits("d"))
+
+			assert.Equal(t, 1, strings.Count(buf.String(), "subscription is lagging"), "the warning is logged once")
+		})
+	}
+}
+
+func TestSubscriptionMetrics(t *testing.T) {
+	m, _ := newTestMonitor("subs-metrics", SubscriptionDropOldest, 0)
+	s := m.Subscribe().(*subT)
+
+	m.notify(context.Background(), testHits("a"))
+	m.notify(context.Background(), testHits("b"))
+
+	prefix := fmt.Sprintf("subs-metrics.subscriptions.%d.", s.idx)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints[prefix+"queue"])
+	assert.Equal(t, int64(1), snapshot.Ints[prefix+"dropped"])
+	lastDelivery, err := time.Parse(time.RFC3339Nano, snapshot.Strings[prefix+"last_delivery"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastDelivery, time.Minute)
+
+	m.Unsubscribe(s)
+	snapshot = monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.NotContains(t, snapshot.Ints, prefix+"queue")
+}
+
+func TestNewSubscriptionPolicy(t *testing.T) {
+	_, err := New("subs-unknown", nil, nil, WithSubscriptionPolicy("unknown"))
+	assert.Error(t, err)
+}
@@ -35,7 +35,8 @@ Policy rollout scheduling should...
 
 This implementation addresses the above issues by queuing subscription requests per
 policy, and moving requests to the pending queue when the requirement is met; ie.
-the policy is updateable.
+the policy is updateable. The subscriptions of the policies with a fleet-server input
+are moved to the priority queue instead, which is dispatched first.
 
 If the subscription is unsubscribed (ie. the agent drops offline), this implementation
 will remove the subscription request from its current location in either the waiting
@@ -60,6 +61,9 @@ type Monitor interface {
 
 	// Unsubscribe removes the current subscription.
 	Unsubscribe(sub Subscription) error
+
+	// IsFleetServerPolicy returns true when the latest revision of the policy has a fleet-server input.
+	IsFleetServerPolicy(policyID string) bool
 }
 
 type policyFetcher func(ctx context.Context, bulker bulk.Bulk, opt ...dl.Option) ([]model.Policy, error)
@@ -79,8 +83,13 @@ type monitorT struct {
 	kickCh   chan struct{}
 	deployCh chan struct{}
 
-	policies map[string]policyT
-	pendingQ *subT
+	policies  map[string]policyT
+	pendingQ  *subT
+	priorityQ *subT // the pending subscriptions of the agents supervising a fleet-server
+
+	// The policies with a fleet-server input, read on the requests of the agents
+	fleetServerMut      sync.RWMutex
+	fleetServerPolicies map[string]struct{}
 
 	policyF       policyFetcher
 	policiesIndex string
@@ -99,10 +108,13 @@ func NewMonitor(bulker bulk.Bulk, monitor monitor.Monitor, throttle time.Duratio
 		deployCh:      make(chan struct{}, 1),
 		policies:      make(map[string]policyT),
 		pendingQ:      makeHead(),
+		priorityQ:     makeHead(),
 		throttle:      throttle,
 		policyF:       dl.QueryLatestPolicies,
 		policiesIndex: dl.FleetPolicies,
 		startCh:       make(chan struct{}),
+
+		fleetServerPolicies: make(map[string]struct{}),
 	}
 }
 
@@ -207,12 +219,15 @@ func (m *monitorT) dispatchPending() bool {
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
-	s := m.pendingQ.popFront()
+	s := m.priorityQ.popFront()
+	if s == nil {
+		s = m.pendingQ.popFront()
+	}
 	if s == nil {
 		return true
 	}
 
-	done := m.pendingQ.isEmpty()
+	done := m.isPendingEmpty()
 
 	// Lookup the latest policy for this subscription
 	policy, ok := m.policies[s.policyID]
@@ -314,6 +329,8 @@ func (m *monitorT) updatePolicy(pp *ParsedPolicy) bool {
 		return false
 	}
 
+	m.setFleetServerPolicy(newPolicy.PolicyID, pp.FleetServer)
+
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
@@ -347,13 +364,7 @@ func (m *monitorT) updatePolicy(pp *ParsedPolicy) bool {
 			iter.Unlink()
 
 			// Push the node onto the pendingQ
-			// HACK: if update is for cloud agent, put on front of queue
-			// not at the end for immediate delivery.
-			if newPolicy.PolicyID == cloudPolicyID {
-				m.pendingQ.pushFront(sub)
-			} else {
-				m.pendingQ.pushBack(sub)
-			}
+			m.schedule(sub, pp)
 
 			zlog.Debug().
 				Str(logger.AgentID, sub.agentID).
@@ -428,8 +439,8 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64,
 		m.policies[policyID] = p
 		m.kickLoad()
 	case s.isUpdate(&p.pp.Policy):
-		empty := m.pendingQ.isEmpty()
-		m.pendingQ.pushBack(s)
+		empty := m.isPendingEmpty()
+		m.schedule(s, &p.pp)
 		m.log.Debug().
 			Str(logger.AgentID, s.agentID).
 			Msg("scheduled pending on subscribe")
@@ -443,6 +454,49 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64,
 	return s, nil
 }
 
+// schedule pushes the subscription onto the pending queue of its policy: the priority queue for the
+// agents supervising a fleet-server, otherwise the pending queue.
+// WARNING: Expects mutex locked.
+func (m *monitorT) schedule(s *subT, pp *ParsedPolicy) {
+	switch {
+	case pp.FleetServer:
+		m.priorityQ.pushBack(s)
+	case pp.Policy.PolicyID == cloudPolicyID:
+		// HACK: if update is for cloud agent, put on front of queue
+		// not at the end for immediate delivery.
+		m.pendingQ.pushFront(s)
+	default:
+		m.pendingQ.pushBack(s)
+	}
+}
+
+// isPendingEmpty returns true when no subscription is pending delivery.
+// WARNING: Expects mutex locked.
+func (m *monitorT) isPendingEmpty() bool {
+	return m.priorityQ.isEmpty() && m.pendingQ.isEmpty()
+}
+
+// setFleetServerPolicy records whether the latest revision of the policy has a fleet-server input.
+func (m *monitorT) setFleetServerPolicy(policyID string, fleetServer bool) {
+	m.fleetServerMut.Lock()
+	defer m.fleetServerMut.Unlock()
+	if fleetServer {
+		m.fleetServerPolicies[policyID] = struct{}{}
+	} else {
+		delete(m.fleetServerPolicies, policyID)
+	}
+}
+
+// IsFleetServerPolicy returns true when the latest revision of the policy has a fleet-server input.
+// The policies are loaded when the monitor starts, so the agents supervising a fleet-server are
+// recognized from their first request after a restart.
+func (m *monitorT) IsFleetServerPolicy(policyID string) bool {
+	m.fleetServerMut.RLock()
+	defer m.fleetServerMut.RUnlock()
+	_, ok := m.fleetServerPolicies[policyID]
+	return ok
+}
+
 // Unsubscribe removes the current subscription.
 func (m *monitorT) Unsubscribe(sub Subscription) error {
 	s, ok := sub.(*subT)
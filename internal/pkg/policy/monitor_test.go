@@ -371,3 +371,55 @@ func runTestMonitor_NewPolicyExists(t *testing.T, delay time.Duration) {
 		t.Fatal("never got policy update; timed out after 500ms")
 	}
 }
+
+func TestMonitor_FleetServerPriority(t *testing.T) {
+	_ = testlog.SetLogger(t)
+	monitor := NewMonitor(ftesting.NewMockBulk(), mmock.NewMockMonitor(), 0)
+	pm := monitor.(*monitorT)
+
+	for policyID, data := range map[string]string{
+		"agents":       `{"outputs":{"default":{"type":"elasticsearch"}}}`,
+		"fleet-server": `{"outputs":{"default":{"type":"elasticsearch"}},"inputs":[{"type":"fleet-server"}]}`,
+	} {
+		pp, err := NewParsedPolicy(model.Policy{PolicyID: policyID, RevisionIdx: 1, CoordinatorIdx: 1, Data: json.RawMessage(data)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm.updatePolicy(pp)
+	}
+
+	// recognized before any agent subscribed, e.g. after a restart
+	if !monitor.IsFleetServerPolicy("fleet-server") || monitor.IsFleetServerPolicy("agents") {
+		t.Fatal("expected only the policy with a fleet-server input to be a fleet-server policy")
+	}
+
+	agentSub, err := monitor.Subscribe("agent", "agents", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fleetServerSub, err := monitor.Subscribe("fleet-server-agent", "fleet-server", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if done := pm.dispatchPending(); done {
+		t.Fatal("expected a subscription still pending")
+	}
+	select {
+	case pp := <-fleetServerSub.Output():
+		if !pp.FleetServer {
+			t.Fatal("expected the fleet-server policy")
+		}
+	default:
+		t.Fatal("expected the fleet-server agent to be dispatched first")
+	}
+
+	if done := pm.dispatchPending(); !done {
+		t.Fatal("expected no subscription pending")
+	}
+	select {
+	case <-agentSub.Output():
+	default:
+		t.Fatal("expected the agent to be dispatched")
+	}
+}
@@ -13,6 +13,7 @@ import (
 )
 
 const (
+	FieldInputs             = "inputs"
 	FieldOutputs            = "outputs"
 	FieldOutputType         = "type"
 	FieldOutputFleetServer  = "fleet_server"
@@ -44,6 +45,9 @@ type ParsedPolicy struct {
 	Roles   RoleMapT
 	Outputs map[string]Output
 	Default ParsedPolicyDefaults
+
+	// FleetServer is set when the policy has a fleet-server input, its agents supervising a fleet-server.
+	FleetServer bool
 }
 
 func NewParsedPolicy(p model.Policy) (*ParsedPolicy, error) {
@@ -77,6 +81,13 @@ func NewParsedPolicy(p model.Policy) (*ParsedPolicy, error) {
 		return nil, err
 	}
 
+	var data policyData
+	if inputs := fields[FieldInputs]; len(inputs) != 0 {
+		if err = json.Unmarshal(inputs, &data.Inputs); err != nil {
+			return nil, err
+		}
+	}
+
 	// We are cool and the gang
 	pp := &ParsedPolicy{
 		Policy:  p,
@@ -86,6 +97,7 @@ func NewParsedPolicy(p model.Policy) (*ParsedPolicy, error) {
 		Default: ParsedPolicyDefaults{
 			Name: defaultName,
 		},
+		FleetServer: data.HasType("fleet-server"),
 	}
 
 	return pp, nil
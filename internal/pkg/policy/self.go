@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +29,17 @@ import (
 // DefaultCheckTime is the default interval for self to check for its policy.
 const DefaultCheckTime = 5 * time.Second
 
+// availabilityReporter is implemented by the bulk engines reporting when elasticsearch is unavailable,
+// see bulk.Bulker.Unavailable.
+type availabilityReporter interface {
+	Unavailable() error
+}
+
+// LoadReporter is implemented by the load shedders reporting the requests they shed, see limit.Shedder.
+type LoadReporter interface {
+	Shedding() string
+}
+
 type enrollmentTokenFetcher func(ctx context.Context, bulker bulk.Bulk, policyID string) ([]model.EnrollmentAPIKey, error)
 
 type SelfMonitor interface {
@@ -48,6 +60,8 @@ type selfMonitorT struct {
 	policyID string
 	state    client.UnitState
 	reporter state.Reporter
+	load     LoadReporter
+	degraded string // the reasons of the degraded state reported once running
 
 	policy *model.Policy
 
@@ -63,8 +77,8 @@ type selfMonitorT struct {
 //
 // Ensures that the policy that this Fleet Server attached to exists and that it
 // has a Fleet Server input defined.
-func NewSelfMonitor(fleet config.Fleet, bulker bulk.Bulk, monitor monitor.Monitor, policyID string, reporter state.Reporter) SelfMonitor {
-	return &selfMonitorT{
+func NewSelfMonitor(fleet config.Fleet, bulker bulk.Bulk, monitor monitor.Monitor, policyID string, reporter state.Reporter, opts ...SelfMonitorOpt) SelfMonitor {
+	m := &selfMonitorT{
 		log:              log.With().Str("ctx", "policy self monitor").Logger(),
 		fleet:            fleet,
 		bulker:           bulker,
@@ -78,10 +92,47 @@ func NewSelfMonitor(fleet config.Fleet, bulker bulk.Bulk, monitor monitor.Monito
 		checkTime:        DefaultCheckTime,
 		startCh:          make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// SelfMonitorOpt is an option of the self policy monitor.
+type SelfMonitorOpt func(*selfMonitorT)
+
+// WithLoadReporter reports degraded while r sheds requests, once running on the policy.
+func WithLoadReporter(r LoadReporter) SelfMonitorOpt {
+	return func(m *selfMonitorT) {
+		m.load = r
+	}
 }
 
 // Run runs the monitor.
+//
+// Once running on its policy, the monitor keeps watching the availability of elasticsearch and the
+// load shedding, reporting degraded while elasticsearch is unavailable or requests are shed.
 func (m *selfMonitorT) Run(ctx context.Context) error {
+	if err := m.waitRunning(ctx); err != nil || ctx.Err() != nil {
+		return err
+	}
+
+	cT := time.NewTimer(m.checkTime)
+	defer cT.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cT.C:
+			m.checkDegraded()
+			cT.Reset(m.checkTime)
+		}
+	}
+}
+
+// waitRunning waits for the policy of this Fleet Server to be ready.
+func (m *selfMonitorT) waitRunning(ctx context.Context) error {
 	s := m.monitor.Subscribe()
 	defer m.monitor.Unsubscribe(s)
 
@@ -95,11 +146,10 @@ func (m *selfMonitorT) Run(ctx context.Context) error {
 
 	close(m.startCh)
 
-LOOP:
 	for {
 		select {
 		case <-ctx.Done():
-			break LOOP
+			return nil
 		case <-cT.C:
 			state, err := m.process(ctx)
 			if err != nil {
@@ -107,8 +157,8 @@ LOOP:
 			}
 			cT.Reset(m.checkTime)
 			if state == client.UnitStateHealthy {
-				// running; can stop
-				break LOOP
+				// running
+				return nil
 			}
 		case hits := <-s.Output():
 			policies := make([]model.Policy, len(hits))
@@ -123,13 +173,49 @@ LOOP:
 				return err
 			}
 			if state == client.UnitStateHealthy {
-				// running; can stop
-				break LOOP
+				// running
+				return nil
 			}
 		}
 	}
+}
 
-	return nil
+// checkDegraded reports degraded while elasticsearch is unavailable or requests are shed, with all
+// the reasons, and healthy again once none remains.
+func (m *selfMonitorT) checkDegraded() {
+	var reasons []string
+	if a, ok := m.bulker.(availabilityReporter); ok {
+		if err := a.Unavailable(); err != nil {
+			reasons = append(reasons, fmt.Sprintf("Elasticsearch unavailable, serving agents from cache: %s", err))
+		}
+	}
+	if m.load != nil {
+		if msg := m.load.Shedding(); msg != "" {
+			reasons = append(reasons, msg)
+		}
+	}
+	msg := strings.Join(reasons, "; ")
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	switch {
+	case msg != "" && (m.state == client.UnitStateHealthy || m.state == client.UnitStateDegraded && msg != m.degraded):
+		m.state = client.UnitStateDegraded
+		m.degraded = msg
+		m.reporter.UpdateState(client.UnitStateDegraded, msg, nil) //nolint:errcheck // not clear what to do in failure cases
+	case msg == "" && m.state == client.UnitStateDegraded:
+		m.state = client.UnitStateHealthy
+		m.degraded = ""
+		m.reporter.UpdateState(client.UnitStateHealthy, m.runningMessage(""), nil) //nolint:errcheck // not clear what to do in failure cases
+	}
+}
+
+func (m *selfMonitorT) runningMessage(extendMsg string) string {
+	if m.policyID == "" {
+		return fmt.Sprintf("Running on default policy with Fleet Server integration%s", extendMsg)
+	}
+	return fmt.Sprintf("Running on policy with Fleet Server integration: %s%s", m.policyID, extendMsg)
 }
 
 func (m *selfMonitorT) State() client.UnitState {
@@ -242,11 +328,7 @@ func (m *selfMonitorT) updateState(ctx context.Context) (client.UnitState, error
 		}
 	}
 	m.state = state
-	if m.policyID == "" {
-		m.reporter.UpdateState(state, fmt.Sprintf("Running on default policy with Fleet Server integration%s", extendMsg), payload) //nolint:errcheck // not clear what to do in failure cases
-	} else {
-		m.reporter.UpdateState(state, fmt.Sprintf("Running on policy with Fleet Server integration: %s%s", m.policyID, extendMsg), payload) //nolint:errcheck // not clear what to do in failure cases
-	}
+	m.reporter.UpdateState(state, m.runningMessage(extendMsg), payload) //nolint:errcheck // not clear what to do in failure cases
 	return state, nil
 }
 
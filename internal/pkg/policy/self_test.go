@@ -11,6 +11,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -631,6 +632,86 @@ func TestSelfMonitor_SpecificPolicy_Degraded(t *testing.T) {
 	}
 }
 
+type unavailableBulk struct {
+	*ftesting.MockBulk
+	err error
+}
+
+func (b *unavailableBulk) Unavailable() error {
+	return b.err
+}
+
+func TestSelfMonitor_CheckAvailability(t *testing.T) {
+	reporter := &FakeReporter{}
+	bulker := &unavailableBulk{MockBulk: ftesting.NewMockBulk()}
+	monitor := NewSelfMonitor(config.Fleet{}, bulker, mmock.NewMockMonitor(), "policy-id", reporter)
+	sm := monitor.(*selfMonitorT)
+	sm.state = client.UnitStateHealthy
+
+	sm.checkDegraded()
+	if state, _, _ := reporter.Current(); state != client.UnitStateStarting {
+		t.Fatalf("should not report while elasticsearch is available; instead its %s", state)
+	}
+
+	bulker.err = bulk.ErrCircuitOpen
+	sm.checkDegraded()
+	if state, _, _ := reporter.Current(); state != client.UnitStateDegraded || sm.State() != client.UnitStateDegraded {
+		t.Fatalf("should be reported as degraded; instead its %s", state)
+	}
+
+	bulker.err = nil
+	sm.checkDegraded()
+	state, msg, _ := reporter.Current()
+	if state != client.UnitStateHealthy || sm.State() != client.UnitStateHealthy {
+		t.Fatalf("should be reported as healthy; instead its %s", state)
+	}
+	if msg != "Running on policy with Fleet Server integration: policy-id" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+type fakeLoadReporter struct {
+	shedding string
+}
+
+func (r *fakeLoadReporter) Shedding() string {
+	return r.shedding
+}
+
+func TestSelfMonitor_CheckShedding(t *testing.T) {
+	reporter := &FakeReporter{}
+	bulker := &unavailableBulk{MockBulk: ftesting.NewMockBulk()}
+	load := &fakeLoadReporter{}
+	monitor := NewSelfMonitor(config.Fleet{}, bulker, mmock.NewMockMonitor(), "policy-id", reporter, WithLoadReporter(load))
+	sm := monitor.(*selfMonitorT)
+	sm.state = client.UnitStateHealthy
+
+	load.shedding = "rejecting new enrollments"
+	sm.checkDegraded()
+	if state, msg, _ := reporter.Current(); state != client.UnitStateDegraded || msg != load.shedding {
+		t.Fatalf("should be reported as degraded by the shedding; instead its %s: %s", state, msg)
+	}
+
+	bulker.err = bulk.ErrCircuitOpen
+	sm.checkDegraded()
+	state, msg, _ := reporter.Current()
+	if state != client.UnitStateDegraded || !strings.Contains(msg, "Elasticsearch unavailable") || !strings.Contains(msg, load.shedding) {
+		t.Fatalf("should be reported as degraded with both reasons; instead its %s: %s", state, msg)
+	}
+
+	bulker.err = nil
+	sm.checkDegraded()
+	if state, msg, _ := reporter.Current(); state != client.UnitStateDegraded || msg != load.shedding {
+		t.Fatalf("should remain degraded while shedding; instead its %s: %s", state, msg)
+	}
+
+	load.shedding = ""
+	sm.checkDegraded()
+	if state, _, _ := reporter.Current(); state != client.UnitStateHealthy || sm.State() != client.UnitStateHealthy {
+		t.Fatalf("should be reported as healthy; instead its %s", state)
+	}
+}
+
 type FakeReporter struct {
 	lock    sync.Mutex
 	state   client.UnitState
@@ -0,0 +1,223 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// v2Signature starts the PROXY protocol v2 headers.
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	// v1Prefix starts the PROXY protocol v1 headers.
+	v1Prefix = []byte("PROXY ")
+)
+
+const (
+	// v1MaxLen is the max length of a PROXY protocol v1 header, CRLF included.
+	v1MaxLen = 107
+
+	v2CmdLocal = 0x0
+	v2CmdProxy = 0x1
+
+	v2FamTCP4 = 0x11
+	v2FamTCP6 = 0x21
+)
+
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener returns a listener reading the PROXY protocol v1 or v2 header of the connections of the
+// trusted proxies, the remote address of the connections being the one of the client of the proxy.
+// The connections of the other peers, and the connections of the proxies without a header, e.g.
+// their health checks, are left unchanged.
+//
+// The header is read on the first read of the connection or call of its RemoteAddr, within timeout,
+// so that a slow proxy does not block the accept loop.
+func Listener(ln net.Listener, t *Trusted, timeout time.Duration) net.Listener {
+	return &listener{
+		Listener: ln,
+		trusted:  t,
+		timeout:  timeout,
+	}
+}
+
+type listener struct {
+	net.Listener
+	trusted *Trusted
+	timeout time.Duration
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted.containsAddr(conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &Conn{
+		Conn:    conn,
+		br:      bufio.NewReader(conn),
+		remote:  conn.RemoteAddr(),
+		timeout: l.timeout,
+	}, nil
+}
+
+// Conn is a connection of a trusted proxy.
+type Conn struct {
+	net.Conn
+	once    sync.Once
+	br      *bufio.Reader
+	remote  net.Addr
+	err     error
+	timeout time.Duration
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+// RemoteAddr returns the address of the client of the proxy, or the one of the proxy when the
+// connection has no header, or an invalid one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.remote
+}
+
+// PeerAddr returns the address of the proxy, without reading the PROXY protocol header.
+func (c *Conn) PeerAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.err = err
+		return
+	}
+	addr, err := readHeader(c.br)
+	if err == nil {
+		err = c.Conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		// e.g. the proxy closed the connection of a health check
+		if !errors.Is(err, io.EOF) {
+			log.Debug().Err(err).Str("remote", c.remote.String()).Msg("fail reading PROXY protocol header")
+		}
+		c.err = err
+		return
+	}
+	if addr != nil {
+		c.remote = addr
+	}
+}
+
+// readHeader reads the PROXY protocol header, and returns the address of the client of the proxy,
+// or nil when the connection has no header or the header has no address, e.g. for a LOCAL command.
+func readHeader(br *bufio.Reader) (net.Addr, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		// The connection closed before any byte, there is nothing to serve
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		if prefix, err := br.Peek(len(v1Prefix)); err != nil || !bytes.Equal(prefix, v1Prefix) {
+			return nil, nil
+		}
+		return readV1(br)
+	case v2Signature[0]:
+		if signature, err := br.Peek(len(v2Signature)); err != nil || !bytes.Equal(signature, v2Signature) {
+			return nil, nil
+		}
+		return readV2(br)
+	}
+	return nil, nil
+}
+
+// readV1 reads a PROXY protocol v1 header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLen {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrInvalidHeader)
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: v1 header %q", ErrInvalidHeader, strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: v1 header %q", ErrInvalidHeader, strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 reads a PROXY protocol v2 header.
+func readV2(br *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	size := int(binary.BigEndian.Uint16(hdr[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 header version %d", ErrInvalidHeader, verCmd>>4)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xF {
+	case v2CmdLocal:
+		// e.g. the health checks of the proxy
+		return nil, nil
+	case v2CmdProxy:
+	default:
+		return nil, fmt.Errorf("%w: v2 header command %d", ErrInvalidHeader, verCmd&0xF)
+	}
+
+	switch fam {
+	case v2FamTCP4:
+		if size < 12 {
+			return nil, fmt.Errorf("%w: v2 header of %d bytes", ErrInvalidHeader, size)
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case v2FamTCP6:
+		if size < 36 {
+			return nil, fmt.Errorf("%w: v2 header of %d bytes", ErrInvalidHeader, size)
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	// The other families, e.g. unix domain sockets, have no client address to resolve
+	return nil, nil
+}
@@ -0,0 +1,202 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(cmd, fam byte, body []byte) []byte {
+	var b bytes.Buffer
+	b.Write(v2Signature)
+	b.WriteByte(0x20 | cmd)
+	b.WriteByte(fam)
+	binary.Write(&b, binary.BigEndian, uint16(len(body))) //nolint:errcheck // writes to a buffer
+	b.Write(body)
+	return b.Bytes()
+}
+
+func v2TCP4(src net.IP, port uint16) []byte {
+	body := make([]byte, 12)
+	copy(body[0:4], src.To4())
+	copy(body[4:8], net.IPv4(198, 51, 100, 1).To4())
+	binary.BigEndian.PutUint16(body[8:10], port)
+	binary.BigEndian.PutUint16(body[10:12], 443)
+	return v2Header(v2CmdProxy, v2FamTCP4, body)
+}
+
+func TestReadHeader(t *testing.T) {
+	tcp6 := make([]byte, 36)
+	copy(tcp6[0:16], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(tcp6[32:34], 56324)
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+		err    error
+	}{{
+		name:   "v1 tcp4",
+		header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+		want:   "192.0.2.1:56324",
+	}, {
+		name:   "v1 tcp6",
+		header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+		want:   "[2001:db8::1]:56324",
+	}, {
+		name:   "v1 unknown",
+		header: []byte("PROXY UNKNOWN\r\n"),
+	}, {
+		name:   "v1 invalid address",
+		header: []byte("PROXY TCP4 example 198.51.100.1 56324 443\r\n"),
+		err:    ErrInvalidHeader,
+	}, {
+		name:   "v1 too long",
+		header: []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLen) + "\r\n"),
+		err:    ErrInvalidHeader,
+	}, {
+		name:   "v2 tcp4",
+		header: v2TCP4(net.IPv4(192, 0, 2, 1), 56324),
+		want:   "192.0.2.1:56324",
+	}, {
+		name:   "v2 tcp6",
+		header: v2Header(v2CmdProxy, v2FamTCP6, tcp6),
+		want:   "[2001:db8::1]:56324",
+	}, {
+		name:   "v2 local",
+		header: v2Header(v2CmdLocal, 0, nil),
+	}, {
+		name:   "v2 truncated address",
+		header: v2Header(v2CmdProxy, v2FamTCP4, make([]byte, 4)),
+		err:    ErrInvalidHeader,
+	}, {
+		name:   "no header",
+		header: []byte("GET / HTTP/1.1\r\n"),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			br := bufio.NewReader(io.MultiReader(bytes.NewReader(tc.header), strings.NewReader("payload")))
+			addr, err := readHeader(br)
+			if tc.err != nil {
+				assert.True(t, errors.Is(err, tc.err), "got error %v", err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.want == "" {
+				assert.Nil(t, addr)
+				return
+			}
+			require.NotNil(t, addr)
+			assert.Equal(t, tc.want, addr.String())
+
+			rest, err := io.ReadAll(br)
+			require.NoError(t, err)
+			assert.Equal(t, "payload", string(rest), "the header is consumed")
+		})
+	}
+}
+
+// serve accepts a connection on ln, returning its remote address and its first line.
+func serve(t *testing.T, ln net.Listener) <-chan [2]string {
+	t.Helper()
+	ch := make(chan [2]string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			ch <- [2]string{"", err.Error()}
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		ch <- [2]string{conn.RemoteAddr().String(), line}
+	}()
+	return ch
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		payload []byte
+		remote  string
+	}{{
+		name:    "trusted proxy",
+		cidrs:   []string{"127.0.0.0/8"},
+		payload: append(v2TCP4(net.IPv4(192, 0, 2, 1), 56324), "hello\n"...),
+		remote:  "192.0.2.1:56324",
+	}, {
+		name:    "trusted proxy without header",
+		cidrs:   []string{"127.0.0.0/8"},
+		payload: []byte("hello\n"),
+	}, {
+		name:    "untrusted peer",
+		cidrs:   []string{"10.0.0.0/8"},
+		payload: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello\n"),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			ln := Listener(inner, testTrusted(t, tc.cidrs...), time.Second)
+			defer ln.Close()
+			ch := serve(t, ln)
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write(tc.payload)
+			require.NoError(t, err)
+
+			got := <-ch
+			want := tc.remote
+			if want == "" {
+				want = conn.LocalAddr().String()
+			}
+			assert.Equal(t, want, got[0])
+			if tc.name == "untrusted peer" {
+				assert.True(t, strings.HasPrefix(got[1], "PROXY "), "the header of an untrusted peer is not read")
+			} else {
+				assert.Equal(t, "hello\n", got[1])
+			}
+		})
+	}
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := Listener(inner, testTrusted(t, "127.0.0.1"), 50*time.Millisecond)
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// An incomplete header
+	_, err = conn.Write([]byte("PROXY TCP4"))
+	require.NoError(t, err)
+
+	accepted, err := ln.Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+	_, err = accepted.Read(make([]byte, 1))
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr), "got error %v", err)
+	assert.True(t, netErr.Timeout())
+	assert.Equal(t, conn.LocalAddr().String(), accepted.RemoteAddr().String())
+}
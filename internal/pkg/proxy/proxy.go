@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package proxy resolves the address of the clients connecting through the trusted load balancers
+// and proxies, from the PROXY protocol headers of their connections and the X-Forwarded-For header
+// of their requests.
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// HeaderForwardedFor is the header listing the addresses of the client and of the proxies a request
+// went through, the address of the client first.
+const HeaderForwardedFor = "X-Forwarded-For"
+
+// Trusted is the set of the trusted proxies.
+type Trusted struct {
+	nets []*net.IPNet
+}
+
+// NewTrusted returns the trusted proxies of cfg, or nil when none is trusted.
+func NewTrusted(cfg *config.TrustedProxies) (*Trusted, error) {
+	nets, err := cfg.Networks()
+	if err != nil {
+		return nil, err
+	}
+	if len(nets) == 0 {
+		return nil, nil
+	}
+	return &Trusted{nets: nets}, nil
+}
+
+// Contains returns true when ip is the one of a trusted proxy.
+func (t *Trusted) Contains(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr returns true when the host of addr, with or without a port, is a trusted proxy.
+func (t *Trusted) containsAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return t.Contains(net.ParseIP(host))
+}
+
+// Handler resolves the address of the client of the requests from the trusted proxies from their
+// X-Forwarded-For header, replacing the remote address of the requests so that it is the one used
+// by the handlers, the logs and the limits alike. The address resolved is the last one of the header
+// that is not a trusted proxy, the proxies appending the address of their peer to the header; the
+// addresses before it may be forged by the client.
+func Handler(t *Trusted, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := t.clientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client of the request from a trusted proxy, or an empty
+// string when the request is not from a trusted proxy or does not tell the address of its client.
+func (t *Trusted) clientIP(r *http.Request) string {
+	if !t.containsAddr(r.RemoteAddr) {
+		return ""
+	}
+	var addrs []string
+	for _, values := range r.Header.Values(HeaderForwardedFor) {
+		for _, v := range strings.Split(values, ",") {
+			addrs = append(addrs, strings.TrimSpace(v))
+		}
+	}
+
+	client := ""
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(addrs[i])
+		if ip == nil {
+			// The header is malformed past this address, the last valid one is the best known
+			break
+		}
+		client = ip.String()
+		if !t.Contains(ip) {
+			break
+		}
+	}
+	return client
+}
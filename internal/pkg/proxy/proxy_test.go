@@ -0,0 +1,98 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func testTrusted(t *testing.T, cidrs ...string) *Trusted {
+	t.Helper()
+	trusted, err := NewTrusted(&config.TrustedProxies{CIDRs: cidrs})
+	require.NoError(t, err)
+	return trusted
+}
+
+func TestNewTrustedEmpty(t *testing.T) {
+	trusted, err := NewTrusted(&config.TrustedProxies{})
+	require.NoError(t, err)
+	assert.Nil(t, trusted)
+	assert.False(t, trusted.containsAddr("10.0.0.1:443"))
+}
+
+func TestHandler(t *testing.T) {
+	trusted := testTrusted(t, "10.0.0.0/8", "192.0.2.10")
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{{
+		name:   "untrusted peer",
+		remote: "198.51.100.1:5000",
+		xff:    []string{"203.0.113.1"},
+		want:   "198.51.100.1:5000",
+	}, {
+		name:   "trusted peer without header",
+		remote: "10.0.0.1:5000",
+		want:   "10.0.0.1:5000",
+	}, {
+		name:   "trusted peer",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"203.0.113.1"},
+		want:   "203.0.113.1",
+	}, {
+		name:   "chained trusted proxies",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"203.0.113.1, 192.0.2.10", "10.1.1.1"},
+		want:   "203.0.113.1",
+	}, {
+		name:   "forged addresses before the client",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"127.0.0.1, 203.0.113.1"},
+		want:   "203.0.113.1",
+	}, {
+		name:   "malformed header",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"unknown, 203.0.113.1"},
+		want:   "203.0.113.1",
+	}, {
+		name:   "only malformed addresses",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"unknown"},
+		want:   "10.0.0.1:5000",
+	}, {
+		name:   "ipv6 client",
+		remote: "10.0.0.1:5000",
+		xff:    []string{"2001:db8::1"},
+		want:   "2001:db8::1",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			h := Handler(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				r.Header.Add(HeaderForwardedFor, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package selfsigned generates and persists the self-signed bootstrap CA and server certificate,
+// served when the TLS of the server is not configured.
+package selfsigned
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	caFile      = "ca.pem"
+	caKeyFile   = "ca.key"
+	certFile    = "cert.pem"
+	certKeyFile = "cert.key"
+
+	// caValidity is the validity of the CA, the server certificate being issued again on expiry.
+	caValidity = 10 * 365 * 24 * time.Hour
+	// renewRatio is the ratio of the validity of the server certificate left when it is issued again.
+	renewRatio = 0.1
+	// notBeforeSkew backdates the certificates to tolerate the clock skew of the agents.
+	notBeforeSkew = 5 * time.Minute
+)
+
+// Bundle is the persisted CA and server certificate.
+type Bundle struct {
+	CA          string // path of the PEM certificate of the CA
+	Certificate string // path of the PEM server certificate
+	Key         string // path of the PEM key of the server certificate
+
+	// Fingerprint is the hex encoded SHA-256 of the CA certificate, as pinned by the agents with
+	// ca_trusted_fingerprint.
+	Fingerprint string
+}
+
+// ServerConfig returns the TLS config of the server serving the bundle.
+func (b *Bundle) ServerConfig() *tlscommon.ServerConfig {
+	return &tlscommon.ServerConfig{
+		Certificate: tlscommon.CertificateConfig{
+			Certificate: b.Certificate,
+			Key:         b.Key,
+		},
+	}
+}
+
+// Ensure returns the bundle persisted in the directory of cfg, generating the CA on the first start,
+// and the server certificate whenever it does not cover the host of the server and the hosts of cfg
+// or is about to expire.
+func Ensure(cfg *config.SelfSigned, host string) (*Bundle, error) {
+	if err := os.MkdirAll(cfg.Path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the bootstrap certificate directory: %w", err)
+	}
+	b := &Bundle{
+		CA:          filepath.Join(cfg.Path, caFile),
+		Certificate: filepath.Join(cfg.Path, certFile),
+		Key:         filepath.Join(cfg.Path, certKeyFile),
+	}
+
+	ca, caKey, err := loadPair(b.CA, filepath.Join(cfg.Path, caKeyFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load the bootstrap CA: %w", err)
+		}
+		if ca, caKey, err = createCA(); err != nil {
+			return nil, err
+		}
+		if err := writePair(b.CA, filepath.Join(cfg.Path, caKeyFile), ca, caKey); err != nil {
+			return nil, err
+		}
+		log.Info().Str("path", b.CA).Msg("Bootstrap CA generated")
+	}
+	sum := sha256.Sum256(ca.Raw)
+	b.Fingerprint = hex.EncodeToString(sum[:])
+
+	hosts := hostsOf(cfg, host)
+	cert, _, err := loadPair(b.Certificate, b.Key)
+	if err == nil && valid(cert, ca, hosts, cfg.Validity) {
+		return b, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msg("Fail to load the bootstrap certificate, issuing a new one")
+	}
+
+	cert, key, err := issue(ca, caKey, hosts, cfg.Validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePair(b.Certificate, b.Key, cert, key); err != nil {
+		return nil, err
+	}
+	log.Info().
+		Strs("hosts", hosts).
+		Time("not_after", cert.NotAfter).
+		Str("ca_trusted_fingerprint", b.Fingerprint).
+		Msg("Bootstrap certificate issued")
+	return b, nil
+}
+
+// hostsOf returns the hosts of the server certificate.
+func hostsOf(cfg *config.SelfSigned, host string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	// The unspecified addresses are the ones of all the interfaces, not hosts of their own, and the
+	// unix domain sockets are served without TLS
+	_, unix := config.UnixSocketPath(host)
+	if ip := net.ParseIP(host); host != "" && !unix && (ip == nil || !ip.IsUnspecified()) {
+		hosts = append(hosts, host)
+	}
+	hosts = append(hosts, cfg.Hosts...)
+
+	seen := make(map[string]struct{}, len(hosts))
+	unique := hosts[:0]
+	for _, h := range hosts {
+		if _, ok := seen[h]; !ok {
+			seen[h] = struct{}{}
+			unique = append(unique, h)
+		}
+	}
+	return unique
+}
+
+// valid returns true when cert was issued by ca for all the hosts, and is not about to expire.
+func valid(cert, ca *x509.Certificate, hosts []string, validity time.Duration) bool {
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return false
+	}
+	renewAt := cert.NotAfter.Add(-time.Duration(float64(validity) * renewRatio))
+	if time.Now().After(renewAt) {
+		return false
+	}
+	for _, h := range hosts {
+		if err := cert.VerifyHostname(h); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func createCA() (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fleet-server bootstrap CA"},
+		NotBefore:             now.Add(-notBeforeSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func issue(ca *x509.Certificate, caKey crypto.Signer, hosts []string, validity time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-notBeforeSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// loadPair loads the PEM certificate and key of the files.
+func loadPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("the key of %s cannot sign", certPath)
+	}
+	return cert, key, nil
+}
+
+// writePair writes the PEM certificate and key to the files, the key being readable by the owner only.
+func writePair(certPath, keyPath string, cert *x509.Certificate, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return err
+	}
+	return writeFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644) //nolint:gosec // certificates are public
+}
+
+// writeFile replaces the file atomically.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,107 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+// +build !integration
+
+package selfsigned
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func testConfig(t *testing.T) *config.SelfSigned {
+	cfg := &config.SelfSigned{}
+	cfg.InitDefaults()
+	cfg.Enabled = true
+	cfg.Path = t.TempDir()
+	return cfg
+}
+
+// load returns the CA and the server certificate of the bundle.
+func load(t *testing.T, b *Bundle) (*x509.Certificate, *x509.Certificate) {
+	t.Helper()
+	ca, _, err := loadPair(b.CA, filepath.Join(filepath.Dir(b.CA), caKeyFile))
+	require.NoError(t, err)
+	pair, err := tls.LoadX509KeyPair(b.Certificate, b.Key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	return ca, cert
+}
+
+func TestEnsure(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Hosts = []string{"fleet.example.com", "10.0.0.1"}
+
+	b, err := Ensure(cfg, "0.0.0.0")
+	require.NoError(t, err)
+	ca, cert := load(t, b)
+
+	sum := sha256.Sum256(ca.Raw)
+	assert.Equal(t, hex.EncodeToString(sum[:]), b.Fingerprint)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "fleet.example.com", "10.0.0.1"} {
+		_, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+	for _, ip := range cert.IPAddresses {
+		assert.False(t, ip.IsUnspecified(), "the unspecified address is not a host")
+	}
+
+	info, err := os.Stat(b.Key)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestEnsurePersisted(t *testing.T) {
+	cfg := testConfig(t)
+	b, err := Ensure(cfg, "")
+	require.NoError(t, err)
+	_, first := load(t, b)
+
+	again, err := Ensure(cfg, "")
+	require.NoError(t, err)
+	assert.Equal(t, b, again)
+	_, cert := load(t, again)
+	assert.Equal(t, first.Raw, cert.Raw, "the certificate is reused")
+
+	cfg.Hosts = []string{"fleet.example.com"}
+	again, err = Ensure(cfg, "")
+	require.NoError(t, err)
+	assert.Equal(t, b.Fingerprint, again.Fingerprint, "the CA is kept")
+	_, cert = load(t, again)
+	assert.NotEqual(t, first.Raw, cert.Raw, "issued again for the new host")
+	assert.NoError(t, cert.VerifyHostname("fleet.example.com"))
+}
+
+func TestEnsureRenew(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Validity = 24 * time.Hour
+	b, err := Ensure(cfg, "")
+	require.NoError(t, err)
+	_, first := load(t, b)
+
+	// The certificate is within the renewal window of a longer validity
+	cfg.Validity = 30 * 24 * time.Hour
+	_, err = Ensure(cfg, "")
+	require.NoError(t, err)
+	_, cert := load(t, b)
+	assert.NotEqual(t, first.Raw, cert.Raw)
+	assert.True(t, cert.NotAfter.After(first.NotAfter))
+}
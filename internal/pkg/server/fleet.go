@@ -20,6 +20,7 @@ import (
 	apmtransport "go.elastic.co/apm/transport"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/agentcert"
 	"github.com/elastic/fleet-server/v7/internal/pkg/api"
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
@@ -30,10 +31,12 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/gc"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/profile"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/selfsigned"
 	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
 
 	"github.com/hashicorp/go-version"
@@ -399,6 +402,32 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 	return g.Wait()
 }
 
+// bootstrapTLS returns the server config serving the self-signed bootstrap certificate when enabled
+// and the TLS of the server is not configured, along the options of the status handler exposing the
+// fingerprint of its CA. The config is a copy; the config of the server is left unchanged so that it
+// is still compared to the next configurations as received.
+func bootstrapTLS(cfg *config.Server) (*config.Server, []api.OptFunc, error) {
+	if !cfg.TLSSelfSigned.Enabled {
+		return cfg, nil, nil
+	}
+	if cfg.TLS != nil && cfg.TLS.IsEnabled() {
+		log.Info().Msg("TLS configured, the self-signed bootstrap certificate is not used")
+		return cfg, nil, nil
+	}
+	bundle, err := selfsigned.Ensure(&cfg.TLSSelfSigned, cfg.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to bootstrap the self-signed certificate: %w", err)
+	}
+	log.Info().
+		Str("ca", bundle.CA).
+		Str("ca_trusted_fingerprint", bundle.Fingerprint).
+		Msg("Serving the self-signed bootstrap certificate")
+
+	withTLS := *cfg
+	withTLS.TLS = bundle.ServerConfig()
+	return &withTLS, []api.OptFunc{api.WithCATrustedFingerprint(bundle.Fingerprint)}, nil
+}
+
 func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgroup.Group, bulker bulk.Bulk, tracer *apm.Tracer) (err error) {
 	esCli := bulker.Client()
 
@@ -440,21 +469,28 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 	pim, err := monitor.New(dl.FleetPolicies, esCli, monCli,
 		monitor.WithFetchSize(cfg.Inputs[0].Monitor.FetchSize),
 		monitor.WithPollTimeout(cfg.Inputs[0].Monitor.PollTimeout),
+		monitor.WithSubscriptionPolicy(monitor.SubscriptionPolicy(cfg.Inputs[0].Monitor.SubscriptionPolicy)),
+		monitor.WithSubscriptionQueueSize(cfg.Inputs[0].Monitor.SubscriptionQueueSize),
+		monitor.WithSubscriptionLagThreshold(cfg.Inputs[0].Monitor.SubscriptionLagThreshold),
 	)
 	if err != nil {
 		return err
 	}
 
 	g.Go(loggedRunFunc(ctx, "Policy index monitor", pim.Run))
-	cord := coordinator.NewMonitor(cfg.Fleet, f.bi.Version, bulker, pim, coordinator.NewCoordinatorZero)
+	cord := coordinator.NewMonitor(cfg.Fleet, cfg.Inputs[0].Server.AgentLifecycle, f.bi.Version, bulker, pim, coordinator.NewCoordinatorZero)
 	g.Go(loggedRunFunc(ctx, "Coordinator policy monitor", cord.Run))
 
 	// Policy monitor
 	pm := policy.NewMonitor(bulker, pim, cfg.Inputs[0].Server.Limits.PolicyThrottle)
 	g.Go(loggedRunFunc(ctx, "Policy monitor", pm.Run))
 
+	// Load shedding, reported by the policy self monitor
+	shedder := limit.NewShedder(&cfg.Inputs[0].Server.Limits.Memory)
+	g.Go(loggedRunFunc(ctx, "Load shedder", shedder.Run))
+
 	// Policy self monitor
-	sm := policy.NewSelfMonitor(cfg.Fleet, bulker, pim, cfg.Inputs[0].Policy.ID, f.reporter)
+	sm := policy.NewSelfMonitor(cfg.Fleet, bulker, pim, cfg.Inputs[0].Policy.ID, f.reporter, policy.WithLoadReporter(shedder))
 	g.Go(loggedRunFunc(ctx, "Policy self monitor", sm.Run))
 
 	// Actions monitoring
@@ -479,20 +515,64 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 		return err
 	}
 
-	bc := checkin.NewBulk(bulker)
+	// Cache invalidation, evicting the agents and API keys revoked by any fleet-server or Kibana
+	agm, err := monitor.NewSimple(dl.FleetAgents, esCli, monCli,
+		monitor.WithFetchSize(cfg.Inputs[0].Monitor.FetchSize),
+		monitor.WithPollTimeout(cfg.Inputs[0].Monitor.PollTimeout),
+		monitor.WithSource(cache.AgentSource...),
+	)
+	if err != nil {
+		return err
+	}
+	g.Go(loggedRunFunc(ctx, "Agent monitor", agm.Run))
+	ekm, err := monitor.NewSimple(dl.FleetEnrollmentAPIKeys, esCli, monCli,
+		monitor.WithFetchSize(cfg.Inputs[0].Monitor.FetchSize),
+		monitor.WithPollTimeout(cfg.Inputs[0].Monitor.PollTimeout),
+		monitor.WithSource(cache.EnrollmentAPIKeySource...),
+	)
+	if err != nil {
+		return err
+	}
+	g.Go(loggedRunFunc(ctx, "Enrollment API key monitor", ekm.Run))
+	inv := cache.NewInvalidator(f.cache, agm, ekm)
+	g.Go(loggedRunFunc(ctx, "Cache invalidator", inv.Run))
+
+	bulkCfg := cfg.Inputs[0].Server.Bulk
+	bcOpts := []checkin.Opt{
+		checkin.WithFlushBounds(bulkCfg.CheckinFlushIntervalMin, bulkCfg.CheckinFlushIntervalMax, bulkCfg.CheckinFlushBatchMax),
+	}
+	if j := cfg.Inputs[0].Server.CheckinJournal; j.Enabled {
+		bcOpts = append(bcOpts, checkin.WithJournal(j.Path, j.MaxSize))
+	}
+	bc := checkin.NewBulk(bulker, bcOpts...)
 	g.Go(loggedRunFunc(ctx, "Bulk checkin", bc.Run))
 
 	ct := api.NewCheckinT(f.verCon, &cfg.Inputs[0].Server, f.cache, bc, pm, am, ad, tr, bulker)
-	et, err := api.NewEnrollerT(f.verCon, &cfg.Inputs[0].Server, bulker, f.cache)
+	ca, err := agentcert.Load(&cfg.Inputs[0].Server.AgentCertificates)
+	if err != nil {
+		return err
+	}
+	et, err := api.NewEnrollerT(f.verCon, &cfg.Inputs[0].Server, bulker, f.cache, ca)
 	if err != nil {
 		return err
 	}
 
 	at := api.NewArtifactT(&cfg.Inputs[0].Server, bulker, f.cache)
 	ack := api.NewAckT(&cfg.Inputs[0].Server, bulker, f.cache)
-	st := api.NewStatusT(&cfg.Inputs[0].Server, bulker, f.cache)
+	srvCfg, stOpts, err := bootstrapTLS(&cfg.Inputs[0].Server)
+	if err != nil {
+		return err
+	}
+	st := api.NewStatusT(&cfg.Inputs[0].Server, bulker, f.cache, stOpts...)
+
+	lc := limit.NewController(&cfg.Inputs[0].Server.Limits, bulker)
+	g.Go(loggedRunFunc(ctx, "Limit controller", lc.Run))
 
-	router := api.NewRouter(&cfg.Inputs[0].Server, bulker, ct, et, at, ack, st, sm, tracer, f.bi)
+	router := api.NewRouter(srvCfg, bulker, ct, et, at, ack, st, sm, tracer, f.bi, ca,
+		limit.WithController(lc),
+		limit.WithShedder(shedder),
+		limit.WithPrioritizer(pm),
+	)
 
 	g.Go(loggedRunFunc(ctx, "Http server", func(ctx context.Context) error {
 		return router.Run(ctx)
@@ -0,0 +1,152 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package membulk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+type apiKeyT struct {
+	id          string
+	key         string
+	name        string
+	roles       json.RawMessage
+	metadata    json.RawMessage
+	expiration  time.Time
+	invalidated bool
+}
+
+func (k *apiKeyT) valid() bool {
+	return !k.invalidated && (k.expiration.IsZero() || time.Now().Before(k.expiration))
+}
+
+func (b *Bulk) APIKeyCreate(ctx context.Context, name, ttl string, roles []byte, meta interface{}) (*bulk.APIKey, error) {
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &apiKeyT{
+		id:       newID(),
+		key:      newID(),
+		name:     name,
+		roles:    append(json.RawMessage(nil), roles...),
+		metadata: metadata,
+	}
+	if ttl != "" {
+		d, err := parseTTL(ttl)
+		if err != nil {
+			return nil, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: err.Error()}
+		}
+		k.expiration = time.Now().Add(d)
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.apiKeys[k.id] = k
+
+	return &bulk.APIKey{ID: k.id, Key: k.key}, nil
+}
+
+func (b *Bulk) APIKeyRead(ctx context.Context, id string, withOwner bool) (*bulk.APIKeyMetadata, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	k, ok := b.apiKeys[id]
+	if !ok {
+		return nil, apikey.ErrAPIKeyNotFound
+	}
+
+	var metadata apikey.Metadata
+	if err := json.Unmarshal(k.metadata, &metadata); err != nil {
+		return nil, fmt.Errorf("could not decode api key %s metadata: %w", id, err)
+	}
+	return &bulk.APIKeyMetadata{
+		ID:              k.id,
+		Metadata:        metadata,
+		RoleDescriptors: k.roles,
+	}, nil
+}
+
+func (b *Bulk) APIKeyAuth(ctx context.Context, key bulk.APIKey) (*bulk.SecurityInfo, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	k, ok := b.apiKeys[key.ID]
+	if !ok || k.key != key.Key || !k.valid() {
+		return nil, fmt.Errorf("apikey auth response %s: %w", key.ID, &es.ErrElastic{
+			Status: http.StatusUnauthorized,
+			Type:   "security_exception",
+			Reason: "unable to authenticate with provided credentials",
+		})
+	}
+
+	return &bulk.SecurityInfo{
+		UserName: "elastic",
+		Roles:    []string{},
+		Metadata: k.metadata,
+		Enabled:  true,
+		AuthRealm: map[string]string{
+			"name": "_es_api_key",
+			"type": "_es_api_key",
+		},
+	}, nil
+}
+
+// APIKeyInvalidate invalidates the api keys, ignoring the unknown ids.
+func (b *Bulk) APIKeyInvalidate(ctx context.Context, ids ...string) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for _, id := range ids {
+		if k, ok := b.apiKeys[id]; ok {
+			k.invalidated = true
+		}
+	}
+	return nil
+}
+
+func (b *Bulk) APIKeyUpdate(ctx context.Context, id, outputPolicyHash string, roles []byte) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	k, ok := b.apiKeys[id]
+	if !ok || k.invalidated {
+		return &es.ErrElastic{Status: http.StatusNotFound, Type: "resource_not_found_exception", Reason: "no API key found for id " + id}
+	}
+	k.roles = append(json.RawMessage(nil), roles...)
+	return nil
+}
+
+// APIKeyValid returns true if the api key exists and is neither invalidated nor expired.
+func (b *Bulk) APIKeyValid(id string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	k, ok := b.apiKeys[id]
+	return ok && k.valid()
+}
+
+// parseTTL parses an elasticsearch time value such as 30m or 7d.
+func parseTTL(ttl string) (time.Duration, error) {
+	if days := strings.TrimSuffix(ttl, "d"); days != ttl {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expiration %q: %w", ttl, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(ttl)
+}
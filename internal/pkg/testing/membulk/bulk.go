@@ -0,0 +1,401 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package membulk implements bulk.Bulk in memory, to run fleet-server components without elasticsearch.
+//
+// The documents are versioned with seq_no and primary_term, and the writes are visible immediately.
+// Searches support the subset of the query DSL generated by fleet-server: bool, term, terms, range,
+// exists and ids queries, sort, size, search_after, _source filtering, points in time, and terms,
+// top_hits, max and min aggregations.
+// Updates support partial documents; painless scripts are not supported. Client returns nil.
+//
+// Handler serves a Bulk over the elasticsearch HTTP API, including the _fleet plugin endpoints, so
+// that components using an elasticsearch client, such as the index monitors, run against it.
+package membulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/gofrs/uuid"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+const primaryTerm = 1
+
+type actionT int
+
+const (
+	actionCreate actionT = iota
+	actionIndex
+	actionUpdate
+	actionDelete
+)
+
+type docT struct {
+	id      string
+	seqNo   int64
+	version int64
+	source  map[string]interface{}
+}
+
+type indexT struct {
+	docs  map[string]*docT
+	seqNo int64 // seq_no of the last write, the global checkpoint
+}
+
+// Bulk is an in-memory bulk.Bulk.
+type Bulk struct {
+	mut     sync.Mutex
+	indices map[string]*indexT
+	apiKeys map[string]*apiKeyT
+	pits    map[string]string // index of the points in time
+	changed chan struct{}     // closed and replaced on every write
+}
+
+// New returns an empty in-memory bulk.Bulk.
+func New() *Bulk {
+	return &Bulk{
+		indices: make(map[string]*indexT),
+		apiKeys: make(map[string]*apiKeyT),
+		pits:    make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+var _ bulk.Bulk = (*Bulk)(nil)
+
+// CreateIndex creates the indices that do not exist, so that they can be searched before the first write.
+func (b *Bulk) CreateIndex(names ...string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for _, name := range names {
+		b.index(name)
+	}
+}
+
+// index returns the named index, creating it if needed.
+// WARNING: Expects mutex locked.
+func (b *Bulk) index(name string) *indexT {
+	idx, ok := b.indices[name]
+	if !ok {
+		idx = &indexT{docs: make(map[string]*docT), seqNo: sqn.UndefinedSeqNo}
+		b.indices[name] = idx
+	}
+	return idx
+}
+
+// write stores the document source, or deletes the document if source is nil, with the next seq_no.
+// WARNING: Expects mutex locked.
+func (b *Bulk) write(idx *indexT, id string, source map[string]interface{}) *docT {
+	idx.seqNo++
+	doc, ok := idx.docs[id]
+	if !ok {
+		doc = &docT{id: id}
+	}
+	doc.seqNo = idx.seqNo
+	doc.version++
+	doc.source = source
+
+	if source == nil {
+		delete(idx.docs, id)
+	} else {
+		idx.docs[id] = doc
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return doc
+}
+
+func (b *Bulk) Create(ctx context.Context, index, id string, body []byte, opts ...bulk.Opt) (string, error) {
+	item := b.do(actionCreate, bulk.MultiOp{Index: index, ID: id, Body: body}, bulk.ParseOpts(opts...))
+	return item.DocumentID, es.TranslateError(item.Status, item.Error)
+}
+
+func (b *Bulk) Index(ctx context.Context, index, id string, body []byte, opts ...bulk.Opt) (string, error) {
+	item := b.do(actionIndex, bulk.MultiOp{Index: index, ID: id, Body: body}, bulk.ParseOpts(opts...))
+	return item.DocumentID, es.TranslateError(item.Status, item.Error)
+}
+
+func (b *Bulk) Update(ctx context.Context, index, id string, body []byte, opts ...bulk.Opt) error {
+	item := b.do(actionUpdate, bulk.MultiOp{Index: index, ID: id, Body: body}, bulk.ParseOpts(opts...))
+	return es.TranslateError(item.Status, item.Error)
+}
+
+func (b *Bulk) Delete(ctx context.Context, index, id string, opts ...bulk.Opt) error {
+	item := b.do(actionDelete, bulk.MultiOp{Index: index, ID: id}, bulk.ParseOpts(opts...))
+	return es.TranslateError(item.Status, item.Error)
+}
+
+func (b *Bulk) Read(ctx context.Context, index, id string, opts ...bulk.Opt) ([]byte, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	idx, ok := b.indices[index]
+	if !ok {
+		return nil, es.ErrElasticNotFound
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return nil, es.ErrElasticNotFound
+	}
+	return json.Marshal(doc.source)
+}
+
+func (b *Bulk) MCreate(ctx context.Context, ops []bulk.MultiOp, opts ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.multi(actionCreate, ops, opts...)
+}
+
+func (b *Bulk) MIndex(ctx context.Context, ops []bulk.MultiOp, opts ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.multi(actionIndex, ops, opts...)
+}
+
+func (b *Bulk) MUpdate(ctx context.Context, ops []bulk.MultiOp, opts ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.multi(actionUpdate, ops, opts...)
+}
+
+func (b *Bulk) MDelete(ctx context.Context, ops []bulk.MultiOp, opts ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	return b.multi(actionDelete, ops, opts...)
+}
+
+// Client returns nil, there is no elasticsearch behind the in-memory bulker.
+func (b *Bulk) Client() *elasticsearch.Client {
+	return nil
+}
+
+// multi runs the operations, returning the error of the last failed one as the bulk engine.
+func (b *Bulk) multi(action actionT, ops []bulk.MultiOp, opts ...bulk.Opt) ([]bulk.BulkIndexerResponseItem, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	opt := bulk.ParseOpts(opts...)
+	items := make([]bulk.BulkIndexerResponseItem, len(ops))
+	var lastErr error
+	for i, op := range ops {
+		items[i] = *b.do(action, op, opt)
+		if err := es.TranslateError(items[i].Status, items[i].Error); err != nil {
+			lastErr = err
+		}
+	}
+	return items, lastErr
+}
+
+// do runs a write operation, reporting the outcome as an item of a bulk response.
+func (b *Bulk) do(action actionT, op bulk.MultiOp, opt bulk.Options) *bulk.BulkIndexerResponseItem {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	idx := b.index(op.Index)
+	cur := idx.docs[op.ID]
+
+	if opt.IfSeqNo != "" {
+		seqNo, _ := strconv.ParseInt(opt.IfSeqNo, 10, 64)
+		term, _ := strconv.ParseInt(opt.IfPrimaryTerm, 10, 64)
+		if cur == nil || cur.seqNo != seqNo || term != primaryTerm {
+			return errItem(op.ID, http.StatusConflict, "version_conflict_engine_exception", "[%s]: version conflict, required seqNo [%d], primary term [%d]", op.ID, seqNo, term)
+		}
+	}
+
+	var source map[string]interface{}
+	status := http.StatusOK
+
+	switch action {
+	case actionCreate, actionIndex:
+		if err := decode(op.Body, &source); err != nil || source == nil {
+			return errItem(op.ID, http.StatusBadRequest, "mapper_parsing_exception", "failed to parse document: %v", err)
+		}
+		if op.ID == "" {
+			op.ID = newID()
+		}
+		if cur = idx.docs[op.ID]; cur != nil && action == actionCreate {
+			return errItem(op.ID, http.StatusConflict, "version_conflict_engine_exception", "[%s]: version conflict, document already exists", op.ID)
+		}
+		if cur == nil {
+			status = http.StatusCreated
+		}
+
+	case actionUpdate:
+		var req struct {
+			Doc         map[string]interface{} `json:"doc"`
+			DocAsUpsert bool                   `json:"doc_as_upsert"`
+			Script      json.RawMessage        `json:"script"`
+		}
+		if err := decode(op.Body, &req); err != nil {
+			return errItem(op.ID, http.StatusBadRequest, "x_content_parse_exception", "failed to parse update: %v", err)
+		}
+		if req.Script != nil {
+			return errItem(op.ID, http.StatusBadRequest, "illegal_argument_exception", "script updates are not supported in memory")
+		}
+		if cur == nil {
+			if !req.DocAsUpsert {
+				return errItem(op.ID, http.StatusNotFound, "document_missing_exception", "[%s]: document missing", op.ID)
+			}
+			source = req.Doc
+			status = http.StatusCreated
+			break
+		}
+		source = merge(copyMap(cur.source), req.Doc)
+		if reflect.DeepEqual(source, cur.source) {
+			// noop, the document keeps its seq_no
+			return docItem(cur, status)
+		}
+
+	case actionDelete:
+		if cur == nil {
+			return &bulk.BulkIndexerResponseItem{DocumentID: op.ID, Status: http.StatusNotFound}
+		}
+	}
+
+	return docItem(b.write(idx, op.ID, source), status)
+}
+
+func docItem(doc *docT, status int) *bulk.BulkIndexerResponseItem {
+	return &bulk.BulkIndexerResponseItem{
+		DocumentID: doc.id,
+		Status:     status,
+		SeqNo:      doc.seqNo,
+		PrimTerm:   primaryTerm,
+	}
+}
+
+func errItem(id string, status int, errType, format string, args ...interface{}) *bulk.BulkIndexerResponseItem {
+	e := es.ErrorT{Type: errType, Reason: fmt.Sprintf(format, args...)}
+	raw, _ := json.Marshal(&e)
+	return &bulk.BulkIndexerResponseItem{
+		DocumentID: id,
+		Status:     status,
+		Error:      raw,
+	}
+}
+
+// GlobalCheckpoint returns the global checkpoint of the index, as the fleet global checkpoints API.
+func (b *Bulk) GlobalCheckpoint(index string) sqn.SeqNo {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if idx, ok := b.indices[index]; ok {
+		return sqn.SeqNo{idx.seqNo}
+	}
+	return sqn.DefaultSeqNo
+}
+
+// WaitAdvance waits until the global checkpoint of the index advances past checkpoint, or the timeout
+// expires, as the fleet global checkpoints API with wait_for_advance.
+func (b *Bulk) WaitAdvance(ctx context.Context, index string, checkpoint sqn.SeqNo, to time.Duration) (sqn.SeqNo, error) {
+	var target int64 = sqn.UndefinedSeqNo
+	if len(checkpoint) > 0 {
+		target = checkpoint.Value()
+	}
+
+	t := time.NewTimer(to)
+	defer t.Stop()
+
+	for {
+		b.mut.Lock()
+		changed := b.changed
+		seqNo := int64(sqn.UndefinedSeqNo)
+		if idx, ok := b.indices[index]; ok {
+			seqNo = idx.seqNo
+		}
+		b.mut.Unlock()
+
+		if seqNo > target {
+			return sqn.SeqNo{seqNo}, nil
+		}
+
+		select {
+		case <-changed:
+		case <-t.C:
+			return nil, es.ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitForCheckpoints waits until the global checkpoint of the index reaches the checkpoints,
+// as _fleet/_fleet_search with wait_for_checkpoints.
+func (b *Bulk) waitForCheckpoints(ctx context.Context, index string, checkpoints []int64) error {
+	if len(checkpoints) == 0 {
+		return nil
+	}
+	target := checkpoints[0]
+	for _, c := range checkpoints[1:] {
+		if c > target {
+			target = c
+		}
+	}
+
+	for {
+		b.mut.Lock()
+		changed := b.changed
+		reached := false
+		if idx, ok := b.indices[index]; ok {
+			reached = idx.seqNo >= target
+		}
+		b.mut.Unlock()
+
+		if reached {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func newID() string {
+	u, err := uuid.NewV4()
+	if err != nil {
+		panic(err)
+	}
+	return u.String()
+}
+
+// decode unmarshals JSON keeping the numbers as json.Number, so that documents are stored as written.
+func decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// merge merges the partial document into the source, as the doc of an update.
+func merge(source, partial map[string]interface{}) map[string]interface{} {
+	for k, v := range partial {
+		if pm, ok := v.(map[string]interface{}); ok {
+			if sm, ok := source[k].(map[string]interface{}); ok {
+				source[k] = merge(sm, pm)
+				continue
+			}
+		}
+		source[k] = v
+	}
+	return source
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if vm, ok := v.(map[string]interface{}); ok {
+			v = copyMap(vm)
+		}
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,249 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package membulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+func TestCRUD(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	id, err := bulker.Create(ctx, "idx", "", []byte(`{"a":1,"b":{"c":"d"}}`))
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	_, err = bulker.Create(ctx, "idx", id, []byte(`{}`))
+	assert.ErrorIs(t, err, es.ErrElasticVersionConflict)
+
+	require.NoError(t, bulker.Update(ctx, "idx", id, []byte(`{"doc":{"b":{"e":"f"}}}`)))
+	data, err := bulker.Read(ctx, "idx", id)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":1,"b":{"c":"d","e":"f"}}`, string(data))
+
+	var esErr *es.ErrElastic
+	err = bulker.Update(ctx, "idx", "missing", []byte(`{"doc":{}}`))
+	require.ErrorAs(t, err, &esErr)
+	assert.Equal(t, 404, esErr.Status)
+
+	require.NoError(t, bulker.Update(ctx, "idx", "upserted", []byte(`{"doc":{"a":2},"doc_as_upsert":true}`)))
+	assert.Error(t, bulker.Update(ctx, "idx", id, []byte(`{"script":{"source":"ctx._source.a++"}}`)))
+
+	require.NoError(t, bulker.Delete(ctx, "idx", id))
+	_, err = bulker.Read(ctx, "idx", id)
+	assert.ErrorIs(t, err, es.ErrElasticNotFound)
+
+	items, err := bulker.MUpdate(ctx, []bulk.MultiOp{
+		{Index: "idx", ID: "upserted", Body: []byte(`{"doc":{"a":3}}`)},
+		{Index: "idx", ID: id, Body: []byte(`{"doc":{"a":3}}`)},
+	})
+	assert.Error(t, err, "the missing document fails the request")
+	require.Len(t, items, 2)
+	assert.Equal(t, 200, items[0].Status)
+	assert.Equal(t, 404, items[1].Status)
+
+	// 3 creates or upserts, 2 updates, 1 delete
+	assert.Equal(t, sqn.SeqNo{4}, bulker.GlobalCheckpoint("idx"))
+}
+
+func TestIfSeqNo(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	_, err := bulker.Index(ctx, "idx", "id", []byte(`{"a":1}`))
+	require.NoError(t, err)
+	items, err := bulker.MIndex(ctx, []bulk.MultiOp{{Index: "idx", ID: "id", Body: []byte(`{"a":2}`)}})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), items[0].SeqNo)
+
+	err = bulker.Update(ctx, "idx", "id", []byte(`{"doc":{"a":3}}`), bulk.WithIfSeqNo(0, 1))
+	assert.ErrorIs(t, err, es.ErrElasticVersionConflict)
+	assert.NoError(t, bulker.Update(ctx, "idx", "id", []byte(`{"doc":{"a":3}}`), bulk.WithIfSeqNo(1, 1)))
+}
+
+func TestPolicyLeadership(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+	bulker.CreateIndex(dl.FleetPoliciesLeader)
+
+	lease, err := dl.TakePolicyLeadership(ctx, bulker, "policy1", "server1", "1.0.0")
+	require.NoError(t, err)
+	_, err = dl.TakePolicyLeadership(ctx, bulker, "policy2", "server1", "1.0.0")
+	require.NoError(t, err)
+
+	leaders, err := dl.SearchPolicyLeaders(ctx, bulker, []string{"policy1", "policy2", "policy3"})
+	require.NoError(t, err)
+	assert.Len(t, leaders, 2)
+
+	// Another server takes over, the lease of the first one is fenced
+	_, err = dl.TakePolicyLeadership(ctx, bulker, "policy1", "server2", "1.0.0")
+	require.NoError(t, err)
+	_, err = dl.RenewPolicyLeadership(ctx, bulker, "policy1", "server1", "1.0.0", lease)
+	assert.Error(t, err)
+}
+
+func TestQueryLatestPolicies(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	for _, p := range []model.Policy{
+		{PolicyID: "policy1", RevisionIdx: 1, CoordinatorIdx: 1},
+		{PolicyID: "policy1", RevisionIdx: 2, CoordinatorIdx: 1},
+		{PolicyID: "policy2", RevisionIdx: 1, CoordinatorIdx: 0},
+		{PolicyID: "policy2", RevisionIdx: 1, CoordinatorIdx: 1},
+	} {
+		_, err := dl.CreatePolicy(ctx, bulker, p)
+		require.NoError(t, err)
+	}
+
+	policies, err := dl.QueryLatestPolicies(ctx, bulker)
+	require.NoError(t, err)
+	require.Len(t, policies, 2)
+	latest := map[string]model.Policy{}
+	for _, p := range policies {
+		latest[p.PolicyID] = p
+	}
+	assert.Equal(t, int64(2), latest["policy1"].RevisionIdx)
+	assert.Equal(t, int64(1), latest["policy2"].CoordinatorIdx)
+}
+
+func TestFindAgent(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	now := time.Now().UTC()
+	for _, agent := range []model.Agent{
+		{ESDocument: model.ESDocument{Id: "agent1"}, Active: true, PolicyID: "policy1", AccessAPIKeyID: "key1", LastCheckin: now.Format(time.RFC3339)},
+		{ESDocument: model.ESDocument{Id: "agent2"}, Active: true, PolicyID: "policy1", AccessAPIKeyID: "key2", LastCheckin: now.Add(-time.Hour).Format(time.RFC3339Nano)},
+		{ESDocument: model.ESDocument{Id: "agent3"}, Active: false, PolicyID: "policy1", AccessAPIKeyID: "key3", LastCheckin: now.Add(-time.Hour).Format(time.RFC3339)},
+	} {
+		body, err := json.Marshal(&agent)
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, dl.FleetAgents, agent.Id, body)
+		require.NoError(t, err)
+	}
+
+	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByAssessAPIKeyID, dl.FieldAccessAPIKeyID, "key2")
+	require.NoError(t, err)
+	assert.Equal(t, "agent2", agent.Id)
+	assert.Equal(t, int64(1), agent.SeqNo)
+
+	_, err = dl.FindAgent(ctx, bulker, dl.QueryAgentByID, dl.FieldID, "missing")
+	assert.ErrorIs(t, err, dl.ErrNotFound)
+
+	offline, err := dl.FindOfflineAgents(ctx, bulker, "policy1", 10*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, offline, 1)
+	assert.Equal(t, "agent2", offline[0].Id)
+}
+
+func TestFindAgentActions(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, action := range []model.Action{
+		{ActionID: "action1", Agents: []string{"agent1", "agent2"}, Expiration: expiration},
+		{ActionID: "action2", Agents: []string{"agent2"}, Expiration: expiration},
+		{ActionID: "expired", Agents: []string{"agent1"}, Expiration: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		{ActionID: "action3", Agents: []string{"agent1"}, Expiration: expiration},
+	} {
+		body, err := json.Marshal(&action)
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, dl.FleetActions, "", body)
+		require.NoError(t, err)
+	}
+
+	actions, err := dl.FindAgentActions(ctx, bulker, sqn.SeqNo{0}, bulker.GlobalCheckpoint(dl.FleetActions), "agent1")
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "action3", actions[0].ActionID)
+	assert.Nil(t, actions[0].Agents, "agents must be excluded from the source")
+
+	// Waits for the checkpoint
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = dl.FindAgentActions(waitCtx, bulker, sqn.SeqNo{3}, sqn.SeqNo{4}, "agent1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitAdvance(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	_, err := bulker.WaitAdvance(ctx, "idx", sqn.DefaultSeqNo, 10*time.Millisecond)
+	assert.ErrorIs(t, err, es.ErrTimeout)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = bulker.Index(ctx, "idx", "id", []byte(`{}`))
+	}()
+	seqNo, err := bulker.WaitAdvance(ctx, "idx", sqn.DefaultSeqNo, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, sqn.SeqNo{0}, seqNo)
+}
+
+func TestSearchUnsupported(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+	bulker.CreateIndex("idx")
+
+	_, err := bulker.Search(ctx, "idx", []byte(`{"query":{"match":{"a":"b"}}}`))
+	assert.Error(t, err)
+
+	_, err = bulker.Search(ctx, "missing", []byte(`{}`))
+	assert.ErrorIs(t, err, es.ErrIndexNotFound)
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	bulker := New()
+
+	key, err := bulker.APIKeyCreate(ctx, "agent1", "", []byte(`{}`), apikey.NewMetadata("agent1", "", apikey.TypeAccess))
+	require.NoError(t, err)
+
+	info, err := bulker.APIKeyAuth(ctx, *key)
+	require.NoError(t, err)
+	assert.True(t, info.Enabled)
+
+	_, err = bulker.APIKeyAuth(ctx, bulk.APIKey{ID: key.ID, Key: "wrong"})
+	assert.Error(t, err)
+
+	meta, err := bulker.APIKeyRead(ctx, key.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", meta.Metadata.AgentID)
+
+	require.NoError(t, bulker.APIKeyUpdate(ctx, key.ID, "hash", []byte(`{"role":{}}`)))
+	meta, err = bulker.APIKeyRead(ctx, key.ID, false)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":{}}`, string(meta.RoleDescriptors))
+
+	require.NoError(t, bulker.APIKeyInvalidate(ctx, key.ID))
+	_, err = bulker.APIKeyAuth(ctx, *key)
+	var esErr *es.ErrElastic
+	require.True(t, errors.As(err, &esErr))
+	assert.Equal(t, 401, esErr.Status)
+	assert.False(t, bulker.APIKeyValid(key.ID))
+
+	_, err = bulker.APIKeyRead(ctx, "missing", false)
+	assert.ErrorIs(t, err, apikey.ErrAPIKeyNotFound)
+}
@@ -0,0 +1,354 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package membulk
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matcherT returns true if the document matches a query.
+type matcherT func(doc *docT) bool
+
+func matchAll(*docT) bool { return true }
+
+func matchNone(*docT) bool { return false }
+
+// compileQuery compiles a query clause, so that unsupported queries fail even when no document
+// would be evaluated.
+func compileQuery(query map[string]interface{}) (matcherT, error) {
+	if len(query) == 0 {
+		return matchAll, nil
+	}
+	if len(query) != 1 {
+		return nil, errUnsupported("query clause with %d keys", len(query))
+	}
+
+	for kind, v := range query {
+		switch kind {
+		case "match_all":
+			return matchAll, nil
+		case "match_none":
+			return matchNone, nil
+		case "bool":
+			return compileBool(v)
+		case "term":
+			field, value, err := fieldClause(v)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := value.(map[string]interface{}); ok {
+				value = m["value"]
+			}
+			return func(doc *docT) bool {
+				return anyEqual(fieldValues(doc, field), value)
+			}, nil
+		case "terms", "ids":
+			return compileTerms(kind, v)
+		case "range":
+			return compileRange(v)
+		case "exists":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errUnsupported("exists is not an object")
+			}
+			field, _ := m["field"].(string)
+			return func(doc *docT) bool {
+				return len(fieldValues(doc, field)) > 0
+			}, nil
+		default:
+			return nil, errUnsupported("unsupported query %q", kind)
+		}
+	}
+	return nil, nil
+}
+
+func compileBool(v interface{}) (matcherT, error) {
+	clauses, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errUnsupported("bool is not an object")
+	}
+
+	var must, mustNot, should []matcherT
+	for occur, c := range clauses {
+		if occur == "minimum_should_match" || occur == "boost" {
+			continue
+		}
+		queries, err := queryList(c)
+		if err != nil {
+			return nil, err
+		}
+		matchers := make([]matcherT, 0, len(queries))
+		for _, q := range queries {
+			m, err := compileQuery(q)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m)
+		}
+		switch occur {
+		case "must", "filter":
+			must = append(must, matchers...)
+		case "must_not":
+			mustNot = append(mustNot, matchers...)
+		case "should":
+			should = append(should, matchers...)
+		default:
+			return nil, errUnsupported("unsupported bool clause %q", occur)
+		}
+	}
+
+	return func(doc *docT) bool {
+		for _, m := range must {
+			if !m(doc) {
+				return false
+			}
+		}
+		for _, m := range mustNot {
+			if m(doc) {
+				return false
+			}
+		}
+		for _, m := range should {
+			if m(doc) {
+				return true
+			}
+		}
+		return len(should) == 0
+	}, nil
+}
+
+func compileTerms(kind string, v interface{}) (matcherT, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errUnsupported("%s is not an object", kind)
+	}
+
+	for field, values := range m {
+		if field == "boost" {
+			continue
+		}
+		if kind == "ids" {
+			field = "_id"
+		}
+		list, ok := values.([]interface{})
+		if !ok {
+			return nil, errUnsupported("%s on %s is not a list", kind, field)
+		}
+		return func(doc *docT) bool {
+			docValues := fieldValues(doc, field)
+			for _, value := range list {
+				if anyEqual(docValues, value) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, errUnsupported("%s without field", kind)
+}
+
+func compileRange(v interface{}) (matcherT, error) {
+	field, value, err := fieldClause(v)
+	if err != nil {
+		return nil, err
+	}
+	bounds, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errUnsupported("range on %s is not an object", field)
+	}
+
+	type boundT struct {
+		op    string
+		value interface{}
+	}
+	var checks []boundT
+	for op, bound := range bounds {
+		switch op {
+		case "gt", "gte", "lt", "lte":
+			checks = append(checks, boundT{op, bound})
+		case "format", "boost":
+		default:
+			return nil, errUnsupported("unsupported range operator %q", op)
+		}
+	}
+
+	inRange := func(v interface{}) bool {
+		for _, b := range checks {
+			c, ok := compare(v, b.value)
+			if !ok {
+				return false
+			}
+			switch {
+			case b.op == "gt" && c <= 0, b.op == "gte" && c < 0, b.op == "lt" && c >= 0, b.op == "lte" && c > 0:
+				return false
+			}
+		}
+		return true
+	}
+
+	return func(doc *docT) bool {
+		for _, v := range fieldValues(doc, field) {
+			if inRange(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// fieldClause returns the field and value of a clause such as {"term":{"field":value}}.
+func fieldClause(v interface{}) (string, interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", nil, errUnsupported("expected a single field clause, got %v", v)
+	}
+	for field, value := range m {
+		return field, value, nil
+	}
+	return "", nil, nil
+}
+
+// queryList returns the queries of a bool clause, either a single query or a list of queries.
+func queryList(v interface{}) ([]map[string]interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{t}, nil
+	case []interface{}:
+		queries := make([]map[string]interface{}, 0, len(t))
+		for _, q := range t {
+			m, ok := q.(map[string]interface{})
+			if !ok {
+				return nil, errUnsupported("bool clause is not an object: %v", q)
+			}
+			queries = append(queries, m)
+		}
+		return queries, nil
+	}
+	return nil, errUnsupported("bool clause is neither an object nor a list: %v", v)
+}
+
+// fieldValues returns the values of the field in the document, flattening the arrays.
+// The field is either a metadata field such as _id and _seq_no, or a dotted path in the source.
+func fieldValues(doc *docT, field string) []interface{} {
+	switch field {
+	case "_id":
+		return []interface{}{doc.id}
+	case "_seq_no", fieldShardDoc:
+		return []interface{}{json.Number(strconv.FormatInt(doc.seqNo, 10))}
+	case "_version":
+		return []interface{}{json.Number(strconv.FormatInt(doc.version, 10))}
+	}
+	return flatten(lookup(doc.source, field), nil)
+}
+
+func lookup(m map[string]interface{}, field string) interface{} {
+	if v, ok := m[field]; ok {
+		return v
+	}
+	// Objects in arrays are not supported, as in the fleet mappings
+	for i := 0; i < len(field); i++ {
+		if field[i] != '.' {
+			continue
+		}
+		if sub, ok := m[field[:i]].(map[string]interface{}); ok {
+			if v := lookup(sub, field[i+1:]); v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func flatten(v interface{}, values []interface{}) []interface{} {
+	switch t := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, e := range t {
+			values = flatten(e, values)
+		}
+	default:
+		values = append(values, t)
+	}
+	return values
+}
+
+func anyEqual(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if c, ok := compare(v, value); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares two scalar values as numbers, dates or strings, as elasticsearch would for
+// the type of the field. It returns false if the values are not comparable.
+func compare(a, b interface{}) (int, bool) {
+	if fa, ok := number(a); ok {
+		if fb, ok := number(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	sa, ok := scalar(a)
+	if !ok {
+		return 0, false
+	}
+	sb, ok := scalar(b)
+	if !ok {
+		return 0, false
+	}
+	if ta, err := time.Parse(time.RFC3339Nano, sa); err == nil {
+		if tb, err := time.Parse(time.RFC3339Nano, sb); err == nil {
+			switch {
+			case ta.Before(tb):
+				return -1, true
+			case ta.After(tb):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return strings.Compare(sa, sb), true
+}
+
+func number(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	return "", false
+}
@@ -0,0 +1,542 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package membulk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+const defaultSize = 10
+
+type searchT struct {
+	Query  map[string]interface{} `json:"query"`
+	Size   *json.Number           `json:"size"`
+	From   json.Number            `json:"from"`
+	Sort   interface{}            `json:"sort"`
+	Source interface{}            `json:"_source"`
+	Aggs   map[string]aggT        `json:"aggs"`
+	// aggregations is an alias of aggs
+	Aggregations map[string]aggT `json:"aggregations"`
+	SearchAfter  []interface{}   `json:"search_after"`
+	Pit          *struct {
+		ID string `json:"id"`
+	} `json:"pit"`
+}
+
+type aggT struct {
+	Terms *struct {
+		Field string       `json:"field"`
+		Size  *json.Number `json:"size"`
+	} `json:"terms"`
+	TopHits *struct {
+		Size   *json.Number `json:"size"`
+		Sort   interface{}  `json:"sort"`
+		Source interface{}  `json:"_source"`
+	} `json:"top_hits"`
+	Max *struct {
+		Field string `json:"field"`
+	} `json:"max"`
+	Min *struct {
+		Field string `json:"field"`
+	} `json:"min"`
+	Aggs map[string]aggT `json:"aggs"`
+}
+
+// errUnsupported is returned for the queries this package does not implement, as elasticsearch
+// would for a malformed query, so that tests fail loudly.
+func errUnsupported(format string, args ...interface{}) error {
+	return &es.ErrElastic{
+		Status: http.StatusBadRequest,
+		Type:   "parsing_exception",
+		Reason: "membulk: " + fmt.Sprintf(format, args...),
+	}
+}
+
+func (b *Bulk) Search(ctx context.Context, index string, body []byte, opts ...bulk.Opt) (*es.ResultT, error) {
+	res, _, err := b.search(ctx, index, body, bulk.ParseOpts(opts...))
+	return res, err
+}
+
+// search runs a search, also returning the sort values of the hits, for search_after. Searches in a
+// point in time run on the current documents of its index, with the seq_no as tiebreaker.
+func (b *Bulk) search(ctx context.Context, index string, body []byte, opt bulk.Options) (*es.ResultT, [][]interface{}, error) {
+	var req searchT
+	if err := decode(body, &req); err != nil {
+		return nil, nil, errUnsupported("failed to parse search: %v", err)
+	}
+	if req.Aggs == nil {
+		req.Aggs = req.Aggregations
+	}
+
+	keys, err := parseSort(req.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Pit != nil {
+		if index, err = b.pitIndex(req.Pit.ID); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, sortKeyT{field: fieldShardDoc})
+	}
+
+	if err := b.waitForCheckpoints(ctx, index, opt.WaitForCheckpoints); err != nil {
+		return nil, nil, err
+	}
+
+	matcher, err := compileQuery(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := b.match(append([]string{index}, opt.Indices...), matcher)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res es.ResultT
+	res.Total.Relation = "eq"
+	res.Total.Value = uint64(len(docs))
+
+	if len(req.Aggs) > 0 {
+		if res.Aggregations, err = aggregate(req.Aggs, docs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	size, err := intValue(req.Size, defaultSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, err := intValue(&req.From, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sortDocs(docs, keys)
+	if req.SearchAfter != nil {
+		if len(req.SearchAfter) != len(keys) {
+			return nil, nil, errUnsupported("search_after has %d value(s) but sort has %d", len(req.SearchAfter), len(keys))
+		}
+		docs = searchAfter(docs, keys, req.SearchAfter)
+	}
+
+	var sortValues [][]interface{}
+	if res.Hits, sortValues, err = hits(docs, keys, req.Source, from, size); err != nil {
+		return nil, nil, err
+	}
+	return &res, sortValues, nil
+}
+
+// OpenPIT opens a point in time on the index. The keep alive is ignored, the point in time is
+// valid until closed.
+func (b *Bulk) OpenPIT(index string) (string, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if _, ok := b.indices[index]; !ok {
+		return "", indexNotFound(index)
+	}
+	id := newID()
+	b.pits[id] = index
+	return id, nil
+}
+
+// ClosePIT closes a point in time, returning false if it is unknown.
+func (b *Bulk) ClosePIT(id string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	_, ok := b.pits[id]
+	delete(b.pits, id)
+	return ok
+}
+
+func indexNotFound(name string) error {
+	return &es.ErrElastic{Status: http.StatusNotFound, Type: "index_not_found_exception", Reason: "no such index [" + name + "]"}
+}
+
+func (b *Bulk) pitIndex(id string) (string, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	index, ok := b.pits[id]
+	if !ok {
+		return "", &es.ErrElastic{Status: http.StatusNotFound, Type: "search_context_missing_exception", Reason: "no search context found for id [" + id + "]"}
+	}
+	return index, nil
+}
+
+type hitDocT struct {
+	index string
+	doc   docT
+}
+
+// match returns copies of the documents of the indices matching the query.
+func (b *Bulk) match(indices []string, matcher matcherT) ([]hitDocT, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	var docs []hitDocT
+	for _, name := range indices {
+		idx, ok := b.indices[name]
+		if !ok {
+			return nil, indexNotFound(name)
+		}
+		for _, doc := range idx.docs {
+			if matcher(doc) {
+				docs = append(docs, hitDocT{index: name, doc: *doc})
+			}
+		}
+	}
+
+	// Index order
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].doc.seqNo < docs[j].doc.seqNo
+	})
+	return docs, nil
+}
+
+// hits returns a page of the sorted documents, and the sort values of the hits.
+func hits(docs []hitDocT, keys []sortKeyT, sourceSpec interface{}, from, size int) ([]es.HitT, [][]interface{}, error) {
+	if from > len(docs) {
+		from = len(docs)
+	}
+	docs = docs[from:]
+	if size < len(docs) {
+		docs = docs[:size]
+	}
+
+	res := make([]es.HitT, 0, len(docs))
+	sortValues := make([][]interface{}, 0, len(docs))
+	for _, d := range docs {
+		source, err := filterSource(d.doc.source, sourceSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, err := json.Marshal(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		res = append(res, es.HitT{
+			ID:          d.doc.id,
+			SeqNo:       d.doc.seqNo,
+			PrimaryTerm: primaryTerm,
+			Version:     d.doc.version,
+			Index:       d.index,
+			Source:      raw,
+		})
+		sortValues = append(sortValues, docSortValues(&d.doc, keys))
+	}
+	return res, sortValues, nil
+}
+
+func intValue(n *json.Number, def int) (int, error) {
+	if n == nil || *n == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(n.String())
+	if err != nil {
+		return 0, errUnsupported("invalid size %q", *n)
+	}
+	return v, nil
+}
+
+// fieldShardDoc is the tiebreaker of the searches in a point in time.
+const fieldShardDoc = "_shard_doc"
+
+type sortKeyT struct {
+	field string
+	desc  bool
+}
+
+// parseSort parses the sort specification: a field, a {field: order} or a {field: {"order": order}}
+// object, or a list of them.
+func parseSort(spec interface{}) ([]sortKeyT, error) {
+	var keys []sortKeyT
+
+	specs, ok := spec.([]interface{})
+	if !ok && spec != nil {
+		specs = []interface{}{spec}
+	}
+	for _, s := range specs {
+		switch t := s.(type) {
+		case string:
+			keys = append(keys, sortKeyT{field: t, desc: t == "_score"})
+		case map[string]interface{}:
+			for field, order := range t {
+				if m, ok := order.(map[string]interface{}); ok {
+					order = m["order"]
+				}
+				keys = append(keys, sortKeyT{field: field, desc: order == "desc"})
+			}
+		default:
+			return nil, errUnsupported("invalid sort %v", s)
+		}
+	}
+	return keys, nil
+}
+
+// sortDocs sorts the documents by the sort keys. Missing values sort last.
+func sortDocs(docs []hitDocT, keys []sortKeyT) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareSortValues(docSortValues(&docs[i].doc, keys), docSortValues(&docs[j].doc, keys), keys) < 0
+	})
+}
+
+// docSortValues returns the sort values of the document, nil for the missing values.
+func docSortValues(doc *docT, keys []sortKeyT) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		if k.field == "_score" {
+			continue
+		}
+		if v := fieldValues(doc, k.field); len(v) > 0 {
+			values[i] = v[0]
+		}
+	}
+	return values
+}
+
+func compareSortValues(a, b []interface{}, keys []sortKeyT) int {
+	for i, k := range keys {
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+		c, _ := compare(a[i], b[i])
+		if c == 0 {
+			continue
+		}
+		if k.desc {
+			return -c
+		}
+		return c
+	}
+	return 0
+}
+
+// searchAfter returns the sorted documents after the sort values.
+func searchAfter(docs []hitDocT, keys []sortKeyT, after []interface{}) []hitDocT {
+	for i := range docs {
+		if compareSortValues(docSortValues(&docs[i].doc, keys), after, keys) > 0 {
+			return docs[i:]
+		}
+	}
+	return nil
+}
+
+// filterSource applies the _source parameter of a search: false, a list of fields, or an object
+// with includes and excludes. The fields may contain wildcards.
+func filterSource(source map[string]interface{}, spec interface{}) (map[string]interface{}, error) {
+	var includes, excludes []string
+
+	switch t := spec.(type) {
+	case nil:
+		return source, nil
+	case bool:
+		if !t {
+			return nil, nil
+		}
+		return source, nil
+	case string:
+		includes = []string{t}
+	case []interface{}:
+		includes = stringList(t)
+	case map[string]interface{}:
+		if l, ok := t["includes"].([]interface{}); ok {
+			includes = stringList(l)
+		}
+		if l, ok := t["excludes"].([]interface{}); ok {
+			excludes = stringList(l)
+		}
+	default:
+		return nil, errUnsupported("invalid _source %v", spec)
+	}
+
+	return filterFields(source, "", includes, excludes, len(includes) == 0), nil
+}
+
+func filterFields(m map[string]interface{}, prefix string, includes, excludes []string, all bool) map[string]interface{} {
+	res := make(map[string]interface{})
+	for k, v := range m {
+		p := prefix + k
+		if matchAny(excludes, p) {
+			continue
+		}
+		included := all || matchAny(includes, p)
+		if sub, ok := v.(map[string]interface{}); ok {
+			if included || isParent(includes, p) {
+				res[k] = filterFields(sub, p+".", includes, excludes, included)
+			}
+			continue
+		}
+		if included {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func matchAny(patterns []string, field string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isParent returns true if a pattern may match a field of the object at prefix.
+func isParent(patterns []string, prefix string) bool {
+	parents := strings.Split(prefix, ".")
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		segments := strings.Split(p, ".")
+		if len(segments) <= len(parents) {
+			continue
+		}
+		matched := true
+		for i, parent := range parents {
+			if ok, _ := path.Match(segments[i], parent); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func stringList(l []interface{}) []string {
+	res := make([]string, 0, len(l))
+	for _, v := range l {
+		if s, ok := v.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// aggregate computes the aggregations over the documents. The terms aggregations only support
+// top_hits sub-aggregations, the only ones es.Bucket can hold.
+func aggregate(aggs map[string]aggT, docs []hitDocT) (map[string]es.Aggregation, error) {
+	res := make(map[string]es.Aggregation, len(aggs))
+	for name, agg := range aggs {
+		switch {
+		case agg.Terms != nil:
+			buckets, err := termsBuckets(agg, docs)
+			if err != nil {
+				return nil, err
+			}
+			res[name] = es.Aggregation{Buckets: buckets}
+		case agg.Max != nil, agg.Min != nil:
+			field, isMax := "", agg.Max != nil
+			if isMax {
+				field = agg.Max.Field
+			} else {
+				field = agg.Min.Field
+			}
+			var value float64
+			found := false
+			for i := range docs {
+				for _, v := range fieldValues(&docs[i].doc, field) {
+					f, ok := number(v)
+					if ok && (!found || (isMax && f > value) || (!isMax && f < value)) {
+						value, found = f, true
+					}
+				}
+			}
+			res[name] = es.Aggregation{Value: value}
+		default:
+			return nil, errUnsupported("unsupported aggregation %q", name)
+		}
+	}
+	return res, nil
+}
+
+func termsBuckets(agg aggT, docs []hitDocT) ([]es.Bucket, error) {
+	size, err := intValue(agg.Terms.Size, defaultSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	groups := make(map[string][]hitDocT)
+	for i := range docs {
+		seen := make(map[string]bool)
+		for _, v := range fieldValues(&docs[i].doc, agg.Terms.Field) {
+			key, ok := scalar(v)
+			if !ok || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], docs[i])
+		}
+	}
+
+	// Most documents first, then by key
+	sort.SliceStable(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+	if size < len(keys) {
+		keys = keys[:size]
+	}
+
+	buckets := make([]es.Bucket, 0, len(keys))
+	for _, key := range keys {
+		bucket := es.Bucket{
+			Key:          key,
+			DocCount:     int64(len(groups[key])),
+			Aggregations: make(map[string]es.HitsT),
+		}
+		for name, sub := range agg.Aggs {
+			if sub.TopHits == nil {
+				return nil, errUnsupported("unsupported sub-aggregation %q", name)
+			}
+			size, err := intValue(sub.TopHits.Size, 3)
+			if err != nil {
+				return nil, err
+			}
+			keys, err := parseSort(sub.TopHits.Sort)
+			if err != nil {
+				return nil, err
+			}
+			group := append([]hitDocT(nil), groups[key]...)
+			sortDocs(group, keys)
+			var h es.HitsT
+			if h.Hits, _, err = hits(group, keys, sub.TopHits.Source, 0, size); err != nil {
+				return nil, err
+			}
+			h.Total.Relation = "eq"
+			h.Total.Value = uint64(len(group))
+			bucket.Aggregations[name] = h
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
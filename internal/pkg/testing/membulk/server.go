@@ -0,0 +1,833 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package membulk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/version"
+)
+
+const defaultCheckpointsTimeout = 30 * time.Second
+
+// Handler serves the subset of the elasticsearch HTTP API used by fleet-server from a Bulk:
+//
+//   - GET / with the cluster info
+//   - _bulk, _mget, _msearch and _search, with points in time and search_after
+//   - _fleet/_fleet_search, _fleet/_fleet_msearch and _fleet/global_checkpoints
+//   - _security/api_key, _security/api_key/_bulk_update and _security/_authenticate
+//   - _update_by_query, when no document matches the query, for the fleet-server migrations
+//   - _refresh and the shard level _stats with the sequence numbers
+//
+// Requests are not authenticated, except _security/_authenticate with an api key. The other
+// endpoints fail with a 400 status so that tests fail loudly.
+type Handler struct {
+	bulk        *Bulk
+	version     string
+	fleetPlugin bool
+}
+
+// HandlerOpt is a functional configuration option of the Handler.
+type HandlerOpt func(*Handler)
+
+// WithoutFleetPlugin makes the _fleet endpoints fail with a 404 status, as a cluster without the
+// fleet plugin.
+func WithoutFleetPlugin() HandlerOpt {
+	return func(s *Handler) {
+		s.fleetPlugin = false
+	}
+}
+
+// NewHandler returns a Handler reporting the fleet-server version as the elasticsearch version.
+func NewHandler(b *Bulk, opts ...HandlerOpt) *Handler {
+	s := &Handler{bulk: b, version: version.DefaultVersion, fleetPlugin: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewServer starts an httptest.Server serving the elasticsearch API from the Bulk. The caller
+// closes the server.
+func NewServer(b *Bulk, opts ...HandlerOpt) *httptest.Server {
+	return httptest.NewServer(NewHandler(b, opts...))
+}
+
+func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "parse_exception", Reason: err.Error()})
+		return
+	}
+
+	// Optional index, followed by the endpoint
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var index string
+	if len(parts) > 0 && parts[0] != "" && !strings.HasPrefix(parts[0], "_") {
+		index, parts = parts[0], parts[1:]
+	}
+	endpoint := strings.Join(parts, "/")
+
+	ctx := r.Context()
+	switch {
+	case !s.fleetPlugin && strings.HasPrefix(endpoint, "_fleet/"):
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error":  fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.URL.Path, r.Method),
+			"status": http.StatusNotFound,
+		})
+	case index == "" && endpoint == "":
+		s.info(w)
+	case endpoint == "_bulk":
+		s.bulkWrite(w, index, body)
+	case endpoint == "_mget":
+		s.mget(w, index, body)
+	case endpoint == "_msearch", endpoint == "_fleet/_fleet_msearch":
+		s.msearch(ctx, w, index, body)
+	case endpoint == "_search", endpoint == "_fleet/_fleet_search":
+		s.search(ctx, w, index, r.URL.Query().Get("wait_for_checkpoints"), body)
+	case endpoint == "_pit":
+		s.pit(w, r, index, body)
+	case index != "" && endpoint == "_fleet/global_checkpoints":
+		s.globalCheckpoints(ctx, w, index, r)
+	case index != "" && endpoint == "_refresh":
+		s.refresh(w, index)
+	case index != "" && (endpoint == "_stats" || strings.HasPrefix(endpoint, "_stats/")):
+		s.stats(w, index)
+	case index != "" && endpoint == "_update_by_query":
+		s.updateByQuery(w, index, body)
+	case index == "" && endpoint == "_security/api_key":
+		s.apiKey(ctx, w, r, body)
+	case index == "" && endpoint == "_security/api_key/_bulk_update":
+		s.apiKeyBulkUpdate(ctx, w, body)
+	case index == "" && endpoint == "_security/_authenticate":
+		s.authenticate(ctx, w, r)
+	default:
+		writeError(w, errUnsupported("unsupported endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// readBody reads the request body, decompressed as sent by the bulk engine.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		fl := flate.NewReader(r.Body)
+		defer fl.Close()
+		reader = fl
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type errorBodyT struct {
+	Error  *es.ErrorT `json:"error"`
+	Status int        `json:"status"`
+}
+
+// errorBody returns the elasticsearch error body of err.
+func errorBody(err error) errorBodyT {
+	var esErr *es.ErrElastic
+	switch {
+	case errors.As(err, &esErr):
+		return errorBodyT{Error: &es.ErrorT{Type: esErr.Type, Reason: esErr.Reason}, Status: esErr.Status}
+	case errors.Is(err, es.ErrTimeout):
+		return errorBodyT{Error: &es.ErrorT{Type: "timeout_exception", Reason: err.Error()}, Status: http.StatusGatewayTimeout}
+	}
+	return errorBodyT{Error: &es.ErrorT{Type: "exception", Reason: err.Error()}, Status: http.StatusInternalServerError}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	body := errorBody(err)
+	writeJSON(w, body.Status, body)
+}
+
+func (s *Handler) info(w http.ResponseWriter) {
+	var resp struct {
+		Name        string `json:"name"`
+		ClusterName string `json:"cluster_name"`
+		ClusterUUID string `json:"cluster_uuid"`
+		Version     struct {
+			Number      string `json:"number"`
+			BuildFlavor string `json:"build_flavor"`
+		} `json:"version"`
+		Tagline string `json:"tagline"`
+	}
+	resp.Name = "membulk"
+	resp.ClusterName = "membulk"
+	resp.ClusterUUID = "membulk"
+	resp.Version.Number = s.version
+	resp.Version.BuildFlavor = "default"
+	resp.Tagline = "You Know, for Search"
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+type bulkMetaT struct {
+	Index         string      `json:"_index"`
+	ID            string      `json:"_id"`
+	IfSeqNo       json.Number `json:"if_seq_no"`
+	IfPrimaryTerm json.Number `json:"if_primary_term"`
+}
+
+// bulkWrite runs the actions of a _bulk request in order. The refresh parameter is ignored, the
+// writes are visible immediately.
+func (s *Handler) bulkWrite(w http.ResponseWriter, index string, body []byte) {
+	lines := ndjson(body)
+
+	var resp struct {
+		Took      int                                        `json:"took"`
+		HasErrors bool                                       `json:"errors"`
+		Items     []map[string]*bulk.BulkIndexerResponseItem `json:"items"`
+	}
+	resp.Items = []map[string]*bulk.BulkIndexerResponseItem{}
+
+	for len(lines) > 0 {
+		var meta map[string]bulkMetaT
+		if err := decode(lines[0], &meta); err != nil || len(meta) != 1 {
+			writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: fmt.Sprintf("malformed action/metadata line %q", lines[0])})
+			return
+		}
+		lines = lines[1:]
+
+		for name, m := range meta {
+			var action actionT
+			switch name {
+			case "create":
+				action = actionCreate
+			case "index":
+				action = actionIndex
+			case "update":
+				action = actionUpdate
+			case "delete":
+				action = actionDelete
+			default:
+				writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: "unknown bulk action " + name})
+				return
+			}
+
+			op := bulk.MultiOp{Index: m.Index, ID: m.ID}
+			if op.Index == "" {
+				op.Index = index
+			}
+			if action != actionDelete {
+				if len(lines) == 0 {
+					writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: "missing source of " + name})
+					return
+				}
+				op.Body, lines = lines[0], lines[1:]
+			}
+
+			var opt bulk.Options
+			if m.IfSeqNo != "" {
+				opt.IfSeqNo, opt.IfPrimaryTerm = m.IfSeqNo.String(), m.IfPrimaryTerm.String()
+			}
+
+			item := s.bulk.do(action, op, opt)
+			if item.Error != nil {
+				resp.HasErrors = true
+			}
+			resp.Items = append(resp.Items, map[string]*bulk.BulkIndexerResponseItem{name: item})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+// ndjson splits a newline delimited body, skipping the empty lines.
+func ndjson(body []byte) [][]byte {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	return lines
+}
+
+func (s *Handler) mget(w http.ResponseWriter, index string, body []byte) {
+	var req struct {
+		Docs []struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		} `json:"docs"`
+	}
+	if err := decode(body, &req); err != nil {
+		writeError(w, errUnsupported("failed to parse mget: %v", err))
+		return
+	}
+
+	type mgetItemT struct {
+		Index       string                 `json:"_index"`
+		ID          string                 `json:"_id"`
+		Found       bool                   `json:"found"`
+		SeqNo       *int64                 `json:"_seq_no,omitempty"`
+		PrimaryTerm *int64                 `json:"_primary_term,omitempty"`
+		Version     *int64                 `json:"_version,omitempty"`
+		Source      map[string]interface{} `json:"_source,omitempty"`
+	}
+	var resp struct {
+		Docs []mgetItemT `json:"docs"`
+	}
+	resp.Docs = make([]mgetItemT, 0, len(req.Docs))
+
+	s.bulk.mut.Lock()
+	defer s.bulk.mut.Unlock()
+
+	for _, d := range req.Docs {
+		item := mgetItemT{Index: d.Index, ID: d.ID}
+		if item.Index == "" {
+			item.Index = index
+		}
+		if idx, ok := s.bulk.indices[item.Index]; ok {
+			if doc, ok := idx.docs[d.ID]; ok {
+				term := int64(primaryTerm)
+				seqNo, version := doc.seqNo, doc.version
+				item.Found = true
+				item.SeqNo, item.PrimaryTerm, item.Version = &seqNo, &term, &version
+				item.Source = doc.source
+			}
+		}
+		resp.Docs = append(resp.Docs, item)
+	}
+
+	// Encode under the lock, the sources are shared with the documents
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+type searchHitT struct {
+	es.HitT
+	Sort []interface{} `json:"sort,omitempty"`
+}
+
+type searchResponseT struct {
+	PitID    string `json:"pit_id,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Took     int    `json:"took"`
+	TimedOut bool   `json:"timed_out"`
+	Shards   struct {
+		Total      int `json:"total"`
+		Successful int `json:"successful"`
+		Skipped    int `json:"skipped"`
+		Failed     int `json:"failed"`
+	} `json:"_shards"`
+	Hits struct {
+		Hits  []searchHitT `json:"hits"`
+		Total struct {
+			Relation string `json:"relation"`
+			Value    uint64 `json:"value"`
+		} `json:"total"`
+		MaxScore *float64 `json:"max_score"`
+	} `json:"hits"`
+	Aggregations map[string]map[string]interface{} `json:"aggregations,omitempty"`
+}
+
+// searchResponse encodes a search result, with the sort values of the hits if any. The aggregations
+// are encoded by hand, as es.Bucket does not encode its sub-aggregations.
+func searchResponse(res *es.ResultT, sortValues [][]interface{}) *searchResponseT {
+	resp := &searchResponseT{}
+	resp.Shards.Total, resp.Shards.Successful = 1, 1
+	resp.Hits.Total = res.Total
+	resp.Hits.Hits = make([]searchHitT, 0, len(res.Hits))
+	for i, hit := range res.Hits {
+		h := searchHitT{HitT: hit}
+		if i < len(sortValues) && len(sortValues[i]) > 0 {
+			h.Sort = sortValues[i]
+		}
+		resp.Hits.Hits = append(resp.Hits.Hits, h)
+	}
+
+	if len(res.Aggregations) > 0 {
+		resp.Aggregations = make(map[string]map[string]interface{}, len(res.Aggregations))
+	}
+	for name, agg := range res.Aggregations {
+		if agg.Buckets == nil {
+			resp.Aggregations[name] = map[string]interface{}{"value": agg.Value}
+			continue
+		}
+		buckets := make([]map[string]interface{}, 0, len(agg.Buckets))
+		for _, bucket := range agg.Buckets {
+			m := map[string]interface{}{
+				"key":       bucket.Key,
+				"doc_count": bucket.DocCount,
+			}
+			for sub, hits := range bucket.Aggregations {
+				m[sub] = map[string]interface{}{"hits": hits}
+			}
+			buckets = append(buckets, m)
+		}
+		resp.Aggregations[name] = map[string]interface{}{
+			"doc_count_error_upper_bound": agg.DocCountErrorUpperBound,
+			"sum_other_doc_count":         agg.SumOtherDocCount,
+			"buckets":                     buckets,
+		}
+	}
+	return resp
+}
+
+// searchOpts returns the search options of the indices, a comma separated list or a JSON string
+// or list, and of the checkpoints to wait for.
+func searchOpts(indices []string, checkpoints []int64) (string, []bulk.Opt) {
+	var all []string
+	for _, i := range indices {
+		all = append(all, strings.Split(i, ",")...)
+	}
+	var opts []bulk.Opt
+	if len(all) == 0 {
+		all = []string{""}
+	}
+	for _, i := range all[1:] {
+		opts = append(opts, bulk.WithIndex(i))
+	}
+	if len(checkpoints) > 0 {
+		opts = append(opts, bulk.WithWaitForCheckpoints(checkpoints))
+	}
+	return all[0], opts
+}
+
+func (s *Handler) search(ctx context.Context, w http.ResponseWriter, index, checkpoints string, body []byte) {
+	seqNos, err := parseSeqNos(checkpoints)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	index, opts := searchOpts([]string{index}, seqNos)
+	res, sortValues, err := s.bulk.search(ctx, index, body, bulk.ParseOpts(opts...))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := searchResponse(res, sortValues)
+	var req struct {
+		Pit *struct {
+			ID string `json:"id"`
+		} `json:"pit"`
+	}
+	if err := json.Unmarshal(body, &req); err == nil && req.Pit != nil {
+		resp.PitID = req.Pit.ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pit opens a point in time on the index, or closes the point in time of the body.
+func (s *Handler) pit(w http.ResponseWriter, r *http.Request, index string, body []byte) {
+	switch {
+	case r.Method == http.MethodPost && index != "":
+		id, err := s.bulk.OpenPIT(index)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+	case r.Method == http.MethodDelete && index == "":
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, errUnsupported("failed to parse point in time: %v", err))
+			return
+		}
+		if !s.bulk.ClosePIT(req.ID) {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"succeeded": true, "num_freed": 0})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"succeeded": true, "num_freed": 1})
+	default:
+		writeError(w, errUnsupported("unsupported endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Handler) msearch(ctx context.Context, w http.ResponseWriter, index string, body []byte) {
+	lines := ndjson(body)
+	if len(lines)%2 != 0 {
+		writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: "msearch request without body"})
+		return
+	}
+
+	var resp struct {
+		Took      int           `json:"took"`
+		Responses []interface{} `json:"responses"`
+	}
+	resp.Responses = make([]interface{}, 0, len(lines)/2)
+
+	for i := 0; i < len(lines); i += 2 {
+		var header struct {
+			Index              interface{} `json:"index"`
+			WaitForCheckpoints []int64     `json:"wait_for_checkpoints"`
+		}
+		if err := decode(lines[i], &header); err != nil {
+			writeError(w, errUnsupported("failed to parse msearch header: %v", err))
+			return
+		}
+
+		indices := []string{index}
+		switch t := header.Index.(type) {
+		case string:
+			indices = []string{t}
+		case []interface{}:
+			indices = stringList(t)
+		}
+
+		idx, opts := searchOpts(indices, header.WaitForCheckpoints)
+		res, err := s.bulk.Search(ctx, idx, lines[i+1], opts...)
+		if err != nil {
+			resp.Responses = append(resp.Responses, errorBody(err))
+			continue
+		}
+		item := searchResponse(res, nil)
+		item.Status = http.StatusOK
+		resp.Responses = append(resp.Responses, item)
+	}
+
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+// parseSeqNos parses a comma separated list of sequence numbers.
+func parseSeqNos(s string) (sqn.SeqNo, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var seqNos sqn.SeqNo
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: fmt.Sprintf("invalid checkpoints %q", s)}
+		}
+		seqNos = append(seqNos, n)
+	}
+	return seqNos, nil
+}
+
+// parseTimeout parses a timeout as formatted by the es package, in ms or nanos.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultCheckpointsTimeout, nil
+	}
+	if nanos := strings.TrimSuffix(s, "nanos"); nanos != s {
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		return time.Duration(n), err
+	}
+	return time.ParseDuration(s)
+}
+
+func (b *Bulk) hasIndex(name string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	_, ok := b.indices[name]
+	return ok
+}
+
+// refresh succeeds on the existing indices, the documents being searchable once written.
+func (s *Handler) refresh(w http.ResponseWriter, index string) {
+	if !s.bulk.hasIndex(index) {
+		writeError(w, indexNotFound(index))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_shards": map[string]int{"total": 1, "successful": 1, "failed": 0},
+	})
+}
+
+// stats returns the shard level stats of the index, reduced to the sequence numbers of its single
+// primary shard.
+func (s *Handler) stats(w http.ResponseWriter, index string) {
+	if !s.bulk.hasIndex(index) {
+		writeError(w, indexNotFound(index))
+		return
+	}
+	seqNo := s.bulk.GlobalCheckpoint(index).Value()
+	shard := map[string]interface{}{
+		"routing": map[string]interface{}{"state": "STARTED", "primary": true},
+		"seq_no": map[string]int64{
+			"max_seq_no":        seqNo,
+			"local_checkpoint":  seqNo,
+			"global_checkpoint": seqNo,
+		},
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_shards": map[string]int{"total": 1, "successful": 1, "failed": 0},
+		"indices": map[string]interface{}{
+			index: map[string]interface{}{
+				"shards": map[string]interface{}{"0": []interface{}{shard}},
+			},
+		},
+	})
+}
+
+func (s *Handler) globalCheckpoints(ctx context.Context, w http.ResponseWriter, index string, r *http.Request) {
+	query := r.URL.Query()
+	waitForAdvance := query.Get("wait_for_advance") == "true"
+	waitForIndex := query.Get("wait_for_index") == "true"
+
+	checkpoints, err := parseSeqNos(query.Get("checkpoints"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	to, err := parseTimeout(query.Get("timeout"))
+	if err != nil {
+		writeError(w, &es.ErrElastic{Status: http.StatusBadRequest, Type: "illegal_argument_exception", Reason: err.Error()})
+		return
+	}
+
+	var resp struct {
+		GlobalCheckpoints []int64 `json:"global_checkpoints"`
+		TimedOut          bool    `json:"timed_out"`
+	}
+
+	if !s.bulk.hasIndex(index) && !(waitForAdvance && waitForIndex) {
+		writeError(w, indexNotFound(index))
+		return
+	}
+	if !waitForAdvance {
+		resp.GlobalCheckpoints = s.bulk.GlobalCheckpoint(index)
+		writeJSON(w, http.StatusOK, &resp)
+		return
+	}
+
+	seqNo, err := s.bulk.WaitAdvance(ctx, index, checkpoints, to)
+	switch {
+	case errors.Is(err, es.ErrTimeout):
+		resp.GlobalCheckpoints = s.bulk.GlobalCheckpoint(index)
+		resp.TimedOut = true
+	case err != nil:
+		writeError(w, err)
+		return
+	default:
+		resp.GlobalCheckpoints = seqNo
+	}
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+// updateByQuery only succeeds when no document matches, as scripts are not supported.
+func (s *Handler) updateByQuery(w http.ResponseWriter, index string, body []byte) {
+	var req searchT
+	if err := decode(body, &req); err != nil {
+		writeError(w, errUnsupported("failed to parse update by query: %v", err))
+		return
+	}
+	matcher, err := compileQuery(req.Query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	docs, err := s.bulk.match([]string{index}, matcher)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(docs) > 0 {
+		writeError(w, errUnsupported("update by query matching %d documents", len(docs)))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":              0,
+		"timed_out":         false,
+		"total":             0,
+		"updated":           0,
+		"deleted":           0,
+		"batches":           0,
+		"version_conflicts": 0,
+		"noops":             0,
+		"failures":          []interface{}{},
+	})
+}
+
+func (s *Handler) apiKey(ctx context.Context, w http.ResponseWriter, r *http.Request, body []byte) {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var req struct {
+			Name       string          `json:"name"`
+			Expiration string          `json:"expiration"`
+			Roles      json.RawMessage `json:"role_descriptors"`
+			Metadata   json.RawMessage `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, errUnsupported("failed to parse api key: %v", err))
+			return
+		}
+		if req.Metadata == nil {
+			req.Metadata = json.RawMessage(`{}`)
+		}
+		key, err := s.bulk.APIKeyCreate(ctx, req.Name, req.Expiration, req.Roles, req.Metadata)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":      key.ID,
+			"name":    req.Name,
+			"api_key": key.Key,
+			"encoded": base64.StdEncoding.EncodeToString([]byte(key.ID + ":" + key.Key)),
+		})
+
+	case http.MethodGet:
+		type apiKeyInfoT struct {
+			ID          string          `json:"id"`
+			Name        string          `json:"name"`
+			Invalidated bool            `json:"invalidated"`
+			Expiration  int64           `json:"expiration,omitempty"`
+			Metadata    json.RawMessage `json:"metadata"`
+			Roles       json.RawMessage `json:"role_descriptors,omitempty"`
+		}
+		resp := struct {
+			APIKeys []apiKeyInfoT `json:"api_keys"`
+		}{APIKeys: []apiKeyInfoT{}}
+
+		s.bulk.mut.Lock()
+		if k, ok := s.bulk.apiKeys[r.URL.Query().Get("id")]; ok {
+			info := apiKeyInfoT{
+				ID:          k.id,
+				Name:        k.name,
+				Invalidated: k.invalidated,
+				Metadata:    k.metadata,
+				Roles:       k.roles,
+			}
+			if !k.expiration.IsZero() {
+				info.Expiration = k.expiration.UnixMilli()
+			}
+			resp.APIKeys = append(resp.APIKeys, info)
+		}
+		s.bulk.mut.Unlock()
+		writeJSON(w, http.StatusOK, &resp)
+
+	case http.MethodDelete:
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, errUnsupported("failed to parse api key invalidation: %v", err))
+			return
+		}
+
+		resp := struct {
+			Invalidated           []string `json:"invalidated_api_keys"`
+			PreviouslyInvalidated []string `json:"previously_invalidated_api_keys"`
+			ErrorCount            int      `json:"error_count"`
+		}{Invalidated: []string{}, PreviouslyInvalidated: []string{}}
+
+		s.bulk.mut.Lock()
+		for _, id := range req.IDs {
+			if k, ok := s.bulk.apiKeys[id]; ok {
+				if k.invalidated {
+					resp.PreviouslyInvalidated = append(resp.PreviouslyInvalidated, id)
+				} else {
+					resp.Invalidated = append(resp.Invalidated, id)
+				}
+			}
+		}
+		s.bulk.mut.Unlock()
+
+		if err := s.bulk.APIKeyInvalidate(ctx, req.IDs...); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &resp)
+
+	default:
+		writeError(w, errUnsupported("unsupported method %s for api keys", r.Method))
+	}
+}
+
+func (s *Handler) apiKeyBulkUpdate(ctx context.Context, w http.ResponseWriter, body []byte) {
+	var req struct {
+		IDs   []string        `json:"ids"`
+		Roles json.RawMessage `json:"role_descriptors"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, errUnsupported("failed to parse api key update: %v", err))
+		return
+	}
+
+	type errorsT struct {
+		Count   int                   `json:"count"`
+		Details map[string]*es.ErrorT `json:"details,omitempty"`
+	}
+	resp := struct {
+		Updated []string `json:"updated"`
+		Noops   []string `json:"noops"`
+		Errors  *errorsT `json:"errors,omitempty"`
+	}{Updated: []string{}, Noops: []string{}}
+
+	for _, id := range req.IDs {
+		if err := s.bulk.APIKeyUpdate(ctx, id, "", req.Roles); err != nil {
+			if resp.Errors == nil {
+				resp.Errors = &errorsT{Details: make(map[string]*es.ErrorT)}
+			}
+			resp.Errors.Count++
+			resp.Errors.Details[id] = errorBody(err).Error
+			continue
+		}
+		resp.Updated = append(resp.Updated, id)
+	}
+	writeJSON(w, http.StatusOK, &resp)
+}
+
+// authenticate authenticates the api key of the request. Other credentials, such as the
+// fleet-server service token, are accepted as the fleet-server service account.
+func (s *Handler) authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get(apikey.AuthKey), "ApiKey ") {
+		writeJSON(w, http.StatusOK, &bulk.SecurityInfo{
+			UserName:  "elastic/fleet-server",
+			Roles:     []string{},
+			Metadata:  json.RawMessage(`{"_elastic_service_account":true}`),
+			Enabled:   true,
+			AuthRealm: map[string]string{"name": "_service_account", "type": "_service_account"},
+		})
+		return
+	}
+
+	key, err := apikey.ExtractAPIKey(r)
+	if err != nil {
+		writeError(w, &es.ErrElastic{Status: http.StatusUnauthorized, Type: "security_exception", Reason: err.Error()})
+		return
+	}
+	info, err := s.bulk.APIKeyAuth(ctx, *key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
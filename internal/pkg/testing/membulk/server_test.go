@@ -0,0 +1,177 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package membulk
+
+import (
+	"compress/flate"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/gcheckpt"
+	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+func startServer(t *testing.T) (*Bulk, *elasticsearch.Client) {
+	t.Helper()
+
+	mem := New()
+	srv := NewServer(mem)
+	t.Cleanup(srv.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+	return mem, client
+}
+
+func runBulker(t *testing.T, client *elasticsearch.Client) *bulk.Bulker {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bulker := bulk.NewBulker(client, nil,
+		bulk.WithFlushInterval(time.Millisecond),
+		bulk.WithRequestCompression("gzip", flate.BestSpeed, 0),
+	)
+	go func() { _ = bulker.Run(ctx) }()
+	return bulker
+}
+
+func TestServerBulker(t *testing.T) {
+	ctx := context.Background()
+	mem, client := startServer(t)
+	bulker := runBulker(t, client)
+
+	id, err := bulker.Create(ctx, "idx", "", []byte(`{"a":1,"b":"c"}`))
+	require.NoError(t, err)
+	_, err = bulker.Create(ctx, "idx", id, []byte(`{}`))
+	assert.ErrorIs(t, err, es.ErrElasticVersionConflict)
+
+	require.NoError(t, bulker.Update(ctx, "idx", id, []byte(`{"doc":{"a":2}}`), bulk.WithIfSeqNo(0, 1)))
+	data, err := bulker.Read(ctx, "idx", id)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":2,"b":"c"}`, string(data))
+	_, err = bulker.Read(ctx, "idx", "missing")
+	assert.ErrorIs(t, err, es.ErrElasticNotFound)
+
+	res, err := bulker.Search(ctx, "idx", []byte(`{"query":{"term":{"b":"c"}}}`), bulk.WithWaitForCheckpoints([]int64{1}))
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, id, res.Hits[0].ID)
+	assert.Equal(t, int64(1), res.Hits[0].SeqNo)
+
+	_, err = bulker.Search(ctx, "missing", []byte(`{}`))
+	assert.ErrorIs(t, err, es.ErrIndexNotFound)
+
+	seqNo, err := gcheckpt.Query(ctx, client, "idx")
+	require.NoError(t, err)
+	assert.Equal(t, mem.GlobalCheckpoint("idx"), seqNo)
+	seqNo, err = gcheckpt.Query(ctx, client, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(sqn.UndefinedSeqNo), seqNo.Value())
+	_, err = gcheckpt.WaitAdvance(ctx, client, "idx", seqNo, 10*time.Millisecond)
+	require.NoError(t, err, "the checkpoint is already past the default")
+	_, err = gcheckpt.WaitAdvance(ctx, client, "idx", mem.GlobalCheckpoint("idx"), 10*time.Millisecond)
+	assert.ErrorIs(t, err, es.ErrTimeout)
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	mem, client := startServer(t)
+	bulker := runBulker(t, client)
+
+	key, err := bulker.APIKeyCreate(ctx, "agent1", "", []byte(`{"role":{}}`), apikey.NewMetadata("agent1", "", apikey.TypeAccess))
+	require.NoError(t, err)
+
+	info, err := bulker.APIKeyAuth(ctx, *key)
+	require.NoError(t, err)
+	assert.True(t, info.Enabled)
+	_, err = bulker.APIKeyAuth(ctx, bulk.APIKey{ID: key.ID, Key: "wrong"})
+	assert.Error(t, err)
+
+	require.NoError(t, bulker.APIKeyUpdate(ctx, key.ID, "hash", []byte(`{"updated":{}}`)))
+	meta, err := bulker.APIKeyRead(ctx, key.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, "agent1", meta.Metadata.AgentID)
+	assert.JSONEq(t, `{"updated":{}}`, string(meta.RoleDescriptors))
+
+	require.NoError(t, bulker.APIKeyInvalidate(ctx, key.ID))
+	assert.False(t, mem.APIKeyValid(key.ID))
+	_, err = bulker.APIKeyAuth(ctx, *key)
+	assert.Error(t, err)
+
+	_, err = bulker.APIKeyRead(ctx, "missing", false)
+	assert.ErrorIs(t, err, apikey.ErrAPIKeyNotFound)
+}
+
+func TestServerMonitor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mem, client := startServer(t)
+
+	readyCh := make(chan error)
+	mon, err := monitor.NewSimple("idx", client, client, monitor.WithReadyChan(readyCh), monitor.WithPollTimeout(time.Second))
+	require.NoError(t, err)
+	go func() { _ = mon.Run(ctx) }()
+	require.NoError(t, <-readyCh)
+
+	// The index is created after the monitor started
+	for _, id := range []string{"a", "b"} {
+		_, err = mem.Create(ctx, "idx", id, []byte(`{"a":1}`))
+		require.NoError(t, err)
+	}
+
+	var ids []string
+	for len(ids) < 2 {
+		select {
+		case hits := <-mon.Output():
+			for _, hit := range hits {
+				ids = append(ids, hit.ID)
+			}
+		case <-ctx.Done():
+			t.Fatalf("expected 2 hits, got %v", ids)
+		}
+	}
+	assert.Equal(t, []string{"a", "b"}, ids)
+}
+
+func TestServerWithoutFleetPlugin(t *testing.T) {
+	ctx := context.Background()
+	mem := New()
+	srv := NewServer(mem, WithoutFleetPlugin())
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	require.NoError(t, err)
+
+	_, err = gcheckpt.Query(ctx, client, "idx")
+	assert.ErrorIs(t, err, gcheckpt.ErrUnsupported)
+
+	seqNo, err := gcheckpt.QueryStats(ctx, client, "idx")
+	require.NoError(t, err)
+	assert.Equal(t, int64(sqn.UndefinedSeqNo), seqNo.Value())
+
+	for _, id := range []string{"a", "b"} {
+		_, err = mem.Create(ctx, "idx", id, []byte(`{}`))
+		require.NoError(t, err)
+	}
+	seqNo, err = gcheckpt.QueryStats(ctx, client, "idx")
+	require.NoError(t, err)
+	assert.Equal(t, mem.GlobalCheckpoint("idx"), seqNo)
+
+	res, err := client.Indices.Refresh(client.Indices.Refresh.WithIndex("idx"))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.False(t, res.IsError())
+}